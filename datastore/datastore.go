@@ -14,6 +14,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
@@ -218,6 +219,16 @@ func GetParentsByVersion(v dvid.VersionID) ([]dvid.VersionID, error) {
 	return manager.getParentsByVersion(v)
 }
 
+// GetNodeTimesByVersion returns the creation and last update times of the node with
+// the given version id.
+func GetNodeTimesByVersion(v dvid.VersionID) (created, updated time.Time, err error) {
+	if manager == nil {
+		err = ErrManagerNotInitialized
+		return
+	}
+	return manager.getNodeTimesByVersion(v)
+}
+
 // GetChildren returns the child nodes of the given version id.
 func GetChildrenByVersion(v dvid.VersionID) ([]dvid.VersionID, error) {
 	if manager == nil {
@@ -1255,6 +1255,22 @@ func (m *repoManager) getParentsByVersion(v dvid.VersionID) ([]dvid.VersionID, e
 	return r.dag.getParents(v)
 }
 
+func (m *repoManager) getNodeTimesByVersion(v dvid.VersionID) (created, updated time.Time, err error) {
+	r, err := m.repoFromVersion(v)
+	if err != nil {
+		return
+	}
+	r.RLock()
+	defer r.RUnlock()
+
+	node, found := r.dag.nodes[v]
+	if !found {
+		err = ErrInvalidVersion
+		return
+	}
+	return node.created, node.updated, nil
+}
+
 func (m *repoManager) getChildrenByVersion(v dvid.VersionID) ([]dvid.VersionID, error) {
 	r, err := m.repoFromVersion(v)
 	if err != nil {
@@ -17,6 +17,8 @@ import (
 	"sync"
 
 	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/keyvalue"
+	"github.com/janelia-flyem/dvid/datatype/labelarray"
 	"github.com/janelia-flyem/dvid/datatype/labelblk"
 	"github.com/janelia-flyem/dvid/datatype/labelvol"
 	"github.com/janelia-flyem/dvid/datatype/roi"
@@ -50,6 +52,11 @@ $ dvid repo <UUID> new annotation <data name> <settings...>
     UUID           Hexidecimal string with enough characters to uniquely identify a version node.
     data name      Name of data to create, e.g., "synapses"
     settings       Configuration settings in "key=value" format separated by spaces.
+
+    Configuration Settings (case-insensitive keys)
+
+    IndexedProps   Comma-separated list of element "Prop" keys that should be indexed for
+                     the "query" endpoint, e.g., "user,confidence".
 	
     ------------------
 
@@ -71,6 +78,15 @@ POST <api URL>/node/<UUID>/<data name>/info
 
     Returns JSON with configuration settings.
 
+    A POST can set the indexed "Prop" keys used by the "query" endpoint:
+
+    { "IndexedProps": "user,confidence" }
+
+    If the indexed keys change, the property indices of the given version are rebuilt
+    asynchronously as with the "reload" endpoint.  Other versions keep stale property
+    indices until they are reloaded, and until then, "query" requests in those versions
+    check property conditions against all elements instead of using the indices.
+
     Arguments:
 
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
@@ -92,7 +108,10 @@ POST <api URL>/node/<UUID>/<data name>/sync?<options>
     already exist.  Currently, syncs should be created before any annotations are pushed to
     the server.  If annotations already exist, these are currently not synced.
 
-    The annotations data type only accepts syncs to labelblk and labelvol data instances.
+    The annotations data type only accepts syncs to labelblk, labelvol, and labelarray data instances.
+    A labelarray instance provides both the label blocks and the merge/split events, so it
+    should not be combined with labelblk or labelvol syncs.  If the labelarray uses mapped
+    labels, annotations are denormalized by body and not supervoxel.
 
     GET Query-string Options:
 
@@ -107,7 +126,7 @@ GET <api URL>/node/<UUID>/<data name>/label/<label>[?<options>]
 
 	Returns all point annotations within the given label as an array of elements.
 	This endpoint is only available if the annotation data instance is synced with
-	a labelblk data instance.
+	a labelblk or labelarray data instance.
 	
 	GET Query-string Option:
 
@@ -132,17 +151,99 @@ GET <api URL>/node/<UUID>/<data name>/tag/<tag>[?<options>]
 
 	GET http://foo.com/api/node/83af/myannotations/tag/goodstuff?relationships=true
 	
+GET <api URL>/node/<UUID>/<data name>/relationships/<coord>[?<options>]
+
+	Returns the point annotation at the given coordinate, which should be of the form X_Y_Z,
+	and all point annotations reachable from it by following relationships.  The returned
+	array of elements, with relationships, is in breadth-first order starting with the
+	annotation at the given coordinate.
+
+	GET Query-string Options:
+
+	depth   The maximum number of relationships followed from the starting annotation.
+	          Default is 1, which returns the annotation and its immediate partners.
+	rel     A comma-separated list of relationship types to follow, e.g., "PreSynTo".
+	          If not given, all relationship types are followed.
+
+	Example:
+
+	GET http://foo.com/api/node/83af/myannotations/relationships/100_200_300?depth=2&rel=PreSynTo,PostSynTo
+
+GET <api URL>/node/<UUID>/<data name>/nearest/<coord>[?<options>]
+
+	Returns the point annotations nearest the given coordinate, which should be of the form
+	X_Y_Z, as an array of elements sorted by increasing distance.  Annotations are searched
+	for in expanding shells of blocks around the coordinate.  By default, the Relationships
+	of an annotation to other annotations is not returned.
+
+	GET Query-string Options:
+
+	k               The maximum number of annotations to return.  Default is 1.
+	kind            A comma-separated list of element kinds, e.g., "PreSyn,PostSyn".
+	                  If not given, annotations of all kinds are returned.
+	maxdist         The maximum distance in voxels of returned annotations.  Default is 1000
+	                  and the largest allowed is 10000.
+	relationships   Set to true to return all relationships for each annotation.
+
+	Example:
+
+	GET http://foo.com/api/node/83af/myannotations/nearest/100_200_300?k=10&kind=PreSyn&maxdist=500
+
+GET <api URL>/node/<UUID>/<data name>/connections/<label>
+
+	Returns the partner labels of the given label and the number of synaptic relationships
+	with each partner.  "outputs" are the labels of PostSyn partners of the label's PreSyn
+	annotations, and "inputs" are the labels of PreSyn partners of the label's PostSyn
+	annotations.  Partners are sorted by decreasing count, and partners in label 0 are
+	omitted.  This endpoint is only available if the annotation data instance is synced
+	with a labelblk or labelarray data instance.
+
+	Example returned JSON:
+
+	{
+		"label": 23,
+		"outputs": [{"label": 17, "count": 12}, {"label": 8, "count": 3}],
+		"inputs": [{"label": 5, "count": 7}]
+	}
+
 
 DELETE <api URL>/node/<UUID>/<data name>/element/<coord>
 
 	Deletes a point annotation given its location.
 
+GET <api URL>/node/<UUID>/<data name>/query?<conditions>
+
+	Returns all point annotations satisfying all the given conditions as an array of elements.
+	Conditions can be:
+
+	prop.<key><op><value>   Property condition where <op> is one of =, !=, <, <=, >, or >=.
+	                          Inequalities compare numerically if both values are numbers, else
+	                          lexicographically.  Elements without the property never match.
+	tag=<tag>               Element must have the given tag.
+	label=<label>           Element must be in the given label.  Requires synced label data.
+	roi=<roiname>[,<uuid>]  Element must be within the ROI.
+
+	Candidate elements are taken from the most selective index available: an equality on an
+	indexed property (see IndexedProps), the tag, the label, an inequality on an indexed
+	property, the ROI, or if none of these are given, a scan of all elements.  The other
+	conditions are then checked against the current elements.  Property keys used in
+	conditions cannot contain the operator characters !, <, >, or =.
+
+	GET Query-string Option:
+
+	relationships   Set to true to return all relationships for each annotation.
+
+	Example:
+
+	GET http://foo.com/api/node/83af/myannotations/query?prop.user=alice&prop.conf>=0.9&roi=medulla
+
 GET <api URL>/node/<UUID>/<data name>/roi/<ROI specification>
 
 	Returns all point annotations within the ROI.  The ROI specification must be specified
 	using a string of format "roiname,uuid".  If just "roiname" is specified without
 	a full UUID string, the current UUID of the request will be used.  Currently, this 
 	request will only work for ROIs that have same block size as the annotation data instance.
+	If the ROI has voxel precision, only annotations within its voxel masks are returned.
 
 	The returned point annotations will be an array of elements.
 
@@ -159,16 +260,75 @@ POST <api URL>/node/<UUID>/<data name>/elements
 	Adds or modifies point annotations.  The POSTed content is an array of elements.
 	Note that deletes are handled via a separate API (see above).
 
+POST <api URL>/node/<UUID>/<data name>/import?format=<format>
+
+	Adds or modifies point annotations using a streamed bulk format, which avoids holding
+	all annotations in memory.  Elements are stored with their label, tag, and property
+	denormalizations in batches of 10,000 elements, so if an error occurs, the annotations
+	in earlier batches will have been stored.
+
+	Query-string Options:
+
+	format    "ndjson" (default) for newline-delimited JSON with one element per line in
+	            the JSON format described below, or "msgpack" for a stream of MessagePack maps,
+	            one per element, with the same keys and values as the JSON format except that
+	            positions are arrays of three integers.
+
+GET <api URL>/node/<UUID>/<data name>/export?<options>
+
+	Streams all point annotations with their relationships in the given bulk format, which
+	is the same as the import format.  Annotations are read in batches of 10,000 elements
+	and the data is only locked while reading a batch, so the export of a locked version is
+	a consistent snapshot but the export of an open version being modified may not be.  If
+	an error occurs after annotations have been sent, the stream ends with an error record,
+	e.g., {"Error": "<error message>"} in NDJSON, which the "import" endpoint rejects.
+
+	Query-string Options:
+
+	format    "ndjson" (default) or "msgpack".
+	roi       Only export annotations within the given ROI specified as "roiname" or 
+	            "roiname,uuid".  If just "roiname" is specified, the current UUID is used.
+
 POST <api URL>/node/<UUID>/<data name>/move/<from_coord>/<to_coord>
 
 	Moves the point annotation from <from_coord> to <to_coord> where
 	<from_coord> and <to_coord> are of the form X_Y_Z.
 
+POST <api URL>/node/<UUID>/<data name>/export-connectome?<options>
+
+	Starts an asynchronous export of the connectome, a CSV table with header
+	"pre_body,post_body,count" giving the number of PreSynTo relationships from PreSyn
+	annotations in each label to PostSyn annotations in another label.  Labels are taken from
+	the label denormalizations, so the annotation must be synced with label data.  Annotations
+	without a label are ignored.  Exporting a locked version gives a consistent snapshot.
+	Progress of the export is reported in the "Export" field of the data instance info.
+	Only one export can run at a time.
+
+	The table is stored in a keyvalue data instance at an open (unlocked) version, so a
+	locked version can be exported by storing the table in an open child version.  The table
+	is split into parts of at most 16 MB, each holding complete lines, stored under keys
+	"<key>.00000", "<key>.00001", and so on.  Only the first part has the header.  The number
+	of parts is given in the export status, and parts left from an earlier, larger export
+	under the same key are deleted.
+
+	Query-string Options:
+
+	keyvalue   Name of a keyvalue data instance in which the table is stored (required).
+	key        Key prefix used for the table parts in the keyvalue instance.  Default is
+	             "connectome.csv".
+	kvuuid     Open version in which the table is stored.  Default is the exported version,
+	             which must then be open.
+
+	Example:
+
+	POST http://foo.com/api/node/83af/myannotations/export-connectome?keyvalue=exports&key=v1.csv&kvuuid=9d2e
+
 POST <api URL>/node/<UUID>/<data name>/reload
 
-	Forces asynchornous denormalization of all annotations for labels and tags.  Can be 
-	used to initialize a newly added sync.  Note that the annotation will be locked until
-	the denormalization is finished with a log message.
+	Forces asynchornous denormalization of all annotations for labels, tags, and indexed properties.  Can be 
+	used to initialize a newly added sync or the property indices of a version after the
+	IndexedProps setting was changed in another version.  Note that the annotation will be
+	locked until the denormalization is finished with a log message.
 
 ------
 
@@ -218,7 +378,8 @@ The "Rel" property can be one of "UnknownRelationship", "PostSynTo", "PreSynTo",
 
 The "Tags" property will be indexed and so can be costly if used for very large numbers of synapse elements.
 
-The "Prop" property is an arbitrary object with string values.  The "Prop" object's key are not indexed.
+The "Prop" property is an arbitrary object with string values.  The "Prop" object's keys are not indexed
+unless listed in the IndexedProps configuration setting.
 `
 
 var (
@@ -735,6 +896,9 @@ func NewData(uuid dvid.UUID, id dvid.InstanceID, name dvid.InstanceName, c dvid.
 		Data:       basedata,
 		Properties: Properties{},
 	}
+	if _, err := data.Properties.setByConfig(c); err != nil {
+		return nil, err
+	}
 	return data, nil
 }
 
@@ -847,8 +1011,81 @@ func getElementsInRLE(ctx *datastore.VersionedCtx, brles dvid.BlockRLEs) (Elemen
 }
 
 // Properties are additional properties for data beyond those in standard datastore.Data.
+// Block sizes are either default or taken from synced labelblk.
 type Properties struct {
-	// Currently unused since block sizes are either default or taken from synced labelblk.
+	// IndexedProps are the element "Prop" keys that have secondary indices for queries.
+	IndexedProps []string
+
+	// PartialPropIndex is true if IndexedProps has changed, after which only the versions
+	// in IndexedVersions have had their property indices rebuilt.
+	PartialPropIndex bool
+	IndexedVersions  []dvid.UUID
+}
+
+// setByConfig sets the indexed properties from a configuration, returning true if
+// the indexed properties were changed.
+func (p *Properties) setByConfig(c dvid.Config) (changed bool, err error) {
+	s, found, err := c.GetString("IndexedProps")
+	if err != nil || !found {
+		return false, err
+	}
+	var props []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.IndexByte(name, 0) >= 0 {
+			return false, fmt.Errorf("indexed property name %q cannot contain 0 bytes", name)
+		}
+		props = append(props, name)
+	}
+	sort.Strings(props)
+	changed = !reflect.DeepEqual(props, p.IndexedProps)
+	p.IndexedProps = props
+	return changed, nil
+}
+
+// isIndexed returns true if the given property key has a current index in the given version.
+func (p *Properties) isIndexed(v dvid.VersionID, name string) bool {
+	var found bool
+	for _, indexed := range p.IndexedProps {
+		if indexed == name {
+			found = true
+			break
+		}
+	}
+	if !found || !p.PartialPropIndex {
+		return found
+	}
+	uuid, err := datastore.UUIDFromVersion(v)
+	if err != nil {
+		return false
+	}
+	for _, indexedUUID := range p.IndexedVersions {
+		if indexedUUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// setIndexedVersion records that the given version has rebuilt property indices.
+func (p *Properties) setIndexedVersion(v dvid.VersionID) error {
+	if !p.PartialPropIndex {
+		return nil
+	}
+	uuid, err := datastore.UUIDFromVersion(v)
+	if err != nil {
+		return err
+	}
+	for _, indexedUUID := range p.IndexedVersions {
+		if indexedUUID == uuid {
+			return nil
+		}
+	}
+	p.IndexedVersions = append(p.IndexedVersions, uuid)
+	return nil
 }
 
 // Data instance of labelvol, label sparse volumes.
@@ -866,6 +1103,12 @@ type Data struct {
 	// Cached in-memory so we only have to lookup block size once.
 	cachedBlockSize *dvid.Point3d
 
+	// Cached block extents of elements for nearest element searches.
+	extents extentsCache
+
+	// Status of the current or last connectome export.
+	export connectomeExport
+
 	sync.RWMutex // For CAS ops.  TODO: Make more specific (e.g., point locks) for efficiency.
 }
 
@@ -876,7 +1119,7 @@ func (d *Data) Equals(d2 *Data) bool {
 	return reflect.DeepEqual(d.Properties, d2.Properties)
 }
 
-// blockSize is either defined by any synced labelblk or by the default block size.
+// blockSize is either defined by any synced label data or by the default block size.
 // Also checks to make sure that synced data is consistent.
 func (d *Data) blockSize() dvid.Point3d {
 	if d.cachedBlockSize != nil {
@@ -892,6 +1135,10 @@ func (d *Data) blockSize() dvid.Point3d {
 		bsize = lv.BlockSize
 		return bsize
 	}
+	if la := d.GetSyncedLabelarray(); la != nil {
+		bsize = la.BlockSize().(dvid.Point3d)
+		return bsize
+	}
 	bsize = dvid.Point3d{DefaultBlockSize, DefaultBlockSize, DefaultBlockSize}
 	return bsize
 }
@@ -916,13 +1163,53 @@ func (d *Data) GetSyncedLabelvol() *labelvol.Data {
 	return nil
 }
 
+func (d *Data) GetSyncedLabelarray() *labelarray.Data {
+	for dataUUID := range d.SyncedData() {
+		source, err := labelarray.GetByDataUUID(dataUUID)
+		if err == nil {
+			return source
+		}
+	}
+	return nil
+}
+
+// labelSource is synced label data that can be used to label annotations.
+type labelSource interface {
+	GetLabelAtPoint(v dvid.VersionID, pt dvid.Point) (uint64, error)
+	GetLabelBlock(v dvid.VersionID, bcoord dvid.ChunkPoint3d) ([]byte, error)
+}
+
+// labelarraySource uses the highest resolution labelarray blocks as a labelSource.
+type labelarraySource struct {
+	*labelarray.Data
+}
+
+func (la labelarraySource) GetLabelBlock(v dvid.VersionID, bcoord dvid.ChunkPoint3d) ([]byte, error) {
+	return la.Data.GetLabelBlock(v, 0, bcoord)
+}
+
+// returns the synced label data or nil if there is none.
+func (d *Data) getSyncedLabels() labelSource {
+	if lb := d.GetSyncedLabelblk(); lb != nil {
+		return lb
+	}
+	if la := d.GetSyncedLabelarray(); la != nil {
+		return labelarraySource{la}
+	}
+	return nil
+}
+
 // returns Elements with Relationships added by querying the block-indexed elements.
 func (d *Data) getExpandedElements(ctx *datastore.VersionedCtx, tk storage.TKey) (Elements, error) {
 	elems, err := getElements(ctx, tk)
 	if err != nil {
 		return elems, err
 	}
+	return d.expandElements(ctx, elems)
+}
 
+// returns the current block-indexed elements, including Relationships, for the given elements.
+func (d *Data) expandElements(ctx *datastore.VersionedCtx, elems Elements) (Elements, error) {
 	// Batch each element into blocks.
 	blockSize := d.blockSize()
 	blockE := make(blockElements)
@@ -1010,7 +1297,7 @@ func (d *Data) deleteElementInTags(ctx *datastore.VersionedCtx, batch storage.Ba
 }
 
 func (d *Data) deleteElementInLabel(ctx *datastore.VersionedCtx, batch storage.Batch, pt dvid.Point3d) error {
-	labelData := d.GetSyncedLabelblk()
+	labelData := d.getSyncedLabels()
 	if labelData == nil {
 		return nil // no synced labels
 	}
@@ -1114,7 +1401,7 @@ func (d *Data) moveElementInTags(ctx *datastore.VersionedCtx, batch storage.Batc
 }
 
 func (d *Data) moveElementInLabels(ctx *datastore.VersionedCtx, batch storage.Batch, from, to dvid.Point3d, moved ElementNR) error {
-	labelData := d.GetSyncedLabelblk()
+	labelData := d.getSyncedLabels()
 	if labelData == nil {
 		return nil // no label denormalization possible
 	}
@@ -1238,6 +1525,7 @@ func (d *Data) storeBlockElements(ctx *datastore.VersionedCtx, batch storage.Bat
 		if err := d.modifyElements(ctx, batch, tk, elems); err != nil {
 			return err
 		}
+		d.extents.extend(ctx.VersionID(), bcoord)
 	}
 	return nil
 }
@@ -1245,7 +1533,7 @@ func (d *Data) storeBlockElements(ctx *datastore.VersionedCtx, batch storage.Bat
 // stores synaptic elements arranged by label, replacing any
 // elements at same position.
 func (d *Data) storeLabelElements(ctx *datastore.VersionedCtx, batch storage.Batch, be blockElements) error {
-	labelData := d.GetSyncedLabelblk()
+	labelData := d.getSyncedLabels()
 	if labelData == nil {
 		dvid.Infof("No synced labels for annotation %q, skipping label-aware denormalization.\n", d.DataName())
 		return nil // no synced labels
@@ -1378,6 +1666,15 @@ func (d *Data) ProcessLabelAnnotations(v dvid.VersionID, f func(label uint64, el
 	return nil
 }
 
+// GetLabelAnnotations returns the elements, without relationships, of a given label.
+func (d *Data) GetLabelAnnotations(v dvid.VersionID, label uint64) (ElementsNR, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	return getElementsNR(ctx, NewLabelTKey(label))
+}
+
 // GetLabelJSON returns JSON for synapse elements in a given label.
 func (d *Data) GetLabelJSON(ctx *datastore.VersionedCtx, label uint64, addRels bool) ([]byte, error) {
 	d.RLock()
@@ -1477,6 +1774,14 @@ func (d *Data) GetRegionSynapses(ctx *datastore.VersionedCtx, ext *dvid.Extents3
 
 // GetROISynapses returns synapse elements for a given ROI.
 func (d *Data) GetROISynapses(ctx *datastore.VersionedCtx, roiSpec storage.FilterSpec) (Elements, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.getROISynapses(ctx, roiSpec)
+}
+
+// returns synapse elements for a given ROI.  This is private method and assumes outer locking.
+func (d *Data) getROISynapses(ctx *datastore.VersionedCtx, roiSpec storage.FilterSpec) (Elements, error) {
 	roidata, roiV, roiFound, err := roi.DataByFilter(roiSpec)
 	if err != nil {
 		return nil, fmt.Errorf("ROI specification was not parsable (%s): %v\n", roiSpec, err)
@@ -1491,15 +1796,16 @@ func (d *Data) GetROISynapses(ctx *datastore.VersionedCtx, roiSpec storage.Filte
 	if !d.blockSize().Equals(roidata.BlockSize) {
 		return nil, fmt.Errorf("/roi endpoint currently requires ROI %q to have same block size as annotation %q", roidata.DataName(), d.DataName())
 	}
+	masks, err := roidata.GetVoxelMasks(roiV)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get ROI voxel masks for %q: %v\n", roiSpec, err)
+	}
 
 	store, err := d.GetOrderedKeyValueDB()
 	if err != nil {
 		return nil, err
 	}
 
-	d.RLock()
-	defer d.RUnlock()
-
 	var elements Elements
 	for _, span := range roiSpans {
 		begBlockCoord := dvid.ChunkPoint3d{span[2], span[1], span[0]}
@@ -1511,7 +1817,11 @@ func (d *Data) GetROISynapses(ctx *datastore.VersionedCtx, roiSpec storage.Filte
 			if err := json.Unmarshal(chunk.V, &blockElems); err != nil {
 				return err
 			}
-			elements = append(elements, blockElems...)
+			for _, elem := range blockElems {
+				if !masks.Excludes(elem.Pos, roidata.BlockSize) {
+					elements = append(elements, elem)
+				}
+			}
 			return nil
 		})
 		if err != nil {
@@ -1537,12 +1847,18 @@ func (d *Data) StoreSynapses(ctx *datastore.VersionedCtx, r io.Reader) error {
 	defer d.Unlock()
 
 	dvid.Infof("%d synaptic elements received via POST", len(elems))
+	return d.storeElements(ctx, elems)
+}
 
+// stores elements and their denormalizations in a single batch.  This is private
+// method and assumes outer locking.
+func (d *Data) storeElements(ctx *datastore.VersionedCtx, elems Elements) error {
 	blockSize := d.blockSize()
 	blockE := make(blockElements)
 	tagE := make(tagElements)
+	propE := make(propElements)
 
-	// Iterate through elements, organizing them into blocks and tags.
+	// Iterate through elements, organizing them into blocks, tags, and indexed properties.
 	// Note: we do not check for redundancy and guarantee uniqueness at this stage.
 	for _, elem := range elems {
 		// Get block coord for this element.
@@ -1561,6 +1877,9 @@ func (d *Data) StoreSynapses(ctx *datastore.VersionedCtx, r io.Reader) error {
 				tagE[tag] = te
 			}
 		}
+
+		// Append to any indexed properties
+		d.addPropElement(propE, elem)
 	}
 
 	// Do modifications under a batch.
@@ -1589,6 +1908,11 @@ func (d *Data) StoreSynapses(ctx *datastore.VersionedCtx, r io.Reader) error {
 		return err
 	}
 
+	// Store the new indexed property elements
+	if err := d.storePropElements(ctx, batch, propE); err != nil {
+		return err
+	}
+
 	return batch.Commit()
 }
 
@@ -1638,6 +1962,11 @@ func (d *Data) DeleteElement(ctx *datastore.VersionedCtx, pt dvid.Point3d) error
 		return err
 	}
 
+	// Delete element in any indexed properties
+	if err := d.deleteElementInProps(ctx, batch, deleted.Pos, deleted.Prop); err != nil {
+		return err
+	}
+
 	// Modify any reference in relationships
 	if err := d.deleteElementInRelationships(ctx, batch, deleted.Pos, deleted.Rels); err != nil {
 		return err
@@ -1697,6 +2026,7 @@ func (d *Data) MoveElement(ctx *datastore.VersionedCtx, from, to dvid.Point3d) e
 		if err := putBatchElements(batch, toTk, toElems); err != nil {
 			return err
 		}
+		d.extents.extend(ctx.VersionID(), toCoord)
 	}
 
 	if err := batch.Commit(); err != nil {
@@ -1714,6 +2044,11 @@ func (d *Data) MoveElement(ctx *datastore.VersionedCtx, from, to dvid.Point3d) e
 		return err
 	}
 
+	// Move element in any indexed properties
+	if err := d.moveElementInProps(ctx, batch, from, to, moved.Prop); err != nil {
+		return err
+	}
+
 	// Move any reference in relationships
 	if err := d.moveElementInRelationships(ctx, batch, from, to, moved.Rels); err != nil {
 		return err
@@ -1780,11 +2115,21 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 		return
 	}
 
-	var numBlockE, numTagE int
-	var totBlockE, totTagE int
+	minPropTKey := storage.MinTKey(keyProp)
+	maxPropTKey := storage.MaxTKey(keyProp)
+	if err := store.DeleteRange(ctx, minPropTKey, maxPropTKey); err != nil {
+		dvid.Errorf("Unable to delete property denormalization for annotations %q: %v\n", d.DataName(), err)
+		d.Unlock()
+		d.StopUpdate()
+		return
+	}
+
+	var numBlockE, numTagE, numPropE int
+	var totBlockE, totTagE, totPropE int
 
 	blockE := make(blockElements)
 	tagE := make(tagElements)
+	propE := make(propElements)
 
 	minTKey := storage.MinTKey(keyBlock)
 	maxTKey := storage.MaxTKey(keyBlock)
@@ -1824,8 +2169,20 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 					tagE[tag] = te
 				}
 			}
+			if len(d.IndexedProps) > 0 {
+				d.addPropElement(propE, elem)
+				numPropE++
+			}
 		}
 
+		if numPropE > 1000 {
+			if err := d.storeProps(batcher, ctx, propE); err != nil {
+				return err
+			}
+			totPropE += numPropE
+			numPropE = 0
+			propE = make(propElements)
+		}
 		if numTagE > 1000 {
 			if err := d.storeTags(batcher, ctx, tagE); err != nil {
 				return err
@@ -1845,6 +2202,7 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 
 		return nil
 	})
+	propsIndexed := err == nil
 	if err != nil {
 		dvid.Errorf("Error in reload of data %q: %v\n", d.DataName(), err)
 	}
@@ -1860,10 +2218,30 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 			dvid.Errorf("Error writing final set of label elements of data %q: %v", err)
 		}
 	}
+	if numPropE > 0 {
+		totPropE += numPropE
+		if err := d.storeProps(batcher, ctx, propE); err != nil {
+			dvid.Errorf("Error writing final set of property elements of data %q: %v", d.DataName(), err)
+			propsIndexed = false
+		}
+	}
+	saveProps := propsIndexed && d.PartialPropIndex
+	if saveProps {
+		if err := d.setIndexedVersion(ctx.VersionID()); err != nil {
+			dvid.Errorf("Unable to record property indices of data %q: %v\n", d.DataName(), err)
+			saveProps = false
+		}
+	}
 	d.Unlock()
 	d.StopUpdate()
 
-	timedLog.Infof("Completed asynchronous annotation %q reload of %d block and %d tag elements.", d.DataName(), totBlockE, totTagE)
+	if saveProps {
+		if err := datastore.SaveDataByVersion(ctx.VersionID(), d); err != nil {
+			dvid.Errorf("Unable to save indexed versions of data %q: %v\n", d.DataName(), err)
+		}
+	}
+
+	timedLog.Infof("Completed asynchronous annotation %q reload of %d block, %d tag, and %d property elements.", d.DataName(), totBlockE, totTagE, totPropE)
 }
 
 func (d *Data) ReloadData(ctx *datastore.VersionedCtx) {
@@ -1907,9 +2285,11 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Base     *datastore.Data
 		Extended Properties
+		Export   *ExportStatus `json:",omitempty"`
 	}{
 		d.Data,
 		d.Properties,
+		d.export.get(),
 	})
 }
 
@@ -1992,6 +2372,34 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		fmt.Fprintln(w, dtype.Help())
 
 	case "info":
+		if action == "post" {
+			config, err := server.DecodeJSON(r)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			d.Lock()
+			changed, err := d.Properties.setByConfig(config)
+			if changed {
+				// Property indices of other versions are stale until reloaded.
+				d.PartialPropIndex = true
+				d.IndexedVersions = nil
+			}
+			d.Unlock()
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			if err := datastore.SaveDataByUUID(uuid, d); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			if changed {
+				d.ReloadData(ctx)
+			}
+			timedLog.Infof("HTTP %s: set indexed properties of %q to %v (%s)", r.Method, d.DataName(), d.IndexedProps, r.URL)
+			return
+		}
 		jsonBytes, err := d.MarshalJSON()
 		if err != nil {
 			server.BadRequest(w, r, err)
@@ -2066,6 +2474,316 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 		timedLog.Infof("HTTP %s: get synaptic elements for tag %s (%s)", r.Method, tag, r.URL)
 
+	case "query":
+		// GET <api URL>/node/<UUID>/<data name>/query?<conditions>
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET action is available on 'query' endpoint.")
+			return
+		}
+		q, err := ParseQuery(uuid, r.URL.RawQuery)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		elements, err := d.GetQueryElements(ctx, q)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		var jsonBytes []byte
+		if r.URL.Query().Get("relationships") == "true" {
+			jsonBytes, err = json.Marshal(elements)
+		} else {
+			elemsNR := make(ElementsNR, len(elements))
+			for i, elem := range elements {
+				elemsNR[i] = elem.ElementNR
+			}
+			jsonBytes, err = json.Marshal(elemsNR)
+		}
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		if _, err := w.Write(jsonBytes); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: %d synaptic elements for query (%s)", r.Method, len(elements), r.URL)
+
+	case "relationships":
+		// GET <api URL>/node/<UUID>/<data name>/relationships/<coord>
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET action is available on 'relationships' endpoint.")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "Must include coordinate after 'relationships' endpoint.")
+			return
+		}
+		pt, err := dvid.StringToPoint3d(parts[4], "_")
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		queryStrings := r.URL.Query()
+		depth := 1
+		if depthStr := queryStrings.Get("depth"); depthStr != "" {
+			if depth, err = strconv.Atoi(depthStr); err != nil || depth < 0 {
+				server.BadRequest(w, r, "bad depth %q given for relationships", depthStr)
+				return
+			}
+		}
+		rels, err := parseRelationTypes(queryStrings.Get("rel"))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		elements, err := d.GetRelationships(ctx, pt, depth, rels)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		jsonBytes, err := json.Marshal(elements)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		if _, err := w.Write(jsonBytes); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: %d related elements from %s (%s)", r.Method, len(elements), pt, r.URL)
+
+	case "nearest":
+		// GET <api URL>/node/<UUID>/<data name>/nearest/<coord>
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET action is available on 'nearest' endpoint.")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "Must include coordinate after 'nearest' endpoint.")
+			return
+		}
+		pt, err := dvid.StringToPoint3d(parts[4], "_")
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		queryStrings := r.URL.Query()
+		k := 1
+		if kStr := queryStrings.Get("k"); kStr != "" {
+			if k, err = strconv.Atoi(kStr); err != nil || k <= 0 {
+				server.BadRequest(w, r, "bad k %q given for nearest", kStr)
+				return
+			}
+		}
+		kinds, err := parseElementTypes(queryStrings.Get("kind"))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		maxdist := float64(defaultMaxNearestDist)
+		if maxdistStr := queryStrings.Get("maxdist"); maxdistStr != "" {
+			if maxdist, err = strconv.ParseFloat(maxdistStr, 64); err != nil || maxdist < 0 {
+				server.BadRequest(w, r, "bad maxdist %q given for nearest", maxdistStr)
+				return
+			}
+		}
+		elements, err := d.GetNearest(ctx, pt, k, kinds, maxdist)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		var jsonBytes []byte
+		if queryStrings.Get("relationships") == "true" {
+			jsonBytes, err = json.Marshal(elements)
+		} else {
+			elemsNR := make(ElementsNR, len(elements))
+			for i, elem := range elements {
+				elemsNR[i] = elem.ElementNR
+			}
+			jsonBytes, err = json.Marshal(elemsNR)
+		}
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		if _, err := w.Write(jsonBytes); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: %d nearest elements to %s (%s)", r.Method, len(elements), pt, r.URL)
+
+	case "connections":
+		// GET <api URL>/node/<UUID>/<data name>/connections/<label>
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET action is available on 'connections' endpoint.")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "Must include label after 'connections' endpoint.")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if label == 0 {
+			server.BadRequest(w, r, "Label 0 is protected background value and cannot be used for query.")
+			return
+		}
+		conns, err := d.GetConnections(ctx, label)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		jsonBytes, err := json.Marshal(conns)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		if _, err := w.Write(jsonBytes); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: connections for label %d (%s)", r.Method, label, r.URL)
+
+	case "export-connectome":
+		// POST <api URL>/node/<UUID>/<data name>/export-connectome?<options>
+		if action != "post" {
+			server.BadRequest(w, r, "Only POST action is available on 'export-connectome' endpoint.")
+			return
+		}
+		queryStrings := r.URL.Query()
+		kvName := queryStrings.Get("keyvalue")
+		if kvName == "" {
+			server.BadRequest(w, r, "Must specify 'keyvalue' destination for connectome export.")
+			return
+		}
+		kvUUID, kvV := uuid, ctx.VersionID()
+		if uuidStr := queryStrings.Get("kvuuid"); uuidStr != "" {
+			var err error
+			if kvUUID, kvV, err = datastore.MatchingUUID(uuidStr); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+		}
+		locked, err := datastore.LockedVersion(kvV)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if locked {
+			server.BadRequest(w, r, "Connectome export destination version %s is locked; specify an open version with 'kvuuid'.", kvUUID)
+			return
+		}
+		kvdata, err := datastore.GetDataByUUIDName(kvUUID, dvid.InstanceName(kvName))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		kv, ok := kvdata.(*keyvalue.Data)
+		if !ok {
+			server.BadRequest(w, r, "Data %q is not a keyvalue data instance", kvName)
+			return
+		}
+		key := queryStrings.Get("key")
+		if key == "" {
+			key = "connectome.csv"
+		}
+		kvctx := datastore.NewVersionedCtx(kv, kvV)
+		dest := fmt.Sprintf("keyvalue %q, key %q, version %s", kvName, key, kvUUID)
+		partKey := func(part int) string {
+			return fmt.Sprintf("%s.%05d", key, part)
+		}
+		write := func(part int, data []byte) error {
+			return kv.PutData(kvctx, partKey(part), data)
+		}
+		done := func(parts int) error {
+			// Delete any parts left from an earlier export to the same key.
+			for part := parts; ; part++ {
+				_, found, err := kv.GetData(kvctx, partKey(part))
+				if err != nil {
+					return err
+				}
+				if !found {
+					return nil
+				}
+				if err := kv.DeleteData(kvctx, partKey(part)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := d.StartConnectomeExport(ctx.VersionID(), dest, write, done); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: started connectome export of %q to %s (%s)", r.Method, d.DataName(), dest, r.URL)
+
+	case "import":
+		// POST <api URL>/node/<UUID>/<data name>/import?format=<format>
+		if action != "post" {
+			server.BadRequest(w, r, "Only POST action is available on 'import' endpoint.")
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = FormatNDJSON
+		}
+		numStored, err := d.ImportElements(ctx, r.Body, format)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: imported %d elements in %s format (%s)", r.Method, numStored, format, r.URL)
+
+	case "export":
+		// GET <api URL>/node/<UUID>/<data name>/export?<options>
+		if action != "get" {
+			server.BadRequest(w, r, "Only GET action is available on 'export' endpoint.")
+			return
+		}
+		queryStrings := r.URL.Query()
+		format := queryStrings.Get("format")
+		switch format {
+		case "", FormatNDJSON:
+			format = FormatNDJSON
+			w.Header().Set("Content-type", "application/x-ndjson")
+		case FormatMsgpack:
+			w.Header().Set("Content-type", "application/x-msgpack")
+		default:
+			server.BadRequest(w, r, "unknown bulk element format %q", format)
+			return
+		}
+		var roiSpec storage.FilterSpec
+		if roiStr := queryStrings.Get("roi"); roiStr != "" {
+			roiParts := strings.Split(roiStr, ",")
+			switch len(roiParts) {
+			case 1:
+				roiSpec = storage.FilterSpec("roi:" + roiStr + "," + string(uuid))
+			case 2:
+				roiSpec = storage.FilterSpec("roi:" + roiStr)
+			default:
+				server.BadRequest(w, r, "Bad ROI specification: %q", roiStr)
+				return
+			}
+		}
+		numWritten, started, err := d.ExportElements(ctx, w, format, roiSpec)
+		if err != nil {
+			if !started {
+				server.BadRequest(w, r, err)
+			} else {
+				dvid.Errorf("Export of %q ended with error after %d elements: %v\n", d.DataName(), numWritten, err)
+			}
+			return
+		}
+		timedLog.Infof("HTTP %s: exported %d elements in %s format (%s)", r.Method, numWritten, format, r.URL)
+
 	case "roi":
 		switch action {
 		case "get":
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"reflect"
 	"strings"
@@ -1042,6 +1043,119 @@ func TestLabels(t *testing.T) {
 	testResponseLabel(t, afterDeleteOn7, "%snode/%s/%s/label/7?relationships=true", server.WebAPIPath, uuid, "renamedData")
 }
 
+func TestLabelarrayLabels(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed labelarray, which provides both label blocks and merge/split events.
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	_ = createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	config.Clear()
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	server.CreateTestSync(t, uuid, "mysynapses", "labels")
+
+	testJSON, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url1 := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(testJSON)))
+
+	testResponseLabel(t, expectedLabel1, "%snode/%s/mysynapses/label/1?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel2, "%snode/%s/mysynapses/label/2?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel3, "%snode/%s/mysynapses/label/3?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel4, "%snode/%s/mysynapses/label/4?relationships=true", server.WebAPIPath, uuid)
+
+	// Mutate the labelarray and make sure our label synapses have been adjusted.
+	_ = modifyLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on sync of labels->annotations: %v\n", err)
+	}
+
+	testResponseLabel(t, expectedLabel1, "%snode/%s/mysynapses/label/1?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel2a, "%snode/%s/mysynapses/label/2?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel3a, "%snode/%s/mysynapses/label/3?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel4, "%snode/%s/mysynapses/label/4?relationships=true", server.WebAPIPath, uuid)
+
+	// Merge 3a into 2a using the labelarray.
+	testMerge := mergeJSON(`[2, 3]`)
+	testMerge.send(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on sync of synapses: %v\n", err)
+	}
+
+	testResponseLabel(t, expectedLabel1, "%snode/%s/mysynapses/label/1?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel2b, "%snode/%s/mysynapses/label/2?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, nil, "%snode/%s/mysynapses/label/3?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel4, "%snode/%s/mysynapses/label/4?relationships=true", server.WebAPIPath, uuid)
+}
+
+func TestMappedLabelarraySplit(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	config.Set("MappedLabels", "true")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	_ = createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	config.Clear()
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	server.CreateTestSync(t, uuid, "mysynapses", "labels")
+
+	testJSON, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url1 := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(testJSON)))
+
+	// Merge body 3 into body 2 so supervoxel 3 is mapped to body 2.
+	testMerge := mergeJSON(`[2, 3]`)
+	testMerge.send(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on sync of synapses: %v\n", err)
+	}
+	testResponseLabel(t, nil, "%snode/%s/mysynapses/label/3?relationships=true", server.WebAPIPath, uuid)
+
+	// Split all of supervoxel 3 out of body 2, which should move its annotations from body 2.
+	rles := make(dvid.RLEs, len(body3.voxelSpans))
+	for i, span := range body3.voxelSpans {
+		rles[i] = dvid.NewRLE(dvid.Point3d{span[2], span[1], span[0]}, span[3]-span[2]+1)
+	}
+	reqStr := fmt.Sprintf("%snode/%s/labels/split/3?splitlabel=9", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", reqStr, getBytesRLE(t, rles))
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on sync of split->annotations: %v\n", err)
+	}
+	testResponseLabel(t, expectedLabel2, "%snode/%s/mysynapses/label/2?relationships=true", server.WebAPIPath, uuid)
+	testResponseLabel(t, expectedLabel3, "%snode/%s/mysynapses/label/9?relationships=true", server.WebAPIPath, uuid)
+}
+
 func TestLabelsReload(t *testing.T) {
 	datastore.OpenTest()
 	defer datastore.CloseTest()
@@ -1292,3 +1406,428 @@ var (
 	body3a    = bodies[5]
 	bodysplit = bodies[6]
 )
+
+var testPropData = Elements{
+	{
+		ElementNR{
+			Pos:  dvid.Point3d{10, 10, 10},
+			Kind: PreSyn,
+			Prop: map[string]string{"user": "alice", "conf": "0.95"},
+		},
+		[]Relationship{{Rel: PreSynTo, To: dvid.Point3d{20, 20, 20}}},
+	},
+	{
+		ElementNR{
+			Pos:  dvid.Point3d{20, 20, 20},
+			Kind: PostSyn,
+			Prop: map[string]string{"user": "bob", "conf": "0.5"},
+		},
+		[]Relationship{{Rel: PostSynTo, To: dvid.Point3d{10, 10, 10}}},
+	},
+	{
+		ElementNR{
+			Pos:  dvid.Point3d{30, 30, 30},
+			Kind: PostSyn,
+			Tags: []Tag{"T1"},
+			Prop: map[string]string{"user": "alice", "conf": "0.8"},
+		},
+		[]Relationship{},
+	},
+	{
+		ElementNR{
+			Pos:  dvid.Point3d{40, 40, 40},
+			Kind: Note,
+			Prop: map[string]string{"note": "x"},
+		},
+		[]Relationship{},
+	},
+}
+
+func TestPropQuery(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	config := dvid.NewConfig()
+	config.Set("IndexedProps", "user,conf")
+	dataservice, err := datastore.NewData(uuid, syntype, "mysynapses", config)
+	if err != nil {
+		t.Fatalf("Error creating new data instance: %v\n", err)
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not synapse.Data\n")
+	}
+	if !reflect.DeepEqual(data.IndexedProps, []string{"conf", "user"}) {
+		t.Fatalf("Expected indexed props [conf user], got %v\n", data.IndexedProps)
+	}
+
+	testJSON, err := json.Marshal(testPropData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url1 := fmt.Sprintf("%snode/%s/%s/elements", server.WebAPIPath, uuid, data.DataName())
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(testJSON)))
+
+	queryURL := server.WebAPIPath + "node/%s/mysynapses/query?"
+	elemA, elemB, elemC, elemD := testPropData[0], testPropData[1], testPropData[2], testPropData[3]
+
+	// Indexed equality, with and without other conditions.
+	testResponse(t, Elements{elemA, elemC}, queryURL+"prop.user=alice&relationships=true", uuid)
+	testResponse(t, Elements{elemA}, queryURL+"prop.user=alice&prop.conf>=0.9&relationships=true", uuid)
+	testResponse(t, Elements{elemC}, queryURL+"tag=T1&prop.user=alice&relationships=true", uuid)
+
+	// Indexed inequality, which compares numerically.
+	testResponse(t, Elements{elemB, elemC}, queryURL+"prop.conf<0.9&relationships=true", uuid)
+	testResponse(t, Elements{elemA}, queryURL+"prop.conf>0.8&relationships=true", uuid)
+
+	// Non-indexed property requires scan of all elements.
+	testResponse(t, Elements{elemD}, queryURL+"prop.note=x&relationships=true", uuid)
+	testResponse(t, Elements{elemB}, queryURL+"prop.user!=alice&relationships=true", uuid)
+
+	// Bad condition.
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(queryURL+"prop.user", uuid), nil)
+
+	// Modify an element's property and make sure old index doesn't return it.
+	modified := *(elemC.Copy())
+	modified.Prop["user"] = "bob"
+	testJSON, err = json.Marshal(Elements{modified})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(testJSON)))
+	testResponse(t, Elements{elemA}, queryURL+"prop.user=alice&relationships=true", uuid)
+	testResponse(t, Elements{elemB, modified}, queryURL+"prop.user=bob&relationships=true", uuid)
+
+	// Delete and move elements.
+	delURL := fmt.Sprintf("%snode/%s/%s/element/10_10_10", server.WebAPIPath, uuid, data.DataName())
+	server.TestHTTP(t, "DELETE", delURL, nil)
+	testResponse(t, Elements{}, queryURL+"prop.user=alice", uuid)
+
+	moveURL := fmt.Sprintf("%snode/%s/%s/move/20_20_20/21_21_21", server.WebAPIPath, uuid, data.DataName())
+	server.TestHTTP(t, "POST", moveURL, nil)
+	movedB := *(elemB.ElementNR.Copy())
+	movedB.Pos = dvid.Point3d{21, 21, 21}
+	testResponseLabel(t, ElementsNR{movedB}, queryURL+"prop.conf<0.6", uuid)
+
+	// Change the indexed properties via info POST, which reindexes asynchronously.
+	infoURL := fmt.Sprintf("%snode/%s/%s/info", server.WebAPIPath, uuid, data.DataName())
+	server.TestHTTP(t, "POST", infoURL, strings.NewReader(`{"IndexedProps": "note"}`))
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on update for annotations: %v\n", err)
+	}
+	if !reflect.DeepEqual(data.IndexedProps, []string{"note"}) {
+		t.Fatalf("Expected indexed props [note], got %v\n", data.IndexedProps)
+	}
+	testResponseLabel(t, ElementsNR{elemD.ElementNR}, queryURL+"prop.note=x", uuid)
+
+	// Changing the indexed properties in a child version leaves the parent's property indices
+	// stale, so parent queries must check all elements.
+	if err := datastore.Commit(uuid, "indexed note", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "indexed user", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	childInfoURL := fmt.Sprintf("%snode/%s/%s/info", server.WebAPIPath, child, data.DataName())
+	server.TestHTTP(t, "POST", childInfoURL, strings.NewReader(`{"IndexedProps": "user"}`))
+	if err := datastore.BlockOnUpdating(child, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on update for annotations: %v\n", err)
+	}
+	rootV, err := datastore.VersionFromUUID(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	childV, err := datastore.VersionFromUUID(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.isIndexed(rootV, "user") {
+		t.Errorf("Expected stale property index for root version\n")
+	}
+	if !data.isIndexed(childV, "user") {
+		t.Errorf("Expected rebuilt property index for child version\n")
+	}
+	expected := ElementsNR{movedB, modified.ElementNR}
+	testResponseLabel(t, expected, queryURL+"prop.user=bob", uuid)
+	testResponseLabel(t, expected, queryURL+"prop.user=bob", child)
+}
+
+func TestRelationships(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelblk", "labels", config)
+	_ = createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	testJSON, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url1 := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(testJSON)))
+
+	// Traverse relationships from the first T-bar and from a GroupedWith PSD.
+	relURL := server.WebAPIPath + "node/%s/mysynapses/relationships/%s"
+	testResponse(t, Elements{testData[0]}, relURL+"?depth=0", uuid, "15_27_35")
+	testResponse(t, testData[0:4], relURL, uuid, "15_27_35")
+	testResponse(t, testData[0:4], relURL+"?depth=5", uuid, "15_27_35")
+	testResponse(t, Elements{testData[5], testData[2], testData[4], testData[1]}, relURL, uuid, "88_47_80")
+	expected := Elements{testData[5], testData[2], testData[4], testData[1], testData[0], testData[6], testData[7]}
+	testResponse(t, expected, relURL+"?depth=2", uuid, "88_47_80")
+	testResponse(t, Elements{testData[5], testData[4]}, relURL+"?depth=2&rel=PostSynTo", uuid, "88_47_80")
+	testResponse(t, Elements{testData[5], testData[2], testData[1]}, relURL+"?depth=2&rel=GroupedWith", uuid, "88_47_80")
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(relURL, uuid, "1_2_3"), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(relURL+"?rel=Foo", uuid, "15_27_35"), nil)
+
+	// Connections require a synced labelblk.
+	connURL := server.WebAPIPath + "node/%s/mysynapses/connections/%d"
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(connURL, uuid, 1), nil)
+	server.CreateTestSync(t, uuid, "mysynapses", "labels")
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/mysynapses/reload", server.WebAPIPath, uuid), nil)
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on reload of annotations: %v\n", err)
+	}
+
+	expectedConns := map[uint64]Connections{
+		1: {Label: 1, Outputs: []Connection{{2, 1}, {3, 1}}, Inputs: []Connection{}},
+		3: {Label: 3, Outputs: []Connection{{4, 1}}, Inputs: []Connection{{1, 1}}},
+		4: {Label: 4, Outputs: []Connection{}, Inputs: []Connection{{3, 1}}},
+	}
+	for label, expected := range expectedConns {
+		returnValue := server.TestHTTP(t, "GET", fmt.Sprintf(connURL, uuid, label), nil)
+		var got Connections
+		if err := json.Unmarshal(returnValue, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Fatalf("Expected connections for label %d:\n%v\nGot:\n%v\n", label, expected, got)
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	testJSON, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url1 := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(testJSON)))
+
+	nearURL := server.WebAPIPath + "node/%s/mysynapses/nearest/%s"
+	tests := []struct {
+		query    string
+		coord    string
+		expected []int // indices into testData in order of distance
+	}{
+		{"", "15_27_35", []int{0}},
+		{"?k=3", "15_27_35", []int{0, 2, 1}},
+		{"?k=10", "15_27_35", []int{0, 2, 1, 3, 5, 6, 7, 4}},
+		{"?k=10&kind=PostSyn", "15_27_35", []int{2, 1, 3, 5, 6, 7}},
+		{"?k=10&kind=PreSyn", "88_47_80", []int{4, 0}},
+		{"?k=10&maxdist=5", "15_27_35", []int{0, 2}},
+		{"?k=10&maxdist=100", "16_26_36", []int{0, 2, 1, 3, 5}},
+		{"?k=2", "125_66_99", []int{7, 4}},
+		{"?k=10&maxdist=100", "1000_1000_1000", []int{}},
+		{"?maxdist=10000", "-5000_25_37", []int{2}},
+	}
+	for _, tc := range tests {
+		returnValue := server.TestHTTP(t, "GET", fmt.Sprintf(nearURL+tc.query, uuid, tc.coord), nil)
+		var got ElementsNR
+		if err := json.Unmarshal(returnValue, &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.expected) {
+			t.Fatalf("Expected %d elements for nearest %s%s, got %d: %v\n", len(tc.expected), tc.coord, tc.query, len(got), got)
+		}
+		for i, j := range tc.expected {
+			if !got[i].Pos.Equals(testData[j].Pos) {
+				t.Fatalf("Expected element %d for nearest %s%s to be at %s, got %v\n", i, tc.coord, tc.query, testData[j].Pos, got)
+			}
+		}
+	}
+	testResponse(t, Elements{testData[0], testData[2]}, nearURL+"?k=2&relationships=true", uuid, "15_27_35")
+
+	// Elements added outside the searched extents should be found.
+	farElem := Elements{{ElementNR{Pos: dvid.Point3d{3000, 3000, 3000}, Kind: PostSyn}, []Relationship{}}}
+	farJSON, err := json.Marshal(farElem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(farJSON)))
+	testResponse(t, farElem, nearURL+"?relationships=true", uuid, "2990_3000_3000")
+
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(nearURL+"?k=0", uuid, "15_27_35"), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(nearURL+"?kind=Foo", uuid, "15_27_35"), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(nearURL+"?maxdist=-1", uuid, "15_27_35"), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(nearURL+"?maxdist=20000", uuid, "15_27_35"), nil)
+}
+
+func TestConnectomeExport(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "labelblk", "labels", config)
+	server.CreateTestInstance(t, uuid, "keyvalue", "exports", config)
+	_ = createLabelTestVolume(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	server.CreateTestSync(t, uuid, "mysynapses", "labels")
+	testJSON, err := json.Marshal(testData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url1 := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url1, strings.NewReader(string(testJSON)))
+
+	exportURL := fmt.Sprintf("%snode/%s/mysynapses/export-connectome", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", exportURL, nil)
+	server.TestBadHTTP(t, "POST", exportURL+"?keyvalue=labels", nil)
+	server.TestHTTP(t, "POST", exportURL+"?keyvalue=exports&key=conn.csv", nil)
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on connectome export: %v\n", err)
+	}
+
+	// Unlabeled PostSyn elements are ignored.
+	expected := "pre_body,post_body,count\n1,2,1\n1,3,1\n3,4,1\n"
+	kvURL := fmt.Sprintf("%snode/%s/exports/key/conn.csv.00000", server.WebAPIPath, uuid)
+	if got := string(server.TestHTTP(t, "GET", kvURL, nil)); got != expected {
+		t.Fatalf("Expected connectome:\n%s\nGot:\n%s\n", expected, got)
+	}
+
+	infoURL := fmt.Sprintf("%snode/%s/mysynapses/info", server.WebAPIPath, uuid)
+	var info struct {
+		Export ExportStatus
+	}
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", infoURL, nil), &info); err != nil {
+		t.Fatal(err)
+	}
+	status := info.Export
+	if status.Stage != ExportDone || status.Labels != 4 || status.PreSyn != 2 || status.PreSynResolved != 2 || status.Connections != 3 || status.Parts != 1 {
+		t.Fatalf("Bad export status after completion: %v\n", status)
+	}
+
+	// Export in small parts, each holding complete lines.
+	partSize := exportPartSize
+	exportPartSize = 31
+	server.TestHTTP(t, "POST", exportURL+"?keyvalue=exports&key=conn.csv", nil)
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on connectome export: %v\n", err)
+	}
+	expectedParts := []string{"pre_body,post_body,count\n1,2,1\n", "1,3,1\n3,4,1\n"}
+	for part, expectedPart := range expectedParts {
+		kvURL = fmt.Sprintf("%snode/%s/exports/key/conn.csv.%05d", server.WebAPIPath, uuid, part)
+		if got := string(server.TestHTTP(t, "GET", kvURL, nil)); got != expectedPart {
+			t.Fatalf("Expected connectome part %d:\n%s\nGot:\n%s\n", part, expectedPart, got)
+		}
+	}
+
+	// A smaller export to the same key deletes the extra parts.
+	exportPartSize = partSize
+	server.TestHTTP(t, "POST", exportURL+"?keyvalue=exports&key=conn.csv", nil)
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on connectome export: %v\n", err)
+	}
+	kvURL = fmt.Sprintf("%snode/%s/exports/key/conn.csv.00001", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", kvURL, nil)
+
+	// Export of a locked version must be stored in an open version.
+	if err := datastore.Commit(uuid, "exported", nil); err != nil {
+		t.Fatalf("Unable to commit root: %v\n", err)
+	}
+	child, err := datastore.NewVersion(uuid, "export destination", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child: %v\n", err)
+	}
+	server.TestBadHTTP(t, "POST", exportURL+"?keyvalue=exports&key=locked.csv", nil)
+	server.TestBadHTTP(t, "POST", exportURL+"?keyvalue=exports&file=/tmp/conn.csv", nil)
+	server.TestHTTP(t, "POST", exportURL+"?keyvalue=exports&key=locked.csv&kvuuid="+string(child), nil)
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on connectome export: %v\n", err)
+	}
+	kvURL = fmt.Sprintf("%snode/%s/exports/key/locked.csv.00000", server.WebAPIPath, child)
+	if got := string(server.TestHTTP(t, "GET", kvURL, nil)); got != expected {
+		t.Fatalf("Expected connectome in child version:\n%s\nGot:\n%s\n", expected, got)
+	}
+}
+
+func decodeNDJSON(t *testing.T, data []byte) Elements {
+	elems := Elements{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var elem Element
+		if err := dec.Decode(&elem); err != nil {
+			if err == io.EOF {
+				return elems
+			}
+			t.Fatalf("Error decoding NDJSON: %v\n", err)
+		}
+		elems = append(elems, elem)
+	}
+}
+
+func TestBulkImportExport(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	server.CreateTestInstance(t, uuid, "annotation", "copied", config)
+
+	// Import as NDJSON.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, elem := range testData {
+		if err := enc.Encode(elem); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apiStr := server.WebAPIPath + "node/%s/%s/%s"
+	server.TestHTTP(t, "POST", fmt.Sprintf(apiStr+"?format=ndjson", uuid, "mysynapses", "import"), &buf)
+	testResponse(t, testData, "%snode/%s/mysynapses/elements/1000_1000_1000/0_0_0", server.WebAPIPath, uuid)
+
+	exported := server.TestHTTP(t, "GET", fmt.Sprintf(apiStr, uuid, "mysynapses", "export"), nil)
+	if got := decodeNDJSON(t, exported); !reflect.DeepEqual(testData.Normalize(), got.Normalize()) {
+		t.Fatalf("Expected NDJSON export:\n%v\nGot:\n%v\n", testData.Normalize(), got.Normalize())
+	}
+
+	// Round-trip through msgpack into another instance.
+	exported = server.TestHTTP(t, "GET", fmt.Sprintf(apiStr+"?format=msgpack", uuid, "mysynapses", "export"), nil)
+	server.TestHTTP(t, "POST", fmt.Sprintf(apiStr+"?format=msgpack", uuid, "copied", "import"), bytes.NewBuffer(exported))
+	testResponse(t, testData, "%snode/%s/copied/elements/1000_1000_1000/0_0_0", server.WebAPIPath, uuid)
+	testResponse(t, getTag("Synapse2", testData), "%snode/%s/copied/tag/Synapse2?relationships=true", server.WebAPIPath, uuid)
+
+	// Truncated msgpack, error records, and unknown formats are errors.
+	server.TestBadHTTP(t, "POST", fmt.Sprintf(apiStr+"?format=msgpack", uuid, "copied", "import"), bytes.NewBuffer(exported[:len(exported)-3]))
+	server.TestBadHTTP(t, "POST", fmt.Sprintf(apiStr+"?format=ndjson", uuid, "copied", "import"), strings.NewReader(`{"Error": "export failed"}`))
+	server.TestBadHTTP(t, "GET", fmt.Sprintf(apiStr+"?format=xml", uuid, "mysynapses", "export"), nil)
+
+	// Export with ROI filtering.
+	server.CreateTestInstance(t, uuid, "roi", "myroi", config)
+	roiURL := fmt.Sprintf("%snode/%s/myroi/roi", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", roiURL, bytes.NewBufferString(labelsJSON()))
+	exported = server.TestHTTP(t, "GET", fmt.Sprintf(apiStr+"?roi=myroi", uuid, "mysynapses", "export"), nil)
+	if got := decodeNDJSON(t, exported); !reflect.DeepEqual(expectedROI.Normalize(), got.Normalize()) {
+		t.Fatalf("Expected ROI export:\n%v\nGot:\n%v\n", expectedROI.Normalize(), got.Normalize())
+	}
+}
@@ -0,0 +1,508 @@
+/*
+	This file supports streaming bulk import and export of elements using newline-delimited
+	JSON or MessagePack.
+*/
+
+package annotation
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Maximum number of elements stored in one batch during bulk import.
+const importBatchSize = 10000
+
+// Maximum number of elements read while the data is locked during bulk export.
+const exportBatchSize = 10000
+
+// Maximum number of relationships, tags, or properties of a MessagePack-encoded element.
+const maxMsgpackItems = 65535
+
+// Bulk formats for import and export.
+const (
+	FormatNDJSON  = "ndjson"
+	FormatMsgpack = "msgpack"
+)
+
+// elementReader returns the next element of a stream or io.EOF if there are no more elements.
+// An error record written by a failed export is returned as an error.
+type elementReader interface {
+	next() (*Element, error)
+}
+
+// elementWriter writes elements to a stream and must be flushed at end.  If an export fails
+// after elements have been written, writeError ends the stream with an error record.
+type elementWriter interface {
+	write(elem *Element) error
+	writeError(err error) error
+	flush() error
+}
+
+func newElementReader(r io.Reader, format string) (elementReader, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonReader{json.NewDecoder(r)}, nil
+	case FormatMsgpack:
+		return &msgpackReader{msgp.NewReader(r)}, nil
+	default:
+		return nil, fmt.Errorf("unknown bulk element format %q", format)
+	}
+}
+
+func newElementWriter(w io.Writer, format string) (elementWriter, error) {
+	switch format {
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonWriter{bw, json.NewEncoder(bw)}, nil
+	case FormatMsgpack:
+		return &msgpackWriter{msgp.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown bulk element format %q", format)
+	}
+}
+
+// ndjsonRecord is an element or, at the end of a failed export, an error.
+type ndjsonRecord struct {
+	Element
+	Error string `json:",omitempty"`
+}
+
+type ndjsonReader struct {
+	dec *json.Decoder
+}
+
+func (r *ndjsonReader) next() (*Element, error) {
+	var rec ndjsonRecord
+	if err := r.dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	if rec.Error != "" {
+		return nil, fmt.Errorf("stream ended with export error: %s", rec.Error)
+	}
+	return &rec.Element, nil
+}
+
+type ndjsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// Encode() terminates each element with a newline.
+func (w *ndjsonWriter) write(elem *Element) error {
+	return w.enc.Encode(elem)
+}
+
+func (w *ndjsonWriter) writeError(err error) error {
+	return w.enc.Encode(struct{ Error string }{err.Error()})
+}
+
+func (w *ndjsonWriter) flush() error {
+	return w.w.Flush()
+}
+
+// returns the JSON name of an element type or relationship type, e.g., "PreSyn".
+func jsonName(m json.Marshaler) (string, error) {
+	b, err := m.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return strconv.Unquote(string(b))
+}
+
+// msgpackReader reads elements encoded as MessagePack maps with the same keys and values
+// as the JSON format, where positions are arrays of three integers.
+type msgpackReader struct {
+	r *msgp.Reader
+}
+
+func (r *msgpackReader) readPoint() (pt dvid.Point3d, err error) {
+	var n uint32
+	if n, err = r.r.ReadArrayHeader(); err != nil {
+		return
+	}
+	if n != 3 {
+		err = fmt.Errorf("expected 3 coordinates in position, got %d", n)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		if pt[i], err = r.r.ReadInt32(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *msgpackReader) readLength(readHeader func() (uint32, error), what string) (int, error) {
+	n, err := readHeader()
+	if err != nil {
+		return 0, err
+	}
+	if n > maxMsgpackItems {
+		return 0, fmt.Errorf("%d %s exceeds maximum of %d", n, what, maxMsgpackItems)
+	}
+	return int(n), nil
+}
+
+func (r *msgpackReader) readRelationship() (rel Relationship, err error) {
+	var n uint32
+	if n, err = r.r.ReadMapHeader(); err != nil {
+		return
+	}
+	for i := uint32(0); i < n; i++ {
+		var key, name string
+		if key, err = r.r.ReadString(); err != nil {
+			return
+		}
+		switch key {
+		case "Rel":
+			if name, err = r.r.ReadString(); err != nil {
+				return
+			}
+			if err = rel.Rel.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+				return
+			}
+		case "To":
+			if rel.To, err = r.readPoint(); err != nil {
+				return
+			}
+		default:
+			if err = r.r.Skip(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (r *msgpackReader) next() (*Element, error) {
+	n, err := r.r.ReadMapHeader()
+	if err != nil {
+		return nil, err // io.EOF if at clean end of stream
+	}
+	elem, err := r.readElement(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return elem, err
+}
+
+func (r *msgpackReader) readElement(numKeys uint32) (*Element, error) {
+	var elem Element
+	for i := uint32(0); i < numKeys; i++ {
+		key, err := r.r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case "Pos":
+			if elem.Pos, err = r.readPoint(); err != nil {
+				return nil, err
+			}
+		case "Kind":
+			name, err := r.r.ReadString()
+			if err != nil {
+				return nil, err
+			}
+			if err := elem.Kind.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+				return nil, err
+			}
+		case "Rels":
+			n, err := r.readLength(r.r.ReadArrayHeader, "relationships")
+			if err != nil {
+				return nil, err
+			}
+			elem.Rels = make(Relationships, n)
+			for j := range elem.Rels {
+				if elem.Rels[j], err = r.readRelationship(); err != nil {
+					return nil, err
+				}
+			}
+		case "Tags":
+			n, err := r.readLength(r.r.ReadArrayHeader, "tags")
+			if err != nil {
+				return nil, err
+			}
+			elem.Tags = make(Tags, n)
+			for j := range elem.Tags {
+				tag, err := r.r.ReadString()
+				if err != nil {
+					return nil, err
+				}
+				elem.Tags[j] = Tag(tag)
+			}
+		case "Prop":
+			n, err := r.readLength(r.r.ReadMapHeader, "properties")
+			if err != nil {
+				return nil, err
+			}
+			elem.Prop = make(map[string]string, n)
+			for j := 0; j < n; j++ {
+				key, err := r.r.ReadString()
+				if err != nil {
+					return nil, err
+				}
+				if elem.Prop[key], err = r.r.ReadString(); err != nil {
+					return nil, err
+				}
+			}
+		case "Error":
+			msg, err := r.r.ReadString()
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("stream ended with export error: %s", msg)
+		default:
+			if err := r.r.Skip(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &elem, nil
+}
+
+type msgpackWriter struct {
+	w *msgp.Writer
+}
+
+func (w *msgpackWriter) writePoint(pt dvid.Point3d) error {
+	if err := w.w.WriteArrayHeader(3); err != nil {
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if err := w.w.WriteInt32(pt[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes a key and a string value.
+func (w *msgpackWriter) writeField(key, value string) error {
+	if err := w.w.WriteString(key); err != nil {
+		return err
+	}
+	return w.w.WriteString(value)
+}
+
+func (w *msgpackWriter) write(elem *Element) error {
+	kind, err := jsonName(elem.Kind)
+	if err != nil {
+		return err
+	}
+	numKeys := uint32(2)
+	for _, n := range []int{len(elem.Rels), len(elem.Tags), len(elem.Prop)} {
+		if n > 0 {
+			numKeys++
+		}
+	}
+	if err := w.w.WriteMapHeader(numKeys); err != nil {
+		return err
+	}
+	if err := w.w.WriteString("Pos"); err != nil {
+		return err
+	}
+	if err := w.writePoint(elem.Pos); err != nil {
+		return err
+	}
+	if err := w.writeField("Kind", kind); err != nil {
+		return err
+	}
+	if len(elem.Rels) > 0 {
+		if err := w.w.WriteString("Rels"); err != nil {
+			return err
+		}
+		if err := w.w.WriteArrayHeader(uint32(len(elem.Rels))); err != nil {
+			return err
+		}
+		for _, rel := range elem.Rels {
+			name, err := jsonName(rel.Rel)
+			if err != nil {
+				return err
+			}
+			if err := w.w.WriteMapHeader(2); err != nil {
+				return err
+			}
+			if err := w.writeField("Rel", name); err != nil {
+				return err
+			}
+			if err := w.w.WriteString("To"); err != nil {
+				return err
+			}
+			if err := w.writePoint(rel.To); err != nil {
+				return err
+			}
+		}
+	}
+	if len(elem.Tags) > 0 {
+		if err := w.w.WriteString("Tags"); err != nil {
+			return err
+		}
+		if err := w.w.WriteArrayHeader(uint32(len(elem.Tags))); err != nil {
+			return err
+		}
+		for _, tag := range elem.Tags {
+			if err := w.w.WriteString(string(tag)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(elem.Prop) > 0 {
+		if err := w.w.WriteString("Prop"); err != nil {
+			return err
+		}
+		if err := w.w.WriteMapHeader(uint32(len(elem.Prop))); err != nil {
+			return err
+		}
+		for key, value := range elem.Prop {
+			if err := w.writeField(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *msgpackWriter) writeError(err error) error {
+	if err := w.w.WriteMapHeader(1); err != nil {
+		return err
+	}
+	return w.writeField("Error", err.Error())
+}
+
+func (w *msgpackWriter) flush() error {
+	return w.w.Flush()
+}
+
+// ImportElements stores a stream of elements in the given format, returning the number of
+// elements stored.  Elements are stored with their denormalizations in batches of bounded
+// size, so if an error occurs, elements in earlier batches will have been stored.
+func (d *Data) ImportElements(ctx *datastore.VersionedCtx, r io.Reader, format string) (int, error) {
+	er, err := newElementReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+	var numStored int
+	elems := make(Elements, 0, importBatchSize)
+	store := func() error {
+		d.Lock()
+		err := d.storeElements(ctx, elems)
+		d.Unlock()
+		if err != nil {
+			return fmt.Errorf("error after storing %d elements: %v", numStored, err)
+		}
+		numStored += len(elems)
+		elems = elems[:0]
+		return nil
+	}
+	for {
+		elem, err := er.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return numStored, fmt.Errorf("error reading element %d: %v", numStored+len(elems), err)
+		}
+		elems = append(elems, *elem)
+		if len(elems) == importBatchSize {
+			if err := store(); err != nil {
+				return numStored, err
+			}
+		}
+	}
+	if len(elems) != 0 {
+		if err := store(); err != nil {
+			return numStored, err
+		}
+	}
+	return numStored, nil
+}
+
+// ExportElements writes all elements, with relationships, to a stream in the given format,
+// returning the number of elements written.  If an ROI is specified, only elements within
+// the ROI are written.  Elements are read in batches and the data is only locked while
+// reading a batch, so an export of an open version being modified may mix elements from
+// before and after a modification.  If an error occurs after elements have been written,
+// the stream is ended with an error record and the returned started flag is true.
+func (d *Data) ExportElements(ctx *datastore.VersionedCtx, w io.Writer, format string, roiSpec storage.FilterSpec) (numWritten int, started bool, err error) {
+	var roiCheck func(pt dvid.Point3d) bool
+	if roiSpec != "" {
+		if roiCheck, err = inROI(roiSpec); err != nil {
+			return
+		}
+	}
+	var ew elementWriter
+	if ew, err = newElementWriter(w, format); err != nil {
+		return
+	}
+	var store storage.OrderedKeyValueDB
+	if store, err = d.GetOrderedKeyValueDB(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil && started {
+			if recErr := ew.writeError(err); recErr == nil {
+				ew.flush()
+			}
+		}
+	}()
+
+	batchFull := fmt.Errorf("export batch full")
+	begTKey := storage.MinTKey(keyBlock)
+	endTKey := storage.MaxTKey(keyBlock)
+	for {
+		var batch Elements
+		var nextTKey storage.TKey
+		d.RLock()
+		err = store.ProcessRange(ctx, begTKey, endTKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+			if c == nil || c.V == nil {
+				return nil
+			}
+			var elems Elements
+			if err := json.Unmarshal(c.V, &elems); err != nil {
+				return err
+			}
+			for _, elem := range elems {
+				if roiCheck == nil || roiCheck(elem.Pos) {
+					batch = append(batch, elem)
+				}
+			}
+			if len(batch) >= exportBatchSize {
+				// Continue after this key, i.e., at the key with an appended zero byte.
+				nextTKey = append(append(storage.TKey{}, c.K...), 0)
+				return batchFull
+			}
+			return nil
+		})
+		d.RUnlock()
+		full := err == batchFull
+		if err != nil && !full {
+			return
+		}
+		started = true
+		for i := range batch {
+			if err = ew.write(&batch[i]); err != nil {
+				return
+			}
+			numWritten++
+		}
+		if !full {
+			break
+		}
+		if err = ew.flush(); err != nil {
+			return
+		}
+		begTKey = nextTKey
+	}
+	err = ew.flush()
+	return
+}
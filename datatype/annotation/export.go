@@ -0,0 +1,273 @@
+/*
+	This file supports asynchronous export of the whole connectome, i.e., the number of
+	PreSyn to PostSyn relationships between each pair of labels.
+*/
+
+package annotation
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// maximum number of CSV bytes in each part of a connectome export.
+var exportPartSize = 16 << 20
+
+// Stages of a connectome export.
+const (
+	ExportScanning  = "scanning labels"
+	ExportResolving = "resolving relationships"
+	ExportWriting   = "writing"
+	ExportDone      = "done"
+	ExportFailed    = "failed"
+)
+
+// ExportStatus gives the progress of the most recent connectome export.
+type ExportStatus struct {
+	Destination    string
+	Stage          string
+	Labels         int    // number of labels scanned
+	PreSyn         int    // number of PreSyn elements found in labels
+	PreSynResolved int    // number of PreSyn elements whose relationships have been resolved
+	Connections    int    // number of (pre label, post label) pairs written
+	Parts          int    // number of CSV parts written
+	Error          string `json:",omitempty"`
+	Started        string
+	Finished       string `json:",omitempty"`
+}
+
+// connectomeExport tracks the status of the current or last export.
+type connectomeExport struct {
+	sync.RWMutex
+	status *ExportStatus
+}
+
+// start sets up a new export status, returning false if an export is already running.
+func (e *connectomeExport) start(dest string) bool {
+	e.Lock()
+	defer e.Unlock()
+	if e.status != nil && e.status.Finished == "" {
+		return false
+	}
+	e.status = &ExportStatus{
+		Destination: dest,
+		Stage:       ExportScanning,
+		Started:     time.Now().Format(time.RFC3339),
+	}
+	return true
+}
+
+func (e *connectomeExport) update(f func(status *ExportStatus)) {
+	e.Lock()
+	f(e.status)
+	e.Unlock()
+}
+
+func (e *connectomeExport) finish(err error) {
+	e.Lock()
+	if err != nil {
+		e.status.Stage = ExportFailed
+		e.status.Error = err.Error()
+	} else {
+		e.status.Stage = ExportDone
+	}
+	e.status.Finished = time.Now().Format(time.RFC3339)
+	e.Unlock()
+}
+
+// returns a copy of the current status or nil if no export has been started.
+func (e *connectomeExport) get() *ExportStatus {
+	e.RLock()
+	defer e.RUnlock()
+	if e.status == nil {
+		return nil
+	}
+	status := *(e.status)
+	return &status
+}
+
+// ConnectomeEdge is the number of PreSyn to PostSyn relationships from one label to another.
+type ConnectomeEdge struct {
+	Pre   uint64
+	Post  uint64
+	Count uint32
+}
+
+type connectomeEdges []ConnectomeEdge
+
+func (c connectomeEdges) Len() int      { return len(c) }
+func (c connectomeEdges) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c connectomeEdges) Less(i, j int) bool {
+	if c[i].Pre != c[j].Pre {
+		return c[i].Pre < c[j].Pre
+	}
+	return c[i].Post < c[j].Post
+}
+
+// writeCSV writes the edges as CSV with a header line, passing consecutive parts of at
+// most exportPartSize bytes to the write function.  Parts only hold complete lines.  The
+// number of parts written is returned.
+func (c connectomeEdges) writeCSV(write func(part int, data []byte) error) (parts int, err error) {
+	var buf bytes.Buffer
+	buf.WriteString("pre_body,post_body,count\n")
+	for _, edge := range c {
+		line := fmt.Sprintf("%d,%d,%d\n", edge.Pre, edge.Post, edge.Count)
+		if buf.Len() > 0 && buf.Len()+len(line) > exportPartSize {
+			if err = write(parts, buf.Bytes()); err != nil {
+				return
+			}
+			parts++
+			buf.Reset()
+		}
+		buf.WriteString(line)
+	}
+	if err = write(parts, buf.Bytes()); err != nil {
+		return
+	}
+	return parts + 1, nil
+}
+
+// labeled PreSyn element position
+type labeledPos struct {
+	label uint64
+	pos   dvid.Point3d
+}
+
+// StartConnectomeExport starts an asynchronous export of the connectome at the given version.
+// The CSV table of (pre body, post body, count) is passed in numbered parts to the write
+// function, and the done function is called with the number of parts after all parts are
+// written.  The given destination is used to describe the export in its status.  An error
+// is returned if an export is already running.
+func (d *Data) StartConnectomeExport(v dvid.VersionID, dest string, write func(part int, data []byte) error, done func(parts int) error) error {
+	if !d.export.start(dest) {
+		return fmt.Errorf("connectome export already running for data %q", d.DataName())
+	}
+	d.StartUpdate()
+	go func() {
+		defer d.StopUpdate()
+		timedLog := dvid.NewTimeLog()
+		err := d.exportConnectome(v, write, done)
+		d.export.finish(err)
+		if err != nil {
+			dvid.Errorf("Connectome export of annotation %q to %s failed: %v\n", d.DataName(), dest, err)
+			return
+		}
+		timedLog.Infof("Completed connectome export of annotation %q to %s", d.DataName(), dest)
+	}()
+	return nil
+}
+
+// GetConnectome returns the number of PreSyn to PostSyn relationships between each pair
+// of labels, using the label denormalizations of the elements.  Relationships to elements
+// without a label are ignored.
+func (d *Data) GetConnectome(v dvid.VersionID) ([]ConnectomeEdge, error) {
+	return d.getConnectome(v, func(func(*ExportStatus)) {})
+}
+
+// writes the connectome while updating the export status.
+func (d *Data) exportConnectome(v dvid.VersionID, write func(int, []byte) error, done func(int) error) error {
+	edges, err := d.getConnectome(v, d.export.update)
+	if err != nil {
+		return err
+	}
+	d.export.update(func(status *ExportStatus) {
+		status.Stage = ExportWriting
+		status.Connections = len(edges)
+	})
+	parts, err := connectomeEdges(edges).writeCSV(func(part int, data []byte) error {
+		if err := write(part, data); err != nil {
+			return err
+		}
+		d.export.update(func(status *ExportStatus) {
+			status.Parts = part + 1
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return done(parts)
+}
+
+func (d *Data) getConnectome(v dvid.VersionID, update func(func(*ExportStatus))) ([]ConnectomeEdge, error) {
+	// Get label for every labeled element and group PreSyn elements by block.
+	blockSize := d.blockSize()
+	posLabels := make(map[string]uint64)
+	preSyn := make(map[dvid.IZYXString][]labeledPos)
+	var labels, numPreSyn int
+	err := d.ProcessLabelAnnotations(v, func(label uint64, elems ElementsNR) {
+		for _, elem := range elems {
+			posLabels[elem.Pos.MapKey()] = label
+			if elem.Kind == PreSyn {
+				izyx := elem.Pos.ToBlockIZYXString(blockSize)
+				preSyn[izyx] = append(preSyn[izyx], labeledPos{label, elem.Pos})
+				numPreSyn++
+			}
+		}
+		labels++
+		if labels%1000 == 0 {
+			update(func(status *ExportStatus) {
+				status.Labels = labels
+				status.PreSyn = numPreSyn
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	update(func(status *ExportStatus) {
+		status.Stage = ExportResolving
+		status.Labels = labels
+		status.PreSyn = numPreSyn
+	})
+
+	// Resolve the relationships of PreSyn elements, which are only stored in block elements.
+	ctx := datastore.NewVersionedCtx(d, v)
+	counts := make(map[[2]uint64]uint32)
+	var resolved int
+	for izyx, lposs := range preSyn {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		elems, err := getElements(ctx, NewBlockTKey(bcoord))
+		if err != nil {
+			return nil, err
+		}
+		rels := make(map[string][]Relationship, len(elems))
+		for _, elem := range elems {
+			if elem.Kind == PreSyn {
+				rels[elem.Pos.MapKey()] = elem.Rels
+			}
+		}
+		for _, lpos := range lposs {
+			for _, rel := range rels[lpos.pos.MapKey()] {
+				if rel.Rel != PreSynTo {
+					continue
+				}
+				post, found := posLabels[rel.To.MapKey()]
+				if !found {
+					continue
+				}
+				counts[[2]uint64{lpos.label, post}]++
+			}
+		}
+		resolved += len(lposs)
+		update(func(status *ExportStatus) {
+			status.PreSynResolved = resolved
+		})
+	}
+
+	edges := make(connectomeEdges, 0, len(counts))
+	for pair, count := range counts {
+		edges = append(edges, ConnectomeEdge{Pre: pair[0], Post: pair[1], Count: count})
+	}
+	sort.Sort(edges)
+	return edges, nil
+}
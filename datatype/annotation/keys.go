@@ -5,8 +5,10 @@
 package annotation
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strings"
 
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
@@ -24,6 +26,10 @@ const (
 
 	// key is block coordinate.  value is serialization of synaptic elements.
 	keyBlock = 72
+
+	// key is property name and value.  value is serialization of all synaptic elements with
+	// that property value.
+	keyProp = 73
 )
 
 // NewTagTKey returns a TKey for a given tag.
@@ -50,6 +56,48 @@ func DecodeTagTKey(tk storage.TKey) (Tag, error) {
 	return Tag(ibytes[:sz]), nil
 }
 
+// NewPropTKey returns a TKey for a given property name and value.
+func NewPropTKey(name, value string) (storage.TKey, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("empty property name not permitted")
+	}
+	if strings.IndexByte(name, 0) >= 0 || strings.IndexByte(value, 0) >= 0 {
+		return nil, fmt.Errorf("property name and value cannot contain 0 bytes")
+	}
+	ibytes := make([]byte, 0, len(name)+len(value)+2)
+	ibytes = append(ibytes, name...)
+	ibytes = append(ibytes, 0)
+	ibytes = append(ibytes, value...)
+	ibytes = append(ibytes, 0)
+	return storage.NewTKey(keyProp, ibytes), nil
+}
+
+// DecodePropTKey returns the property name and value corresponding to this type-specific key.
+func DecodePropTKey(tk storage.TKey) (name, value string, err error) {
+	ibytes, err := tk.ClassBytes(keyProp)
+	if err != nil {
+		return
+	}
+	sz := len(ibytes) - 1
+	if sz <= 0 || ibytes[sz] != 0 {
+		err = fmt.Errorf("expected 0 byte ending property key")
+		return
+	}
+	sep := bytes.IndexByte(ibytes, 0)
+	if sep <= 0 || sep == sz {
+		err = fmt.Errorf("bad property key: %v", ibytes)
+		return
+	}
+	return string(ibytes[:sep]), string(ibytes[sep+1 : sz]), nil
+}
+
+// propTKeyRange returns the range of TKeys for all values of a given property name.
+func propTKeyRange(name string) (minTKey, maxTKey storage.TKey) {
+	minTKey = storage.NewTKey(keyProp, append([]byte(name), 0))
+	maxTKey = storage.NewTKey(keyProp, append([]byte(name), 1))
+	return
+}
+
 func NewLabelTKey(label uint64) storage.TKey {
 	buf := make([]byte, 8)
 	binary.BigEndian.PutUint64(buf, label)
@@ -0,0 +1,293 @@
+/*
+	This file supports nearest-neighbor queries of elements by searching outward over
+	block-indexed elements in expanding shells of blocks.
+*/
+
+package annotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Default maximum distance in voxels for nearest element searches.
+const defaultMaxNearestDist = 1000
+
+// MaxNearestDist is the largest allowed maximum distance in voxels for nearest element searches.
+const MaxNearestDist = 10000
+
+// blockExtents is the bounding box of the block coordinates of stored elements.
+type blockExtents struct {
+	empty    bool
+	min, max dvid.ChunkPoint3d
+}
+
+func (e *blockExtents) extend(bcoord dvid.ChunkPoint3d) {
+	if e.empty {
+		e.empty = false
+		e.min, e.max = bcoord, bcoord
+		return
+	}
+	for i := 0; i < 3; i++ {
+		if bcoord[i] < e.min[i] {
+			e.min[i] = bcoord[i]
+		}
+		if bcoord[i] > e.max[i] {
+			e.max[i] = bcoord[i]
+		}
+	}
+}
+
+// extentsCache holds the block extents of versions.  Extents are computed by a scan of
+// block keys on first use in a version and then extended as blocks are written, so they
+// can be larger than the actual extents after deletions.
+type extentsCache struct {
+	sync.Mutex
+	m map[dvid.VersionID]*blockExtents
+}
+
+// extends any cached extents of a version to include the given block.
+func (c *extentsCache) extend(v dvid.VersionID, bcoord dvid.ChunkPoint3d) {
+	c.Lock()
+	if e, found := c.m[v]; found {
+		e.extend(bcoord)
+	}
+	c.Unlock()
+}
+
+// returns the block extents of elements in a version.  This should be called with at
+// least a read lock on the data so blocks aren't written during a scan.
+func (d *Data) getBlockExtents(ctx *datastore.VersionedCtx) (blockExtents, error) {
+	d.extents.Lock()
+	defer d.extents.Unlock()
+
+	v := ctx.VersionID()
+	if e, found := d.extents.m[v]; found {
+		return *e, nil
+	}
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return blockExtents{}, err
+	}
+	e := &blockExtents{empty: true}
+	ch := make(storage.KeyChan, 1000)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- store.SendKeysInRange(ctx, storage.MinTKey(keyBlock), storage.MaxTKey(keyBlock), ch)
+	}()
+	var decodeErr error
+	for key := range ch {
+		if key == nil {
+			break
+		}
+		if decodeErr != nil {
+			continue // drain remaining keys
+		}
+		var tk storage.TKey
+		if tk, decodeErr = storage.TKeyFromKey(key); decodeErr != nil {
+			continue
+		}
+		var bcoord dvid.ChunkPoint3d
+		if bcoord, decodeErr = DecodeBlockTKey(tk); decodeErr != nil {
+			continue
+		}
+		e.extend(bcoord)
+	}
+	if err := <-errCh; err != nil {
+		return blockExtents{}, err
+	}
+	if decodeErr != nil {
+		return blockExtents{}, decodeErr
+	}
+	if d.extents.m == nil {
+		d.extents.m = make(map[dvid.VersionID]*blockExtents)
+	}
+	d.extents.m[v] = e
+	return *e, nil
+}
+
+// element with its squared distance from a query point.
+type nearElement struct {
+	elem  Element
+	dist2 int64
+}
+
+type nearElements []nearElement
+
+func (n nearElements) Len() int      { return len(n) }
+func (n nearElements) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n nearElements) Less(i, j int) bool {
+	if n[i].dist2 != n[j].dist2 {
+		return n[i].dist2 < n[j].dist2
+	}
+	return n[i].elem.Pos.Less(n[j].elem.Pos)
+}
+
+// parses a comma-separated list of element kinds.
+func parseElementTypes(s string) ([]ElementType, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var kinds []ElementType
+	for _, kindStr := range strings.Split(s, ",") {
+		kind := StringToElementType(kindStr)
+		if kind == UnknownElem && kindStr != "Unknown" {
+			return nil, fmt.Errorf("unknown element kind %q", kindStr)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// GetNearest returns up to k elements nearest to the given point, sorted by increasing
+// distance, that are within maxdist voxels, which can be at most MaxNearestDist.  If kinds
+// is not empty, only elements of the given kinds are returned.  The search is limited to
+// the block extents of stored elements.
+func (d *Data) GetNearest(ctx *datastore.VersionedCtx, pt dvid.Point3d, k int, kinds []ElementType, maxdist float64) (Elements, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("number of nearest elements must be positive, not %d", k)
+	}
+	if maxdist < 0 || maxdist > MaxNearestDist || math.IsNaN(maxdist) {
+		return nil, fmt.Errorf("maximum distance for nearest elements must be between 0 and %d, not %f", MaxNearestDist, maxdist)
+	}
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+
+	d.RLock()
+	defer d.RUnlock()
+
+	extents, err := d.getBlockExtents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if extents.empty {
+		return Elements{}, nil
+	}
+
+	kindOK := make(map[ElementType]bool, len(kinds))
+	for _, kind := range kinds {
+		kindOK[kind] = true
+	}
+	maxdist2 := maxdist * maxdist
+
+	var found nearElements
+	processBlocks := func(beg, end dvid.ChunkPoint3d) error {
+		return store.ProcessRange(ctx, NewBlockTKey(beg), NewBlockTKey(end), &storage.ChunkOp{}, func(c *storage.Chunk) error {
+			if c == nil || c.V == nil {
+				return nil
+			}
+			var elems Elements
+			if err := json.Unmarshal(c.V, &elems); err != nil {
+				return err
+			}
+			for _, elem := range elems {
+				if len(kindOK) != 0 && !kindOK[elem.Kind] {
+					continue
+				}
+				var dist2 int64
+				for i := 0; i < 3; i++ {
+					delta := int64(elem.Pos[i]) - int64(pt[i])
+					dist2 += delta * delta
+				}
+				if float64(dist2) <= maxdist2 {
+					found = append(found, nearElement{elem, dist2})
+				}
+			}
+			return nil
+		})
+	}
+
+	// returns the intersection of [lo, hi] with the extents along the given axis.
+	clip := func(lo, hi int32, axis int) (int32, int32) {
+		if lo < extents.min[axis] {
+			lo = extents.min[axis]
+		}
+		if hi > extents.max[axis] {
+			hi = extents.max[axis]
+		}
+		return lo, hi
+	}
+	inX := func(x int32) bool {
+		return x >= extents.min[0] && x <= extents.max[0]
+	}
+
+	blockSize := d.blockSize()
+	center := pt.Chunk(blockSize).(dvid.ChunkPoint3d)
+	for r := int32(0); ; r++ {
+		// Get elements in the shell of blocks at Chebyshev distance r that are within the
+		// extents.  Rows of blocks along x that lie on a y or z face of the shell are read
+		// with one range query, while other rows only intersect the shell at their two end blocks.
+		zBeg, zEnd := clip(center[2]-r, center[2]+r, 2)
+		yBeg, yEnd := clip(center[1]-r, center[1]+r, 1)
+		xBeg, xEnd := clip(center[0]-r, center[0]+r, 0)
+		for z := zBeg; z <= zEnd; z++ {
+			for y := yBeg; y <= yEnd; y++ {
+				var err error
+				if z == center[2]-r || z == center[2]+r || y == center[1]-r || y == center[1]+r {
+					if xBeg <= xEnd {
+						err = processBlocks(dvid.ChunkPoint3d{xBeg, y, z}, dvid.ChunkPoint3d{xEnd, y, z})
+					}
+				} else {
+					beg := dvid.ChunkPoint3d{center[0] - r, y, z}
+					end := dvid.ChunkPoint3d{center[0] + r, y, z}
+					if inX(beg[0]) {
+						err = processBlocks(beg, beg)
+					}
+					if err == nil && r > 0 && inX(end[0]) {
+						err = processBlocks(end, end)
+					}
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		sort.Sort(found)
+		if len(found) > k {
+			found = found[:k]
+		}
+
+		// Compute the minimum distance from the point to any voxel outside the searched blocks.
+		var bound int64 = -1
+		for i := 0; i < 3; i++ {
+			minVoxel := int64(center[i]-r) * int64(blockSize[i])
+			maxVoxel := int64(center[i]+r+1)*int64(blockSize[i]) - 1
+			for _, dist := range []int64{int64(pt[i]) - minVoxel + 1, maxVoxel - int64(pt[i]) + 1} {
+				if bound < 0 || dist < bound {
+					bound = dist
+				}
+			}
+		}
+		if float64(bound) > maxdist {
+			break
+		}
+		covered := true
+		for i := 0; i < 3; i++ {
+			if center[i]-r > extents.min[i] || center[i]+r < extents.max[i] {
+				covered = false
+			}
+		}
+		if covered {
+			break
+		}
+		if len(found) == k && found[k-1].dist2 <= bound*bound {
+			break
+		}
+	}
+
+	elems := make(Elements, len(found))
+	for i, near := range found {
+		elems[i] = near.elem
+	}
+	return elems, nil
+}
@@ -0,0 +1,463 @@
+/*
+	This file supports secondary indices on element properties and queries that combine
+	property conditions with the block, tag, and label keyspaces.
+*/
+
+package annotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// propIndex identifies an indexed property name and value.
+type propIndex struct {
+	name  string
+	value string
+}
+
+type propElements map[propIndex]Elements
+
+// adds an element to the index of each of its indexed properties.
+func (d *Data) addPropElement(pe propElements, elem Element) {
+	for _, name := range d.IndexedProps {
+		value, found := elem.Prop[name]
+		if !found {
+			continue
+		}
+		pi := propIndex{name, value}
+		pe[pi] = append(pe[pi], elem)
+	}
+}
+
+// stores synaptic elements arranged by indexed property value, replacing any
+// elements at same position.
+func (d *Data) storePropElements(ctx *datastore.VersionedCtx, batch storage.Batch, pe propElements) error {
+	for pi, elems := range pe {
+		tk, err := NewPropTKey(pi.name, pi.value)
+		if err != nil {
+			return err
+		}
+		if err := d.modifyElements(ctx, batch, tk, elems); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete all reference to given element point in the indices of its property values.
+// This is private method and assumes outer locking.
+func (d *Data) deleteElementInProps(ctx *datastore.VersionedCtx, batch storage.Batch, pt dvid.Point3d, prop map[string]string) error {
+	for _, name := range d.IndexedProps {
+		value, found := prop[name]
+		if !found {
+			continue
+		}
+		tk, err := NewPropTKey(name, value)
+		if err != nil {
+			return err
+		}
+		elems, err := getElementsNR(ctx, tk)
+		if err != nil {
+			return err
+		}
+		if _, changed := elems.delete(pt); !changed {
+			continue
+		}
+		if err := putBatchElements(batch, tk, elems); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move all reference to given element point in the indices of its property values.
+// This is private method and assumes outer locking.
+func (d *Data) moveElementInProps(ctx *datastore.VersionedCtx, batch storage.Batch, from, to dvid.Point3d, prop map[string]string) error {
+	for _, name := range d.IndexedProps {
+		value, found := prop[name]
+		if !found {
+			continue
+		}
+		tk, err := NewPropTKey(name, value)
+		if err != nil {
+			return err
+		}
+		elems, err := getElementsNR(ctx, tk)
+		if err != nil {
+			return err
+		}
+		if moved, _ := elems.move(from, to, false); moved == nil {
+			dvid.Errorf("Unable to find moved element %s in property %q = %q", from, name, value)
+			continue
+		}
+		if err := putBatchElements(batch, tk, elems); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Data) storeProps(batcher storage.KeyValueBatcher, ctx *datastore.VersionedCtx, propE propElements) error {
+	batch := batcher.NewBatch(ctx)
+	if err := d.storePropElements(ctx, batch, propE); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("bad batch commit in reload for data %q: %v", d.DataName(), err)
+	}
+	return nil
+}
+
+// propOp is a comparison operator for a property condition.
+type propOp uint8
+
+const (
+	opEqual propOp = iota
+	opNotEqual
+	opLess
+	opLessEqual
+	opGreater
+	opGreaterEqual
+)
+
+// operators in the order they should be matched at an operator position, so two-character
+// operators come first.
+var propOps = []struct {
+	s  string
+	op propOp
+}{
+	{"!=", opNotEqual},
+	{"<=", opLessEqual},
+	{">=", opGreaterEqual},
+	{"=", opEqual},
+	{"<", opLess},
+	{">", opGreater},
+}
+
+// PropCondition is a condition on an element's property value.
+type PropCondition struct {
+	Name  string
+	op    propOp
+	Value string
+}
+
+// matches returns true if the given property value satisfies the condition.  Values are
+// compared numerically for inequalities if both parse as numbers, else lexicographically.
+func (c PropCondition) matches(value string) bool {
+	switch c.op {
+	case opEqual:
+		return value == c.Value
+	case opNotEqual:
+		return value != c.Value
+	}
+	var cmp int
+	v1, err1 := strconv.ParseFloat(value, 64)
+	v2, err2 := strconv.ParseFloat(c.Value, 64)
+	switch {
+	case err1 == nil && err2 == nil && v1 < v2, (err1 != nil || err2 != nil) && value < c.Value:
+		cmp = -1
+	case err1 == nil && err2 == nil && v1 > v2, (err1 != nil || err2 != nil) && value > c.Value:
+		cmp = 1
+	}
+	switch c.op {
+	case opLess:
+		return cmp < 0
+	case opLessEqual:
+		return cmp <= 0
+	case opGreater:
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// Query gives conditions that must all be satisfied by returned elements.
+type Query struct {
+	Props []PropCondition
+	Tag   Tag
+	Label uint64
+	ROI   storage.FilterSpec
+}
+
+// ParseQuery parses a raw URL query string like "prop.user=alice&prop.conf>=0.9&tag=foo".
+// The given uuid is used for any ROI specification without an explicit UUID.  Query
+// parameters that aren't conditions, e.g., "relationships", are ignored.
+func ParseQuery(uuid dvid.UUID, rawQuery string) (*Query, error) {
+	q := new(Query)
+	for _, term := range strings.Split(rawQuery, "&") {
+		if term == "" {
+			continue
+		}
+		term, err := url.QueryUnescape(term)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(term, "prop.") {
+			cond, err := parsePropCondition(term[len("prop."):])
+			if err != nil {
+				return nil, err
+			}
+			q.Props = append(q.Props, cond)
+			continue
+		}
+		parts := strings.SplitN(term, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "tag":
+			q.Tag = Tag(parts[1])
+		case "label":
+			if q.Label, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("bad label in query: %v", err)
+			}
+		case "roi":
+			roiParts := strings.Split(parts[1], ",")
+			switch len(roiParts) {
+			case 1:
+				q.ROI = storage.FilterSpec("roi:" + roiParts[0] + "," + string(uuid))
+			case 2:
+				q.ROI = storage.FilterSpec("roi:" + parts[1])
+			default:
+				return nil, fmt.Errorf("bad ROI specification: %q", parts[1])
+			}
+		}
+	}
+	return q, nil
+}
+
+// parses a property condition, where the operator is the first operator character and
+// possibly the one after it.  Property keys therefore cannot contain operator characters.
+func parsePropCondition(s string) (PropCondition, error) {
+	if i := strings.IndexAny(s, "!<>="); i > 0 {
+		for _, o := range propOps {
+			if strings.HasPrefix(s[i:], o.s) {
+				return PropCondition{Name: s[:i], op: o.op, Value: s[i+len(o.s):]}, nil
+			}
+		}
+	}
+	return PropCondition{}, fmt.Errorf("bad property condition %q: expected =, !=, <, <=, >, or >=", s)
+}
+
+// inROI returns a function that checks if a point is within the ROI given by the spec.
+func inROI(roiSpec storage.FilterSpec) (func(pt dvid.Point3d) bool, error) {
+	roidata, roiV, roiFound, err := roi.DataByFilter(roiSpec)
+	if err != nil {
+		return nil, fmt.Errorf("ROI specification was not parsable (%s): %v\n", roiSpec, err)
+	}
+	if !roiFound {
+		return nil, fmt.Errorf("No ROI found that matches specification %q", roiSpec)
+	}
+	spans, err := roidata.GetSpans(roiV)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get ROI spans for %q: %v\n", roiSpec, err)
+	}
+	masks, err := roidata.GetVoxelMasks(roiV)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get ROI voxel masks for %q: %v\n", roiSpec, err)
+	}
+	blocks := make(map[dvid.ChunkPoint3d]struct{})
+	for _, span := range spans {
+		for x := span[2]; x <= span[3]; x++ {
+			blocks[dvid.ChunkPoint3d{x, span[1], span[0]}] = struct{}{}
+		}
+	}
+	blockSize := roidata.BlockSize
+	return func(pt dvid.Point3d) bool {
+		_, found := blocks[pt.Chunk(blockSize).(dvid.ChunkPoint3d)]
+		return found && !masks.Excludes(pt, blockSize)
+	}, nil
+}
+
+// returns the elements with an indexed property value satisfying the condition.
+func (d *Data) getPropCandidates(ctx *datastore.VersionedCtx, cond PropCondition) (Elements, error) {
+	if cond.op == opEqual {
+		tk, err := NewPropTKey(cond.Name, cond.Value)
+		if err != nil {
+			return nil, err
+		}
+		return getElements(ctx, tk)
+	}
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	var candidates Elements
+	minTKey, maxTKey := propTKeyRange(cond.Name)
+	err = store.ProcessRange(ctx, minTKey, maxTKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+		if c == nil || c.V == nil {
+			return nil
+		}
+		_, value, err := DecodePropTKey(c.K)
+		if err != nil {
+			return err
+		}
+		if !cond.matches(value) {
+			return nil
+		}
+		var elems Elements
+		if err := json.Unmarshal(c.V, &elems); err != nil {
+			return err
+		}
+		candidates = append(candidates, elems...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return candidates, nil
+}
+
+// returns all elements in the block keyspace.
+func (d *Data) getAllElements(ctx *datastore.VersionedCtx) (Elements, error) {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	var elements Elements
+	err = store.ProcessRange(ctx, storage.MinTKey(keyBlock), storage.MaxTKey(keyBlock), &storage.ChunkOp{}, func(c *storage.Chunk) error {
+		if c == nil || c.V == nil {
+			return nil
+		}
+		var blockElems Elements
+		if err := json.Unmarshal(c.V, &blockElems); err != nil {
+			return err
+		}
+		elements = append(elements, blockElems...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return elements, nil
+}
+
+// GetQueryElements returns all elements satisfying the query.  Candidate elements are
+// retrieved from the most selective available index: an indexed property equality, the tag,
+// the label, an indexed property inequality, the ROI, or if none of these are available,
+// all elements.  All conditions are then checked against the current block-indexed elements.
+func (d *Data) GetQueryElements(ctx *datastore.VersionedCtx, q *Query) (Elements, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	var candidates Elements
+	var err error
+	var found bool
+	for _, cond := range q.Props {
+		if cond.op == opEqual && d.isIndexed(ctx.VersionID(), cond.Name) {
+			candidates, err = d.getPropCandidates(ctx, cond)
+			found = true
+			break
+		}
+	}
+	if !found && q.Tag != "" {
+		var tk storage.TKey
+		if tk, err = NewTagTKey(q.Tag); err != nil {
+			return nil, err
+		}
+		candidates, err = getElements(ctx, tk)
+		found = true
+	}
+	if !found && q.Label != 0 {
+		candidates, err = getElements(ctx, NewLabelTKey(q.Label))
+		found = true
+	}
+	if !found {
+		for _, cond := range q.Props {
+			if d.isIndexed(ctx.VersionID(), cond.Name) {
+				candidates, err = d.getPropCandidates(ctx, cond)
+				found = true
+				break
+			}
+		}
+	}
+	if !found && q.ROI != "" {
+		candidates, err = d.getROISynapses(ctx, q.ROI)
+		found = true
+	}
+	if !found {
+		candidates, err = d.getAllElements(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the current elements since the index elements may not have current properties.
+	if candidates, err = d.expandElements(ctx, candidates); err != nil {
+		return nil, err
+	}
+
+	// Get any other constraints.
+	var roiCheck func(pt dvid.Point3d) bool
+	if q.ROI != "" {
+		if roiCheck, err = inROI(q.ROI); err != nil {
+			return nil, err
+		}
+	}
+	var labelPts map[string]struct{}
+	if q.Label != 0 {
+		labelElems, err := getElementsNR(ctx, NewLabelTKey(q.Label))
+		if err != nil {
+			return nil, err
+		}
+		labelPts = make(map[string]struct{}, len(labelElems))
+		for _, elem := range labelElems {
+			labelPts[elem.Pos.MapKey()] = struct{}{}
+		}
+	}
+
+	elements := Elements{}
+	seen := make(map[string]struct{}, len(candidates))
+	for _, elem := range candidates {
+		key := elem.Pos.MapKey()
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		if !q.matches(elem) {
+			continue
+		}
+		if labelPts != nil {
+			if _, found := labelPts[key]; !found {
+				continue
+			}
+		}
+		if roiCheck != nil && !roiCheck(elem.Pos) {
+			continue
+		}
+		elements = append(elements, elem)
+	}
+	return elements, nil
+}
+
+// matches returns true if the element satisfies the property and tag conditions.
+func (q *Query) matches(elem Element) bool {
+	for _, cond := range q.Props {
+		value, found := elem.Prop[cond.Name]
+		if !found || !cond.matches(value) {
+			return false
+		}
+	}
+	if q.Tag != "" {
+		var hasTag bool
+		for _, tag := range elem.Tags {
+			if tag == q.Tag {
+				hasTag = true
+				break
+			}
+		}
+		if !hasTag {
+			return false
+		}
+	}
+	return true
+}
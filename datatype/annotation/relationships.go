@@ -0,0 +1,225 @@
+/*
+	This file supports server-side traversal of element relationships and label-level
+	connectivity computed from synaptic relationships.
+*/
+
+package annotation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// StringToRelationType converts a string like "PreSynTo" to a RelationType.
+func StringToRelationType(s string) (RelationType, error) {
+	var r RelationType
+	if err := r.UnmarshalJSON([]byte(strconv.Quote(s))); err != nil {
+		return UnknownRel, err
+	}
+	return r, nil
+}
+
+// elementFinder retrieves block-indexed elements by position, caching each block read.
+type elementFinder struct {
+	ctx       *datastore.VersionedCtx
+	blockSize dvid.Point3d
+	blocks    map[dvid.IZYXString]map[string]int
+	elems     map[dvid.IZYXString]Elements
+}
+
+func (d *Data) newElementFinder(ctx *datastore.VersionedCtx) *elementFinder {
+	return &elementFinder{
+		ctx:       ctx,
+		blockSize: d.blockSize(),
+		blocks:    make(map[dvid.IZYXString]map[string]int),
+		elems:     make(map[dvid.IZYXString]Elements),
+	}
+}
+
+// find returns the element at the given position or nil if there is none.
+func (f *elementFinder) find(pt dvid.Point3d) (*Element, error) {
+	bcoord := pt.Chunk(f.blockSize).(dvid.ChunkPoint3d)
+	izyx := bcoord.ToIZYXString()
+	emap, found := f.blocks[izyx]
+	if !found {
+		elems, err := getElements(f.ctx, NewBlockTKey(bcoord))
+		if err != nil {
+			return nil, err
+		}
+		emap = make(map[string]int, len(elems))
+		for i, elem := range elems {
+			emap[elem.Pos.MapKey()] = i
+		}
+		f.blocks[izyx] = emap
+		f.elems[izyx] = elems
+	}
+	i, found := emap[pt.MapKey()]
+	if !found {
+		return nil, nil
+	}
+	return &(f.elems[izyx][i]), nil
+}
+
+// GetRelationships returns the element at the given point and all elements reachable from it
+// by following at most depth relationships.  If rels is not empty, only relationships of the
+// given types are followed.  Elements are returned in breadth-first order.
+func (d *Data) GetRelationships(ctx *datastore.VersionedCtx, pt dvid.Point3d, depth int, rels []RelationType) (Elements, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	follow := make(map[RelationType]bool, len(rels))
+	for _, rel := range rels {
+		follow[rel] = true
+	}
+
+	finder := d.newElementFinder(ctx)
+	start, err := finder.find(pt)
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		return nil, fmt.Errorf("no element found at %s", pt)
+	}
+
+	visited := map[string]struct{}{pt.MapKey(): {}}
+	elements := Elements{*start}
+	frontier := Elements{*start}
+	for level := 0; level < depth && len(frontier) != 0; level++ {
+		var next Elements
+		for _, elem := range frontier {
+			for _, rel := range elem.Rels {
+				if len(follow) != 0 && !follow[rel.Rel] {
+					continue
+				}
+				key := rel.To.MapKey()
+				if _, found := visited[key]; found {
+					continue
+				}
+				visited[key] = struct{}{}
+				related, err := finder.find(rel.To)
+				if err != nil {
+					return nil, err
+				}
+				if related == nil {
+					dvid.Errorf("Relationship of element %s in data %q points to missing element %s\n", elem.Pos, d.DataName(), rel.To)
+					continue
+				}
+				next = append(next, *related)
+			}
+		}
+		elements = append(elements, next...)
+		frontier = next
+	}
+	return elements, nil
+}
+
+// Connection gives the number of synaptic relationships with a partner label.
+type Connection struct {
+	Label uint64 `json:"label"`
+	Count uint32 `json:"count"`
+}
+
+type connectionsByCount []Connection
+
+func (c connectionsByCount) Len() int      { return len(c) }
+func (c connectionsByCount) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c connectionsByCount) Less(i, j int) bool {
+	if c[i].Count != c[j].Count {
+		return c[i].Count > c[j].Count
+	}
+	return c[i].Label < c[j].Label
+}
+
+func sortedConnections(counts map[uint64]uint32) []Connection {
+	conns := make(connectionsByCount, 0, len(counts))
+	for label, count := range counts {
+		conns = append(conns, Connection{Label: label, Count: count})
+	}
+	sort.Sort(conns)
+	return conns
+}
+
+// Connections gives the partner labels of a label, where outputs are the labels of
+// post-synaptic partners of the label's PreSyn elements and inputs are the labels of
+// pre-synaptic partners of the label's PostSyn elements.
+type Connections struct {
+	Label   uint64       `json:"label"`
+	Outputs []Connection `json:"outputs"`
+	Inputs  []Connection `json:"inputs"`
+}
+
+// GetConnections returns the partner labels of a label and the number of synaptic
+// relationships with each.  Partners in label 0 are ignored.
+func (d *Data) GetConnections(ctx *datastore.VersionedCtx, label uint64) (*Connections, error) {
+	labelData := d.getSyncedLabels()
+	if labelData == nil {
+		return nil, fmt.Errorf("data %q must be synced with label data to compute connections", d.DataName())
+	}
+	elems, err := d.GetLabelSynapses(ctx, label)
+	if err != nil {
+		return nil, err
+	}
+
+	partnerLabels := make(map[string]uint64)
+	getLabel := func(pt dvid.Point3d) (uint64, error) {
+		key := pt.MapKey()
+		if partner, found := partnerLabels[key]; found {
+			return partner, nil
+		}
+		partner, err := labelData.GetLabelAtPoint(ctx.VersionID(), pt)
+		if err != nil {
+			return 0, err
+		}
+		partnerLabels[key] = partner
+		return partner, nil
+	}
+
+	outputs := make(map[uint64]uint32)
+	inputs := make(map[uint64]uint32)
+	for _, elem := range elems {
+		for _, rel := range elem.Rels {
+			var counts map[uint64]uint32
+			switch {
+			case elem.Kind == PreSyn && rel.Rel == PreSynTo:
+				counts = outputs
+			case elem.Kind == PostSyn && rel.Rel == PostSynTo:
+				counts = inputs
+			default:
+				continue
+			}
+			partner, err := getLabel(rel.To)
+			if err != nil {
+				return nil, err
+			}
+			if partner != 0 {
+				counts[partner]++
+			}
+		}
+	}
+	return &Connections{
+		Label:   label,
+		Outputs: sortedConnections(outputs),
+		Inputs:  sortedConnections(inputs),
+	}, nil
+}
+
+// parses a comma-separated list of relationship types.
+func parseRelationTypes(s string) ([]RelationType, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var rels []RelationType
+	for _, relStr := range strings.Split(s, ",") {
+		rel, err := StringToRelationType(relStr)
+		if err != nil {
+			return nil, err
+		}
+		rels = append(rels, rel)
+	}
+	return rels, nil
+}
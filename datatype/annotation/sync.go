@@ -9,6 +9,7 @@ import (
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/datatype/common/labels"
 	"github.com/janelia-flyem/dvid/datatype/imageblk"
+	"github.com/janelia-flyem/dvid/datatype/labelarray"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
 )
@@ -138,6 +139,29 @@ func (d *Data) GetSyncSubs(synced dvid.Data) (subs datastore.SyncSubs, err error
 				Ch:     d.syncCh,
 			},
 		}
+	case "labelarray":
+		subs = datastore.SyncSubs{
+			{
+				Event:  datastore.SyncEvent{synced.DataUUID(), labelarray.IngestBlockEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+			{
+				Event:  datastore.SyncEvent{synced.DataUUID(), labelarray.MutateBlockEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+			{
+				Event:  datastore.SyncEvent{synced.DataUUID(), labels.MergeBlockEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+			{
+				Event:  datastore.SyncEvent{synced.DataUUID(), labels.SplitLabelEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+		}
 	default:
 		err = fmt.Errorf("Unable to sync %s with %s since datatype %q is not supported.", d.DataName(), synced.DataName(), synced.TypeName())
 	}
@@ -189,6 +213,31 @@ func (d *Data) handleSyncMessage(ctx *datastore.VersionedCtx, msg datastore.Sync
 	case imageblk.MutatedBlock:
 		d.mutateBlock(ctx, delta, batcher)
 
+	case labelarray.IngestedBlock:
+		block, err := d.labelarrayBlock(msg.Version, delta.BCoord, delta.Data)
+		if err != nil {
+			dvid.Errorf("error on ingesting labelarray block %s for data %q: %v\n", delta.BCoord, d.DataName(), err)
+			return
+		}
+		block.MutID = delta.MutID
+		d.ingestBlock(ctx, block, batcher)
+	case labelarray.MutatedBlock:
+		block, err := d.labelarrayBlock(msg.Version, delta.BCoord, delta.Data)
+		if err != nil {
+			dvid.Errorf("error on mutating labelarray block %s for data %q: %v\n", delta.BCoord, d.DataName(), err)
+			return
+		}
+		var prev []byte
+		if delta.Prev != nil {
+			prevBlock, err := d.labelarrayBlock(msg.Version, delta.BCoord, delta.Prev)
+			if err != nil {
+				dvid.Errorf("error on mutating labelarray block %s for data %q: %v\n", delta.BCoord, d.DataName(), err)
+				return
+			}
+			prev = prevBlock.Data
+		}
+		d.mutateBlock(ctx, imageblk.MutatedBlock{Index: block.Index, Prev: prev, Data: block.Data, MutID: delta.MutID}, batcher)
+
 	case labels.DeltaMergeStart:
 		// ignore
 	case labels.DeltaMerge:
@@ -219,6 +268,29 @@ func (d *Data) handleSyncMessage(ctx *datastore.VersionedCtx, msg datastore.Sync
 	}
 }
 
+// converts a labelarray block into an uncompressed block of labels, mapping supervoxels
+// to bodies if the labelarray uses mapped labels.
+func (d *Data) labelarrayBlock(v dvid.VersionID, bcoord dvid.IZYXString, block *labels.Block) (imageblk.Block, error) {
+	var converted imageblk.Block
+	chunkPt, err := bcoord.ToChunkPoint3d()
+	if err != nil {
+		return converted, err
+	}
+	index := dvid.IndexZYX(chunkPt)
+	converted.Index = &index
+
+	labelData := d.GetSyncedLabelarray()
+	if labelData == nil {
+		return converted, fmt.Errorf("no synced labelarray")
+	}
+	mapped := *block
+	if mapped.Labels, err = labelData.MapLabels(v, block.Labels); err != nil {
+		return converted, err
+	}
+	converted.Data, _ = mapped.MakeLabelVolume()
+	return converted, nil
+}
+
 // If a block of labels is ingested, adjust each label's synaptic element list.
 func (d *Data) ingestBlock(ctx *datastore.VersionedCtx, block imageblk.Block, batcher storage.KeyValueBatcher) {
 	// Get the synaptic elements for this block
@@ -434,6 +506,22 @@ func (d *Data) mergeLabels(batcher storage.KeyValueBatcher, v dvid.VersionID, op
 	return nil
 }
 
+// returns the bodies of the old and new labels of a split, which are the labels themselves
+// unless the synced labelarray has mapped labels, in which case the split labels are
+// supervoxels and that labelarray is returned.
+func (d *Data) splitBodies(v dvid.VersionID, op labels.DeltaSplit) (oldLabel, newLabel uint64, mapped *labelarray.Data, err error) {
+	oldLabel, newLabel = op.OldLabel, op.NewLabel
+	labelData := d.GetSyncedLabelarray()
+	if labelData == nil || !labelData.MappedLabels {
+		return
+	}
+	var bodies []uint64
+	if bodies, err = labelData.MapLabels(v, []uint64{op.OldLabel, op.NewLabel}); err != nil {
+		return
+	}
+	return bodies[0], bodies[1], labelData, nil
+}
+
 func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.VersionID, op labels.DeltaSplit) error {
 	d.Lock()
 	defer d.Unlock()
@@ -441,14 +529,22 @@ func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.Version
 	d.StartUpdate()
 	defer d.StopUpdate()
 
+	oldLabel, newLabel, mapped, err := d.splitBodies(v, op)
+	if err != nil {
+		return err
+	}
+	if oldLabel == newLabel {
+		return nil
+	}
+
 	ctx := datastore.NewVersionedCtx(d, v)
 	batch := batcher.NewBatch(ctx)
 
 	// Get the elements for the old label.
-	oldTk := NewLabelTKey(op.OldLabel)
+	oldTk := NewLabelTKey(oldLabel)
 	oldElems, err := getElements(ctx, oldTk)
 	if err != nil {
-		return fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), op.OldLabel, err)
+		return fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), oldLabel, err)
 	}
 
 	// Create a map to test each point.
@@ -465,12 +561,22 @@ func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.Version
 	for i, elem := range oldElems {
 		zyxStr := elem.Pos.ToBlockIZYXString(blockSize)
 		if _, found := splitBlocks[zyxStr]; found {
+			// A body's split blocks can have other supervoxels that remain in the body.
+			if mapped != nil {
+				supervoxel, err := mapped.GetSupervoxelAtPoint(v, elem.Pos)
+				if err != nil {
+					return err
+				}
+				if supervoxel != op.NewLabel {
+					continue
+				}
+			}
 			toDel[i] = struct{}{}
 			toAdd = append(toAdd, elem)
 
 			// for downstream annotation syncs like labelsz.  TODO: only perform if subscribed.  Better: do ROI filtering here.
-			delta.Del = append(delta.Del, ElementPos{Label: op.OldLabel, Kind: elem.Kind, Pos: elem.Pos})
-			delta.Add = append(delta.Add, ElementPos{Label: op.NewLabel, Kind: elem.Kind, Pos: elem.Pos})
+			delta.Del = append(delta.Del, ElementPos{Label: oldLabel, Kind: elem.Kind, Pos: elem.Pos})
+			delta.Add = append(delta.Add, ElementPos{Label: newLabel, Kind: elem.Kind, Pos: elem.Pos})
 		}
 	}
 	if len(toDel) == 0 {
@@ -478,10 +584,10 @@ func (d *Data) splitLabelsCoarse(batcher storage.KeyValueBatcher, v dvid.Version
 	}
 
 	// Store split elements into new label elements.
-	newTk := NewLabelTKey(op.NewLabel)
+	newTk := NewLabelTKey(newLabel)
 	newElems, err := getElements(ctx, newTk)
 	if err != nil {
-		return fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), op.NewLabel, err)
+		return fmt.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), newLabel, err)
 	}
 	newElems.add(toAdd)
 	val, err := json.Marshal(newElems)
@@ -531,6 +637,14 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 	d.StartUpdate()
 	defer d.StopUpdate()
 
+	oldLabel, newLabel, _, err := d.splitBodies(v, op)
+	if err != nil {
+		return err
+	}
+	if oldLabel == newLabel {
+		return nil
+	}
+
 	ctx := datastore.NewVersionedCtx(d, v)
 	batch := batcher.NewBatch(ctx)
 
@@ -548,7 +662,7 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 		tk := NewBlockTKey(blockPt)
 		elems, err := getElements(ctx, tk)
 		if err != nil {
-			dvid.Errorf("getting annotations for block %s on split of %d from %d: %v\n", blockPt, op.NewLabel, op.OldLabel, err)
+			dvid.Errorf("getting annotations for block %s on split of %d from %d: %v\n", blockPt, newLabel, oldLabel, err)
 			continue
 		}
 
@@ -560,8 +674,8 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 					toDel[elem.Pos.String()] = struct{}{}
 
 					// for downstream annotation syncs like labelsz.  TODO: only perform if subscribed.  Better: do ROI filtering here.
-					delta.Del = append(delta.Del, ElementPos{Label: op.OldLabel, Kind: elem.Kind, Pos: elem.Pos})
-					delta.Add = append(delta.Add, ElementPos{Label: op.NewLabel, Kind: elem.Kind, Pos: elem.Pos})
+					delta.Del = append(delta.Del, ElementPos{Label: oldLabel, Kind: elem.Kind, Pos: elem.Pos})
+					delta.Add = append(delta.Add, ElementPos{Label: newLabel, Kind: elem.Kind, Pos: elem.Pos})
 					break
 				}
 			}
@@ -570,10 +684,10 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 
 	// Modify the old label k/v
 	if len(toDel) != 0 {
-		tk := NewLabelTKey(op.OldLabel)
+		tk := NewLabelTKey(oldLabel)
 		elems, err := getElements(ctx, tk)
 		if err != nil {
-			dvid.Errorf("unable to get annotations for instance %q, old label %d in syncSplit: %v\n", d.DataName(), op.OldLabel, err)
+			dvid.Errorf("unable to get annotations for instance %q, old label %d in syncSplit: %v\n", d.DataName(), oldLabel, err)
 		} else {
 			filtered := elems[:0]
 			for _, elem := range elems {
@@ -596,10 +710,10 @@ func (d *Data) splitLabelsFine(batcher storage.KeyValueBatcher, v dvid.VersionID
 
 	// Modify the new label k/v
 	if len(toAdd) != 0 {
-		tk := NewLabelTKey(op.NewLabel)
+		tk := NewLabelTKey(newLabel)
 		elems, err := getElements(ctx, tk)
 		if err != nil {
-			dvid.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), op.NewLabel, err)
+			dvid.Errorf("unable to get annotations for instance %q, label %d in syncSplit: %v\n", d.DataName(), newLabel, err)
 		} else {
 			elems.add(toAdd)
 			val, err := json.Marshal(elems)
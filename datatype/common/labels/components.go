@@ -0,0 +1,174 @@
+package labels
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// rowKey identifies a row of voxels along X by its (y, z) coordinate.
+type rowKey struct {
+	y, z int32
+}
+
+// union-find forest over run indices.
+type runForest []int
+
+func (f runForest) find(i int) int {
+	for f[i] != i {
+		f[i] = f[f[i]]
+		i = f[i]
+	}
+	return i
+}
+
+func (f runForest) union(i, j int) {
+	ri, rj := f.find(i), f.find(j)
+	if ri != rj {
+		f[rj] = ri
+	}
+}
+
+type componentsBySize struct {
+	comps []dvid.RLEs
+	sizes []uint64
+}
+
+func (c componentsBySize) Len() int { return len(c.comps) }
+func (c componentsBySize) Swap(i, j int) {
+	c.comps[i], c.comps[j] = c.comps[j], c.comps[i]
+	c.sizes[i], c.sizes[j] = c.sizes[j], c.sizes[i]
+}
+func (c componentsBySize) Less(i, j int) bool { return c.sizes[i] > c.sizes[j] }
+
+// ParseConnectivity parses a connectivity string, e.g., from a query string, where an empty
+// string defaults to 6 connectivity.
+func ParseConnectivity(s string) (int, error) {
+	switch s {
+	case "", "6":
+		return 6, nil
+	case "26":
+		return 26, nil
+	default:
+		return 0, fmt.Errorf("connectivity must be 6 or 26, not %q", s)
+	}
+}
+
+// ConnectedComponents partitions the voxels given by the RLEs into connected components
+// using either 6 (face) or 26 (face, edge, and corner) connectivity.  The components are
+// returned as normalized RLEs sorted by decreasing voxel count.
+func ConnectedComponents(rles dvid.RLEs, connectivity int) ([]dvid.RLEs, error) {
+	var reach int32 // how far along X a run in a neighboring row can be and still touch.
+	var neighbors []rowKey
+	switch connectivity {
+	case 6:
+		reach = 0
+		neighbors = []rowKey{{1, 0}, {0, 1}}
+	case 26:
+		reach = 1
+		neighbors = []rowKey{{1, 0}, {-1, 1}, {0, 1}, {1, 1}}
+	default:
+		return nil, fmt.Errorf("connectivity must be 6 or 26, not %d", connectivity)
+	}
+
+	// After normalization, runs in the same row are never adjacent so we only need to
+	// check runs in neighboring rows.  Since runs are sorted by z, y, then x, the runs
+	// for each row are contiguous and sorted by x.
+	norm := rles.Normalize()
+	if len(norm) == 0 {
+		return nil, nil
+	}
+	rows := make(map[rowKey][2]int) // begin and end index of runs in each row.
+	for i, rle := range norm {
+		pt := rle.StartPt()
+		key := rowKey{pt[1], pt[2]}
+		if r, found := rows[key]; found {
+			rows[key] = [2]int{r[0], i + 1}
+		} else {
+			rows[key] = [2]int{i, i + 1}
+		}
+	}
+
+	// Only look at neighboring rows with greater (z, y) so each pair of rows is checked once.
+	forest := make(runForest, len(norm))
+	for i := range forest {
+		forest[i] = i
+	}
+	for i, rle := range norm {
+		pt := rle.StartPt()
+		x0 := pt[0] - reach
+		x1 := pt[0] + rle.Length() - 1 + reach
+		for _, offset := range neighbors {
+			r, found := rows[rowKey{pt[1] + offset.y, pt[2] + offset.z}]
+			if !found {
+				continue
+			}
+			// Find first run in row that ends at or after x0.
+			candidates := norm[r[0]:r[1]]
+			j := sort.Search(len(candidates), func(k int) bool {
+				start := candidates[k].StartPt()[0]
+				return start+candidates[k].Length()-1 >= x0
+			})
+			for ; j < len(candidates); j++ {
+				if candidates[j].StartPt()[0] > x1 {
+					break
+				}
+				forest.union(i, r[0]+j)
+			}
+		}
+	}
+
+	// Gather the runs for each component.
+	compIndex := make(map[int]int)
+	var c componentsBySize
+	for i, rle := range norm {
+		root := forest.find(i)
+		ci, found := compIndex[root]
+		if !found {
+			ci = len(c.comps)
+			compIndex[root] = ci
+			c.comps = append(c.comps, dvid.RLEs{})
+			c.sizes = append(c.sizes, 0)
+		}
+		c.comps[ci] = append(c.comps[ci], rle)
+		c.sizes[ci] += uint64(rle.Length())
+	}
+	sort.Stable(c)
+	return c.comps, nil
+}
+
+// ComponentSplitter can split all but the largest connected component of a label into new labels.
+type ComponentSplitter interface {
+	SplitComponents(v dvid.VersionID, label uint64, connectivity int) ([]uint64, error)
+}
+
+// WriteSplitResponse writes the JSON response for a committed split of fromLabel into toLabel.
+// If relabel is true, the disconnected components remaining in fromLabel are then split into
+// new labels, which are included in the response.  A relabel error doesn't undo the committed
+// split, so it is written into the response along with the split label and then returned.
+func WriteSplitResponse(w io.Writer, s ComponentSplitter, v dvid.VersionID, fromLabel, toLabel uint64, relabel bool, connectivity int) error {
+	if !relabel {
+		_, err := fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+		return err
+	}
+	components, relabelErr := s.SplitComponents(v, fromLabel, connectivity)
+	if relabelErr != nil {
+		errBytes, err := json.Marshal(fmt.Sprintf("relabel of disconnected components: %v", relabelErr))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "{%q: %d, %q: %s}", "label", toLabel, "relabel-error", string(errBytes)); err != nil {
+			return err
+		}
+		return relabelErr
+	}
+	jsonBytes, err := json.Marshal(components)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "{%q: %d, %q: %s}", "label", toLabel, "components", string(jsonBytes))
+	return err
+}
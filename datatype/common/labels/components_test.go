@@ -0,0 +1,94 @@
+package labels
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+func TestConnectedComponents(t *testing.T) {
+	rles := dvid.RLEs{
+		// Component of 3 rows connected across blocks and z: 8 + 4 + 4 = 16 voxels
+		dvid.NewRLE(dvid.Point3d{0, 0, 0}, 4),
+		dvid.NewRLE(dvid.Point3d{4, 0, 0}, 4), // adjacent run from another block
+		dvid.NewRLE(dvid.Point3d{2, 1, 0}, 4),
+		dvid.NewRLE(dvid.Point3d{5, 1, 1}, 4),
+		// Only diagonally connected to the above run at (8, 1, 1) -> (9, 0, 2)
+		dvid.NewRLE(dvid.Point3d{9, 0, 2}, 2),
+		// Isolated single voxel
+		dvid.NewRLE(dvid.Point3d{20, 20, 20}, 1),
+	}
+
+	comps, err := ConnectedComponents(rles, 6)
+	if err != nil {
+		t.Fatalf("error on 6-connected components: %v\n", err)
+	}
+	if len(comps) != 3 {
+		t.Fatalf("expected 3 6-connected components, got %d: %v\n", len(comps), comps)
+	}
+	expectedSizes := []uint64{16, 2, 1}
+	for i, comp := range comps {
+		if size, _ := comp.Stats(); size != expectedSizes[i] {
+			t.Errorf("expected 6-connected component %d to have %d voxels, got %d\n", i, expectedSizes[i], size)
+		}
+	}
+
+	comps, err = ConnectedComponents(rles, 26)
+	if err != nil {
+		t.Fatalf("error on 26-connected components: %v\n", err)
+	}
+	if len(comps) != 2 {
+		t.Fatalf("expected 2 26-connected components, got %d: %v\n", len(comps), comps)
+	}
+	if size, _ := comps[0].Stats(); size != 18 {
+		t.Errorf("expected largest 26-connected component to have 18 voxels, got %d\n", size)
+	}
+
+	if _, err = ConnectedComponents(rles, 18); err == nil {
+		t.Errorf("expected error on unsupported connectivity\n")
+	}
+}
+
+type testSplitter struct {
+	components []uint64
+	err        error
+}
+
+func (s testSplitter) SplitComponents(v dvid.VersionID, label uint64, connectivity int) ([]uint64, error) {
+	return s.components, s.err
+}
+
+func TestWriteSplitResponse(t *testing.T) {
+	var resp struct {
+		Label        uint64   `json:"label"`
+		Components   []uint64 `json:"components"`
+		RelabelError string   `json:"relabel-error"`
+	}
+	var buf bytes.Buffer
+	if err := WriteSplitResponse(&buf, testSplitter{}, 1, 7, 8, false, 6); err != nil {
+		t.Fatalf("error writing split response: %v\n", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &resp); err != nil || resp.Label != 8 || resp.Components != nil {
+		t.Errorf("bad split response without relabel: %s\n", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteSplitResponse(&buf, testSplitter{components: []uint64{9, 10}}, 1, 7, 8, true, 6); err != nil {
+		t.Fatalf("error writing split response: %v\n", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &resp); err != nil || resp.Label != 8 || len(resp.Components) != 2 || resp.Components[1] != 10 {
+		t.Errorf("bad split response with relabel: %s\n", buf.String())
+	}
+
+	buf.Reset()
+	resp.Components = nil
+	if err := WriteSplitResponse(&buf, testSplitter{err: fmt.Errorf("bad \"store\"")}, 1, 7, 8, true, 6); err == nil {
+		t.Errorf("expected relabel error to be returned\n")
+	}
+	if err := json.Unmarshal(buf.Bytes(), &resp); err != nil || resp.Label != 8 || resp.Components != nil || resp.RelabelError == "" {
+		t.Errorf("bad split response with relabel error: %s\n", buf.String())
+	}
+}
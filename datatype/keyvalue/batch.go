@@ -0,0 +1,356 @@
+/*
+	This file supports getting and putting many key-value pairs in a single request.
+*/
+
+package keyvalue
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Formats for bundles of key-value pairs.
+const (
+	// FormatTar is a tar archive where each file name is a key and file contents is the value.
+	FormatTar = "tar"
+
+	// FormatBinary is a sequence of key-value pairs, each given by a little-endian uint32
+	// key length, the key, a little-endian uint64 value length, and the value.
+	FormatBinary = "binary"
+
+	// FormatJSON is a JSON object mapping keys to values, which must be valid JSON.  Empty
+	// values are written as null.
+	FormatJSON = "json"
+)
+
+// Maximum lengths in bytes of keys and values read in bundles.
+const (
+	MaxKeyLen   = 1 << 16
+	MaxValueLen = 1 << 30
+)
+
+// reads n bytes, growing the buffer as bytes arrive so a bad length from a client can't
+// force a large allocation.
+func readBytes(r io.Reader, n uint64) ([]byte, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(buf)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf, nil
+}
+
+// ReadKeys reads a list of keys, either as a JSON array of strings or, if binaryKeys is
+// true, as a sequence of little-endian uint32 key lengths each followed by the key.
+func ReadKeys(r io.Reader, binaryKeys bool) ([]string, error) {
+	if !binaryKeys {
+		var keys []string
+		if err := json.NewDecoder(r).Decode(&keys); err != nil {
+			return nil, fmt.Errorf("unable to decode JSON list of keys: %v", err)
+		}
+		return keys, nil
+	}
+	var keys []string
+	for {
+		var keyLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &keyLen); err != nil {
+			if err == io.EOF {
+				return keys, nil
+			}
+			return nil, fmt.Errorf("unable to read key length: %v", err)
+		}
+		if keyLen > MaxKeyLen {
+			return nil, fmt.Errorf("key length %d exceeds maximum of %d bytes", keyLen, MaxKeyLen)
+		}
+		key, err := readBytes(r, uint64(keyLen))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read key of length %d: %v", keyLen, err)
+		}
+		keys = append(keys, string(key))
+	}
+}
+
+// bundleWriter writes key-value pairs in a bundle format.
+type bundleWriter interface {
+	writePair(keyStr string, value []byte) error
+	close() error
+}
+
+// ends a bundle after an error once pairs have been written and an error status can no
+// longer be returned.  The error is written as a final pair with an empty key, which is
+// never a valid key, whose value is the error message as a JSON string.
+func endWithError(bw bundleWriter, err error) {
+	msg, jsonErr := json.Marshal(err.Error())
+	if jsonErr != nil {
+		msg = []byte(`"unknown error"`)
+	}
+	if err := bw.writePair("", msg); err != nil {
+		dvid.Errorf("Unable to write error marker to key-value bundle: %v\n", err)
+		return
+	}
+	if err := bw.close(); err != nil {
+		dvid.Errorf("Unable to close key-value bundle after error: %v\n", err)
+	}
+}
+
+// returns a bundleWriter for the given format.
+func newBundleWriter(w io.Writer, format string) (bundleWriter, error) {
+	switch format {
+	case FormatTar:
+		return tarWriter{tar.NewWriter(w)}, nil
+	case FormatBinary:
+		return binaryWriter{w}, nil
+	case FormatJSON:
+		return &jsonWriter{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unknown key-value bundle format %q", format)
+	}
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+}
+
+func (t tarWriter) writePair(keyStr string, value []byte) error {
+	hdr := &tar.Header{
+		Name: keyStr,
+		Mode: 0644,
+		Size: int64(len(value)),
+	}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := t.tw.Write(value)
+	return err
+}
+
+func (t tarWriter) close() error {
+	return t.tw.Close()
+}
+
+type binaryWriter struct {
+	w io.Writer
+}
+
+func (b binaryWriter) writePair(keyStr string, value []byte) error {
+	if err := binary.Write(b.w, binary.LittleEndian, uint32(len(keyStr))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(b.w, keyStr); err != nil {
+		return err
+	}
+	if err := binary.Write(b.w, binary.LittleEndian, uint64(len(value))); err != nil {
+		return err
+	}
+	_, err := b.w.Write(value)
+	return err
+}
+
+func (b binaryWriter) close() error {
+	return nil
+}
+
+type jsonWriter struct {
+	w        io.Writer
+	numPairs int
+}
+
+func (j *jsonWriter) writePair(keyStr string, value []byte) error {
+	if len(value) == 0 {
+		value = []byte("null")
+	} else {
+		var raw json.RawMessage
+		if err := json.Unmarshal(value, &raw); err != nil {
+			return fmt.Errorf("value of key %q is not valid JSON: %v", keyStr, err)
+		}
+	}
+	keyJSON, err := json.Marshal(keyStr)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if j.numPairs == 0 {
+		sep = "{"
+	}
+	j.numPairs++
+	if _, err := fmt.Fprintf(j.w, "%s%s:", sep, keyJSON); err != nil {
+		return err
+	}
+	_, err = j.w.Write(value)
+	return err
+}
+
+func (j *jsonWriter) close() error {
+	if j.numPairs == 0 {
+		_, err := io.WriteString(j.w, "{}")
+		return err
+	}
+	_, err := io.WriteString(j.w, "}")
+	return err
+}
+
+// WriteValues writes the values of the given keys as a bundle in the given format, where
+// each value is retrieved just before it is written.  Keys that are not found are
+// omitted so they can be distinguished from keys with empty values.  It returns the
+// number of key-value pairs written.  If an error occurs after pairs have been written,
+// the bundle is ended with an error marker.
+func (d *Data) WriteValues(ctx storage.Context, w io.Writer, keys []string, format string) (numWritten int, err error) {
+	bw, err := newBundleWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil && numWritten > 0 {
+			endWithError(bw, err)
+		}
+	}()
+	for _, keyStr := range keys {
+		value, found, err := d.GetData(ctx, keyStr)
+		if err != nil {
+			return numWritten, err
+		}
+		if !found {
+			continue
+		}
+		if err := bw.writePair(keyStr, value); err != nil {
+			return numWritten, err
+		}
+		numWritten++
+	}
+	return numWritten, bw.close()
+}
+
+// PutValues reads a bundle of key-value pairs in the given format and stores them using
+// a single batch.  In JSON mode, all values are validated and the indices of their fields
+// are updated in the same batch.  It returns the number of key-value pairs stored.
+func (d *Data) PutValues(ctx storage.Context, r io.Reader, format string) (int, error) {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return 0, err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return 0, fmt.Errorf("Unable to put key-values: store for keyvalue %q can't do batching!", d.DataName())
+	}
+	batch := batcher.NewBatch(ctx)
+
+	// Hold the context mutex like all writes so conditional writes stay atomic.
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	// In JSON mode, keep values put in this batch so field indices of keys put more than
+	// once are computed from the previous value in the batch.
+	var batchValues map[string][]byte
+	if d.JSON {
+		batchValues = make(map[string][]byte)
+	}
+
+	var numPut int
+	putValue := func(keyStr string, value []byte) error {
+		if keyStr == "" {
+			return fmt.Errorf("keys of keyvalue %q must not be empty", d.DataName())
+		}
+		if d.JSON {
+			if err := d.validateJSON(keyStr, value); err != nil {
+				return err
+			}
+			if len(d.IndexedFields) != 0 {
+				oldValue, found := batchValues[keyStr]
+				if !found {
+					var err error
+					if oldValue, _, err = d.GetData(ctx, keyStr); err != nil {
+						return err
+					}
+				}
+				d.batchIndexUpdate(batch, keyStr, oldValue, value)
+				batchValues[keyStr] = value
+			}
+		}
+		serialization, err := dvid.SerializeData(value, d.Compression(), d.Checksum())
+		if err != nil {
+			return fmt.Errorf("Unable to serialize data: %v\n", err)
+		}
+		tk, err := NewTKey(keyStr)
+		if err != nil {
+			return err
+		}
+		batch.Put(tk, serialization)
+		numPut++
+		return nil
+	}
+
+	switch format {
+	case FormatTar:
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+				continue
+			}
+			if len(hdr.Name) > MaxKeyLen || hdr.Size > MaxValueLen {
+				return 0, fmt.Errorf("tar file %q exceeds maximum key length of %d or value length of %d bytes", hdr.Name, MaxKeyLen, MaxValueLen)
+			}
+			value, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return 0, err
+			}
+			if err := putValue(hdr.Name, value); err != nil {
+				return 0, err
+			}
+		}
+	case FormatBinary:
+		for {
+			var keyLen uint32
+			if err := binary.Read(r, binary.LittleEndian, &keyLen); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return 0, fmt.Errorf("unable to read key length: %v", err)
+			}
+			if keyLen > MaxKeyLen {
+				return 0, fmt.Errorf("key length %d exceeds maximum of %d bytes", keyLen, MaxKeyLen)
+			}
+			key, err := readBytes(r, uint64(keyLen))
+			if err != nil {
+				return 0, fmt.Errorf("unable to read key of length %d: %v", keyLen, err)
+			}
+			var valueLen uint64
+			if err := binary.Read(r, binary.LittleEndian, &valueLen); err != nil {
+				return 0, fmt.Errorf("unable to read value length for key %q: %v", string(key), err)
+			}
+			if valueLen > MaxValueLen {
+				return 0, fmt.Errorf("value length %d for key %q exceeds maximum of %d bytes", valueLen, string(key), MaxValueLen)
+			}
+			value, err := readBytes(r, valueLen)
+			if err != nil {
+				return 0, fmt.Errorf("unable to read value of length %d for key %q: %v", valueLen, string(key), err)
+			}
+			if err := putValue(string(key), value); err != nil {
+				return 0, err
+			}
+		}
+	default:
+		return 0, fmt.Errorf("unknown key-value bundle format %q", format)
+	}
+
+	if err := batch.Commit(); err != nil {
+		return 0, fmt.Errorf("Error on batch PUT of %d key-values: %v\n", numPut, err)
+	}
+	return numPut, nil
+}
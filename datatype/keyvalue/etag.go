@@ -0,0 +1,133 @@
+/*
+	This file supports entity tags for values and conditional writes using If-Match and
+	If-None-Match preconditions.
+*/
+
+package keyvalue
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// ErrPreconditionFailed is returned when a conditional write is not done because the
+// current value does not satisfy its preconditions.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ETag returns the quoted entity tag of a value, which is a hash of its content.
+func ETag(value []byte) string {
+	return fmt.Sprintf("\"%x\"", md5.Sum(value))
+}
+
+// Preconditions are the entity tags of If-Match and If-None-Match headers that must be
+// satisfied by the current value of a key for a conditional write.  A "*" tag matches
+// any current value.
+type Preconditions struct {
+	IfMatch     []string
+	IfNoneMatch []string
+}
+
+// returns the entity tags in a comma-separated header value.
+func parseETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ParsePreconditions returns the preconditions in the If-Match and If-None-Match headers.
+func ParsePreconditions(h http.Header) Preconditions {
+	return Preconditions{
+		IfMatch:     parseETags(h.Get("If-Match")),
+		IfNoneMatch: parseETags(h.Get("If-None-Match")),
+	}
+}
+
+// Empty returns true if there are no preconditions.
+func (p Preconditions) Empty() bool {
+	return len(p.IfMatch) == 0 && len(p.IfNoneMatch) == 0
+}
+
+// returns true if the current value's entity tag is among the given tags.
+func etagsMatch(tags []string, value []byte, found bool) bool {
+	if !found {
+		return false
+	}
+	etag := ETag(value)
+	for _, tag := range tags {
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Holds returns true if a current value, which may not be found, satisfies the preconditions.
+func (p Preconditions) Holds(value []byte, found bool) bool {
+	if len(p.IfMatch) != 0 && !etagsMatch(p.IfMatch, value, found) {
+		return false
+	}
+	if len(p.IfNoneMatch) != 0 && etagsMatch(p.IfNoneMatch, value, found) {
+		return false
+	}
+	return true
+}
+
+// PutDataIf puts a key-value only if the current value satisfies the preconditions,
+// returning ErrPreconditionFailed if not.  The check and write are atomic since all
+// writes hold the context mutex.
+func (d *Data) PutDataIf(ctx storage.Context, keyStr string, value []byte, cond Preconditions) error {
+	if cond.Empty() {
+		return d.PutData(ctx, keyStr, value)
+	}
+	if d.JSON {
+		return d.putJSON(ctx, keyStr, value, cond)
+	}
+
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	oldValue, found, err := d.GetData(ctx, keyStr)
+	if err != nil {
+		return err
+	}
+	if !cond.Holds(oldValue, found) {
+		return ErrPreconditionFailed
+	}
+	return d.putData(ctx, keyStr, value)
+}
+
+// DeleteDataIf deletes a key-value pair only if the current value satisfies the
+// preconditions, returning ErrPreconditionFailed if not.  The check and delete are
+// atomic since all writes hold the context mutex.
+func (d *Data) DeleteDataIf(ctx storage.Context, keyStr string, cond Preconditions) error {
+	if cond.Empty() {
+		return d.DeleteData(ctx, keyStr)
+	}
+	if len(d.IndexedFields) != 0 {
+		return d.deleteJSON(ctx, keyStr, cond)
+	}
+
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	oldValue, found, err := d.GetData(ctx, keyStr)
+	if err != nil {
+		return err
+	}
+	if !cond.Holds(oldValue, found) {
+		return ErrPreconditionFailed
+	}
+	return d.deleteData(ctx, keyStr)
+}
@@ -0,0 +1,151 @@
+/*
+	This file supports retrieving the history of a key's values across the version DAG.
+*/
+
+package keyvalue
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// KeyVersion describes a write or deletion of a key in a version node.  Since writes are
+// not timestamped, the creation and last update times of the version node are given.
+type KeyVersion struct {
+	UUID    dvid.UUID
+	Deleted bool // true if the key was deleted (tombstoned) in this version
+	Size    int  // size of the value in bytes
+	Created time.Time
+	Updated time.Time
+	Value   []byte `json:",omitempty"`
+}
+
+// byDistance sorts key versions by increasing distance of their version nodes.
+type byDistance struct {
+	history   []KeyVersion
+	distances map[dvid.UUID]int
+}
+
+func (b byDistance) Len() int      { return len(b.history) }
+func (b byDistance) Swap(i, j int) { b.history[i], b.history[j] = b.history[j], b.history[i] }
+func (b byDistance) Less(i, j int) bool {
+	return b.distances[b.history[i].UUID] < b.distances[b.history[j].UUID]
+}
+
+// returns the ancestors of a version, including itself, mapped to their distance in
+// nodes from the version.
+func getAncestors(v dvid.VersionID) (map[dvid.VersionID]int, error) {
+	ancestors := map[dvid.VersionID]int{v: 0}
+	toVisit := []dvid.VersionID{v}
+	for len(toVisit) != 0 {
+		cur := toVisit[0]
+		toVisit = toVisit[1:]
+		parents, err := datastore.GetParentsByVersion(cur)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range parents {
+			if _, found := ancestors[parent]; !found {
+				ancestors[parent] = ancestors[cur] + 1
+				toVisit = append(toVisit, parent)
+			}
+		}
+	}
+	return ancestors, nil
+}
+
+// GetHistory returns the writes and deletions of a key in the context's version and its
+// ancestors, ordered from the context's version toward the root.  If withValues is true,
+// the values written are also returned.
+func (d *Data) GetHistory(ctx *datastore.VersionedCtx, keyStr string, withValues bool) ([]KeyVersion, error) {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	tk, err := NewTKey(keyStr)
+	if err != nil {
+		return nil, err
+	}
+	ancestors, err := getAncestors(ctx.VersionID())
+	if err != nil {
+		return nil, err
+	}
+
+	// Scan all versions of the key.
+	minKey, err := ctx.MinVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	maxKey, err := ctx.MaxVersionKey(tk)
+	if err != nil {
+		return nil, err
+	}
+	var kvs []*storage.KeyValue
+	ch := make(chan *storage.KeyValue)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for kv := range ch {
+			if kv != nil && kv.K != nil {
+				kvs = append(kvs, kv)
+			}
+		}
+	}()
+
+	// Close the channel once the query returns since stores don't send a terminating nil
+	// on every return path.
+	keysOnly := false
+	queryErr := db.RawRangeQuery(minKey, maxKey, keysOnly, ch, nil)
+	close(ch)
+	wg.Wait()
+	if queryErr != nil {
+		return nil, queryErr
+	}
+
+	history := []KeyVersion{}
+	distances := make(map[dvid.UUID]int)
+	for _, kv := range kvs {
+		kvTKey, err := storage.TKeyFromKey(kv.K)
+		if err != nil || !bytes.Equal(kvTKey, tk) {
+			continue
+		}
+		v, err := ctx.VersionFromKey(kv.K)
+		if err != nil {
+			return nil, err
+		}
+		distance, isAncestor := ancestors[v]
+		if !isAncestor {
+			continue
+		}
+		uuid, err := datastore.UUIDFromVersion(v)
+		if err != nil {
+			return nil, err
+		}
+		kversion := KeyVersion{UUID: uuid, Deleted: kv.K.IsTombstone()}
+		if kversion.Created, kversion.Updated, err = datastore.GetNodeTimesByVersion(v); err != nil {
+			return nil, err
+		}
+		if !kversion.Deleted {
+			value, _, err := dvid.DeserializeData(kv.V, true)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to deserialize data for key '%s' in version %s: %v\n", keyStr, uuid, err)
+			}
+			kversion.Size = len(value)
+			if withValues {
+				kversion.Value = value
+			}
+		}
+		distances[uuid] = distance
+		history = append(history, kversion)
+	}
+	sort.Stable(byDistance{history, distances})
+	return history, nil
+}
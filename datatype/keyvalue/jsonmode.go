@@ -0,0 +1,332 @@
+/*
+	This file supports keyvalue data in JSON mode, where values are JSON documents that
+	can be validated against a JSON Schema and queried by indexed top-level fields.
+*/
+
+package keyvalue
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/gojsonschema"
+)
+
+// Properties are additional properties for keyvalue data beyond those in standard datastore.Data.
+type Properties struct {
+	// JSON is true if values must be JSON documents.
+	JSON bool
+
+	// Schema is an optional JSON Schema that values must satisfy in JSON mode.
+	Schema string
+
+	// IndexedFields are the top-level fields of JSON values that have indices for queries.
+	IndexedFields []string
+}
+
+// setByConfig sets the JSON mode and indexed fields from a configuration.
+func (p *Properties) setByConfig(c dvid.Config) error {
+	jsonMode, found, err := c.GetBool("JSON")
+	if err != nil {
+		return err
+	}
+	if found {
+		p.JSON = jsonMode
+	}
+	s, found, err := c.GetString("IndexedFields")
+	if err != nil {
+		return err
+	}
+	if found {
+		var fields []string
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if strings.IndexByte(name, 0) >= 0 {
+				return fmt.Errorf("indexed field name %q cannot contain 0 bytes", name)
+			}
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+		p.IndexedFields = fields
+	}
+	if len(p.IndexedFields) != 0 && !p.JSON {
+		return fmt.Errorf("IndexedFields can only be set for keyvalue data in JSON mode")
+	}
+	return nil
+}
+
+// isIndexed returns true if the given field is indexed.
+func (p *Properties) isIndexed(field string) bool {
+	for _, indexed := range p.IndexedFields {
+		if indexed == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Equals returns true if the properties are identical.
+func (p Properties) Equals(p2 Properties) bool {
+	return reflect.DeepEqual(p, p2)
+}
+
+// fieldValue is a value of an indexed field.
+type fieldValue struct {
+	field, value string
+}
+
+// returns the values of indexed fields in a JSON value.  Field values are the text of
+// strings, the JSON text of numbers and booleans, and each such element of arrays.  Other
+// field values, and strings with 0 bytes, are not indexed.
+func (p *Properties) fieldValues(value []byte) map[fieldValue]struct{} {
+	if len(p.IndexedFields) == 0 || len(value) == 0 {
+		return nil
+	}
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil // not a JSON object, so it has no fields
+	}
+	values := make(map[fieldValue]struct{})
+	addValue := func(field string, v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if strings.IndexByte(v, 0) < 0 {
+				values[fieldValue{field, v}] = struct{}{}
+			}
+		case json.Number:
+			values[fieldValue{field, v.String()}] = struct{}{}
+		case bool:
+			values[fieldValue{field, strconv.FormatBool(v)}] = struct{}{}
+		}
+	}
+	for _, field := range p.IndexedFields {
+		switch v := doc[field].(type) {
+		case []interface{}:
+			for _, elem := range v {
+				addValue(field, elem)
+			}
+		default:
+			addValue(field, v)
+		}
+	}
+	return values
+}
+
+// returns a parsed JSON Schema.
+func parseSchema(schemaJSON []byte) (*gojsonschema.JsonSchemaDocument, error) {
+	var schemaData interface{}
+	if err := json.Unmarshal(schemaJSON, &schemaData); err != nil {
+		return nil, fmt.Errorf("JSON schema is not valid JSON: %v", err)
+	}
+	schema, err := gojsonschema.NewJsonSchemaDocument(schemaData)
+	if err != nil {
+		return nil, fmt.Errorf("JSON schema did not build: %v", err)
+	}
+	return schema, nil
+}
+
+// GetSchema returns the JSON Schema for values or an empty string if there is none.
+func (d *Data) GetSchema() string {
+	d.schemaMu.RLock()
+	defer d.schemaMu.RUnlock()
+	return d.Schema
+}
+
+// SetSchema sets the JSON Schema that values put after this call must satisfy.  An empty
+// schema removes any schema.
+func (d *Data) SetSchema(uuid dvid.UUID, schemaJSON []byte) error {
+	if !d.JSON {
+		return fmt.Errorf("keyvalue %q is not in JSON mode so cannot have a JSON schema", d.DataName())
+	}
+	schemaJSON = bytes.TrimSpace(schemaJSON)
+	var schema *gojsonschema.JsonSchemaDocument
+	if len(schemaJSON) != 0 {
+		var err error
+		if schema, err = parseSchema(schemaJSON); err != nil {
+			return err
+		}
+	}
+	d.schemaMu.Lock()
+	d.Schema = string(schemaJSON)
+	d.schema = schema
+	d.schemaMu.Unlock()
+	return datastore.SaveDataByUUID(uuid, d)
+}
+
+// validateJSON returns an error if a value is not valid JSON or does not satisfy the
+// JSON Schema, if any.
+func (d *Data) validateJSON(keyStr string, value []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return fmt.Errorf("value for key %q of keyvalue %q is not valid JSON: %v", keyStr, d.DataName(), err)
+	}
+	d.schemaMu.RLock()
+	schema := d.schema
+	d.schemaMu.RUnlock()
+	if schema == nil {
+		return nil
+	}
+	if !schema.Validate(doc).Valid() {
+		return fmt.Errorf("value for key %q does not satisfy JSON schema of keyvalue %q", keyStr, d.DataName())
+	}
+	return nil
+}
+
+// batchIndexUpdate adds to a batch the changes to field indices when a key's value changes
+// from oldValue to newValue, where a nil value means the key has no value.
+func (d *Data) batchIndexUpdate(batch storage.Batch, keyStr string, oldValue, newValue []byte) {
+	oldValues := d.fieldValues(oldValue)
+	newValues := d.fieldValues(newValue)
+	for fv := range oldValues {
+		if _, found := newValues[fv]; !found {
+			batch.Delete(NewFieldIndexTKey(fv.field, fv.value, keyStr))
+		}
+	}
+	for fv := range newValues {
+		if _, found := oldValues[fv]; !found {
+			batch.Put(NewFieldIndexTKey(fv.field, fv.value, keyStr), dvid.EmptyValue())
+		}
+	}
+}
+
+// returns a batch for JSON mode changes.
+func (d *Data) newJSONBatch(ctx storage.Context) (storage.Batch, error) {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return nil, fmt.Errorf("Unable to index keyvalue %q: store can't do batching!", d.DataName())
+	}
+	return batcher.NewBatch(ctx), nil
+}
+
+// putJSON validates a JSON value and, if the current value satisfies the preconditions,
+// stores it with any changes to field indices.
+func (d *Data) putJSON(ctx storage.Context, keyStr string, value []byte, cond Preconditions) error {
+	if err := d.validateJSON(keyStr, value); err != nil {
+		return err
+	}
+	serialization, err := dvid.SerializeData(value, d.Compression(), d.Checksum())
+	if err != nil {
+		return fmt.Errorf("Unable to serialize data: %v\n", err)
+	}
+	tk, err := NewTKey(keyStr)
+	if err != nil {
+		return err
+	}
+
+	// Reading the old value and writing the new value with its indices must be atomic.
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	var oldValue []byte
+	if len(d.IndexedFields) != 0 || !cond.Empty() {
+		var found bool
+		if oldValue, found, err = d.GetData(ctx, keyStr); err != nil {
+			return err
+		}
+		if !cond.Holds(oldValue, found) {
+			return ErrPreconditionFailed
+		}
+	}
+	batch, err := d.newJSONBatch(ctx)
+	if err != nil {
+		return err
+	}
+	batch.Put(tk, serialization)
+	d.batchIndexUpdate(batch, keyStr, oldValue, value)
+	return batch.Commit()
+}
+
+// deleteJSON deletes a JSON value and its field indices if the current value satisfies
+// the preconditions.
+func (d *Data) deleteJSON(ctx storage.Context, keyStr string, cond Preconditions) error {
+	tk, err := NewTKey(keyStr)
+	if err != nil {
+		return err
+	}
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	oldValue, found, err := d.GetData(ctx, keyStr)
+	if err != nil {
+		return err
+	}
+	if !cond.Holds(oldValue, found) {
+		return ErrPreconditionFailed
+	}
+	batch, err := d.newJSONBatch(ctx)
+	if err != nil {
+		return err
+	}
+	batch.Delete(tk)
+	d.batchIndexUpdate(batch, keyStr, oldValue, nil)
+	return batch.Commit()
+}
+
+// Query returns the keys, in order, of JSON values with indexed fields matching all the
+// given conditions.  Each condition maps a field to values, any of which can match.
+func (d *Data) Query(ctx storage.Context, conditions map[string][]string) ([]string, error) {
+	if !d.JSON {
+		return nil, fmt.Errorf("keyvalue %q is not in JSON mode so cannot be queried", d.DataName())
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("at least one field condition must be given for a query")
+	}
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	var matches map[string]struct{}
+	for field, values := range conditions {
+		if !d.isIndexed(field) {
+			return nil, fmt.Errorf("field %q is not indexed in keyvalue %q", field, d.DataName())
+		}
+		fieldMatches := make(map[string]struct{})
+		for _, value := range values {
+			minTKey, maxTKey := fieldIndexTKeyRange(field, value)
+			tkeys, err := db.KeysInRange(ctx, minTKey, maxTKey)
+			if err != nil {
+				return nil, err
+			}
+			for _, tk := range tkeys {
+				keyStr, err := decodeFieldIndexTKey(tk, field, value)
+				if err != nil {
+					return nil, err
+				}
+				if matches == nil {
+					fieldMatches[keyStr] = struct{}{}
+				} else if _, found := matches[keyStr]; found {
+					fieldMatches[keyStr] = struct{}{}
+				}
+			}
+		}
+		matches = fieldMatches
+		if len(matches) == 0 {
+			break
+		}
+	}
+	keys := make([]string, 0, len(matches))
+	for keyStr := range matches {
+		keys = append(keys, keyStr)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
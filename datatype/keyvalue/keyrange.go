@@ -0,0 +1,81 @@
+/*
+	This file supports streaming the key-value pairs in a range of keys.
+*/
+
+package keyvalue
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// errRangeLimit stops a range query once the requested number of key-value pairs is written.
+var errRangeLimit = errors.New("limit of key-value pairs reached")
+
+// WriteValuesInRange streams the key-value pairs with keys between keyBeg and keyEnd,
+// inclusive, in key order as a bundle in the given format.  If after is not empty, only
+// keys after it are written, so the last key of one call can be used as a cursor for the
+// next.  If limit is positive, at most limit pairs are written.  It returns the number
+// of key-value pairs written.  If an error occurs after pairs have been written, the
+// bundle is ended with an error marker.
+func (d *Data) WriteValuesInRange(ctx storage.Context, w io.Writer, keyBeg, keyEnd, after string, limit int, format string) (numWritten int, err error) {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return 0, err
+	}
+	bw, err := newBundleWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	if after > keyBeg {
+		keyBeg = after
+	}
+	first, err := NewTKey(keyBeg)
+	if err != nil {
+		return 0, err
+	}
+	last, err := NewTKey(keyEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil && numWritten > 0 {
+			endWithError(bw, err)
+		}
+	}()
+	err = db.ProcessRange(ctx, first, last, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+		if c == nil || c.TKeyValue == nil {
+			return nil
+		}
+		keyStr, err := DecodeTKey(c.K)
+		if err != nil {
+			return err
+		}
+		if after != "" && keyStr == after {
+			return nil
+		}
+		var value []byte
+		if c.V != nil {
+			if value, _, err = dvid.DeserializeData(c.V, true); err != nil {
+				return fmt.Errorf("Unable to deserialize data for key '%s': %v\n", keyStr, err)
+			}
+		}
+		if err := bw.writePair(keyStr, value); err != nil {
+			return err
+		}
+		numWritten++
+		if limit > 0 && numWritten >= limit {
+			return errRangeLimit
+		}
+		return nil
+	})
+	if err != nil && err != errRangeLimit {
+		return numWritten, err
+	}
+	return numWritten, bw.close()
+}
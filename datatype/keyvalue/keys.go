@@ -16,6 +16,10 @@ const (
 
 	// the byte id for a standard key of a keyvalue
 	keyStandard = 177
+
+	// the byte id for an index of a JSON value's field.  The key is the field name, the
+	// field value, and the keyvalue key, each terminated by a 0 byte.
+	keyFieldIndex = 178
 )
 
 // NewTKey returns the "key" key component.
@@ -38,3 +42,42 @@ func DecodeTKey(tk storage.TKey) (string, error) {
 	}
 	return string(ibytes[:sz]), nil
 }
+
+// returns the prefix of field index keys for the given field name and value.
+func fieldIndexPrefix(field, value string) []byte {
+	ibytes := make([]byte, 0, len(field)+len(value)+2)
+	ibytes = append(ibytes, field...)
+	ibytes = append(ibytes, 0)
+	ibytes = append(ibytes, value...)
+	return append(ibytes, 0)
+}
+
+// NewFieldIndexTKey returns a TKey for the index of a key with the given field value.
+func NewFieldIndexTKey(field, value, key string) storage.TKey {
+	ibytes := fieldIndexPrefix(field, value)
+	ibytes = append(ibytes, key...)
+	return storage.NewTKey(keyFieldIndex, append(ibytes, 0))
+}
+
+// returns the range of field index TKeys for all keys with the given field value.
+func fieldIndexTKeyRange(field, value string) (minTKey, maxTKey storage.TKey) {
+	minTKey = storage.NewTKey(keyFieldIndex, fieldIndexPrefix(field, value))
+	maxPrefix := fieldIndexPrefix(field, value)
+	maxPrefix[len(maxPrefix)-1] = 1
+	maxTKey = storage.NewTKey(keyFieldIndex, maxPrefix)
+	return
+}
+
+// decodeFieldIndexTKey returns the keyvalue key of a field index TKey for the given
+// field name and value.
+func decodeFieldIndexTKey(tk storage.TKey, field, value string) (string, error) {
+	ibytes, err := tk.ClassBytes(keyFieldIndex)
+	if err != nil {
+		return "", err
+	}
+	prefixLen := len(field) + len(value) + 2
+	if len(ibytes) < prefixLen+1 || ibytes[len(ibytes)-1] != 0 {
+		return "", fmt.Errorf("bad field index key for field %q, value %q", field, value)
+	}
+	return string(ibytes[prefixLen : len(ibytes)-1]), nil
+}
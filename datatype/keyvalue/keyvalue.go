@@ -8,14 +8,18 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/server"
 	"github.com/janelia-flyem/dvid/storage"
+	"github.com/janelia-flyem/gojsonschema"
 )
 
 const (
@@ -52,6 +56,13 @@ $ dvid repo <UUID> new keyvalue <data name> <settings...>
                    versioned data, distribution (push/pull) of unversioned data is not defined 
                    at this time.
 
+    JSON           Set to "true" or "1" if values must be JSON documents.  In JSON mode, values
+                   can be validated against a JSON Schema set with the "schema" endpoint and
+                   queried by indexed fields with the "query" endpoint.
+
+    IndexedFields  Comma-separated list of top-level fields of JSON values that should be
+                   indexed for the "query" endpoint, e.g., "status,user".  Requires JSON mode.
+
 $ dvid -stdin node <UUID> <data name> put <key> < data
 
 	Puts stdin data into the keyvalue data instance under the given key.
@@ -105,6 +116,40 @@ GET  <api URL>/node/<UUID>/<data name>/keyrange/<key1>/<key2>
     key1          First alphanumeric key in range.
     key2          Last alphanumeric key in range.
 
+GET  <api URL>/node/<UUID>/<data name>/keyrangevalues/<key1>/<key2>[?limit=N&after=<key>&format=json|tar|binary]
+
+    Streams all key-value pairs with keys between 'key1' and 'key2', inclusive, in key order.
+    The pairs are read from storage as they are written to the response, so large ranges
+    can be exported without holding them in memory.  Use the "limit" and "after" query
+    strings to page through a range: pass the last key of a page as "after" to get the
+    next page.  A page with fewer than "limit" pairs is the last page.
+
+    The default JSON format returns an object mapping keys to values, where every value
+    must be valid JSON.  The "tar" and "binary" formats allow arbitrary values and are
+    described in the "keyvalues" endpoint below.
+
+    If an error occurs before any pairs are written, an error status is returned.  If an
+    error occurs after pairs have been written, e.g., a value that is not valid JSON in the
+    json format, the response ends with an error marker: a final pair with an empty key,
+    which is never a valid key, whose value is the error message as a JSON string.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/stuff/keyrangevalues/a/d?limit=1000&after=cat
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of keyvalue data instance.
+    key1          First alphanumeric key in range.
+    key2          Last alphanumeric key in range.
+
+    Query-string Options:
+
+    limit         Maximum number of key-value pairs to return.  No limit if not given.
+    after         Only return key-value pairs with keys after this key.
+    format        Format of the returned pairs, either "json" (default), "tar", or "binary".
+
 GET  <api URL>/node/<UUID>/<data name>/key/<key>
 POST <api URL>/node/<UUID>/<data name>/key/<key>
 DEL  <api URL>/node/<UUID>/<data name>/key/<key> 
@@ -121,11 +166,143 @@ DEL  <api URL>/node/<UUID>/<data name>/key/<key>
     The "Content-type" of the HTTP response (and usually the request) are
     "application/octet-stream" for arbitrary binary data.
 
+    A GET returns the value's entity tag, a hash of its content, in the "ETag" header, as
+    does a successful POST for the new value.  A GET with an "If-None-Match" header that
+    matches the entity tag returns status code 304 (Not Modified) without the value.
+
+    A POST or DELETE can be made conditional on the current value using the "If-Match" and
+    "If-None-Match" headers with a comma-separated list of entity tags, where "*" matches
+    any existing value.  For example, "If-Match" with the entity tag from a previous GET
+    only writes if the value is unchanged, and "If-None-Match: *" only writes if the key
+    does not exist.  If the preconditions are not met, status code 412 (Precondition Failed)
+    is returned.  The check and write are atomic.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of keyvalue data instance.
+    key           An alphanumeric key.
+
+GET  <api URL>/node/<UUID>/<data name>/key/<key>/history[?values=true]
+
+    Returns how the value of a key evolved through the version DAG as a JSON list with an
+    entry for the given version and each of its ancestors in which the key was written or
+    deleted, ordered from the given version toward the root:
+
+    [
+        {
+            "UUID": "3f8c...",
+            "Deleted": false,
+            "Size": 14,
+            "Created": "2017-06-12T10:21:09.453Z",
+            "Updated": "2017-06-14T17:02:45.112Z"
+        },
+        ...
+    ]
+
+    "Deleted" is true if the key was deleted in the version, and "Size" is the number of
+    bytes in the value written.  Since writes are not timestamped, "Created" and "Updated"
+    are the creation and last update times of the version node.
+
     Arguments:
 
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of keyvalue data instance.
     key           An alphanumeric key.
+
+    Query-string Options:
+
+    values        If "true", each written version includes its value in "Value", encoded
+                    as a base64 string.
+
+GET  <api URL>/node/<UUID>/<data name>/schema
+POST <api URL>/node/<UUID>/<data name>/schema
+DEL  <api URL>/node/<UUID>/<data name>/schema
+
+    Retrieves, sets, or removes the JSON Schema that values must satisfy for keyvalue data
+    in JSON mode.  The schema only applies to values put after it is set.  A GET returns
+    status code 404 if there is no schema.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of keyvalue data instance.
+
+GET  <api URL>/node/<UUID>/<data name>/query?<field>=<value>[&<field>=<value>...]
+
+    Returns a JSON object mapping keys to values for all JSON values whose indexed fields
+    match all the given conditions.  Only fields listed in the IndexedFields configuration
+    setting can be queried.  If a field is given more than once, any of its values can match.
+    The server-wide "interactive" query string is not treated as a field condition.
+
+    An indexed field matches if its value is a string equal to the given value, a number or
+    boolean whose JSON text equals the given value, or an array with such an element.  The
+    indices are versioned like the values, so queries reflect the values at the given version.
+
+    Example: 
+
+    GET <api URL>/node/3f8c/bodyannotations/query?status=Traced&user=alice
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of keyvalue data instance.
+
+POST <api URL>/node/<UUID>/<data name>/keyvalues[?keys=json|binary&format=tar|binary|json]
+
+    Returns the values of many keys in one request.  The request body is a list of keys,
+    either a JSON array of strings (default) or, if "keys=binary", a sequence of keys each
+    preceded by its length as a little-endian uint32.  Values are streamed back in the
+    order of the requested keys.  Keys that are not found are omitted from the response,
+    so they can be distinguished from keys with empty values.  Keys can be at most 65536
+    bytes.  Errors after pairs have been written end the response with an error marker as
+    described in the "keyrangevalues" endpoint.
+
+    The "Content-type" of the HTTP response is "application/x-tar" for the default tar
+    format, where each file name is a key and the file contents is its value.  For the
+    binary format, the response is "application/octet-stream" with each key-value pair
+    given by:
+
+        uint32   Length of key in bytes (little-endian)
+        bytes    Key
+        uint64   Length of value in bytes (little-endian)
+        bytes    Value
+
+    The "json" format returns an object mapping keys to values, where every value must be
+    valid JSON and empty values are null.
+
+    Example: 
+
+    POST <api URL>/node/3f8c/stuff/keyvalues
+
+    Body: ["myfile.dat", "myotherfile.dat"]
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of keyvalue data instance.
+
+    Query-string Options:
+
+    keys          Format of the list of keys, either "json" (default) or "binary".
+    format        Format of the returned values, either "tar" (default), "binary", or "json".
+
+POST <api URL>/node/<UUID>/<data name>/keyvalues-put[?format=tar|binary]
+
+    Stores many key-value pairs in one request using a single storage batch.  The request
+    body is a bundle of key-value pairs in the same formats returned by the "keyvalues"
+    endpoint: a tar archive (default) where each file name is a key and the file contents
+    is its value, or the binary format of length-prefixed keys and values.  Keys can be at
+    most 65536 bytes and values at most 1 GiB.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of keyvalue data instance.
+
+    Query-string Options:
+
+    format        Format of the key-value pairs, either "tar" (default) or "binary".
 `
 
 func init() {
@@ -163,32 +340,46 @@ func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.
 	if err != nil {
 		return nil, err
 	}
-	return &Data{basedata}, nil
+	data := &Data{Data: basedata}
+	if err := data.Properties.setByConfig(c); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 func (dtype *Type) Help() string {
 	return fmt.Sprintf(HelpMessage)
 }
 
-// Data embeds the datastore's Data and extends it with keyvalue properties (none for now).
+// Data embeds the datastore's Data and extends it with keyvalue properties.
 type Data struct {
 	*datastore.Data
+	Properties
+
+	// guards changes to the JSON schema and its compiled form.
+	schemaMu sync.RWMutex
+	schema   *gojsonschema.JsonSchemaDocument
 }
 
 func (d *Data) Equals(d2 *Data) bool {
 	if !d.Data.Equals(d2.Data) {
 		return false
 	}
+	if !d.Properties.Equals(d2.Properties) {
+		return false
+	}
 	return true
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
+	d.schemaMu.RLock()
+	defer d.schemaMu.RUnlock()
 	return json.Marshal(struct {
 		Base     *datastore.Data
-		Extended struct{}
+		Extended Properties
 	}{
 		d.Data,
-		struct{}{},
+		d.Properties,
 	})
 }
 
@@ -198,6 +389,17 @@ func (d *Data) GobDecode(b []byte) error {
 	if err := dec.Decode(&(d.Data)); err != nil {
 		return err
 	}
+	// Data stored before keyvalue properties were added has no properties.
+	if err := dec.Decode(&(d.Properties)); err != nil && err != io.EOF {
+		return err
+	}
+	if d.Schema != "" {
+		schema, err := parseSchema([]byte(d.Schema))
+		if err != nil {
+			return err
+		}
+		d.schema = schema
+	}
 	return nil
 }
 
@@ -207,6 +409,11 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.Data); err != nil {
 		return nil, err
 	}
+	d.schemaMu.RLock()
+	defer d.schemaMu.RUnlock()
+	if err := enc.Encode(d.Properties); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -289,8 +496,21 @@ func (d *Data) GetData(ctx storage.Context, keyStr string) ([]byte, bool, error)
 	return value, true, nil
 }
 
-// PutData puts a key-value at a given uuid
+// PutData puts a key-value at a given uuid.  In JSON mode, the value is validated and
+// the indices of its fields are updated.  All writes hold the context mutex, so they are
+// atomic with respect to conditional writes.
 func (d *Data) PutData(ctx storage.Context, keyStr string, value []byte) error {
+	if d.JSON {
+		return d.putJSON(ctx, keyStr, value, Preconditions{})
+	}
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+	return d.putData(ctx, keyStr, value)
+}
+
+// puts a key-value without JSON handling.  The caller must hold the context mutex.
+func (d *Data) putData(ctx storage.Context, keyStr string, value []byte) error {
 	db, err := d.GetOrderedKeyValueDB()
 	if err != nil {
 		return err
@@ -308,6 +528,17 @@ func (d *Data) PutData(ctx storage.Context, keyStr string, value []byte) error {
 
 // DeleteData deletes a key-value pair
 func (d *Data) DeleteData(ctx storage.Context, keyStr string) error {
+	if len(d.IndexedFields) != 0 {
+		return d.deleteJSON(ctx, keyStr, Preconditions{})
+	}
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+	return d.deleteData(ctx, keyStr)
+}
+
+// deletes a key-value pair without index handling.  The caller must hold the context mutex.
+func (d *Data) deleteData(ctx storage.Context, keyStr string) error {
 	db, err := d.GetOrderedKeyValueDB()
 	if err != nil {
 		return err
@@ -368,6 +599,16 @@ func (d *Data) Help() string {
 	return fmt.Sprintf(HelpMessage)
 }
 
+// IsMutationRequest overrides the default behavior to specify POST of keyvalues, which
+// retrieves values, as an immutable request.
+func (d *Data) IsMutationRequest(action, endpoint string) bool {
+	lc := strings.ToLower(action)
+	if endpoint == "keyvalues" && lc == "post" {
+		return false
+	}
+	return d.Data.IsMutationRequest(action, endpoint) // default for rest.
+}
+
 // DoRPC acts as a switchboard for RPC commands.
 func (d *Data) DoRPC(request datastore.Request, reply *datastore.Response) error {
 	switch request.TypeCommand() {
@@ -452,6 +693,171 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		fmt.Fprintf(w, string(jsonBytes))
 		comment = fmt.Sprintf("HTTP GET keyrange [%q, %q]", keyBeg, keyEnd)
 
+	case "schema":
+		switch action {
+		case "get":
+			schemaJSON := d.GetSchema()
+			if schemaJSON == "" {
+				http.Error(w, fmt.Sprintf("keyvalue %q has no JSON schema", d.DataName()), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, schemaJSON)
+		case "post":
+			schemaJSON, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			if len(bytes.TrimSpace(schemaJSON)) == 0 {
+				server.BadRequest(w, r, "expected JSON schema in POST body")
+				return
+			}
+			if err := d.SetSchema(uuid, schemaJSON); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+		case "delete":
+			if err := d.SetSchema(uuid, nil); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+		default:
+			server.BadRequest(w, r, "schema endpoint does not support %q HTTP verb", action)
+			return
+		}
+		comment = fmt.Sprintf("HTTP %s schema of keyvalue %q\n", r.Method, d.DataName())
+
+	case "query":
+		if action != "get" {
+			server.BadRequest(w, r, "query endpoint only supports GET HTTP verb")
+			return
+		}
+		// Server-wide query strings like "interactive" aren't field conditions.
+		conditions := r.URL.Query()
+		delete(conditions, "interactive")
+		keys, err := d.Query(ctx, conditions)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if numPairs, err := d.WriteValues(ctx, w, keys, FormatJSON); err != nil {
+			if numPairs == 0 {
+				server.BadRequest(w, r, err)
+			} else {
+				dvid.Errorf("Error in query of keyvalue %q after %d values: %v\n", d.DataName(), numPairs, err)
+			}
+			return
+		}
+		comment = fmt.Sprintf("HTTP GET query of keyvalue %q: %d matching keys (%s)\n", d.DataName(), len(keys), url)
+
+	case "keyrangevalues":
+		if action != "get" {
+			server.BadRequest(w, r, "keyrangevalues endpoint only supports GET HTTP verb")
+			return
+		}
+		if len(parts) < 6 {
+			server.BadRequest(w, r, "expect beginning and end keys to follow 'keyrangevalues' endpoint")
+			return
+		}
+		keyBeg := parts[4]
+		keyEnd := parts[5]
+		queryStrings := r.URL.Query()
+		var limit int
+		if limitStr := queryStrings.Get("limit"); limitStr != "" {
+			var err error
+			if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+				server.BadRequest(w, r, "limit query string must be a non-negative integer, not %q", limitStr)
+				return
+			}
+		}
+		after := queryStrings.Get("after")
+		format := queryStrings.Get("format")
+		switch format {
+		case "", FormatJSON:
+			format = FormatJSON
+			w.Header().Set("Content-Type", "application/json")
+		case FormatTar:
+			w.Header().Set("Content-Type", "application/x-tar")
+		case FormatBinary:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		default:
+			server.BadRequest(w, r, "unknown key-value bundle format %q", format)
+			return
+		}
+		numPairs, err := d.WriteValuesInRange(ctx, w, keyBeg, keyEnd, after, limit, format)
+		if err != nil {
+			if numPairs == 0 {
+				server.BadRequest(w, r, err)
+			} else {
+				dvid.Errorf("Error in keyrangevalues of keyvalue %q after %d values: %v\n", d.DataName(), numPairs, err)
+			}
+			return
+		}
+		comment = fmt.Sprintf("HTTP GET keyrangevalues [%q, %q] after %q of keyvalue %q: %d key-values (%s)\n", keyBeg, keyEnd, after, d.DataName(), numPairs, url)
+
+	case "keyvalues":
+		if action != "post" {
+			server.BadRequest(w, r, "keyvalues endpoint only supports POST HTTP verb")
+			return
+		}
+		queryStrings := r.URL.Query()
+		binaryKeys := false
+		switch queryStrings.Get("keys") {
+		case "", "json":
+		case "binary":
+			binaryKeys = true
+		default:
+			server.BadRequest(w, r, "keys query string must be 'json' or 'binary', not %q", queryStrings.Get("keys"))
+			return
+		}
+		format := queryStrings.Get("format")
+		if format == "" {
+			format = FormatTar
+		}
+		keys, err := ReadKeys(r.Body, binaryKeys)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		switch format {
+		case FormatTar:
+			w.Header().Set("Content-Type", "application/x-tar")
+		case FormatBinary:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		case FormatJSON:
+			w.Header().Set("Content-Type", "application/json")
+		default:
+			server.BadRequest(w, r, "unknown key-value bundle format %q", format)
+			return
+		}
+		if numPairs, err := d.WriteValues(ctx, w, keys, format); err != nil {
+			if numPairs == 0 {
+				server.BadRequest(w, r, err)
+			} else {
+				dvid.Errorf("Error in keyvalues of keyvalue %q after %d values: %v\n", d.DataName(), numPairs, err)
+			}
+			return
+		}
+		comment = fmt.Sprintf("HTTP POST keyvalues of keyvalue %q: %d keys, %s format (%s)\n", d.DataName(), len(keys), format, url)
+
+	case "keyvalues-put":
+		if action != "post" {
+			server.BadRequest(w, r, "keyvalues-put endpoint only supports POST HTTP verb")
+			return
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = FormatTar
+		}
+		numPut, err := d.PutValues(ctx, r.Body, format)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		comment = fmt.Sprintf("HTTP POST keyvalues-put of keyvalue %q: %d key-values (%s)\n", d.DataName(), numPut, url)
+
 	case "key":
 		if len(parts) < 5 {
 			server.BadRequest(w, r, "expect key string to follow 'key' endpoint")
@@ -459,6 +865,28 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 		keyStr := parts[4]
 
+		if len(parts) > 5 && parts[5] == "history" {
+			if action != "get" {
+				server.BadRequest(w, r, "key history only supports GET HTTP verb")
+				return
+			}
+			withValues := r.URL.Query().Get("values") == "true"
+			history, err := d.GetHistory(ctx, keyStr, withValues)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			jsonBytes, err := json.Marshal(history)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(jsonBytes)
+			comment = fmt.Sprintf("HTTP GET history of key %q of keyvalue %q: %d versions (%s)\n", keyStr, d.DataName(), len(history), url)
+			break
+		}
+
 		switch action {
 		case "get":
 			// Return value of single key
@@ -471,18 +899,30 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				http.Error(w, fmt.Sprintf("Key %q not found", keyStr), http.StatusNotFound)
 				return
 			}
+			etag := ETag(value)
+			w.Header().Set("ETag", etag)
+			if etagsMatch(parseETags(r.Header.Get("If-None-Match")), value, found) {
+				w.WriteHeader(http.StatusNotModified)
+				comment = fmt.Sprintf("HTTP GET key %q of keyvalue %q: not modified (%s)\n", keyStr, d.DataName(), url)
+				break
+			}
 			if value != nil || len(value) > 0 {
+				w.Header().Set("Content-Type", "application/octet-stream")
 				_, err = w.Write(value)
 				if err != nil {
 					server.BadRequest(w, r, err)
 					return
 				}
-				w.Header().Set("Content-Type", "application/octet-stream")
 			}
 			comment = fmt.Sprintf("HTTP GET key %q of keyvalue %q: %d bytes (%s)\n", keyStr, d.DataName(), len(value), url)
 
 		case "delete":
-			if err := d.DeleteData(ctx, keyStr); err != nil {
+			err := d.DeleteDataIf(ctx, keyStr, ParsePreconditions(r.Header))
+			if err == ErrPreconditionFailed {
+				http.Error(w, fmt.Sprintf("Key %q of keyvalue %q does not satisfy preconditions", keyStr, d.DataName()), http.StatusPreconditionFailed)
+				return
+			}
+			if err != nil {
 				server.BadRequest(w, r, err)
 				return
 			}
@@ -494,11 +934,16 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, err)
 				return
 			}
-			err = d.PutData(ctx, keyStr, data)
+			err = d.PutDataIf(ctx, keyStr, data, ParsePreconditions(r.Header))
+			if err == ErrPreconditionFailed {
+				http.Error(w, fmt.Sprintf("Key %q of keyvalue %q does not satisfy preconditions", keyStr, d.DataName()), http.StatusPreconditionFailed)
+				return
+			}
 			if err != nil {
 				server.BadRequest(w, r, err)
 				return
 			}
+			w.Header().Set("ETag", ETag(data))
 			comment = fmt.Sprintf("HTTP POST keyvalue '%s': %d bytes (%s)\n", d.DataName(), len(data), url)
 		default:
 			server.BadRequest(w, r, "key endpoint does not support %q HTTP verb", action)
@@ -1,10 +1,18 @@
 package keyvalue
 
 import (
+	"archive/tar"
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -220,6 +228,523 @@ func TestKeyvalueRequests(t *testing.T) {
 	testRequest(t, uuid, versionID, "mykeyvalue")
 }
 
+func TestKeyvalueBatch(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "keyvalue", "batchkv", dvid.Config{})
+
+	// Put key-values as a tar archive.
+	values := map[string]string{
+		"key1":        "some stuff",
+		"another key": "more stuff",
+		"key3":        "",
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, key := range []string{"key1", "another key", "key3"} {
+		if err := tw.WriteHeader(&tar.Header{Name: key, Mode: 0644, Size: int64(len(values[key]))}); err != nil {
+			t.Fatalf("unable to write tar header: %v\n", err)
+		}
+		if _, err := tw.Write([]byte(values[key])); err != nil {
+			t.Fatalf("unable to write tar file: %v\n", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unable to close tar: %v\n", err)
+	}
+	putreq := fmt.Sprintf("%snode/%s/batchkv/keyvalues-put", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", putreq, &buf)
+
+	keyreq := fmt.Sprintf("%snode/%s/batchkv/key/another%%20key", server.WebAPIPath, uuid)
+	if returnValue := server.TestHTTP(t, "GET", keyreq, nil); string(returnValue) != "more stuff" {
+		t.Errorf("expected value %q for batch-put key, got %q\n", "more stuff", string(returnValue))
+	}
+
+	// Get values as a tar archive, where a missing key is omitted.
+	getreq := fmt.Sprintf("%snode/%s/batchkv/keyvalues", server.WebAPIPath, uuid)
+	returnValue := server.TestHTTP(t, "POST", getreq, strings.NewReader(`["key3","missing","key1"]`))
+	tr := tar.NewReader(bytes.NewReader(returnValue))
+	expected := []struct{ key, value string }{{"key3", ""}, {"key1", "some stuff"}}
+	for _, exp := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("error reading tar of values: %v\n", err)
+		}
+		value, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("error reading tar file: %v\n", err)
+		}
+		if hdr.Name != exp.key || string(value) != exp.value {
+			t.Errorf("expected key %q with value %q, got key %q with value %q\n", exp.key, exp.value, hdr.Name, string(value))
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected end of tar of values, got %v\n", err)
+	}
+
+	// Put and get using binary keys and values.
+	buf.Reset()
+	for _, kv := range []struct{ key, value string }{{"bin1", "binary value"}, {"key1", "changed"}} {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(kv.key)))
+		buf.WriteString(kv.key)
+		binary.Write(&buf, binary.LittleEndian, uint64(len(kv.value)))
+		buf.WriteString(kv.value)
+	}
+	server.TestHTTP(t, "POST", putreq+"?format=binary", &buf)
+
+	buf.Reset()
+	for _, key := range []string{"bin1", "key1", "another key"} {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(key)))
+		buf.WriteString(key)
+	}
+	returnValue = server.TestHTTP(t, "POST", getreq+"?keys=binary&format=binary", &buf)
+	expected = []struct{ key, value string }{{"bin1", "binary value"}, {"key1", "changed"}, {"another key", "more stuff"}}
+	r := bytes.NewReader(returnValue)
+	for _, exp := range expected {
+		var keyLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &keyLen); err != nil {
+			t.Fatalf("error reading key length: %v\n", err)
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			t.Fatalf("error reading key: %v\n", err)
+		}
+		var valueLen uint64
+		if err := binary.Read(r, binary.LittleEndian, &valueLen); err != nil {
+			t.Fatalf("error reading value length: %v\n", err)
+		}
+		value := make([]byte, valueLen)
+		if _, err := io.ReadFull(r, value); err != nil {
+			t.Fatalf("error reading value: %v\n", err)
+		}
+		if string(key) != exp.key || string(value) != exp.value {
+			t.Errorf("expected key %q with value %q, got key %q with value %q\n", exp.key, exp.value, string(key), string(value))
+		}
+	}
+	if r.Len() != 0 {
+		t.Errorf("expected end of binary values, got %d more bytes\n", r.Len())
+	}
+
+	// Lengths beyond the maximums or the request body are rejected.
+	buf.Reset()
+	binary.Write(&buf, binary.LittleEndian, uint32(MaxKeyLen+1))
+	server.TestBadHTTP(t, "POST", getreq+"?keys=binary", &buf)
+	buf.Reset()
+	binary.Write(&buf, binary.LittleEndian, uint32(4))
+	buf.WriteString("big1")
+	binary.Write(&buf, binary.LittleEndian, uint64(1)<<40)
+	server.TestBadHTTP(t, "POST", putreq+"?format=binary", &buf)
+	buf.Reset()
+	binary.Write(&buf, binary.LittleEndian, uint32(4))
+	buf.WriteString("big2")
+	binary.Write(&buf, binary.LittleEndian, uint64(1000))
+	buf.WriteString("too short")
+	server.TestBadHTTP(t, "POST", putreq+"?format=binary", &buf)
+
+	// Values can be retrieved from committed nodes.
+	if err := datastore.Commit(uuid, "batch put", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid, err)
+	}
+	returnValue = server.TestHTTP(t, "POST", getreq+"?format=binary", strings.NewReader(`["key3"]`))
+	if expectedLen := 4 + len("key3") + 8; len(returnValue) != expectedLen {
+		t.Errorf("expected %d bytes for empty value from committed node, got %d\n", expectedLen, len(returnValue))
+	}
+	server.TestBadHTTP(t, "POST", putreq, strings.NewReader(""))
+
+	// Bad requests
+	server.TestBadHTTP(t, "GET", getreq, nil)
+	server.TestBadHTTP(t, "POST", getreq, strings.NewReader(`not json`))
+	server.TestBadHTTP(t, "POST", getreq+"?format=zip", strings.NewReader(`["key1"]`))
+	server.TestBadHTTP(t, "POST", putreq+"?format=binary", bytes.NewReader([]byte{3, 0, 0, 0, 'a'}))
+}
+
+func TestKeyvalueRangeValues(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "keyvalue", "rangekv", dvid.Config{})
+
+	for i, key := range []string{"a", "b1", "b2", "b3", "b4", "b5", "c"} {
+		keyreq := fmt.Sprintf("%snode/%s/rangekv/key/%s", server.WebAPIPath, uuid, key)
+		server.TestHTTP(t, "POST", keyreq, strings.NewReader(fmt.Sprintf(`{"num":%d}`, i)))
+	}
+
+	// Page through the range in JSON.
+	var keys []string
+	var after string
+	for numPages := 0; ; numPages++ {
+		if numPages > 3 {
+			t.Fatalf("too many pages returned for key range\n")
+		}
+		rangereq := fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/b/b9?limit=2&after=%s", server.WebAPIPath, uuid, after)
+		returnValue := server.TestHTTP(t, "GET", rangereq, nil)
+		var page map[string]struct{ Num int }
+		if err := json.Unmarshal(returnValue, &page); err != nil {
+			t.Fatalf("unable to decode page of key-values %q: %v\n", string(returnValue), err)
+		}
+		var pageKeys []string
+		for key, value := range page {
+			if expected := int(key[1] - '0'); value.Num != expected {
+				t.Errorf("expected value %d for key %q, got %d\n", expected, key, value.Num)
+			}
+			pageKeys = append(pageKeys, key)
+		}
+		sort.Strings(pageKeys)
+		keys = append(keys, pageKeys...)
+		if len(pageKeys) < 2 {
+			break
+		}
+		after = pageKeys[len(pageKeys)-1]
+	}
+	if strings.Join(keys, ",") != "b1,b2,b3,b4,b5" {
+		t.Errorf("expected keys b1 to b5 from paging through range, got %v\n", keys)
+	}
+
+	// Get whole range as tar.
+	rangereq := fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/a/b2?format=tar", server.WebAPIPath, uuid)
+	returnValue := server.TestHTTP(t, "GET", rangereq, nil)
+	tr := tar.NewReader(bytes.NewReader(returnValue))
+	expected := []struct{ key, value string }{{"a", `{"num":0}`}, {"b1", `{"num":1}`}, {"b2", `{"num":2}`}}
+	for _, exp := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("error reading tar of values: %v\n", err)
+		}
+		value, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("error reading tar file: %v\n", err)
+		}
+		if hdr.Name != exp.key || string(value) != exp.value {
+			t.Errorf("expected key %q with value %q, got key %q with value %q\n", exp.key, exp.value, hdr.Name, string(value))
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected end of tar of values, got %v\n", err)
+	}
+
+	// A value that isn't JSON fails the request if it's first and otherwise ends the
+	// response with an error marker.
+	keyreq := fmt.Sprintf("%snode/%s/rangekv/key/b6", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", keyreq, strings.NewReader("not json"))
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/b6/b6", server.WebAPIPath, uuid), nil)
+	rangereq = fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/b5/b6", server.WebAPIPath, uuid)
+	returnValue = server.TestHTTP(t, "GET", rangereq, nil)
+	var withErr map[string]json.RawMessage
+	if err := json.Unmarshal(returnValue, &withErr); err != nil {
+		t.Fatalf("unable to decode key-values ending with error %q: %v\n", string(returnValue), err)
+	}
+	var errMsg string
+	if err := json.Unmarshal(withErr[""], &errMsg); err != nil || !strings.Contains(errMsg, "b6") {
+		t.Errorf("expected error marker for key b6, got %q\n", string(returnValue))
+	}
+	if string(withErr["b5"]) != `{"num":5}` {
+		t.Errorf("expected value of b5 before error marker, got %q\n", string(returnValue))
+	}
+
+	// Empty range
+	rangereq = fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/d/e", server.WebAPIPath, uuid)
+	if returnValue = server.TestHTTP(t, "GET", rangereq, nil); string(returnValue) != "{}" {
+		t.Errorf("expected empty JSON object for empty range, got %q\n", string(returnValue))
+	}
+
+	// Bad requests
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/a", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/a/c?limit=-1", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/rangekv/keyrangevalues/a/c?format=zip", server.WebAPIPath, uuid), nil)
+}
+
+func TestKeyvalueJSON(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	// IndexedFields requires JSON mode.
+	config := dvid.NewConfig()
+	config.Set("IndexedFields", "status")
+	if _, err := datastore.NewData(uuid, kvtype, "notjson", config); err == nil {
+		t.Fatalf("expected error creating keyvalue with indexed fields without JSON mode\n")
+	}
+
+	config = dvid.NewConfig()
+	config.Set("JSON", "true")
+	config.Set("IndexedFields", "status, tags")
+	dataservice, err := datastore.NewData(uuid, kvtype, "bodyannotations", config)
+	if err != nil {
+		t.Fatalf("Error creating new keyvalue instance: %v\n", err)
+	}
+	data, ok := dataservice.(*Data)
+	if !ok {
+		t.Fatalf("Returned new data instance is not keyvalue.Data\n")
+	}
+	if !data.JSON || !reflect.DeepEqual(data.IndexedFields, []string{"status", "tags"}) {
+		t.Fatalf("expected JSON mode with indexed fields [status tags], got %v\n", data.Properties)
+	}
+
+	keyURL := func(uuid dvid.UUID, key string) string {
+		return fmt.Sprintf("%snode/%s/bodyannotations/key/%s", server.WebAPIPath, uuid, key)
+	}
+	queryKeys := func(uuid dvid.UUID, query string) []string {
+		queryreq := fmt.Sprintf("%snode/%s/bodyannotations/query?%s", server.WebAPIPath, uuid, query)
+		returnValue := server.TestHTTP(t, "GET", queryreq, nil)
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(returnValue, &values); err != nil {
+			t.Fatalf("unable to decode query response %q: %v\n", string(returnValue), err)
+		}
+		keys := []string{}
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	// Set a schema requiring a string status.
+	schemareq := fmt.Sprintf("%snode/%s/bodyannotations/schema", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", schemareq, nil)
+	schema := `{"type": "object", "properties": {"status": {"type": "string"}}, "required": ["status"]}`
+	server.TestHTTP(t, "POST", schemareq, strings.NewReader(schema))
+	if returnValue := server.TestHTTP(t, "GET", schemareq, nil); string(returnValue) != schema {
+		t.Errorf("expected schema %s, got %s\n", schema, string(returnValue))
+	}
+	server.TestBadHTTP(t, "POST", schemareq, strings.NewReader(`{"type": `))
+
+	server.TestHTTP(t, "POST", keyURL(uuid, "100"), strings.NewReader(`{"status": "Traced", "tags": ["a", "b"]}`))
+	server.TestHTTP(t, "POST", keyURL(uuid, "200"), strings.NewReader(`{"status": "Orphan", "tags": ["b"]}`))
+	server.TestHTTP(t, "POST", keyURL(uuid, "300"), strings.NewReader(`{"status": "Traced"}`))
+	server.TestBadHTTP(t, "POST", keyURL(uuid, "400"), strings.NewReader(`not json`))
+	server.TestBadHTTP(t, "POST", keyURL(uuid, "400"), strings.NewReader(`{"status": 4}`))
+	server.TestBadHTTP(t, "POST", keyURL(uuid, "400"), strings.NewReader(`{"tags": ["a"]}`))
+
+	if keys := queryKeys(uuid, "status=Traced"); !reflect.DeepEqual(keys, []string{"100", "300"}) {
+		t.Errorf("expected keys [100 300] for status=Traced, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid, "tags=b"); !reflect.DeepEqual(keys, []string{"100", "200"}) {
+		t.Errorf("expected keys [100 200] for tags=b, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid, "status=Traced&tags=b"); !reflect.DeepEqual(keys, []string{"100"}) {
+		t.Errorf("expected keys [100] for status=Traced and tags=b, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid, "status=Traced&status=Orphan"); !reflect.DeepEqual(keys, []string{"100", "200", "300"}) {
+		t.Errorf("expected keys [100 200 300] for status Traced or Orphan, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid, "status=Traced&interactive=false"); !reflect.DeepEqual(keys, []string{"100", "300"}) {
+		t.Errorf("expected keys [100 300] for non-interactive status=Traced, got %v\n", keys)
+	}
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/bodyannotations/query?interactive=false", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/bodyannotations/query?user=alice", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/bodyannotations/query", server.WebAPIPath, uuid), nil)
+
+	// Indices should be versioned like the values.
+	if err = datastore.Commit(uuid, "annotated bodies", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "some child", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create new version off node %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyURL(uuid2, "100"), strings.NewReader(`{"status": "Orphan", "tags": ["a"]}`))
+	server.TestHTTP(t, "DELETE", keyURL(uuid2, "300"), nil)
+
+	if keys := queryKeys(uuid2, "status=Traced"); len(keys) != 0 {
+		t.Errorf("expected no keys for status=Traced in child version, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid2, "status=Orphan"); !reflect.DeepEqual(keys, []string{"100", "200"}) {
+		t.Errorf("expected keys [100 200] for status=Orphan in child version, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid2, "tags=b"); !reflect.DeepEqual(keys, []string{"200"}) {
+		t.Errorf("expected keys [200] for tags=b in child version, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid, "status=Traced"); !reflect.DeepEqual(keys, []string{"100", "300"}) {
+		t.Errorf("expected keys [100 300] for status=Traced in parent version, got %v\n", keys)
+	}
+
+	// Batch puts are validated and indexed.
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, kv := range []struct{ key, value string }{{"500", `{"status": "Traced"}`}, {"200", `{"status": "Traced"}`}} {
+		if err := tw.WriteHeader(&tar.Header{Name: kv.key, Mode: 0644, Size: int64(len(kv.value))}); err != nil {
+			t.Fatalf("unable to write tar header: %v\n", err)
+		}
+		if _, err := tw.Write([]byte(kv.value)); err != nil {
+			t.Fatalf("unable to write tar file: %v\n", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unable to close tar: %v\n", err)
+	}
+	putreq := fmt.Sprintf("%snode/%s/bodyannotations/keyvalues-put", server.WebAPIPath, uuid2)
+	server.TestHTTP(t, "POST", putreq, &buf)
+	if keys := queryKeys(uuid2, "status=Traced"); !reflect.DeepEqual(keys, []string{"200", "500"}) {
+		t.Errorf("expected keys [200 500] for status=Traced after batch put, got %v\n", keys)
+	}
+	if keys := queryKeys(uuid2, "status=Orphan"); !reflect.DeepEqual(keys, []string{"100"}) {
+		t.Errorf("expected keys [100] for status=Orphan after batch put, got %v\n", keys)
+	}
+
+	// Removing the schema allows any JSON.
+	schemareq = fmt.Sprintf("%snode/%s/bodyannotations/schema", server.WebAPIPath, uuid2)
+	server.TestHTTP(t, "DELETE", schemareq, nil)
+	server.TestBadHTTP(t, "GET", schemareq, nil)
+	server.TestHTTP(t, "POST", keyURL(uuid2, "400"), strings.NewReader(`{"tags": ["a"]}`))
+	server.TestBadHTTP(t, "POST", keyURL(uuid2, "600"), strings.NewReader(`not json`))
+}
+
+// sends a request with the given header and returns the response.
+func testHeaderHTTP(t *testing.T, method, urlStr, header, headerValue string, payload io.Reader) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, urlStr, payload)
+	if err != nil {
+		t.Fatalf("Unsuccessful %s on %q: %v\n", method, urlStr, err)
+	}
+	if header != "" {
+		req.Header.Set(header, headerValue)
+	}
+	resp := httptest.NewRecorder()
+	server.ServeSingleHTTP(resp, req)
+	return resp
+}
+
+func TestKeyvalueETag(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "keyvalue", "etagkv", dvid.Config{})
+	keyreq := fmt.Sprintf("%snode/%s/etagkv/key/status", server.WebAPIPath, uuid)
+
+	// Create only if key does not exist.
+	resp := testHeaderHTTP(t, "POST", keyreq, "If-None-Match", "*", strings.NewReader("Traced"))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected create with If-None-Match * to succeed, got status %d: %s\n", resp.Code, resp.Body.String())
+	}
+	etag := resp.Header().Get("ETag")
+	if etag != ETag([]byte("Traced")) {
+		t.Errorf("expected ETag %s after POST, got %s\n", ETag([]byte("Traced")), etag)
+	}
+	resp = testHeaderHTTP(t, "POST", keyreq, "If-None-Match", "*", strings.NewReader("Orphan"))
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 on create of existing key, got status %d\n", resp.Code)
+	}
+
+	// GET returns ETag and honors If-None-Match.
+	resp = testHeaderHTTP(t, "GET", keyreq, "", "", nil)
+	if resp.Code != http.StatusOK || resp.Body.String() != "Traced" || resp.Header().Get("ETag") != etag {
+		t.Errorf("expected value Traced with ETag %s, got status %d, value %q, ETag %s\n", etag, resp.Code, resp.Body.String(), resp.Header().Get("ETag"))
+	}
+	resp = testHeaderHTTP(t, "GET", keyreq, "If-None-Match", etag, nil)
+	if resp.Code != http.StatusNotModified || resp.Body.Len() != 0 {
+		t.Errorf("expected 304 with no body for matching If-None-Match, got status %d, body %q\n", resp.Code, resp.Body.String())
+	}
+
+	// Compare-and-swap: only one of two writers with the same ETag succeeds.
+	resp = testHeaderHTTP(t, "POST", keyreq, "If-Match", etag, strings.NewReader("Roughly traced"))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected POST with matching If-Match to succeed, got status %d: %s\n", resp.Code, resp.Body.String())
+	}
+	resp = testHeaderHTTP(t, "POST", keyreq, "If-Match", etag, strings.NewReader("Leaves"))
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for stale If-Match, got status %d\n", resp.Code)
+	}
+	if value := server.TestHTTP(t, "GET", keyreq, nil); string(value) != "Roughly traced" {
+		t.Errorf("expected value %q after compare-and-swap, got %q\n", "Roughly traced", string(value))
+	}
+
+	// Conditional delete
+	resp = testHeaderHTTP(t, "DELETE", keyreq, "If-Match", etag, nil)
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for delete with stale If-Match, got status %d\n", resp.Code)
+	}
+	newETag := ETag([]byte("Roughly traced"))
+	resp = testHeaderHTTP(t, "DELETE", keyreq, "If-Match", etag+", "+newETag, nil)
+	if resp.Code != http.StatusOK {
+		t.Errorf("expected delete with matching If-Match to succeed, got status %d: %s\n", resp.Code, resp.Body.String())
+	}
+	resp = testHeaderHTTP(t, "GET", keyreq, "", "", nil)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected deleted key to be not found, got status %d\n", resp.Code)
+	}
+	resp = testHeaderHTTP(t, "POST", keyreq, "If-Match", "*", strings.NewReader("Traced"))
+	if resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for If-Match * on missing key, got status %d\n", resp.Code)
+	}
+}
+
+func TestKeyvalueHistory(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "keyvalue", "histkv", dvid.Config{})
+	keyURL := func(uuid dvid.UUID) string {
+		return fmt.Sprintf("%snode/%s/histkv/key/status", server.WebAPIPath, uuid)
+	}
+
+	// root -> child -> grandchild, with a sibling branch off root.
+	server.TestHTTP(t, "POST", keyURL(uuid), strings.NewReader("Traced"))
+	if err := datastore.Commit(uuid, "root", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid, err)
+	}
+	child, err := datastore.NewVersion(uuid, "child", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create new version off node %s: %v\n", uuid, err)
+	}
+	sibling, err := datastore.NewVersion(uuid, "sibling", "sibling", nil)
+	if err != nil {
+		t.Fatalf("Unable to create new version off node %s: %v\n", uuid, err)
+	}
+	server.TestHTTP(t, "POST", keyURL(sibling), strings.NewReader("Orphan"))
+	server.TestHTTP(t, "POST", keyURL(child), strings.NewReader("Roughly traced"))
+	if err := datastore.Commit(child, "child", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", child, err)
+	}
+	grandchild, err := datastore.NewVersion(child, "grandchild", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create new version off node %s: %v\n", child, err)
+	}
+	server.TestHTTP(t, "DELETE", keyURL(grandchild), nil)
+
+	returnValue := server.TestHTTP(t, "GET", keyURL(grandchild)+"/history", nil)
+	var history []KeyVersion
+	if err := json.Unmarshal(returnValue, &history); err != nil {
+		t.Fatalf("unable to decode key history %q: %v\n", string(returnValue), err)
+	}
+	expected := []KeyVersion{
+		{UUID: grandchild, Deleted: true},
+		{UUID: child, Size: len("Roughly traced")},
+		{UUID: uuid, Size: len("Traced")},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d versions in key history, got %s\n", len(expected), string(returnValue))
+	}
+	for i, kv := range history {
+		if kv.UUID != expected[i].UUID || kv.Deleted != expected[i].Deleted || kv.Size != expected[i].Size || kv.Value != nil {
+			t.Errorf("expected history entry %d to be %v, got %v\n", i, expected[i], kv)
+		}
+		if kv.Created.IsZero() || kv.Updated.Before(kv.Created) {
+			t.Errorf("bad timestamps for history entry %d: %v\n", i, kv)
+		}
+	}
+
+	// Get history with values from the child.
+	returnValue = server.TestHTTP(t, "GET", keyURL(child)+"/history?values=true", nil)
+	if err := json.Unmarshal(returnValue, &history); err != nil {
+		t.Fatalf("unable to decode key history %q: %v\n", string(returnValue), err)
+	}
+	if len(history) != 2 || string(history[0].Value) != "Roughly traced" || string(history[1].Value) != "Traced" {
+		t.Errorf("expected child history with values, got %s\n", string(returnValue))
+	}
+
+	// Keys never written have no history.
+	returnValue = server.TestHTTP(t, "GET", fmt.Sprintf("%snode/%s/histkv/key/nothing/history", server.WebAPIPath, grandchild), nil)
+	if string(returnValue) != "[]" {
+		t.Errorf("expected empty history for unwritten key, got %s\n", string(returnValue))
+	}
+	server.TestBadHTTP(t, "POST", keyURL(grandchild)+"/history", nil)
+}
+
 type resolveResp struct {
 	Child dvid.UUID `json:"child"`
 }
@@ -0,0 +1,314 @@
+/*
+	This file supports computation of label adjacency, where the contact between two labels
+	is the number of voxel faces shared by the two labels.
+*/
+
+package labelarray
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Contact gives the number of voxel faces shared with a neighboring label.
+type Contact struct {
+	Label   uint64 `json:"label"`
+	Contact uint64 `json:"contact"`
+}
+
+type contactsByArea []Contact
+
+func (c contactsByArea) Len() int      { return len(c) }
+func (c contactsByArea) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c contactsByArea) Less(i, j int) bool {
+	if c[i].Contact != c[j].Contact {
+		return c[i].Contact > c[j].Contact
+	}
+	return c[i].Label < c[j].Label
+}
+
+// Edge gives the number of voxel faces shared by two labels, where Label1 < Label2.
+type Edge struct {
+	Label1  uint64 `json:"label1"`
+	Label2  uint64 `json:"label2"`
+	Contact uint64 `json:"contact"`
+}
+
+type edgesByLabel []Edge
+
+func (e edgesByLabel) Len() int      { return len(e) }
+func (e edgesByLabel) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e edgesByLabel) Less(i, j int) bool {
+	if e[i].Label1 != e[j].Label1 {
+		return e[i].Label1 < e[j].Label1
+	}
+	return e[i].Label2 < e[j].Label2
+}
+
+// adjBlockCache holds label volumes of blocks being scanned.  Since blocks are scanned in
+// ZYX order, blocks more than one block behind in z can be evicted.
+type adjBlockCache struct {
+	d      *Data
+	v      dvid.VersionID
+	mapper *svMapper
+	blocks map[dvid.ChunkPoint3d][]byte
+}
+
+func (d *Data) newAdjBlockCache(v dvid.VersionID) *adjBlockCache {
+	return &adjBlockCache{
+		d:      d,
+		v:      v,
+		mapper: d.newMapper(v),
+		blocks: make(map[dvid.ChunkPoint3d][]byte),
+	}
+}
+
+// get returns the label volume for a block, which is empty if the block is not stored.
+func (c *adjBlockCache) get(bcoord dvid.ChunkPoint3d) ([]byte, error) {
+	if data, found := c.blocks[bcoord]; found {
+		return data, nil
+	}
+	data, err := c.d.getMappedLabelBlock(c.v, 0, bcoord, c.mapper)
+	if err != nil {
+		return nil, err
+	}
+	c.blocks[bcoord] = data
+	return data, nil
+}
+
+func (c *adjBlockCache) evictBefore(z int32) {
+	for bcoord := range c.blocks {
+		if bcoord[2] < z-1 {
+			delete(c.blocks, bcoord)
+		}
+	}
+}
+
+// neighbor returns the label of the voxel neighboring the given voxel of a block along
+// the given axis and direction, which may be in an adjacent block.
+func (c *adjBlockCache) neighbor(bcoord dvid.ChunkPoint3d, data []byte, blockSize dvid.Point3d, pos dvid.Point3d, axis int, dir int32) (uint64, error) {
+	nbrPos := pos
+	nbrPos[axis] += dir
+	if nbrPos[axis] < 0 || nbrPos[axis] >= blockSize[axis] {
+		bcoord[axis] += dir
+		nbrPos[axis] -= dir * blockSize[axis]
+		var err error
+		if data, err = c.get(bcoord); err != nil {
+			return 0, err
+		}
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	i := (nbrPos[2]*blockSize[0]*blockSize[1] + nbrPos[1]*blockSize[0] + nbrPos[0]) * 8
+	return binary.LittleEndian.Uint64(data[i : i+8]), nil
+}
+
+// GetAdjacency returns the labels neighboring the given label and the number of voxel faces
+// shared with each, sorted by decreasing contact.
+func (d *Data) GetAdjacency(v dvid.VersionID, label uint64) ([]Contact, error) {
+	timedLog := dvid.NewTimeLog()
+
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("can't compute adjacency because block size for instance %s is not 3d: %v", d.DataName(), d.BlockSize())
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	meta, _, err := d.GetMappedLabelMeta(ctx, label, dvid.Bounds{})
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil || len(meta.Blocks) == 0 {
+		return nil, fmt.Errorf("label %d not found in data %q", label, d.DataName())
+	}
+
+	contacts := make(map[uint64]uint64)
+	cache := d.newAdjBlockCache(v)
+	for _, izyx := range meta.Blocks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		cache.evictBefore(bcoord[2])
+		data, err := cache.get(bcoord)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var i int32
+		var pos dvid.Point3d
+		for pos[2] = 0; pos[2] < blockSize[2]; pos[2]++ {
+			for pos[1] = 0; pos[1] < blockSize[1]; pos[1]++ {
+				for pos[0] = 0; pos[0] < blockSize[0]; pos[0], i = pos[0]+1, i+1 {
+					if binary.LittleEndian.Uint64(data[i*8:i*8+8]) != label {
+						continue
+					}
+					for axis := 0; axis < 3; axis++ {
+						for _, dir := range []int32{-1, 1} {
+							nbr, err := cache.neighbor(bcoord, data, blockSize, pos, axis, dir)
+							if err != nil {
+								return nil, err
+							}
+							if nbr != 0 && nbr != label {
+								contacts[nbr]++
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	adjacency := make(contactsByArea, 0, len(contacts))
+	for nbr, contact := range contacts {
+		adjacency = append(adjacency, Contact{Label: nbr, Contact: contact})
+	}
+	sort.Sort(adjacency)
+
+	timedLog.Infof("Computed adjacency of label %d, data %q: %d neighbors over %d blocks", label, d.DataName(), len(adjacency), len(meta.Blocks))
+	return adjacency, nil
+}
+
+// GetROIAdjacency returns all pairs of touching labels within the given ROI and the number of
+// voxel faces shared by each pair.  A shared face is counted if the voxel with the smaller
+// coordinate is within the ROI.
+func (d *Data) GetROIAdjacency(v dvid.VersionID, roiname dvid.InstanceName) ([]Edge, error) {
+	timedLog := dvid.NewTimeLog()
+
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("can't compute adjacency because block size for instance %s is not 3d: %v", d.DataName(), d.BlockSize())
+	}
+	uuid, err := datastore.UUIDFromVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	roiData, err := roi.GetByUUIDName(uuid, roiname)
+	if err != nil {
+		return nil, err
+	}
+	spans, err := roiData.GetSpans(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the ROI blocks and the sorted label blocks that intersect the ROI.
+	roiSize := roiData.BlockSize
+	roiBlocks := make(map[dvid.ChunkPoint3d]struct{})
+	labelBlocks := make(map[dvid.IZYXString]struct{})
+	for _, span := range spans {
+		z, y, x0, x1 := span[0], span[1], span[2], span[3]
+		for x := x0; x <= x1; x++ {
+			c := dvid.ChunkPoint3d{x, y, z}
+			roiBlocks[c] = struct{}{}
+			minPt := c.MinPoint(roiSize).(dvid.Point3d)
+			maxPt := c.MaxPoint(roiSize).(dvid.Point3d)
+			minBlock := minPt.Chunk(blockSize).(dvid.ChunkPoint3d)
+			maxBlock := maxPt.Chunk(blockSize).(dvid.ChunkPoint3d)
+			for bz := minBlock[2]; bz <= maxBlock[2]; bz++ {
+				for by := minBlock[1]; by <= maxBlock[1]; by++ {
+					for bx := minBlock[0]; bx <= maxBlock[0]; bx++ {
+						labelBlocks[dvid.ChunkPoint3d{bx, by, bz}.ToIZYXString()] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+	sortedBlocks := make(dvid.IZYXSlice, 0, len(labelBlocks))
+	for izyx := range labelBlocks {
+		sortedBlocks = append(sortedBlocks, izyx)
+	}
+	sort.Sort(sortedBlocks)
+
+	type labelPair struct {
+		label1, label2 uint64
+	}
+	contacts := make(map[labelPair]uint64)
+	cache := d.newAdjBlockCache(v)
+	for _, izyx := range sortedBlocks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		cache.evictBefore(bcoord[2])
+		data, err := cache.get(bcoord)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		offset := bcoord.MinPoint(blockSize).(dvid.Point3d)
+		var i int32
+		var pos dvid.Point3d
+		for pos[2] = 0; pos[2] < blockSize[2]; pos[2]++ {
+			for pos[1] = 0; pos[1] < blockSize[1]; pos[1]++ {
+				for pos[0] = 0; pos[0] < blockSize[0]; pos[0], i = pos[0]+1, i+1 {
+					label := binary.LittleEndian.Uint64(data[i*8 : i*8+8])
+					if label == 0 {
+						continue
+					}
+					voxel := dvid.Point3d{offset[0] + pos[0], offset[1] + pos[1], offset[2] + pos[2]}
+					if _, inside := roiBlocks[voxel.Chunk(roiSize).(dvid.ChunkPoint3d)]; !inside {
+						continue
+					}
+					for axis := 0; axis < 3; axis++ {
+						nbr, err := cache.neighbor(bcoord, data, blockSize, pos, axis, 1)
+						if err != nil {
+							return nil, err
+						}
+						if nbr == 0 || nbr == label {
+							continue
+						}
+						if label < nbr {
+							contacts[labelPair{label, nbr}]++
+						} else {
+							contacts[labelPair{nbr, label}]++
+						}
+					}
+				}
+			}
+		}
+	}
+
+	edges := make(edgesByLabel, 0, len(contacts))
+	for pair, contact := range contacts {
+		edges = append(edges, Edge{Label1: pair.label1, Label2: pair.label2, Contact: contact})
+	}
+	sort.Sort(edges)
+
+	timedLog.Infof("Computed adjacency within ROI %q, data %q: %d edges over %d blocks", roiname, d.DataName(), len(edges), len(sortedBlocks))
+	return edges, nil
+}
+
+// edgeWeightSetter is implemented by data instances like labelgraph that can store
+// weighted edges between labels.
+type edgeWeightSetter interface {
+	SetEdgeWeights(v dvid.VersionID, weights map[dvid.VertexPairID]float64) error
+}
+
+// StoreAdjacency writes label adjacencies as weighted edges into a graph data instance,
+// e.g., labelgraph, where the edge weight is the contact.
+func (d *Data) StoreAdjacency(v dvid.VersionID, graphname dvid.InstanceName, edges []Edge) error {
+	data, err := datastore.GetDataByVersionName(v, graphname)
+	if err != nil {
+		return err
+	}
+	graph, ok := data.(edgeWeightSetter)
+	if !ok {
+		return fmt.Errorf("data %q cannot store weighted edges", graphname)
+	}
+	weights := make(map[dvid.VertexPairID]float64, len(edges))
+	for _, edge := range edges {
+		pair := dvid.VertexPairID{Vertex1: dvid.VertexID(edge.Label1), Vertex2: dvid.VertexID(edge.Label2)}
+		weights[pair] = float64(edge.Contact)
+	}
+	return graph.SetEdgeWeights(v, weights)
+}
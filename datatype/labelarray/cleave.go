@@ -0,0 +1,586 @@
+/*
+	This file supports server-side cleaving of a label into partitions given sets of seeds.
+	Each voxel of the label is assigned to the seed set that reaches it first when growing
+	regions from all seeds simultaneously through 6-connected voxels of the label.
+*/
+
+package labelarray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/server"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// maximum number of seed sets, limited by the 8-bit voxel status used during cleaving.
+const maxCleaveSeedSets = 254
+
+// maximum number of blocks in a cleaved label, since all its voxels are held in memory.
+const maxCleaveBlocks = 2048
+
+// CleaveRequest gives the seeds for each partition of a cleave.  Partition i is seeded
+// by the voxels at Seeds[i] and all voxels of the supervoxels in Supervoxels[i].
+type CleaveRequest struct {
+	Seeds       [][]dvid.Point3d `json:"seeds"`
+	Supervoxels [][]uint64       `json:"supervoxels"`
+}
+
+// number of seed sets in the request.
+func (req CleaveRequest) numSets() int {
+	if len(req.Seeds) > len(req.Supervoxels) {
+		return len(req.Seeds)
+	}
+	return len(req.Supervoxels)
+}
+
+// returns true if every seed set is only given by supervoxels.
+func (req CleaveRequest) supervoxelSeedsOnly() bool {
+	for _, pts := range req.Seeds {
+		if len(pts) != 0 {
+			return false
+		}
+	}
+	if len(req.Supervoxels) != req.numSets() {
+		return false
+	}
+	for _, supervoxels := range req.Supervoxels {
+		if len(supervoxels) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Cleave is one partition of a cleaved label.
+type Cleave struct {
+	Voxels    uint64 `json:"voxels"`
+	Sparsevol []byte `json:"sparsevol"` // legacy binary sparse volume, as used by the split endpoint.
+
+	rles        dvid.RLEs
+	supervoxels labels.Set // only set if partitions are groups of whole supervoxels.
+}
+
+// voxel status during cleaving.
+const (
+	cleaveOutside    uint8 = iota // voxel is not part of the cleaved label.
+	cleaveUnassigned              // voxel is part of label but not yet reached by a seed.
+	cleaveFirstSet                // voxel is assigned to seed set (status - cleaveFirstSet).
+)
+
+type cleaveBlock struct {
+	offset dvid.Point3d // voxel coordinate of first voxel in block
+	status []uint8
+}
+
+type cleaver struct {
+	blockSize dvid.Point3d
+	blocks    map[dvid.IZYXString]*cleaveBlock
+	queue     []dvid.Point3d
+
+	// last block accessed, since most neighbor lookups are in the same block.
+	lastBCoord dvid.ChunkPoint3d
+	lastBlock  *cleaveBlock
+}
+
+func floorDiv(a, b int32) int32 {
+	if a < 0 {
+		return (a - b + 1) / b
+	}
+	return a / b
+}
+
+// returns the block and voxel index within that block for a point, or nil if the
+// point isn't within a block of the label.
+func (c *cleaver) voxel(pt dvid.Point3d) (*cleaveBlock, int) {
+	bcoord := dvid.ChunkPoint3d{
+		floorDiv(pt[0], c.blockSize[0]),
+		floorDiv(pt[1], c.blockSize[1]),
+		floorDiv(pt[2], c.blockSize[2]),
+	}
+	var block *cleaveBlock
+	if c.lastBlock != nil && bcoord == c.lastBCoord {
+		block = c.lastBlock
+	} else {
+		block = c.blocks[bcoord.ToIZYXString()]
+		if block == nil {
+			return nil, 0
+		}
+		c.lastBCoord = bcoord
+		c.lastBlock = block
+	}
+	x := pt[0] - block.offset[0]
+	y := pt[1] - block.offset[1]
+	z := pt[2] - block.offset[2]
+	i := int(z*c.blockSize[0]*c.blockSize[1] + y*c.blockSize[0] + x)
+	return block, i
+}
+
+// grow assigns all reachable unassigned voxels to the seed set of the nearest seed.
+func (c *cleaver) grow() {
+	neighbors := [6]dvid.Point3d{{-1, 0, 0}, {1, 0, 0}, {0, -1, 0}, {0, 1, 0}, {0, 0, -1}, {0, 0, 1}}
+	for head := 0; head < len(c.queue); head++ {
+		pt := c.queue[head]
+		block, i := c.voxel(pt)
+		status := block.status[i]
+		for _, offset := range neighbors {
+			nbr := dvid.Point3d{pt[0] + offset[0], pt[1] + offset[1], pt[2] + offset[2]}
+			nblock, ni := c.voxel(nbr)
+			if nblock == nil || nblock.status[ni] != cleaveUnassigned {
+				continue
+			}
+			nblock.status[ni] = status
+			c.queue = append(c.queue, nbr)
+		}
+	}
+	c.queue = nil
+}
+
+// rles returns the run-length encoding of voxels with the given status in the given blocks.
+func (c *cleaver) rles(blocks dvid.IZYXSlice, status ...uint8) (rles dvid.RLEs, voxels uint64) {
+	var match [256]bool
+	for _, s := range status {
+		match[s] = true
+	}
+	nx, ny, nz := c.blockSize[0], c.blockSize[1], c.blockSize[2]
+	for _, izyx := range blocks {
+		block := c.blocks[izyx]
+		i := 0
+		for z := int32(0); z < nz; z++ {
+			for y := int32(0); y < ny; y++ {
+				var run int32
+				var start dvid.Point3d
+				for x := int32(0); x < nx; x, i = x+1, i+1 {
+					if match[block.status[i]] {
+						if run == 0 {
+							start = dvid.Point3d{block.offset[0] + x, block.offset[1] + y, block.offset[2] + z}
+						}
+						run++
+					} else if run != 0 {
+						rles = append(rles, dvid.NewRLE(start, run))
+						voxels += uint64(run)
+						run = 0
+					}
+				}
+				if run != 0 {
+					rles = append(rles, dvid.NewRLE(start, run))
+					voxels += uint64(run)
+				}
+			}
+		}
+	}
+	return
+}
+
+// CleaveLabel partitions the voxels of a label using the given seed sets.  Voxels not
+// connected to any seed are placed in the first partition.  The label is not modified.
+func (d *Data) CleaveLabel(v dvid.VersionID, label uint64, req CleaveRequest) ([]Cleave, error) {
+	return d.cleaveLabel(v, label, req, false)
+}
+
+// ApplyCleave partitions the voxels of a label like CleaveLabel and splits off all but the
+// first partition into new labels, which are returned with the original label as the first
+// element.  The partitions are computed and split as one large mutation so no other
+// mutation can intervene, and if any split fails, the label is returned to its original
+// voxels.
+//
+// If the data instance has MappedLabels set and the label is a body of more than one
+// supervoxel, every seed set must be given as supervoxels.  Each supervoxel is then assigned
+// to the partition holding most of its voxels, and all but the first group of supervoxels
+// are mapped to new bodies without modifying label blocks.
+func (d *Data) ApplyCleave(v dvid.VersionID, label uint64, req CleaveRequest) ([]Cleave, []uint64, error) {
+	server.LargeMutationMutex.Lock()
+	defer server.LargeMutationMutex.Unlock()
+
+	var bySupervoxel bool
+	if d.MappedLabels {
+		if req.supervoxelSeedsOnly() {
+			bySupervoxel = true
+		} else if err := d.checkSplitSupervoxel(v, label); err != nil {
+			return nil, nil, fmt.Errorf("cleave can only be applied if every seed set is given as supervoxels: %v", err)
+		}
+	}
+
+	cleaves, err := d.cleaveLabel(v, label, req, bySupervoxel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bySupervoxel {
+		newLabels, err := d.cleaveMapping(v, label, cleaves)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cleaves, newLabels, nil
+	}
+	partitions := make([]dvid.RLEs, len(cleaves))
+	for i := 1; i < len(cleaves); i++ {
+		if cleaves[i].Voxels != 0 {
+			partitions[i] = cleaves[i].rles
+		}
+	}
+	newLabels, err := d.splitPartitions(v, label, partitions)
+	if err != nil {
+		return nil, nil, err
+	}
+	newLabels[0] = label
+	return cleaves, newLabels, nil
+}
+
+// cleaveLabel computes the partitions of a label.  If bySupervoxel is true, the partitions
+// are made of whole supervoxels, each assigned to the seed set that reached most of its voxels.
+func (d *Data) cleaveLabel(v dvid.VersionID, label uint64, req CleaveRequest, bySupervoxel bool) ([]Cleave, error) {
+	timedLog := dvid.NewTimeLog()
+
+	numSets := req.numSets()
+	if numSets < 2 {
+		return nil, fmt.Errorf("cleave of label %d requires at least 2 seed sets, got %d", label, numSets)
+	}
+	if numSets > maxCleaveSeedSets {
+		return nil, fmt.Errorf("cleave of label %d can have at most %d seed sets, got %d", label, maxCleaveSeedSets, numSets)
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("can't cleave because block size for instance %s is not 3d: %v", d.DataName(), d.BlockSize())
+	}
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	meta, lbls, err := d.GetMappedLabelMeta(ctx, label, dvid.Bounds{})
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil || len(meta.Blocks) == 0 {
+		return nil, fmt.Errorf("label %d not found in data %q", label, d.DataName())
+	}
+	if len(meta.Blocks) > maxCleaveBlocks {
+		return nil, fmt.Errorf("label %d has %d blocks, more than the %d blocks that can be cleaved", label, len(meta.Blocks), maxCleaveBlocks)
+	}
+
+	// Any supervoxel seeds must be part of the label.
+	svSeeds := make(map[uint64]uint8)
+	for set, supervoxels := range req.Supervoxels {
+		for _, supervoxel := range supervoxels {
+			if _, found := lbls[supervoxel]; !found {
+				return nil, fmt.Errorf("seed supervoxel %d is not part of label %d", supervoxel, label)
+			}
+			if _, found := svSeeds[supervoxel]; found {
+				return nil, fmt.Errorf("seed supervoxel %d is in more than one seed set", supervoxel)
+			}
+			svSeeds[supervoxel] = cleaveFirstSet + uint8(set)
+		}
+	}
+
+	// Load all blocks of the label, noting voxels of the label and supervoxel seeds.
+	c := &cleaver{
+		blockSize: blockSize,
+		blocks:    make(map[dvid.IZYXString]*cleaveBlock, len(meta.Blocks)),
+	}
+	numVoxels := blockSize.Prod()
+	for _, izyx := range meta.Blocks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		labelData, err := d.getCleaveBlock(v, bcoord, numVoxels)
+		if err != nil {
+			return nil, err
+		}
+		block := &cleaveBlock{
+			offset: bcoord.MinPoint(blockSize).(dvid.Point3d),
+			status: make([]uint8, numVoxels),
+		}
+		var i int32
+		for z := int32(0); z < blockSize[2]; z++ {
+			for y := int32(0); y < blockSize[1]; y++ {
+				for x := int32(0); x < blockSize[0]; x, i = x+1, i+1 {
+					voxelLabel := binary.LittleEndian.Uint64(labelData[i*8 : i*8+8])
+					if _, found := lbls[voxelLabel]; !found {
+						continue
+					}
+					if status, isSeed := svSeeds[voxelLabel]; isSeed {
+						block.status[i] = status
+						c.queue = append(c.queue, dvid.Point3d{block.offset[0] + x, block.offset[1] + y, block.offset[2] + z})
+					} else {
+						block.status[i] = cleaveUnassigned
+					}
+				}
+			}
+		}
+		c.blocks[izyx] = block
+	}
+
+	// Add the point seeds.
+	for set, pts := range req.Seeds {
+		status := cleaveFirstSet + uint8(set)
+		for _, pt := range pts {
+			block, i := c.voxel(pt)
+			if block == nil || block.status[i] == cleaveOutside {
+				return nil, fmt.Errorf("seed point %s is not within label %d", pt, label)
+			}
+			if block.status[i] != cleaveUnassigned && block.status[i] != status {
+				return nil, fmt.Errorf("seed point %s is in more than one seed set", pt)
+			}
+			block.status[i] = status
+			c.queue = append(c.queue, pt)
+		}
+	}
+
+	c.grow()
+
+	var assigned map[uint64]uint8
+	if bySupervoxel {
+		if assigned, err = d.assignSupervoxels(v, c, meta.Blocks, lbls, numSets); err != nil {
+			return nil, err
+		}
+	}
+
+	cleaves := make([]Cleave, numSets)
+	for set := range cleaves {
+		var rles dvid.RLEs
+		var voxels uint64
+		if set == 0 {
+			rles, voxels = c.rles(meta.Blocks, cleaveFirstSet, cleaveUnassigned)
+		} else {
+			rles, voxels = c.rles(meta.Blocks, cleaveFirstSet+uint8(set))
+		}
+		sparsevol, err := encodeSparseVol(rles)
+		if err != nil {
+			return nil, err
+		}
+		cleaves[set] = Cleave{Voxels: voxels, Sparsevol: sparsevol, rles: rles}
+		if bySupervoxel {
+			cleaves[set].supervoxels = make(labels.Set)
+		}
+	}
+	for supervoxel, status := range assigned {
+		cleaves[status-cleaveFirstSet].supervoxels[supervoxel] = struct{}{}
+	}
+
+	timedLog.Infof("Cleaved label %d of data %q into %d partitions over %d blocks", label, d.DataName(), numSets, len(meta.Blocks))
+	return cleaves, nil
+}
+
+// returns the supervoxel labels of a block, checking it has the expected number of voxels.
+func (d *Data) getCleaveBlock(v dvid.VersionID, bcoord dvid.ChunkPoint3d, numVoxels int64) ([]byte, error) {
+	labelData, err := d.GetSupervoxelBlock(v, 0, bcoord)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(labelData)) != numVoxels*8 {
+		return nil, fmt.Errorf("block %s has %d bytes, expected %d", bcoord, len(labelData), numVoxels*8)
+	}
+	return labelData, nil
+}
+
+// calls the function for each voxel of the given blocks belonging to one of the labels.
+func (d *Data) processCleaveVoxels(v dvid.VersionID, c *cleaver, blocks dvid.IZYXSlice, lbls labels.Set, f func(block *cleaveBlock, i int, supervoxel uint64)) error {
+	numVoxels := c.blockSize.Prod()
+	for _, izyx := range blocks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return err
+		}
+		labelData, err := d.getCleaveBlock(v, bcoord, numVoxels)
+		if err != nil {
+			return err
+		}
+		block := c.blocks[izyx]
+		for i := 0; i < int(numVoxels); i++ {
+			supervoxel := binary.LittleEndian.Uint64(labelData[i*8 : i*8+8])
+			if _, found := lbls[supervoxel]; found {
+				f(block, i, supervoxel)
+			}
+		}
+	}
+	return nil
+}
+
+// assigns each supervoxel to the seed set that reached most of its voxels, with unreached
+// voxels counted for the first set, and then modifies the voxel status so partitions are
+// made of whole supervoxels.  Returns the voxel status of each supervoxel.
+func (d *Data) assignSupervoxels(v dvid.VersionID, c *cleaver, blocks dvid.IZYXSlice, lbls labels.Set, numSets int) (map[uint64]uint8, error) {
+	counts := make(map[uint64][]uint64, len(lbls))
+	err := d.processCleaveVoxels(v, c, blocks, lbls, func(block *cleaveBlock, i int, supervoxel uint64) {
+		setCounts, found := counts[supervoxel]
+		if !found {
+			setCounts = make([]uint64, numSets)
+			counts[supervoxel] = setCounts
+		}
+		var set int
+		if block.status[i] >= cleaveFirstSet {
+			set = int(block.status[i] - cleaveFirstSet)
+		}
+		setCounts[set]++
+	})
+	if err != nil {
+		return nil, err
+	}
+	assigned := make(map[uint64]uint8, len(counts))
+	for supervoxel, setCounts := range counts {
+		var best int
+		for set, count := range setCounts {
+			if count > setCounts[best] {
+				best = set
+			}
+		}
+		assigned[supervoxel] = cleaveFirstSet + uint8(best)
+	}
+	err = d.processCleaveVoxels(v, c, blocks, lbls, func(block *cleaveBlock, i int, supervoxel uint64) {
+		block.status[i] = assigned[supervoxel]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assigned, nil
+}
+
+// cleaveMapping applies a cleave of a body whose partitions are groups of whole supervoxels
+// by mapping all but the first group to new bodies, which are returned with the original
+// body as the first element.  Label blocks and indices are not modified.  Since labels in
+// split events of mapped data are supervoxels, each event uses a supervoxel of the old and
+// new bodies.  The caller must hold server.LargeMutationMutex.
+func (d *Data) cleaveMapping(v dvid.VersionID, body uint64, cleaves []Cleave) ([]uint64, error) {
+	if len(cleaves[0].supervoxels) == 0 {
+		return nil, fmt.Errorf("cleave of body %d would leave no supervoxels in the body", body)
+	}
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("can't cleave because block size for instance %s is not 3d: %v", d.DataName(), d.BlockSize())
+	}
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, fmt.Errorf("Data %q cleave had error initializing store: %v\n", d.DataName(), err)
+	}
+	batcher, ok := store.(storage.KeyValueBatcher)
+	if !ok {
+		return nil, fmt.Errorf("Data %q cleave requires batch-enabled store, which %q is not\n", d.DataName(), store)
+	}
+
+	d.StartUpdate()
+	defer d.StopUpdate()
+
+	// Make sure we can split given current merges in progress.
+	iv := dvid.InstanceVersion{Data: d.DataUUID(), Version: v}
+	newLabels := make([]uint64, len(cleaves))
+	newLabels[0] = body
+	var started []labels.DeltaSplitStart
+	defer func() {
+		for _, op := range started {
+			labels.SplitStop(iv, labels.DeltaSplitEnd{op.OldLabel, op.NewLabel})
+		}
+	}()
+	for i := 1; i < len(cleaves); i++ {
+		if len(cleaves[i].supervoxels) == 0 {
+			continue
+		}
+		toLabel, err := d.NewLabel(v)
+		if err != nil {
+			return nil, err
+		}
+		op := labels.DeltaSplitStart{body, toLabel}
+		if err := labels.SplitStart(iv, op); err != nil {
+			return nil, err
+		}
+		started = append(started, op)
+		newLabels[i] = toLabel
+
+		evt := datastore.SyncEvent{d.DataUUID(), labels.SplitStartEvent}
+		msg := datastore.SyncMessage{labels.SplitStartEvent, v, op}
+		if err := datastore.NotifySubscribers(evt, msg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Modify the mapping for all new bodies at once.
+	ctx := datastore.NewVersionedCtx(d, v)
+	batch := batcher.NewBatch(ctx)
+	for i := 1; i < len(cleaves); i++ {
+		if newLabels[i] == 0 {
+			continue
+		}
+		bodyBuf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(bodyBuf, newLabels[i])
+		for supervoxel := range cleaves[i].supervoxels {
+			batch.Put(NewSupervoxelMapTKey(supervoxel), bodyBuf)
+		}
+		batch.Put(NewBodySupervoxelsTKey(newLabels[i]), encodeSupervoxels(cleaves[i].supervoxels))
+	}
+	batch.Put(NewBodySupervoxelsTKey(body), encodeSupervoxels(cleaves[0].supervoxels))
+	if err := batch.Commit(); err != nil {
+		return nil, fmt.Errorf("unable to commit mapping for cleave of body %d, data %q: %v", body, d.DataName(), err)
+	}
+	d.invalidateStats(v, newLabels...)
+
+	// Publish split events for each new body.
+	oldSupervoxel := sortedLabels(cleaves[0].supervoxels)[0]
+	for i := 1; i < len(cleaves); i++ {
+		if newLabels[i] == 0 {
+			continue
+		}
+		splitmap, err := cleaves[i].rles.Partition(blockSize)
+		if err != nil {
+			return nil, err
+		}
+		newSupervoxel := sortedLabels(cleaves[i].supervoxels)[0]
+		delta := labels.DeltaSplit{
+			OldLabel:     oldSupervoxel,
+			NewLabel:     newSupervoxel,
+			Split:        splitmap,
+			SortedBlocks: splitmap.SortedKeys(),
+			SplitVoxels:  cleaves[i].Voxels,
+		}
+		evt := datastore.SyncEvent{d.DataUUID(), labels.SplitLabelEvent}
+		msg := datastore.SyncMessage{labels.SplitLabelEvent, v, delta}
+		if err := datastore.NotifySubscribers(evt, msg); err != nil {
+			dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+		}
+
+		deltaNewSize := labels.DeltaNewSize{
+			Label: newLabels[i],
+			Size:  cleaves[i].Voxels,
+		}
+		evt = datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
+		msg = datastore.SyncMessage{labels.ChangeSizeEvent, v, deltaNewSize}
+		if err := datastore.NotifySubscribers(evt, msg); err != nil {
+			dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+		}
+
+		deltaModSize := labels.DeltaModSize{
+			Label:      body,
+			SizeChange: -int64(cleaves[i].Voxels),
+		}
+		evt = datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
+		msg = datastore.SyncMessage{labels.ChangeSizeEvent, v, deltaModSize}
+		if err := datastore.NotifySubscribers(evt, msg); err != nil {
+			dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+		}
+
+		evt = datastore.SyncEvent{d.DataUUID(), labels.SplitEndEvent}
+		msg = datastore.SyncMessage{labels.SplitEndEvent, v, labels.DeltaSplitEnd{oldSupervoxel, newSupervoxel}}
+		if err := datastore.NotifySubscribers(evt, msg); err != nil {
+			dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+		}
+	}
+	return newLabels, nil
+}
+
+// encodes RLEs into the legacy binary sparse volume format.
+func encodeSparseVol(rles dvid.RLEs) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3)) // # of dimensions
+	binary.Write(buf, binary.LittleEndian, byte(0))  // dimension of run (X = 0)
+	buf.WriteByte(byte(0))                           // reserved for later
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	binary.Write(buf, binary.LittleEndian, uint32(len(rles)))
+	rleBytes, err := rles.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(rleBytes)
+	return buf.Bytes(), nil
+}
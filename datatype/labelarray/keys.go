@@ -22,6 +22,12 @@ const (
 	// key = label. value = labels.LabelMeta
 	keyLabelIndex = 187
 
+	// key = supervoxel. value = body label it is mapped to.
+	keySupervoxelMap = 188
+
+	// key = body. value = sorted list of supervoxels mapped to the body.
+	keyBodySupervoxels = 189
+
 	// Used to store max label on commit for each version of the instance.
 	keyLabelMax = 237
 
@@ -84,3 +90,38 @@ func DecodeLabelIndexTKey(tk storage.TKey) (label uint64, err error) {
 	label = binary.BigEndian.Uint64(ibytes[0:8])
 	return
 }
+
+// NewSupervoxelMapTKey returns a TKey for a supervoxel's mapping.  Value will hold the body label.
+func NewSupervoxelMapTKey(supervoxel uint64) storage.TKey {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, supervoxel)
+	return storage.NewTKey(keySupervoxelMap, buf)
+}
+
+// DecodeSupervoxelMapTKey parses a TKey and returns the corresponding supervoxel.
+func DecodeSupervoxelMapTKey(tk storage.TKey) (supervoxel uint64, err error) {
+	ibytes, err := tk.ClassBytes(keySupervoxelMap)
+	if err != nil {
+		return
+	}
+	supervoxel = binary.BigEndian.Uint64(ibytes[0:8])
+	return
+}
+
+// NewBodySupervoxelsTKey returns a TKey for a body's supervoxels.  Value will hold the
+// sorted supervoxels mapped to the body.
+func NewBodySupervoxelsTKey(body uint64) storage.TKey {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, body)
+	return storage.NewTKey(keyBodySupervoxels, buf)
+}
+
+// DecodeBodySupervoxelsTKey parses a TKey and returns the corresponding body.
+func DecodeBodySupervoxelsTKey(tk storage.TKey) (body uint64, err error) {
+	ibytes, err := tk.ClassBytes(keyBodySupervoxels)
+	if err != nil {
+		return
+	}
+	body = binary.BigEndian.Uint64(ibytes[0:8])
+	return
+}
@@ -72,6 +72,8 @@ $ dvid repo <UUID> new labelarray <data name> <settings...>
     VoxelUnits     Resolution units (default: "nanometers")
 	IndexedLabels  "false" if no sparse volume support is required (default "true")
 	CountLabels    "false" if no voxel counts per label is required (default "true")
+	MappedLabels   "true" if labels in blocks are supervoxels that are mapped to bodies via a
+	                 versioned mapping (default "false").  Merges then only modify the mapping.
 	DownresLevels  Number of down-resolution levels supported.  Each down-res is factor of 2.
 
 $ dvid node <UUID> <data name> load <offset> <image glob> <settings...>
@@ -327,7 +329,7 @@ GET  <api URL>/node/<UUID>/<data name>/pseudocolor/<dims>/<size>/<offset>[?query
                     are handled.  If the server can't initiate the API call right away, a 503 (Service Unavailable) 
                     status code is returned.
 
-GET <api URL>/node/<UUID>/<data name>/label/<coord>
+GET <api URL>/node/<UUID>/<data name>/label/<coord>[?queryopts]
 
 	Returns JSON for the label at the given coordinate:
 	{ "Label": 23 }
@@ -337,6 +339,11 @@ GET <api URL>/node/<UUID>/<data name>/label/<coord>
     data name     Name of label data.
     coord     	  Coordinate of voxel with underscore as separator, e.g., 10_20_30
 
+    Query-string Options:
+
+    supervoxels   If "true", returns the unmapped supervoxel instead of the body label when
+                    the instance has MappedLabels set.
+
 GET <api URL>/node/<UUID>/<data name>/labels[?queryopts]
 
 	Returns JSON for the labels at a list of coordinates.  Expects JSON in GET body:
@@ -354,6 +361,8 @@ GET <api URL>/node/<UUID>/<data name>/labels[?queryopts]
     Query-string Options:
 
     hash          MD5 hash of request body content in hexidecimal string format.
+    supervoxels   If "true", returns the unmapped supervoxels instead of the body labels when
+                    the instance has MappedLabels set.
 
 GET <api URL>/node/<UUID>/<data name>/blocks/<size>/<offset>[?queryopts]
 
@@ -674,13 +683,23 @@ POST <api URL>/node/<UUID>/<data name>/merge
 	same toLabel as a single merge request instead of multiple merge requests.
 
 
-POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X]
+POST <api URL>/node/<UUID>/<data name>/split/<label>[?queryopts]
 
 	Splits a portion of a label's voxels into a new label or, if "splitlabel" is specified
 	as an optional query string, the given split label.  Returns the following JSON:
 
 		{ "label": <new label> }
 
+	If "relabel-disconnected" is true, the JSON also includes the new labels given to
+	disconnected components of the remaining label voxels:
+
+		{ "label": <new label>, "components": [ <new label 1>, <new label 2>, ...] }
+
+	The split is committed before the relabeling, so if the relabeling fails, the split
+	label is still returned with the error instead of the components:
+
+		{ "label": <new label>, "relabel-error": "<error message>" }
+
 	This request requires a binary sparse volume in the POSTed body with the following 
 	encoded RLE format, which is compatible with the format returned by a GET on the 
 	"sparsevol" endpoint described above:
@@ -709,8 +728,16 @@ POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X]
 	chain operations like "split-coarse" followed by "split" using voxels, where the new label
 	created by the split coarse is used as the split label for the smaller, higher-res "split".
 
+    Query-string Options:
+
+    splitlabel            Label to use for the split voxels instead of a new label.
+    relabel-disconnected  If "true", after the split, all but the largest connected component
+                            of the remaining label's voxels are given new labels as in the
+                            "split-components" endpoint.
+    connectivity          Either "6" (default) or "26" connectivity for "relabel-disconnected".
+
 
-POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X]
+POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?queryopts]
 
 	Splits a portion of a label's blocks into a new label or, if "splitlabel" is specified
 	as an optional query string, the given split label.  Returns the following JSON:
@@ -737,7 +764,219 @@ POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X]
 			  ...
 	        int32   Length of run
 
-	The Notes for "split" endpoint above are applicable to this "split-coarse" endpoint.
+	The Notes and query-string options for "split" endpoint above are applicable to this
+	"split-coarse" endpoint.
+
+POST <api URL>/node/<UUID>/<data name>/split-components/<label>[?connectivity=26]
+
+	Finds the connected components of a label's voxels across block boundaries and splits
+	all but the largest component into new labels.  Returns the new labels in JSON:
+
+		{ "labels": [ <new label 1>, <new label 2>, ...] }
+
+	The "labels" list is empty if the label's voxels are already connected.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+    label         The label to be split into connected components.
+
+    Query-string Options:
+
+    connectivity  Either "6" (default) for face-connected voxels or "26" for face, edge, and
+                    corner-connected voxels.
+
+POST <api URL>/node/<UUID>/<data name>/cleave/<label>[?apply=true]
+
+	Partitions a label's voxels using two or more sets of seeds.  Each voxel of the label is
+	assigned to the seed set that reaches it first when growing regions from all seeds
+	simultaneously through 6-connected voxels of the label.  Voxels that are not connected
+	to any seed are assigned to the first partition.  Expects JSON in POST body:
+
+	{
+		"seeds": [ [[x1, y1, z1], [x2, y2, z2], ...], [[x3, y3, z3], ...], ... ],
+		"supervoxels": [ [sv1, sv2, ...], [sv3, ...], ... ]
+	}
+
+	Partition i is seeded by the points in the i-th "seeds" list and all voxels of the
+	supervoxels in the i-th "supervoxels" list.  Either list may be omitted.  Returns JSON:
+
+	{
+		"label": <label>,
+		"cleaves": [ { "voxels": 1023, "sparsevol": "<base64 sparse volume>" }, ... ],
+		"labels": [ <label>, <new label 1>, ... ]
+	}
+
+	Each sparse volume uses the binary format of the POST "split" endpoint and could be used
+	to do a split.  The "labels" property is only returned if "apply" is true, in which case
+	every partition except the first is split off into a new label.  The first partition
+	keeps the original label.  A new label is 0 if its partition is empty.
+
+	All voxels of the label's blocks are held in memory, so this is meant for bodies of
+	moderate size, and a label with more than 2048 blocks returns an error.
+
+	If the data instance has MappedLabels set and the label is a body of more than one
+	supervoxel, a cleave can only be applied if every seed set is given as supervoxels.
+	Each supervoxel of the body is then assigned to the partition holding most of its voxels,
+	and the returned partitions are made of whole supervoxels.  Applying the cleave only
+	modifies the mapping, where each group of supervoxels except the first is mapped to a
+	new body.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+    label         The label to be cleaved.
+
+    Query-string Options:
+
+    apply         If "true", applies the cleave by splitting off partitions into new labels.
+
+
+GET <api URL>/node/<UUID>/<data name>/stats/<label>
+
+	Returns statistics of a label's voxels in JSON:
+
+	{
+		"label": 23,
+		"voxels": 32000,
+		"blocks": 3,
+		"bounds": { "minpoint": [10, 40, 10], "maxpoint": [29, 59, 89] },
+		"centroid": [19.5, 49.5, 49.5],
+		"moments": { "xx": 33.25, "yy": 33.25, "zz": 533.25, "xy": 0, "xz": 0, "yz": 0 }
+	}
+
+	"blocks" is the number of blocks touched by the label, "bounds" is the exact bounding box
+	of the voxels, and "moments" are the central second moments of the voxel coordinates
+	normalized by the number of voxels, i.e., the covariance.  Stats are computed by scanning
+	the label's blocks and are cached per version until the label is modified.  Returns a
+	status code 404 (Not Found) if the label has no voxels.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+    label         The label whose stats should be returned.
+
+POST <api URL>/node/<UUID>/<data name>/stats
+
+	Returns statistics for a list of labels.  Expects JSON in POST body:
+
+	[ label1, label2, ...]
+
+	Returns a JSON list of stats in the same order, using the format of the GET "stats"
+	endpoint above.  A label without voxels only has "label" and "voxels" (= 0) properties.
+	At most 1000 labels can be requested at once.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+
+GET <api URL>/node/<UUID>/<data name>/adjacency/<label>[?graph=<labelgraph name>]
+
+	Returns the labels touching the given label and the contact area with each, measured as
+	the number of 6-connected voxel faces shared by the two labels.  Background label 0 is
+	never a neighbor.  Neighbors are sorted by decreasing contact:
+
+	{
+		"label": <label>,
+		"neighbors": [ { "label": 23, "contact": 1012 }, { "label": 911, "contact": 34 }, ... ]
+	}
+
+	If the data instance has MappedLabels set, the label and its neighbors are body labels.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+    label         The label whose neighbors should be returned.
+
+    Query-string Options:
+
+    graph         Name of a labelgraph data instance in the same repo.  If given, an edge
+                    between the label and each neighbor is stored in the labelgraph with
+                    the contact as the edge weight.
+
+GET <api URL>/node/<UUID>/<data name>/adjacency?roi=<roi name>[&graph=<labelgraph name>]
+
+	Returns all pairs of touching labels within the given ROI and the contact area of each
+	pair, sorted by label pair:
+
+	[ { "label1": 23, "label2": 911, "contact": 34 }, ... ]
+
+	A shared voxel face is counted if the voxel with the smaller coordinate is within the ROI.
+	Every label block intersecting the ROI is read, so large ROIs can take a long time.
+	Unlike the label form above, this form does not require IndexedLabels.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+
+    Query-string Options:
+
+    roi           Name of a roi data instance in the same repo.  Required.
+    graph         Name of a labelgraph data instance in the same repo.  If given, an edge for
+                    each label pair is stored in the labelgraph with the contact as the
+                    edge weight.
+
+
+-------------------------------------------------------------------------------------------------------
+--- The following endpoints are most useful when the labelarray data instance has MappedLabels set. ---
+-------------------------------------------------------------------------------------------------------
+
+When MappedLabels is true, the labels stored in blocks are supervoxels, and a versioned mapping
+assigns each supervoxel to a body label.  A supervoxel with no mapping is its own body.  Merges
+only modify the mapping and do not rewrite label blocks, so they are fast even for huge bodies.
+All label reads (raw, isotropic, pseudocolor, blocks, label, labels, sparsevol) return body labels
+after applying the mapping.  Splits and coarse splits are done on supervoxels, so the label given
+to a split must not be a body composed of more than one supervoxel.  The split-off voxels
+become a new unmapped supervoxel.
+
+GET <api URL>/node/<UUID>/<data name>/mapping[?queryopts]
+
+	Returns JSON for the body labels of a list of supervoxels.  Expects JSON in GET body:
+
+	[ supervoxel1, supervoxel2, ...]
+
+	Returns for each supervoxel the mapped body label:
+
+	[ 23, 911, ...]
+
+	If the data instance does not have MappedLabels set, each supervoxel maps to itself.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+
+    Query-string Options:
+
+    hash          MD5 hash of request body content in hexidecimal string format.
+
+
+GET <api URL>/node/<UUID>/<data name>/supervoxels/<body>
+
+	Returns JSON for the supervoxels that have been mapped to the given body label:
+
+	[ 23, 911, ...]
+
+	Returns a status code 404 (Not Found) if the body does not exist, e.g., it has been
+	merged into another body.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+    body          The body label.
+
+
+GET <api URL>/node/<UUID>/<data name>/supervoxel-sizes/<body>
+
+	Returns the supervoxels and their sizes in voxels for the given body label in JSON:
+
+	{ "supervoxels": [ 23, 911, ...], "sizes": [ 1012, 322, ...] }
+
+	This requires the labelarray data instance to have IndexedLabels set to true.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+    body          The body label.
 `
 
 var (
@@ -914,6 +1153,10 @@ type Data struct {
 	// True if we keep track of # voxels per label.  (Default false)
 	CountLabels bool
 
+	// True if block labels are supervoxels that are mapped to bodies using a versioned
+	// supervoxel -> body mapping.  (Default false)
+	MappedLabels bool
+
 	// Number of down-resolution levels supported.  Each down-res level is 2x scope of
 	// the higher level.
 	DownresLevels uint8
@@ -926,6 +1169,8 @@ type Data struct {
 	// unpersisted data: channels for mutations
 	mutateCh [numBlockHandlers]chan procMsg     // channels into mutate (merge/split) ops.
 	indexCh  [numLabelHandlers]chan labelChange // channels into label indexing
+
+	statsCache labelStatsCache // cached label stats per version
 }
 
 // GetDownresLevels returns the number of down-res levels, where level 0 = high-resolution
@@ -984,6 +1229,7 @@ func (d *Data) CopyPropertiesFrom(src datastore.DataService, fs storage.FilterSp
 
 	d.IndexedLabels = d2.IndexedLabels
 	d.CountLabels = d2.CountLabels
+	d.MappedLabels = d2.MappedLabels
 	d.DownresLevels = d2.DownresLevels
 
 	return d.Data.CopyPropertiesFrom(d2.Data, fs)
@@ -1023,6 +1269,11 @@ func NewData(uuid dvid.UUID, id dvid.InstanceID, name dvid.InstanceName, c dvid.
 		countLabels = b
 	}
 
+	mappedLabels, _, err := c.GetBool("MappedLabels")
+	if err != nil {
+		return nil, err
+	}
+
 	var downresLevels uint8
 	levels, found, err := c.GetInt("DownresLevels")
 	if err != nil {
@@ -1039,6 +1290,7 @@ func NewData(uuid dvid.UUID, id dvid.InstanceID, name dvid.InstanceName, c dvid.
 	data.MaxLabel = make(map[dvid.VersionID]uint64)
 	data.IndexedLabels = indexedLabels
 	data.CountLabels = countLabels
+	data.MappedLabels = mappedLabels
 	data.DownresLevels = downresLevels
 	return data, nil
 }
@@ -1053,12 +1305,35 @@ func (d *Data) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// labelarray-specific properties that are persisted after the embedded imageblk data.
+type extendedProperties struct {
+	IndexedLabels bool
+	CountLabels   bool
+	MappedLabels  bool
+	DownresLevels uint8
+}
+
 func (d *Data) GobDecode(b []byte) error {
 	buf := bytes.NewBuffer(b)
 	dec := gob.NewDecoder(buf)
 	if err := dec.Decode(&(d.Data)); err != nil {
 		return err
 	}
+
+	// Older metadata did not persist the extended properties so use defaults.
+	var ext extendedProperties
+	if err := dec.Decode(&ext); err != nil {
+		if err != io.EOF {
+			return err
+		}
+		ext.IndexedLabels = true
+		ext.CountLabels = true
+	}
+	d.IndexedLabels = ext.IndexedLabels
+	d.CountLabels = ext.CountLabels
+	d.MappedLabels = ext.MappedLabels
+	d.DownresLevels = ext.DownresLevels
+	d.updates = make([]uint32, d.DownresLevels+1)
 	return nil
 }
 
@@ -1068,6 +1343,15 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.Data); err != nil {
 		return nil, err
 	}
+	ext := extendedProperties{
+		IndexedLabels: d.IndexedLabels,
+		CountLabels:   d.CountLabels,
+		MappedLabels:  d.MappedLabels,
+		DownresLevels: d.DownresLevels,
+	}
+	if err := enc.Encode(ext); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -1488,7 +1772,9 @@ func (d *Data) putLabelBlock(ctx *datastore.VersionedCtx, scale uint8, pb *label
 	return store.Put(ctx, tk, val)
 }
 
-func (d *Data) sendBlock(w http.ResponseWriter, x, y, z int32, v []byte, compression string) error {
+// sendBlock writes a block to the writer in the requested compression.  If a supervoxel mapper
+// is given, the block must be decoded so its labels can be mapped.
+func (d *Data) sendBlock(w http.ResponseWriter, x, y, z int32, v []byte, compression string, mapper *svMapper) error {
 	formatIn, checksum := dvid.DecodeSerializationFormat(dvid.SerializationFormat(v[0]))
 
 	var start int
@@ -1532,7 +1818,7 @@ func (d *Data) sendBlock(w http.ResponseWriter, x, y, z int32, v []byte, compres
 	// Need to do uncompression/recompression if we are changing compression
 	var err error
 	var uncompressed, recompressed []byte
-	if formatIn != formatOut || compression == "gzip" {
+	if formatIn != formatOut || compression == "gzip" || mapper != nil {
 		switch formatIn {
 		case dvid.LZ4:
 			uncompressed = make([]byte, outsize)
@@ -1558,10 +1844,23 @@ func (d *Data) sendBlock(w http.ResponseWriter, x, y, z int32, v []byte, compres
 		if err = block.UnmarshalBinary(uncompressed); err != nil {
 			return fmt.Errorf("unable to deserialize label block (%d, %d, %d): %v\n", x, y, z, err)
 		}
-		uint64array, size := block.MakeLabelVolume()
-		expectedSize := d.BlockSize().(dvid.Point3d)
-		if !size.Equals(expectedSize) {
-			return fmt.Errorf("deserialized label block size %s does not equal data %q block size %s", size, d.DataName(), expectedSize)
+		if err = mapper.ApplyToBlock(&block); err != nil {
+			return fmt.Errorf("unable to map supervoxels of label block (%d, %d, %d): %v\n", x, y, z, err)
+		}
+
+		// "blocks" compression sends the label block serialization, otherwise send the label volume.
+		var uint64array []byte
+		if compression == "blocks" {
+			if uint64array, err = block.MarshalBinary(); err != nil {
+				return err
+			}
+		} else {
+			var size dvid.Point3d
+			uint64array, size = block.MakeLabelVolume()
+			expectedSize := d.BlockSize().(dvid.Point3d)
+			if !size.Equals(expectedSize) {
+				return fmt.Errorf("deserialized label block size %s does not equal data %q block size %s", size, d.DataName(), expectedSize)
+			}
 		}
 
 		switch formatOut {
@@ -1644,6 +1943,8 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, sc
 		okv = req.NewBuffer(ctx)
 	}
 
+	mapper := d.newMapper(ctx.VersionID())
+
 	for ziter := int32(0); ziter < blocksdims.Value(2); ziter++ {
 		for yiter := int32(0); yiter < blocksdims.Value(1); yiter++ {
 			beginPoint := dvid.ChunkPoint3d{blocksoff.Value(0), blocksoff.Value(1) + yiter, blocksoff.Value(2) + ziter}
@@ -1674,7 +1975,7 @@ func (d *Data) SendBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, sc
 				if z != sz || y != sy || x < sx || x >= sx+int32(blocksdims.Value(0)) {
 					return nil
 				}
-				if err := d.sendBlock(w, x, y, z, kv.V, compression); err != nil {
+				if err := d.sendBlock(w, x, y, z, kv.V, compression, mapper); err != nil {
 					return err
 				}
 				return nil
@@ -2255,7 +2556,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	// Prevent use of APIs that require IndexedLabels when it is not set.
 	if !d.IndexedLabels {
 		switch parts[3] {
-		case "sparsevol", "sparsevol-by-point", "sparsevol-coarse", "maxlabel", "nextlabel", "split", "split-coarse", "merge":
+		case "sparsevol", "sparsevol-by-point", "sparsevol-coarse", "maxlabel", "nextlabel", "split", "split-coarse", "split-components", "merge", "cleave", "supervoxel-sizes", "stats":
 			server.BadRequest(w, r, "data %q is not label indexed (IndexedLabels=false): %q endpoint is not supported", d.DataName(), parts[3])
 			return
 		}
@@ -2313,6 +2614,12 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	case "labels":
 		d.handleLabels(ctx, w, r)
 
+	case "mapping":
+		d.handleMapping(ctx, w, r)
+
+	case "supervoxels":
+		d.handleSupervoxels(ctx, w, r, parts)
+
 	case "blocks":
 		d.handleBlocks(ctx, w, r, parts)
 
@@ -2345,9 +2652,24 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	case "split-coarse":
 		d.handleSplitCoarse(ctx, w, r, parts)
 
+	case "split-components":
+		d.handleSplitComponents(ctx, w, r, parts)
+
 	case "merge":
 		d.handleMerge(ctx, w, r, parts)
 
+	case "cleave":
+		d.handleCleave(ctx, w, r, parts)
+
+	case "supervoxel-sizes":
+		d.handleSupervoxelSizes(ctx, w, r, parts)
+
+	case "adjacency":
+		d.handleAdjacency(ctx, w, r, parts)
+
+	case "stats":
+		d.handleStats(ctx, w, r, parts)
+
 	default:
 		server.BadAPIRequest(w, r, d)
 	}
@@ -2368,7 +2690,12 @@ func (d *Data) handleLabel(ctx *datastore.VersionedCtx, w http.ResponseWriter, r
 		server.BadRequest(w, r, err)
 		return
 	}
-	label, err := d.GetLabelAtPoint(ctx.VersionID(), coord)
+	var label uint64
+	if r.URL.Query().Get("supervoxels") == "true" {
+		label, err = d.GetSupervoxelAtPoint(ctx.VersionID(), coord)
+	} else {
+		label, err = d.GetLabelAtPoint(ctx.VersionID(), coord)
+	}
 	if err != nil {
 		server.BadRequest(w, r, err)
 		return
@@ -2404,11 +2731,17 @@ func (d *Data) handleLabels(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 		server.BadRequest(w, r, fmt.Sprintf("Bad labels request JSON: %v", err))
 		return
 	}
+	supervoxels := queryStrings.Get("supervoxels") == "true"
 	w.Header().Set("Content-type", "application/json")
 	fmt.Fprintf(w, "[")
 	sep := false
 	for _, coord := range coords {
-		label, err := d.GetLabelAtPoint(ctx.VersionID(), coord)
+		var label uint64
+		if supervoxels {
+			label, err = d.GetSupervoxelAtPoint(ctx.VersionID(), coord)
+		} else {
+			label, err = d.GetLabelAtPoint(ctx.VersionID(), coord)
+		}
 		if err != nil {
 			server.BadRequest(w, r, err)
 			return
@@ -2424,6 +2757,130 @@ func (d *Data) handleLabels(ctx *datastore.VersionedCtx, w http.ResponseWriter,
 	timedLog.Infof("HTTP GET batch label-at-point query (%s)", r.URL)
 }
 
+func (d *Data) handleMapping(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request) {
+	// GET <api URL>/node/<UUID>/<data name>/mapping
+	timedLog := dvid.NewTimeLog()
+
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "Batch mapping query must be a GET request")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		server.BadRequest(w, r, "Bad GET request body for batch query: %v", err)
+		return
+	}
+	hash := r.URL.Query().Get("hash")
+	if err := checkContentHash(hash, data); err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	var supervoxels []uint64
+	if err := json.Unmarshal(data, &supervoxels); err != nil {
+		server.BadRequest(w, r, fmt.Sprintf("Bad mapping request JSON: %v", err))
+		return
+	}
+	mapped, err := d.MapLabels(ctx.VersionID(), supervoxels)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	jsonBytes, err := json.Marshal(mapped)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+
+	timedLog.Infof("HTTP GET batch mapping query of %d supervoxels (%s)", len(supervoxels), r.URL)
+}
+
+func (d *Data) handleSupervoxels(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/supervoxels/<body>
+	if len(parts) < 5 {
+		server.BadRequest(w, r, "DVID requires body label to follow 'supervoxels' command")
+		return
+	}
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "Only GET action is available on 'supervoxels' endpoint.")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	body, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if body == 0 {
+		server.BadRequest(w, r, "Label 0 is protected background value and cannot be used as body.\n")
+		return
+	}
+	supervoxels, err := d.GetSupervoxels(ctx.VersionID(), body)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if len(supervoxels) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	jsonBytes, err := json.Marshal(sortedLabels(supervoxels))
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+
+	timedLog.Infof("HTTP GET supervoxels for body %d (%s)", body, r.URL)
+}
+
+func (d *Data) handleSupervoxelSizes(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/supervoxel-sizes/<body>
+	if len(parts) < 5 {
+		server.BadRequest(w, r, "DVID requires body label to follow 'supervoxel-sizes' command")
+		return
+	}
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "Only GET action is available on 'supervoxel-sizes' endpoint.")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	body, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if body == 0 {
+		server.BadRequest(w, r, "Label 0 is protected background value and cannot be used as body.\n")
+		return
+	}
+	supervoxels, sizes, err := d.GetSupervoxelSizes(ctx.VersionID(), body)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if len(supervoxels) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	jsonBytes, err := json.Marshal(struct {
+		Supervoxels []uint64 `json:"supervoxels"`
+		Sizes       []uint64 `json:"sizes"`
+	}{supervoxels, sizes})
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+
+	timedLog.Infof("HTTP GET supervoxel sizes for body %d (%s)", body, r.URL)
+}
+
 func (d *Data) handleBlocks(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
 	// GET <api URL>/node/<UUID>/<data name>/blocks/<size>/<offset>[?compression=...]
 	// POST <api URL>/node/<UUID>/<data name>/blocks[?compression=...]
@@ -2890,7 +3347,7 @@ func (d *Data) handleNextlabel(ctx *datastore.VersionedCtx, w http.ResponseWrite
 }
 
 func (d *Data) handleSplit(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
-	// POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X]
+	// POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X&relabel-disconnected=true]
 	if strings.ToLower(r.Method) != "post" {
 		server.BadRequest(w, r, "Split requests must be POST actions.")
 		return
@@ -2919,19 +3376,275 @@ func (d *Data) handleSplit(ctx *datastore.VersionedCtx, w http.ResponseWriter, r
 			server.BadRequest(w, r, "Bad parameter for 'splitlabel' query string (%q).  Must be uint64.\n", splitStr)
 		}
 	}
+	relabel := queryStrings.Get("relabel-disconnected") == "true"
+	connectivity, err := labels.ParseConnectivity(queryStrings.Get("connectivity"))
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
 	toLabel, err := d.SplitLabels(ctx.VersionID(), fromLabel, splitLabel, r.Body)
 	if err != nil {
 		server.BadRequest(w, r, fmt.Sprintf("split: %v", err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+	if err := labels.WriteSplitResponse(w, d, ctx.VersionID(), fromLabel, toLabel, relabel, connectivity); err != nil {
+		dvid.Errorf("split of label %d into %d, data %q: %v\n", fromLabel, toLabel, d.DataName(), err)
+	}
 
 	timedLog.Infof("HTTP split of label %d request (%s)", fromLabel, r.URL)
 }
 
+func (d *Data) handleSplitComponents(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// POST <api URL>/node/<UUID>/<data name>/split-components/<label>[?connectivity=26]
+	if strings.ToLower(r.Method) != "post" {
+		server.BadRequest(w, r, "Split-components requests must be POST actions.")
+		return
+	}
+	if len(parts) < 5 {
+		server.BadRequest(w, r, "ERROR: DVID requires label ID to follow 'split-components' command")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	label, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if label == 0 {
+		server.BadRequest(w, r, "Label 0 is protected background value and cannot be split.\n")
+		return
+	}
+	connectivity, err := labels.ParseConnectivity(r.URL.Query().Get("connectivity"))
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	components, err := d.SplitComponents(ctx.VersionID(), label, connectivity)
+	if err != nil {
+		server.BadRequest(w, r, fmt.Sprintf("split-components: %v", err))
+		return
+	}
+	jsonBytes, err := json.Marshal(components)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "{%q: %s}", "labels", string(jsonBytes))
+
+	timedLog.Infof("HTTP split-components of label %d into %d new labels (%s)", label, len(components), r.URL)
+}
+
+func (d *Data) handleCleave(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// POST <api URL>/node/<UUID>/<data name>/cleave/<label>[?apply=true]
+	if strings.ToLower(r.Method) != "post" {
+		server.BadRequest(w, r, "Cleave requests must be POST actions.")
+		return
+	}
+	if len(parts) < 5 {
+		server.BadRequest(w, r, "ERROR: DVID requires label ID to follow 'cleave' command")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+
+	label, err := strconv.ParseUint(parts[4], 10, 64)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if label == 0 {
+		server.BadRequest(w, r, "Label 0 is protected background value and cannot be cleaved.\n")
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		server.BadRequest(w, r, "Bad POST request body for cleave: %v", err)
+		return
+	}
+	var req CleaveRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		server.BadRequest(w, r, fmt.Sprintf("Bad cleave request JSON: %v", err))
+		return
+	}
+	resp := struct {
+		Label   uint64   `json:"label"`
+		Cleaves []Cleave `json:"cleaves"`
+		Labels  []uint64 `json:"labels,omitempty"`
+	}{
+		Label: label,
+	}
+	if r.URL.Query().Get("apply") == "true" {
+		resp.Cleaves, resp.Labels, err = d.ApplyCleave(ctx.VersionID(), label, req)
+	} else {
+		resp.Cleaves, err = d.CleaveLabel(ctx.VersionID(), label, req)
+	}
+	if err != nil {
+		server.BadRequest(w, r, fmt.Sprintf("cleave: %v", err))
+		return
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+
+	timedLog.Infof("HTTP cleave of label %d into %d partitions (%s)", label, len(resp.Cleaves), r.URL)
+}
+
+func (d *Data) handleAdjacency(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/adjacency/<label>[?graph=<labelgraph name>]
+	// GET <api URL>/node/<UUID>/<data name>/adjacency?roi=<roi name>[&graph=<labelgraph name>]
+	if strings.ToLower(r.Method) != "get" {
+		server.BadRequest(w, r, "Adjacency requests must be GET actions.")
+		return
+	}
+	timedLog := dvid.NewTimeLog()
+	queryStrings := r.URL.Query()
+	graphname := dvid.InstanceName(queryStrings.Get("graph"))
+
+	var edges []Edge
+	var jsonBytes []byte
+	var err error
+	if len(parts) >= 5 && parts[4] != "" {
+		if !d.IndexedLabels {
+			server.BadRequest(w, r, "data %q is not label indexed (IndexedLabels=false): adjacency of a label is not supported", d.DataName())
+			return
+		}
+		var label uint64
+		if label, err = strconv.ParseUint(parts[4], 10, 64); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if label == 0 {
+			server.BadRequest(w, r, "Label 0 is protected background value and has no adjacency.\n")
+			return
+		}
+		var neighbors []Contact
+		if neighbors, err = d.GetAdjacency(ctx.VersionID(), label); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("adjacency: %v", err))
+			return
+		}
+		for _, nbr := range neighbors {
+			edge := Edge{Label1: label, Label2: nbr.Label, Contact: nbr.Contact}
+			if nbr.Label < label {
+				edge.Label1, edge.Label2 = nbr.Label, label
+			}
+			edges = append(edges, edge)
+		}
+		resp := struct {
+			Label     uint64    `json:"label"`
+			Neighbors []Contact `json:"neighbors"`
+		}{label, neighbors}
+		jsonBytes, err = json.Marshal(resp)
+	} else {
+		roiname := dvid.InstanceName(queryStrings.Get("roi"))
+		if roiname == "" {
+			server.BadRequest(w, r, "adjacency requires either a label or a 'roi' query string")
+			return
+		}
+		if edges, err = d.GetROIAdjacency(ctx.VersionID(), roiname); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("adjacency: %v", err))
+			return
+		}
+		jsonBytes, err = json.Marshal(edges)
+	}
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
+	if graphname != "" {
+		if err := d.StoreAdjacency(ctx.VersionID(), graphname, edges); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("adjacency: %v", err))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+
+	timedLog.Infof("HTTP adjacency request with %d edges (%s)", len(edges), r.URL)
+}
+
+func (d *Data) handleStats(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
+	// GET <api URL>/node/<UUID>/<data name>/stats/<label>
+	// POST <api URL>/node/<UUID>/<data name>/stats
+	timedLog := dvid.NewTimeLog()
+
+	var jsonBytes []byte
+	var numLabels int
+	switch strings.ToLower(r.Method) {
+	case "get":
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "DVID requires label to follow 'stats' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if label == 0 {
+			server.BadRequest(w, r, "Label 0 is protected background value and has no stats.\n")
+			return
+		}
+		stats, err := d.GetLabelStats(ctx.VersionID(), label)
+		if err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("stats: %v", err))
+			return
+		}
+		if stats.Voxels == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if jsonBytes, err = json.Marshal(stats); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		numLabels = 1
+	case "post":
+		var lbls []uint64
+		if err := json.NewDecoder(r.Body).Decode(&lbls); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("bad JSON list of labels for stats: %v", err))
+			return
+		}
+		if len(lbls) > MaxStatsLabels {
+			server.BadRequest(w, r, fmt.Sprintf("stats requested for %d labels, more than maximum %d labels", len(lbls), MaxStatsLabels))
+			return
+		}
+		allStats := make([]*LabelStats, len(lbls))
+		for i, label := range lbls {
+			if label == 0 {
+				allStats[i] = &LabelStats{}
+				continue
+			}
+			stats, err := d.GetLabelStats(ctx.VersionID(), label)
+			if err != nil {
+				server.BadRequest(w, r, fmt.Sprintf("stats of label %d: %v", label, err))
+				return
+			}
+			allStats[i] = stats
+		}
+		var err error
+		if jsonBytes, err = json.Marshal(allStats); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		numLabels = len(lbls)
+	default:
+		server.BadRequest(w, r, "Only GET or POST actions are available on 'stats' endpoint.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, string(jsonBytes))
+
+	timedLog.Infof("HTTP stats request for %d labels (%s)", numLabels, r.URL)
+}
+
 func (d *Data) handleSplitCoarse(ctx *datastore.VersionedCtx, w http.ResponseWriter, r *http.Request, parts []string) {
-	// POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X]
+	// POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X&relabel-disconnected=true]
 	if strings.ToLower(r.Method) != "post" {
 		server.BadRequest(w, r, "Split-coarse requests must be POST actions.")
 		return
@@ -2960,13 +3673,21 @@ func (d *Data) handleSplitCoarse(ctx *datastore.VersionedCtx, w http.ResponseWri
 			server.BadRequest(w, r, "Bad parameter for 'splitlabel' query string (%q).  Must be uint64.\n", splitStr)
 		}
 	}
+	relabel := queryStrings.Get("relabel-disconnected") == "true"
+	connectivity, err := labels.ParseConnectivity(queryStrings.Get("connectivity"))
+	if err != nil {
+		server.BadRequest(w, r, err)
+		return
+	}
 	toLabel, err := d.SplitCoarseLabels(ctx.VersionID(), fromLabel, splitLabel, r.Body)
 	if err != nil {
 		server.BadRequest(w, r, fmt.Sprintf("split-coarse: %v", err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+	if err := labels.WriteSplitResponse(w, d, ctx.VersionID(), fromLabel, toLabel, relabel, connectivity); err != nil {
+		dvid.Errorf("split-coarse of label %d into %d, data %q: %v\n", fromLabel, toLabel, d.DataName(), err)
+	}
 
 	timedLog.Infof("HTTP split-coarse of label %d request (%s)", fromLabel, r.URL)
 }
@@ -3004,8 +3725,18 @@ func (d *Data) handleMerge(ctx *datastore.VersionedCtx, w http.ResponseWriter, r
 
 // --------- Other functions on labelarray Data -----------------
 
-// GetLabelBlock returns a block of labels corresponding to the block coordinate.
+// GetLabelBlock returns a block of labels corresponding to the block coordinate.  If the
+// data uses MappedLabels, the returned labels are bodies and not supervoxels.
 func (d *Data) GetLabelBlock(v dvid.VersionID, scale uint8, bcoord dvid.ChunkPoint3d) ([]byte, error) {
+	return d.getMappedLabelBlock(v, scale, bcoord, d.newMapper(v))
+}
+
+// GetSupervoxelBlock returns a block of supervoxels corresponding to the block coordinate.
+func (d *Data) GetSupervoxelBlock(v dvid.VersionID, scale uint8, bcoord dvid.ChunkPoint3d) ([]byte, error) {
+	return d.getMappedLabelBlock(v, scale, bcoord, nil)
+}
+
+func (d *Data) getMappedLabelBlock(v dvid.VersionID, scale uint8, bcoord dvid.ChunkPoint3d, mapper *svMapper) ([]byte, error) {
 	store, err := d.GetOrderedKeyValueDB()
 	if err != nil {
 		return nil, err
@@ -3029,12 +3760,19 @@ func (d *Data) GetLabelBlock(v dvid.VersionID, scale uint8, bcoord dvid.ChunkPoi
 	if err = block.UnmarshalBinary(deserialization); err != nil {
 		return nil, err
 	}
+	if err = mapper.ApplyToBlock(&block); err != nil {
+		return nil, err
+	}
 	labelData, _ := block.MakeLabelVolume()
 	return labelData, nil
 }
 
 // GetLabelBytesAtPoint returns the 8 byte slice corresponding to a 64-bit label at a point.
 func (d *Data) GetLabelBytesAtPoint(v dvid.VersionID, pt dvid.Point) ([]byte, error) {
+	return d.getLabelBytesAtPoint(v, pt, d.newMapper(v))
+}
+
+func (d *Data) getLabelBytesAtPoint(v dvid.VersionID, pt dvid.Point, mapper *svMapper) ([]byte, error) {
 	coord, ok := pt.(dvid.Chunkable)
 	if !ok {
 		return nil, fmt.Errorf("Can't determine block of point %s", pt)
@@ -3042,7 +3780,7 @@ func (d *Data) GetLabelBytesAtPoint(v dvid.VersionID, pt dvid.Point) ([]byte, er
 	blockSize := d.BlockSize()
 	bcoord := coord.Chunk(blockSize).(dvid.ChunkPoint3d)
 
-	labelData, err := d.GetLabelBlock(v, 0, bcoord)
+	labelData, err := d.getMappedLabelBlock(v, 0, bcoord, mapper)
 	if err != nil {
 		return nil, err
 	}
@@ -3066,3 +3804,13 @@ func (d *Data) GetLabelAtPoint(v dvid.VersionID, pt dvid.Point) (uint64, error)
 	}
 	return binary.LittleEndian.Uint64(labelBytes), nil
 }
+
+// GetSupervoxelAtPoint returns the 64-bit unsigned int supervoxel for a given point.
+// For data without MappedLabels, this is the same as GetLabelAtPoint.
+func (d *Data) GetSupervoxelAtPoint(v dvid.VersionID, pt dvid.Point) (uint64, error) {
+	labelBytes, err := d.getLabelBytesAtPoint(v, pt, nil)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(labelBytes), nil
+}
@@ -1355,3 +1355,79 @@ func TestLabels(t *testing.T) {
 func TestLabelsUnindexed(t *testing.T) {
 	testLabels(t, false)
 }
+
+func TestROIVoxelsFromLabel(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	vol := newTestVolume(64, 64, 64)
+	vol.addSubvol(dvid.Point3d{10, 20, 30}, dvid.Point3d{40, 10, 5}, 5)
+	vol.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on labels updating: %v\n", err)
+	}
+
+	var roiConfig dvid.Config
+	server.CreateTestInstance(t, uuid, "roi", "layer", roiConfig)
+	voxelsReq := fmt.Sprintf("%snode/%s/layer/voxels", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", voxelsReq+"?data=labels&label=5", nil)
+	server.TestBadHTTP(t, "POST", voxelsReq+"?data=labels&label=6", nil)
+
+	rles, err := dvid.ReadRLEs(bytes.NewReader(server.TestHTTP(t, "GET", voxelsReq, nil)))
+	if err != nil {
+		t.Fatalf("Error reading ROI voxels: %v\n", err)
+	}
+	if numVoxels, numRuns := rles.Stats(); numVoxels != 40*10*5 || numRuns != 10*5 {
+		t.Errorf("Expected ROI from label to have %d voxels in %d runs, got %d voxels in %d runs\n", 40*10*5, 10*5, numVoxels, numRuns)
+	}
+	roiReq := fmt.Sprintf("%snode/%s/layer/roi", server.WebAPIPath, uuid)
+	var spans []dvid.Span
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", roiReq, nil), &spans); err != nil {
+		t.Fatalf("Error decoding ROI spans: %v\n", err)
+	}
+	if !reflect.DeepEqual(spans, []dvid.Span{{0, 0, 0, 1}, {1, 0, 0, 1}}) {
+		t.Errorf("Bad block spans for ROI from label: %v\n", spans)
+	}
+}
+
+func TestROIFromLabel(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	vol := newTestVolume(96, 64, 64)
+	vol.addSubvol(dvid.Point3d{10, 20, 30}, dvid.Point3d{80, 10, 5}, 5)
+	vol.addSubvol(dvid.Point3d{70, 40, 40}, dvid.Point3d{10, 10, 10}, 5)
+	vol.put(t, uuid, "labels")
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on labels updating: %v\n", err)
+	}
+
+	var roiConfig dvid.Config
+	server.CreateTestInstance(t, uuid, "roi", "neuropil", roiConfig)
+	var bigConfig dvid.Config
+	bigConfig.Set("BlockSize", "64,64,64")
+	server.CreateTestInstance(t, uuid, "roi", "bigblocks", bigConfig)
+
+	fromLabelReq := fmt.Sprintf("%snode/%s/neuropil/from-label?data=labels&label=5", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", fromLabelReq, nil)
+	roiReq := fmt.Sprintf("%snode/%s/neuropil/roi", server.WebAPIPath, uuid)
+	var spans []dvid.Span
+	if err := json.Unmarshal(server.TestHTTP(t, "GET", roiReq, nil), &spans); err != nil {
+		t.Fatalf("Error decoding ROI spans: %v\n", err)
+	}
+	expected := []dvid.Span{{0, 0, 0, 2}, {1, 0, 0, 2}, {1, 1, 2, 2}}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Errorf("Bad block spans for ROI from label\nExpected: %v\nGot: %v\n", expected, spans)
+	}
+
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/neuropil/from-label?data=labels&label=6", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/bigblocks/from-label?data=labels&label=5", server.WebAPIPath, uuid), nil)
+}
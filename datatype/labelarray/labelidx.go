@@ -12,6 +12,7 @@ import (
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/datatype/common/labels"
 	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
 
 	lz4 "github.com/janelia-flyem/go/golz4"
 )
@@ -241,7 +242,7 @@ func (d *Data) aggregateBlockChanges(v dvid.VersionID, ch <-chan blockChange) {
 				bdm = make(blockDiffMap)
 				ldm[label] = bdm
 			}
-			var diff labelDiff
+			diff := bdm[change.bcoord]
 			switch flag {
 			case presentOld: // we no longer have this label in the block
 				diff.present = false
@@ -265,13 +266,14 @@ func (d *Data) aggregateBlockChanges(v dvid.VersionID, ch <-chan blockChange) {
 			}
 			diff := bdm[change.bcoord]
 			diff.delta += delta
+			bdm[change.bcoord] = diff
 		}
 	}
 	d.updateMaxLabel(v, maxLabel)
 
 	if d.IndexedLabels {
 		for label, bdm := range ldm {
-			change := labelChange{v, label, bdm}
+			change := labelChange{v: v, label: label, bdm: bdm, notifySize: true}
 			shard := label % numLabelHandlers
 			d.indexCh[shard] <- change
 		}
@@ -290,6 +292,10 @@ type labelChange struct {
 	v     dvid.VersionID
 	label uint64
 	bdm   blockDiffMap
+
+	// true if the change in label size should be published.  Merges and splits publish
+	// their own size changes.
+	notifySize bool
 }
 
 // goroutines (n = numLabelHandlers) spawned during startup to handle all get/put tx on label indexes,
@@ -309,6 +315,7 @@ func (d *Data) indexLabels(ch <-chan labelChange) {
 				continue
 			}
 		}
+		oldVoxels := meta.Voxels
 		if err := meta.applyChanges(change.bdm); err != nil {
 			dvid.Criticalf("Error on applying mutation changes to label %d meta: %v\n", change.label, err)
 			continue
@@ -319,10 +326,48 @@ func (d *Data) indexLabels(ch <-chan labelChange) {
 			dvid.Criticalf("Error trying to store indexing for label %d, data %q: %v\n", change.label, d.DataName(), err)
 			continue
 		}
+		d.invalidateStats(change.v, change.label)
+		if change.notifySize && meta.Voxels != oldVoxels {
+			d.publishSizeChange(change.v, change.label, oldVoxels, meta.Voxels)
+		}
 	}
 	dvid.Infof("Closing index handler for data %q...\n", d.DataName())
 }
 
+// publishes the change in size of a label due to written voxels.  In mapped mode, the change
+// is published for the label's body, which can have other supervoxels, so it's always a
+// size modification.
+func (d *Data) publishSizeChange(v dvid.VersionID, label, oldVoxels, newVoxels uint64) {
+	var delta interface{}
+	switch {
+	case d.MappedLabels:
+		body, err := d.newMapper(v).MapLabel(label)
+		if err != nil {
+			dvid.Errorf("can't map supervoxel %d to publish size change in data %q: %v\n", label, d.DataName(), err)
+			return
+		}
+		delta = labels.DeltaModSize{
+			Label:      body,
+			SizeChange: int64(newVoxels) - int64(oldVoxels),
+		}
+	case oldVoxels == 0:
+		delta = labels.DeltaNewSize{
+			Label: label,
+			Size:  newVoxels,
+		}
+	default:
+		delta = labels.DeltaModSize{
+			Label:      label,
+			SizeChange: int64(newVoxels) - int64(oldVoxels),
+		}
+	}
+	evt := datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
+	msg := datastore.SyncMessage{labels.ChangeSizeEvent, v, delta}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+}
+
 type labelBlock struct {
 	index dvid.IZYXString
 	data  []byte
@@ -532,6 +577,10 @@ func (d *Data) FoundSparseVol(ctx *datastore.VersionedCtx, label uint64, bounds
 		// If not, see if labels have been merged into it.
 		constituents = mapping.ConstituentLabels(label)
 	}
+	constituents, err := d.expandSupervoxels(ctx, constituents)
+	if err != nil {
+		return false, err
+	}
 
 	// See if any constituent label is within bounds.
 	store, err := d.GetKeyValueDB()
@@ -589,6 +638,9 @@ func (d *Data) GetMappedLabelMeta(ctx *datastore.VersionedCtx, label uint64, bou
 	} else {
 		lbls = mapping.ConstituentLabels(label)
 	}
+	if lbls, err = d.expandSupervoxels(ctx, lbls); err != nil {
+		return
+	}
 
 	// Get the block indices for the set of labels.
 	meta, err = d.getLabelMeta(ctx, lbls, bounds)
@@ -669,6 +721,79 @@ func (d *Data) PutLabelMeta(ctx *datastore.VersionedCtx, label uint64, meta *Met
 	return nil
 }
 
+// ProcessLabelSizes calls the given function with the number of voxels of each label
+// in the given version's label indices.  If labels are mapped, the sizes of supervoxels
+// are summed and the function is called with the number of voxels of each body.
+func (d *Data) ProcessLabelSizes(v dvid.VersionID, f func(label, voxels uint64)) error {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return fmt.Errorf("Data %q ProcessLabelSizes had error initializing store: %v\n", d.DataName(), err)
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	minTKey := storage.MinTKey(keyLabelIndex)
+	maxTKey := storage.MaxTKey(keyLabelIndex)
+	if d.MappedLabels {
+		mapper := d.newMapper(v)
+		bodySizes := make(map[uint64]uint64)
+		err = store.ProcessRange(ctx, minTKey, maxTKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+			if c == nil || len(c.V) == 0 {
+				return nil
+			}
+			supervoxel, voxels, err := d.decodeLabelSize(c)
+			if err != nil {
+				return err
+			}
+			if voxels == 0 {
+				return nil
+			}
+			body, err := mapper.MapLabel(supervoxel)
+			if err != nil {
+				return err
+			}
+			bodySizes[body] += voxels
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for body, voxels := range bodySizes {
+			f(body, voxels)
+		}
+		return nil
+	}
+	return store.ProcessRange(ctx, minTKey, maxTKey, &storage.ChunkOp{}, func(c *storage.Chunk) error {
+		if c == nil || len(c.V) == 0 {
+			return nil
+		}
+		label, voxels, err := d.decodeLabelSize(c)
+		if err != nil {
+			return err
+		}
+		if voxels != 0 {
+			f(label, voxels)
+		}
+		return nil
+	})
+}
+
+// returns the label and # voxels of a stored label index.
+func (d *Data) decodeLabelSize(c *storage.Chunk) (label, voxels uint64, err error) {
+	label, err = DecodeLabelIndexTKey(c.K)
+	if err != nil {
+		return
+	}
+	val, _, err := dvid.DeserializeData(c.V, true)
+	if err != nil {
+		return
+	}
+	var meta Meta
+	if err = meta.UnmarshalBinary(val); err != nil {
+		err = fmt.Errorf("unable to decode index for label %d, data %q: %v", label, d.DataName(), err)
+		return
+	}
+	return label, meta.Voxels, nil
+}
+
 // WriteBinaryBlocks does a streaming write of an encoded sparse volume given a label.
 // It returns a bool whether the label was found in the given bounds and any error.
 func (d *Data) WriteBinaryBlocks(ctx *datastore.VersionedCtx, label uint64, scale uint8, bounds dvid.Bounds, compression string, w io.Writer) (bool, error) {
@@ -943,6 +1068,10 @@ func (d *Data) GetSparseCoarseVol(ctx *datastore.VersionedCtx, label uint64, bou
 	} else {
 		lbls = mapping.ConstituentLabels(label)
 	}
+	lbls, err := d.expandSupervoxels(ctx, lbls)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get the block indices for the set of labels.
 	meta, err := d.getLabelMeta(ctx, lbls, bounds)
@@ -0,0 +1,315 @@
+/*
+	This file supports the optional versioned supervoxel -> body mapping for labelarray.
+	When a data instance has MappedLabels set, the labels stored in blocks are supervoxels,
+	and merges only modify the mapping instead of rewriting all affected blocks.
+*/
+
+package labelarray
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// svMapper memoizes supervoxel -> body lookups for the duration of a single request
+// on a version.  A nil svMapper is valid and maps every label to itself.
+type svMapper struct {
+	d   *Data
+	ctx *datastore.VersionedCtx
+
+	sync.RWMutex
+	m map[uint64]uint64
+}
+
+// returns a mapper for the given version or nil if the data instance is not mapped.
+func (d *Data) newMapper(v dvid.VersionID) *svMapper {
+	if !d.MappedLabels {
+		return nil
+	}
+	return &svMapper{
+		d:   d,
+		ctx: datastore.NewVersionedCtx(d, v),
+		m:   make(map[uint64]uint64),
+	}
+}
+
+// MapLabel returns the body label for a supervoxel.
+func (m *svMapper) MapLabel(supervoxel uint64) (uint64, error) {
+	if m == nil || supervoxel == 0 {
+		return supervoxel, nil
+	}
+	m.RLock()
+	body, found := m.m[supervoxel]
+	m.RUnlock()
+	if found {
+		return body, nil
+	}
+	body, err := m.d.getMappedLabel(m.ctx, supervoxel)
+	if err != nil {
+		return 0, err
+	}
+	m.Lock()
+	m.m[supervoxel] = body
+	m.Unlock()
+	return body, nil
+}
+
+// ApplyToBlock modifies the labels of a block in place so they are mapped to bodies.
+// Since only the block's label list is modified, the cost is independent of block size.
+func (m *svMapper) ApplyToBlock(block *labels.Block) error {
+	if m == nil {
+		return nil
+	}
+	for i, supervoxel := range block.Labels {
+		body, err := m.MapLabel(supervoxel)
+		if err != nil {
+			return err
+		}
+		block.Labels[i] = body
+	}
+	return nil
+}
+
+// MapLabels returns the body labels for the given supervoxels in the given version.
+func (d *Data) MapLabels(v dvid.VersionID, supervoxels []uint64) ([]uint64, error) {
+	mapper := d.newMapper(v)
+	mapped := make([]uint64, len(supervoxels))
+	for i, supervoxel := range supervoxels {
+		body, err := mapper.MapLabel(supervoxel)
+		if err != nil {
+			return nil, err
+		}
+		mapped[i] = body
+	}
+	return mapped, nil
+}
+
+// GetSupervoxels returns the set of supervoxels for a body in the given version.  An empty set
+// is returned if the body does not exist, e.g., it has been merged into another body.
+func (d *Data) GetSupervoxels(v dvid.VersionID, body uint64) (labels.Set, error) {
+	ctx := datastore.NewVersionedCtx(d, v)
+	return d.getSupervoxels(ctx, body)
+}
+
+// returns the body a supervoxel is mapped to, which is the supervoxel itself if not mapped.
+func (d *Data) getMappedLabel(ctx *datastore.VersionedCtx, supervoxel uint64) (uint64, error) {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return 0, err
+	}
+	val, err := store.Get(ctx, NewSupervoxelMapTKey(supervoxel))
+	if err != nil {
+		return 0, err
+	}
+	if val == nil {
+		return supervoxel, nil
+	}
+	if len(val) != 8 {
+		return 0, fmt.Errorf("bad mapping for supervoxel %d in data %q: expected 8 bytes, got %d", supervoxel, d.DataName(), len(val))
+	}
+	return binary.LittleEndian.Uint64(val), nil
+}
+
+func (d *Data) getSupervoxels(ctx *datastore.VersionedCtx, body uint64) (labels.Set, error) {
+	if !d.MappedLabels {
+		return labels.NewSet(body), nil
+	}
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	val, err := store.Get(ctx, NewBodySupervoxelsTKey(body))
+	if err != nil {
+		return nil, err
+	}
+	if val != nil {
+		return decodeSupervoxels(val)
+	}
+	mapped, err := d.getMappedLabel(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	if mapped != body {
+		return labels.Set{}, nil
+	}
+	return labels.NewSet(body), nil
+}
+
+// expands a set of body labels into the set of supervoxels that compose them.
+func (d *Data) expandSupervoxels(ctx *datastore.VersionedCtx, bodies labels.Set) (labels.Set, error) {
+	if !d.MappedLabels {
+		return bodies, nil
+	}
+	supervoxels := make(labels.Set, len(bodies))
+	for body := range bodies {
+		s, err := d.getSupervoxels(ctx, body)
+		if err != nil {
+			return nil, err
+		}
+		supervoxels.Merge(s)
+	}
+	return supervoxels, nil
+}
+
+// verifies that a label is not a body composed of more than one supervoxel, which
+// is required for splits on mapped data.
+func (d *Data) checkSplitSupervoxel(v dvid.VersionID, label uint64) error {
+	if !d.MappedLabels {
+		return nil
+	}
+	supervoxels, err := d.GetSupervoxels(v, label)
+	if err != nil {
+		return err
+	}
+	if len(supervoxels) > 1 {
+		return fmt.Errorf("label %d is a body with %d supervoxels: split one of its supervoxels instead", label, len(supervoxels))
+	}
+	return nil
+}
+
+// mergeMapping modifies the supervoxel -> body mapping so all supervoxels of the merged
+// bodies are mapped to the target body.
+func (d *Data) mergeMapping(ctx *datastore.VersionedCtx, op labels.MergeOp) error {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return fmt.Errorf("Data %q merge had error initializing store: %v\n", d.DataName(), err)
+	}
+	batcher, ok := store.(storage.KeyValueBatcher)
+	if !ok {
+		return fmt.Errorf("Data %q merge requires batch-enabled store, which %q is not\n", d.DataName(), store)
+	}
+
+	targetSupervoxels, err := d.getSupervoxels(ctx, op.Target)
+	if err != nil {
+		return err
+	}
+	if len(targetSupervoxels) == 0 {
+		return fmt.Errorf("merge target %d has already been merged into another body", op.Target)
+	}
+	allSupervoxels := targetSupervoxels.Copy()
+
+	targetBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(targetBuf, op.Target)
+
+	batch := batcher.NewBatch(ctx)
+	for merged := range op.Merged {
+		supervoxels, err := d.getSupervoxels(ctx, merged)
+		if err != nil {
+			return err
+		}
+		if len(supervoxels) == 0 {
+			return fmt.Errorf("label %d to be merged is not a body and has already been merged", merged)
+		}
+		for supervoxel := range supervoxels {
+			batch.Put(NewSupervoxelMapTKey(supervoxel), targetBuf)
+			allSupervoxels[supervoxel] = struct{}{}
+		}
+		batch.Delete(NewBodySupervoxelsTKey(merged))
+	}
+	batch.Put(NewBodySupervoxelsTKey(op.Target), encodeSupervoxels(allSupervoxels))
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("unable to commit mapping for merge %s, data %q: %v", op, d.DataName(), err)
+	}
+	return nil
+}
+
+// handle mapping and events for a merge in a mapped data instance, where
+// no label blocks or label indices need to be modified.
+func (d *Data) processMappedMerge(v dvid.VersionID, delta labels.DeltaMerge) error {
+	timedLog := dvid.NewTimeLog()
+	defer d.invalidateStats(v, append(sortedLabels(delta.Merged), delta.Target)...)
+
+	evt := datastore.SyncEvent{d.DataUUID(), labels.MergeBlockEvent}
+	msg := datastore.SyncMessage{labels.MergeBlockEvent, v, delta}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		return fmt.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	if err := d.mergeMapping(ctx, delta.MergeOp); err != nil {
+		return err
+	}
+
+	deltaRep := labels.DeltaReplaceSize{
+		Label:   delta.Target,
+		OldSize: delta.TargetVoxels,
+		NewSize: delta.TargetVoxels + delta.MergedVoxels,
+	}
+	evt = datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
+	msg = datastore.SyncMessage{labels.ChangeSizeEvent, v, deltaRep}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Criticalf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+
+	evt = datastore.SyncEvent{d.DataUUID(), labels.MergeEndEvent}
+	msg = datastore.SyncMessage{labels.MergeEndEvent, v, labels.DeltaMergeEnd{delta.MergeOp}}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Criticalf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+
+	timedLog.Infof("Mapped merge %s -> %d, data %q, covering %d blocks", delta.Merged, delta.Target, d.DataName(), len(delta.Blocks))
+	return nil
+}
+
+// GetSupervoxelSizes returns the supervoxels of a body and the number of voxels in each.
+func (d *Data) GetSupervoxelSizes(v dvid.VersionID, body uint64) (supervoxels []uint64, sizes []uint64, err error) {
+	ctx := datastore.NewVersionedCtx(d, v)
+	var s labels.Set
+	if s, err = d.getSupervoxels(ctx, body); err != nil {
+		return
+	}
+	supervoxels = sortedLabels(s)
+	sizes = make([]uint64, len(supervoxels))
+	for i, supervoxel := range supervoxels {
+		var meta *Meta
+		if meta, err = d.getLabelMeta(ctx, labels.NewSet(supervoxel), dvid.Bounds{}); err != nil {
+			return
+		}
+		sizes[i] = meta.Voxels
+	}
+	return
+}
+
+type labelSlice []uint64
+
+func (s labelSlice) Len() int           { return len(s) }
+func (s labelSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s labelSlice) Less(i, j int) bool { return s[i] < s[j] }
+
+func sortedLabels(s labels.Set) []uint64 {
+	lbls := make(labelSlice, 0, len(s))
+	for label := range s {
+		lbls = append(lbls, label)
+	}
+	sort.Sort(lbls)
+	return lbls
+}
+
+// serializes a set of supervoxels as sorted little-endian uint64.
+func encodeSupervoxels(s labels.Set) []byte {
+	lbls := sortedLabels(s)
+	buf := make([]byte, len(lbls)*8)
+	for i, label := range lbls {
+		binary.LittleEndian.PutUint64(buf[i*8:i*8+8], label)
+	}
+	return buf
+}
+
+func decodeSupervoxels(b []byte) (labels.Set, error) {
+	if len(b)%8 != 0 {
+		return nil, fmt.Errorf("bad supervoxel list serialization of %d bytes", len(b))
+	}
+	n := len(b) / 8
+	s := make(labels.Set, n)
+	for i := 0; i < n; i++ {
+		s[binary.LittleEndian.Uint64(b[i*8:i*8+8])] = struct{}{}
+	}
+	return s, nil
+}
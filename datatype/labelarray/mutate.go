@@ -8,9 +8,11 @@
 package labelarray
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"sort"
+	"sync/atomic"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/datatype/common/downres"
@@ -27,6 +29,8 @@ type sizeChange struct {
 // MergeLabels handles merging of any number of labels throughout the various label data
 // structures.  It assumes that the merges aren't cascading, e.g., there is no attempt
 // to merge label 3 into 4 and also 4 into 5.  The caller should have flattened the merges.
+// If the data instance has MappedLabels set, only the supervoxel -> body mapping is modified
+// and no label blocks are rewritten.
 // TODO: Provide some indication that subset of labels are under evolution, returning
 //   an "unavailable" status or 203 for non-authoritative response.  This might not be
 //   feasible for clustered DVID front-ends due to coordination issues.
@@ -71,12 +75,22 @@ func (d *Data) MergeLabels(v dvid.VersionID, op labels.MergeOp) error {
 		}()
 
 		// Get all the affected blocks in the merge.
-		targetMeta, err := d.getLabelMeta(ctx, labels.NewSet(op.Target), dvid.Bounds{})
+		targetLbls, err := d.expandSupervoxels(ctx, labels.NewSet(op.Target))
+		if err != nil {
+			dvid.Errorf("can't get supervoxels of merge target label %d: %v\n", op.Target, err)
+			return
+		}
+		mergedLbls, err := d.expandSupervoxels(ctx, op.Merged)
+		if err != nil {
+			dvid.Errorf("can't get supervoxels of labels to merge %s: %v\n", op.Merged, err)
+			return
+		}
+		targetMeta, err := d.getLabelMeta(ctx, targetLbls, dvid.Bounds{})
 		if err != nil {
 			dvid.Errorf("can't get block indices of to merge target label %d\n", op.Target)
 			return
 		}
-		mergedMeta, err := d.getLabelMeta(ctx, op.Merged, dvid.Bounds{})
+		mergedMeta, err := d.getLabelMeta(ctx, mergedLbls, dvid.Bounds{})
 		if err != nil {
 			dvid.Errorf("can't get block indices of to merge labels %s\n", op.Merged)
 			return
@@ -88,7 +102,12 @@ func (d *Data) MergeLabels(v dvid.VersionID, op labels.MergeOp) error {
 			TargetVoxels: targetMeta.Voxels,
 			MergedVoxels: mergedMeta.Voxels,
 		}
-		if err := d.processMerge(v, delta); err != nil {
+		if d.MappedLabels {
+			err = d.processMappedMerge(v, delta)
+		} else {
+			err = d.processMerge(v, delta)
+		}
+		if err != nil {
 			dvid.Criticalf("unable to process merge: %v\n", err)
 		}
 		dvid.Infof("processed merge for %q in gofunc\n", d.DataName())
@@ -100,6 +119,7 @@ func (d *Data) MergeLabels(v dvid.VersionID, op labels.MergeOp) error {
 // handle block and label index mods for a merge.
 func (d *Data) processMerge(v dvid.VersionID, delta labels.DeltaMerge) error {
 	timedLog := dvid.NewTimeLog()
+	defer d.invalidateStats(v, append(sortedLabels(delta.Merged), delta.Target)...)
 
 	evt := datastore.SyncEvent{d.DataUUID(), labels.MergeBlockEvent}
 	msg := datastore.SyncMessage{labels.MergeBlockEvent, v, delta}
@@ -190,6 +210,62 @@ func (d *Data) processMerge(v dvid.VersionID, delta labels.DeltaMerge) error {
 // labels.SplitEndEvent occurs at end of split and transmits labels.DeltaSplitEnd struct.
 //
 func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.ReadCloser) (toLabel uint64, err error) {
+	// Read the sparse volume from reader.
+	var split dvid.RLEs
+	split, err = dvid.ReadRLEs(r)
+	if err != nil {
+		return
+	}
+	return d.splitRLEs(v, fromLabel, splitLabel, split)
+}
+
+// splitRLEs splits the voxels given by the RLEs from a label into a given split label or,
+// if the given split label is 0, a new label, which is returned.
+func (d *Data) splitRLEs(v dvid.VersionID, fromLabel, splitLabel uint64, split dvid.RLEs) (toLabel uint64, err error) {
+	// Only do one large mutation at a time, although each request can start many goroutines.
+	server.LargeMutationMutex.Lock()
+	defer server.LargeMutationMutex.Unlock()
+
+	return d.splitRLEsLocked(v, fromLabel, splitLabel, split)
+}
+
+// splitPartitions splits each of the given sets of RLEs from a label into a new label as a
+// single mutation, returning the new labels with 0 for any empty set.  If a split fails,
+// the sets already split are returned to the label.  The caller must hold
+// server.LargeMutationMutex.
+func (d *Data) splitPartitions(v dvid.VersionID, label uint64, partitions []dvid.RLEs) ([]uint64, error) {
+	if err := d.checkSplitSupervoxel(v, label); err != nil {
+		return nil, err
+	}
+	newLabels := make([]uint64, len(partitions))
+	for i, rles := range partitions {
+		if len(rles) == 0 {
+			continue
+		}
+		toLabel, err := d.splitRLEsLocked(v, label, 0, rles)
+		if err == nil {
+			newLabels[i] = toLabel
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if newLabels[j] == 0 {
+				continue
+			}
+			if _, rbErr := d.splitRLEsLocked(v, newLabels[j], label, partitions[j]); rbErr != nil {
+				dvid.Criticalf("unable to return split label %d to label %d, data %q: %v\n", newLabels[j], label, d.DataName(), rbErr)
+			}
+		}
+		return nil, err
+	}
+	return newLabels, nil
+}
+
+// splitRLEsLocked is splitRLEs for callers that hold server.LargeMutationMutex.
+func (d *Data) splitRLEsLocked(v dvid.VersionID, fromLabel, splitLabel uint64, split dvid.RLEs) (toLabel uint64, err error) {
+	if err = d.checkSplitSupervoxel(v, fromLabel); err != nil {
+		return
+	}
+
 	// Create a new label id for this version that will persist to store
 	if splitLabel != 0 {
 		toLabel = splitLabel
@@ -202,10 +278,6 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 		dvid.Debugf("Splitting subset of label %d into new label %d ...\n", fromLabel, toLabel)
 	}
 
-	// Only do one large mutation at a time, although each request can start many goroutines.
-	server.LargeMutationMutex.Lock()
-	defer server.LargeMutationMutex.Unlock()
-
 	evt := datastore.SyncEvent{d.DataUUID(), labels.SplitStartEvent}
 	splitOpStart := labels.DeltaSplitStart{fromLabel, toLabel}
 	splitOpEnd := labels.DeltaSplitEnd{fromLabel, toLabel}
@@ -228,12 +300,6 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 		return
 	}
 
-	// Read the sparse volume from reader.
-	var split dvid.RLEs
-	split, err = dvid.ReadRLEs(r)
-	if err != nil {
-		return
-	}
 	toLabelSize, _ := split.Stats()
 
 	// Partition the split spans into blocks.
@@ -279,6 +345,10 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 // labels.SplitEndEvent occurs at end of split and transmits labels.DeltaSplitEnd struct.
 //
 func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.ReadCloser) (toLabel uint64, err error) {
+	if err = d.checkSplitSupervoxel(v, fromLabel); err != nil {
+		return
+	}
+
 	// Create a new label id for this version that will persist to store
 	if splitLabel != 0 {
 		toLabel = splitLabel
@@ -354,6 +424,39 @@ func (d *Data) SplitCoarseLabels(v dvid.VersionID, fromLabel, splitLabel uint64,
 	return toLabel, nil
 }
 
+// SplitComponents finds the connected components of a label's voxels using 6 or 26 connectivity
+// and splits all but the largest component into new labels, which are returned.  The components
+// are computed and split as one mutation, so either all components are split or none are.
+func (d *Data) SplitComponents(v dvid.VersionID, label uint64, connectivity int) ([]uint64, error) {
+	timedLog := dvid.NewTimeLog()
+
+	server.LargeMutationMutex.Lock()
+	defer server.LargeMutationMutex.Unlock()
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	encoding, err := d.GetLegacyRLE(ctx, label, 0, dvid.Bounds{})
+	if err != nil {
+		return nil, err
+	}
+	rles, err := dvid.ReadRLEs(bytes.NewBuffer(encoding))
+	if err != nil {
+		return nil, err
+	}
+	comps, err := labels.ConnectedComponents(rles, connectivity)
+	if err != nil {
+		return nil, err
+	}
+	if len(comps) < 2 {
+		return []uint64{}, nil
+	}
+	newLabels, err := d.splitPartitions(v, label, comps[1:])
+	if err != nil {
+		return nil, err
+	}
+	timedLog.Infof("Split %d disconnected components from label %d, data %q", len(newLabels), label, d.DataName())
+	return newLabels, nil
+}
+
 func (d *Data) processSplit(v dvid.VersionID, delta labels.DeltaSplit) error {
 	timedLog := dvid.NewTimeLog()
 
@@ -362,6 +465,7 @@ func (d *Data) processSplit(v dvid.VersionID, delta labels.DeltaSplit) error {
 
 	var doneCh chan struct{}
 	var deleteBlks dvid.IZYXSlice
+	var coarseSize uint64
 	if delta.Split == nil {
 		// Coarse Split so block indexing simple because all split blocks are removed from old label.
 		deleteBlks = delta.SortedBlocks
@@ -375,6 +479,7 @@ func (d *Data) processSplit(v dvid.VersionID, delta labels.DeltaSplit) error {
 					NewLabel: delta.NewLabel,
 				},
 				bcoord:     izyx,
+				splitSize:  &coarseSize,
 				downresMut: downresMut,
 			}
 			d.mutateCh[n] <- procMsg{op: op, v: v}
@@ -410,27 +515,6 @@ func (d *Data) processSplit(v dvid.VersionID, delta labels.DeltaSplit) error {
 			}
 			d.mutateCh[n] <- procMsg{op: op, v: v}
 		}
-
-		// Publish change in label sizes.
-		deltaNewSize := labels.DeltaNewSize{
-			Label: delta.NewLabel,
-			Size:  delta.SplitVoxels,
-		}
-		evt := datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
-		msg := datastore.SyncMessage{labels.ChangeSizeEvent, v, deltaNewSize}
-		if err := datastore.NotifySubscribers(evt, msg); err != nil {
-			dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
-		}
-
-		deltaModSize := labels.DeltaModSize{
-			Label:      delta.OldLabel,
-			SizeChange: int64(-delta.SplitVoxels),
-		}
-		evt = datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
-		msg = datastore.SyncMessage{labels.ChangeSizeEvent, v, deltaModSize}
-		if err := datastore.NotifySubscribers(evt, msg); err != nil {
-			dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
-		}
 	}
 
 	// Wait for all blocks to be split then modify label indices and mark end of split op.
@@ -439,14 +523,40 @@ func (d *Data) processSplit(v dvid.VersionID, delta labels.DeltaSplit) error {
 	if doneCh != nil {
 		close(doneCh)
 	}
+
+	// Publish change in label sizes.  For coarse splits, the # of split voxels is only
+	// known after all blocks have been relabeled.
+	splitVoxels := delta.SplitVoxels
+	if delta.Split == nil {
+		splitVoxels = atomic.LoadUint64(&coarseSize)
+	}
+	deltaNewSize := labels.DeltaNewSize{
+		Label: delta.NewLabel,
+		Size:  splitVoxels,
+	}
+	evt := datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
+	msg := datastore.SyncMessage{labels.ChangeSizeEvent, v, deltaNewSize}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
+
+	deltaModSize := labels.DeltaModSize{
+		Label:      delta.OldLabel,
+		SizeChange: int64(-splitVoxels),
+	}
+	evt = datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
+	msg = datastore.SyncMessage{labels.ChangeSizeEvent, v, deltaModSize}
+	if err := datastore.NotifySubscribers(evt, msg); err != nil {
+		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
+	}
 	if err := d.splitIndices(v, delta, deleteBlks); err != nil {
 		return err
 	}
 	timedLog.Debugf("labelarray sync complete for split (%d blocks) of %d -> %d", len(delta.Split), delta.OldLabel, delta.NewLabel)
 
 	// Publish split event
-	evt := datastore.SyncEvent{d.DataUUID(), labels.SplitLabelEvent}
-	msg := datastore.SyncMessage{labels.SplitLabelEvent, v, delta}
+	evt = datastore.SyncEvent{d.DataUUID(), labels.SplitLabelEvent}
+	msg = datastore.SyncMessage{labels.SplitLabelEvent, v, delta}
 	if err := datastore.NotifySubscribers(evt, msg); err != nil {
 		dvid.Errorf("can't notify subscribers for event %v: %v\n", evt, err)
 	}
@@ -589,26 +699,9 @@ func (d *Data) splitBlock(ctx *datastore.VersionedCtx, op splitOp) {
 			dvid.Errorf("can't replace label %d with %d in block %s: %v\n", op.Target, op.NewLabel, op.bcoord, err)
 			return
 		}
-		delta := labels.DeltaNewSize{
-			Label: op.Target,
-			Size:  toLabelSize,
+		if op.splitSize != nil {
+			atomic.AddUint64(op.splitSize, toLabelSize)
 		}
-		evt := datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
-		msg := datastore.SyncMessage{labels.ChangeSizeEvent, ctx.VersionID(), delta}
-		if err := datastore.NotifySubscribers(evt, msg); err != nil {
-			dvid.Criticalf("Unable to notify subscribers to data %q for evt %v\n", d.DataName(), evt)
-		}
-
-		delta2 := labels.DeltaModSize{
-			Label:      op.Target,
-			SizeChange: int64(-toLabelSize),
-		}
-		evt = datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
-		msg = datastore.SyncMessage{labels.ChangeSizeEvent, ctx.VersionID(), delta2}
-		if err := datastore.NotifySubscribers(evt, msg); err != nil {
-			dvid.Criticalf("Unable to notify subscribers to data %q for evt %v\n", d.DataName(), evt)
-		}
-
 	}
 
 	splitpb := labels.PositionedBlock{*splitBlock, op.bcoord}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"reflect"
 	"testing"
@@ -487,6 +488,317 @@ func TestMergeLabels(t *testing.T) {
 	}
 }
 
+func TestMappedMergeLabels(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed volume and data instances
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("MappedLabels", "true")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+
+	expected := createLabelTestVolume(t, uuid, "labels")
+	expected.addBody(body3, 2)
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Test merge of 3 into 2, which should only modify the mapping.
+	testMerge := mergeJSON(`[2, 3]`)
+	testMerge.send(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Make sure label 3 is no longer a body.
+	reqStr := fmt.Sprintf("%snode/%s/labels/sparsevol/%d", server.WebAPIPath, uuid, 3)
+	server.TestBadHTTP(t, "GET", reqStr, nil)
+	reqStr = fmt.Sprintf("%snode/%s/labels/supervoxels/%d", server.WebAPIPath, uuid, 3)
+	server.TestBadHTTP(t, "GET", reqStr, nil)
+
+	// Check the mapping and supervoxels of the merged body.
+	reqStr = fmt.Sprintf("%snode/%s/labels/mapping", server.WebAPIPath, uuid)
+	r := server.TestHTTP(t, "GET", reqStr, bytes.NewBufferString(`[1, 2, 3, 4]`))
+	var mapped []uint64
+	if err := json.Unmarshal(r, &mapped); err != nil {
+		t.Fatalf("Unable to parse mapping response %q: %v\n", string(r), err)
+	}
+	if len(mapped) != 4 || mapped[0] != 1 || mapped[1] != 2 || mapped[2] != 2 || mapped[3] != 4 {
+		t.Errorf("Expected mapping [1, 2, 2, 4], got %v\n", mapped)
+	}
+	reqStr = fmt.Sprintf("%snode/%s/labels/supervoxels/%d", server.WebAPIPath, uuid, 2)
+	r = server.TestHTTP(t, "GET", reqStr, nil)
+	var supervoxels []uint64
+	if err := json.Unmarshal(r, &supervoxels); err != nil {
+		t.Fatalf("Unable to parse supervoxels response %q: %v\n", string(r), err)
+	}
+	if len(supervoxels) != 2 || supervoxels[0] != 2 || supervoxels[1] != 3 {
+		t.Errorf("Expected supervoxels [2, 3] for body 2, got %v\n", supervoxels)
+	}
+
+	// The merged body's sparse volume should include the merged supervoxel.
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/%d", server.WebAPIPath, uuid, 2)
+	encoding := server.TestHTTP(t, "GET", reqStr, nil)
+	merged := body2
+	merged.voxelSpans = make(dvid.Spans, 0, len(body2.voxelSpans)+len(body3.voxelSpans))
+	merged.voxelSpans = append(merged.voxelSpans, body2.voxelSpans...)
+	merged.voxelSpans = append(merged.voxelSpans, body3.voxelSpans...)
+	merged.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+
+	// Volume reads should return mapped labels while supervoxel queries do not.
+	retrieved := newTestVolume(128, 128, 128)
+	retrieved.get(t, uuid, "labels")
+	if err := retrieved.equals(expected); err != nil {
+		t.Errorf("Merged label volume: %v\n", err)
+	}
+	pt := body3.voxelSpans[0]
+	reqStr = fmt.Sprintf("%snode/%s/labels/label/%d_%d_%d?supervoxels=true", server.WebAPIPath, uuid, pt[2], pt[1], pt[0])
+	r = server.TestHTTP(t, "GET", reqStr, nil)
+	jsonVal := make(map[string]uint64)
+	if err := json.Unmarshal(r, &jsonVal); err != nil {
+		t.Fatalf("Unable to parse label response %q: %v\n", string(r), err)
+	}
+	if jsonVal["Label"] != 3 {
+		t.Errorf("Expected supervoxel 3 at %v, got %d\n", pt, jsonVal["Label"])
+	}
+
+	// Can't split a body with more than one supervoxel.
+	span := body3.voxelSpans[0]
+	rles := dvid.RLEs{dvid.NewRLE(dvid.Point3d{span[2], span[1], span[0]}, span[3]-span[2]+1)}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3))  // # of dimensions
+	binary.Write(buf, binary.LittleEndian, byte(0))   // dimension of run (X = 0)
+	buf.WriteByte(byte(0))                            // reserved for later
+	binary.Write(buf, binary.LittleEndian, uint32(0)) // Placeholder for # voxels
+	binary.Write(buf, binary.LittleEndian, uint32(1)) // # spans
+	rleBytes, err := rles.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unable to serialize RLEs: %v\n", err)
+	}
+	buf.Write(rleBytes)
+	reqStr = fmt.Sprintf("%snode/%s/labels/split/2", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", reqStr, buf)
+}
+
+func TestCleaveLabel(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed volume and data instances
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32") // Previous test data was on 32^3 blocks
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Merge 3 into 1 so label 1 has two disconnected pieces.
+	testMerge := mergeJSON(`[1, 3]`)
+	testMerge.send(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Need at least two seed sets.
+	reqStr := fmt.Sprintf("%snode/%s/labels/cleave/1", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"seeds": [[[15, 45, 20]]]}`))
+
+	// Seeds must be within the label.
+	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"seeds": [[[15, 45, 20]], [[100, 100, 100]]]}`))
+
+	// Cleave the two pieces apart and apply it.
+	reqStr = fmt.Sprintf("%snode/%s/labels/cleave/1?apply=true", server.WebAPIPath, uuid)
+	r := server.TestHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"seeds": [[[15, 45, 20]], [[45, 45, 20]]]}`))
+	var resp struct {
+		Label   uint64
+		Cleaves []struct {
+			Voxels    uint64
+			Sparsevol []byte
+		}
+		Labels []uint64
+	}
+	if err := json.Unmarshal(r, &resp); err != nil {
+		t.Fatalf("Unable to parse cleave response %q: %v\n", string(r), err)
+	}
+	if len(resp.Cleaves) != 2 {
+		t.Fatalf("Expected 2 cleaves, got %d\n", len(resp.Cleaves))
+	}
+	if resp.Cleaves[0].Voxels != 20*20*80 || resp.Cleaves[1].Voxels != 20*20*30 {
+		t.Errorf("Expected cleaves of 32000 and 12000 voxels, got %d and %d\n", resp.Cleaves[0].Voxels, resp.Cleaves[1].Voxels)
+	}
+	body3.checkSparseVol(t, resp.Cleaves[1].Sparsevol, dvid.OptionalBounds{})
+	if len(resp.Labels) != 2 || resp.Labels[0] != 1 || resp.Labels[1] != 5 {
+		t.Fatalf("Expected cleave to produce labels [1, 5], got %v\n", resp.Labels)
+	}
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/1", server.WebAPIPath, uuid)
+	encoding := server.TestHTTP(t, "GET", reqStr, nil)
+	body1.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/5", server.WebAPIPath, uuid)
+	encoding = server.TestHTTP(t, "GET", reqStr, nil)
+	body3.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+}
+
+func TestMappedCleaveLabel(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed volume and data instances
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32") // Previous test data was on 32^3 blocks
+	config.Set("MappedLabels", "true")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Merge 3 into 1 so body 1 has two supervoxels.
+	testMerge := mergeJSON(`[1, 3]`)
+	testMerge.send(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// A body with more than one supervoxel can only be cleaved by supervoxel seeds when applied.
+	reqStr := fmt.Sprintf("%snode/%s/labels/cleave/1", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"seeds": [[[15, 45, 20]], [[45, 45, 20]]]}`))
+	reqStr = fmt.Sprintf("%snode/%s/labels/cleave/1?apply=true", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"seeds": [[[15, 45, 20]], [[45, 45, 20]]]}`))
+	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"seeds": [[], [[45, 45, 20]]], "supervoxels": [[1], [3]]}`))
+
+	r := server.TestHTTP(t, "POST", reqStr, bytes.NewBufferString(`{"supervoxels": [[1], [3]]}`))
+	var resp struct {
+		Label   uint64
+		Cleaves []struct {
+			Voxels    uint64
+			Sparsevol []byte
+		}
+		Labels []uint64
+	}
+	if err := json.Unmarshal(r, &resp); err != nil {
+		t.Fatalf("Unable to parse cleave response %q: %v\n", string(r), err)
+	}
+	if len(resp.Cleaves) != 2 {
+		t.Fatalf("Expected 2 cleaves, got %d\n", len(resp.Cleaves))
+	}
+	if resp.Cleaves[0].Voxels != 20*20*80 || resp.Cleaves[1].Voxels != 20*20*30 {
+		t.Errorf("Expected cleaves of 32000 and 12000 voxels, got %d and %d\n", resp.Cleaves[0].Voxels, resp.Cleaves[1].Voxels)
+	}
+	if len(resp.Labels) != 2 || resp.Labels[0] != 1 || resp.Labels[1] != 5 {
+		t.Fatalf("Expected cleave to produce labels [1, 5], got %v\n", resp.Labels)
+	}
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Only the mapping should have changed.
+	reqStr = fmt.Sprintf("%snode/%s/labels/mapping", server.WebAPIPath, uuid)
+	r = server.TestHTTP(t, "GET", reqStr, bytes.NewBufferString(`[1, 3]`))
+	var mapped []uint64
+	if err := json.Unmarshal(r, &mapped); err != nil {
+		t.Fatalf("Unable to parse mapping response %q: %v\n", string(r), err)
+	}
+	if len(mapped) != 2 || mapped[0] != 1 || mapped[1] != 5 {
+		t.Errorf("Expected mapping [1, 5], got %v\n", mapped)
+	}
+	for body, supervoxel := range map[uint64]uint64{1: 1, 5: 3} {
+		reqStr = fmt.Sprintf("%snode/%s/labels/supervoxels/%d", server.WebAPIPath, uuid, body)
+		r = server.TestHTTP(t, "GET", reqStr, nil)
+		var supervoxels []uint64
+		if err := json.Unmarshal(r, &supervoxels); err != nil {
+			t.Fatalf("Unable to parse supervoxels response %q: %v\n", string(r), err)
+		}
+		if len(supervoxels) != 1 || supervoxels[0] != supervoxel {
+			t.Errorf("Expected supervoxels [%d] for body %d, got %v\n", supervoxel, body, supervoxels)
+		}
+	}
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/1", server.WebAPIPath, uuid)
+	encoding := server.TestHTTP(t, "GET", reqStr, nil)
+	body1.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/5", server.WebAPIPath, uuid)
+	encoding = server.TestHTTP(t, "GET", reqStr, nil)
+	body3.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+}
+
+func TestSplitComponents(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed volume and data instances
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32") // Previous test data was on 32^3 blocks
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Merge 3 into 1 so label 1 has two disconnected pieces.
+	testMerge := mergeJSON(`[1, 3]`)
+	testMerge.send(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	reqStr := fmt.Sprintf("%snode/%s/labels/split-components/1?connectivity=18", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "POST", reqStr, nil)
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/split-components/1?connectivity=26", server.WebAPIPath, uuid)
+	r := server.TestHTTP(t, "POST", reqStr, nil)
+	var resp struct {
+		Labels []uint64
+	}
+	if err := json.Unmarshal(r, &resp); err != nil {
+		t.Fatalf("Unable to parse split-components response %q: %v\n", string(r), err)
+	}
+	if len(resp.Labels) != 1 || resp.Labels[0] != 5 {
+		t.Fatalf("Expected split-components to produce new label [5], got %v\n", resp.Labels)
+	}
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// The largest component keeps the original label.
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/1", server.WebAPIPath, uuid)
+	encoding := server.TestHTTP(t, "GET", reqStr, nil)
+	body1.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/sparsevol/5", server.WebAPIPath, uuid)
+	encoding = server.TestHTTP(t, "GET", reqStr, nil)
+	body3.checkSparseVol(t, encoding, dvid.OptionalBounds{})
+
+	// Connected labels have no new components.
+	reqStr = fmt.Sprintf("%snode/%s/labels/split-components/1", server.WebAPIPath, uuid)
+	r = server.TestHTTP(t, "POST", reqStr, nil)
+	if string(r) != `{"labels": []}` {
+		t.Errorf("Expected no new labels for connected label 1, got %s\n", string(r))
+	}
+}
+
 func TestSplitCoarseLabel(t *testing.T) {
 	datastore.OpenTest()
 	defer datastore.CloseTest()
@@ -2743,3 +3055,153 @@ var (
 		body1, body2, body3, body4, bodysplit, body6, body7,
 	}
 )
+
+func TestLabelAdjacency(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed volume and data instances
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Body 2 touches body 1 along x, body 3 along z, and body 4 along z.
+	reqStr := fmt.Sprintf("%snode/%s/labels/adjacency/2", server.WebAPIPath, uuid)
+	r := server.TestHTTP(t, "GET", reqStr, nil)
+	var resp struct {
+		Label     uint64
+		Neighbors []Contact
+	}
+	if err := json.Unmarshal(r, &resp); err != nil {
+		t.Fatalf("Unable to parse adjacency response %q: %v\n", string(r), err)
+	}
+	expected := []Contact{{1, 400}, {3, 400}, {4, 100}}
+	if resp.Label != 2 || len(resp.Neighbors) != len(expected) {
+		t.Fatalf("Expected adjacency of label 2 to be %v, got %s\n", expected, string(r))
+	}
+	for i, contact := range expected {
+		if resp.Neighbors[i] != contact {
+			t.Errorf("Expected neighbor %d of label 2 to be %v, got %v\n", i, contact, resp.Neighbors[i])
+		}
+	}
+
+	// Body 1 only touches body 2.
+	reqStr = fmt.Sprintf("%snode/%s/labels/adjacency/1", server.WebAPIPath, uuid)
+	r = server.TestHTTP(t, "GET", reqStr, nil)
+	if err := json.Unmarshal(r, &resp); err != nil {
+		t.Fatalf("Unable to parse adjacency response %q: %v\n", string(r), err)
+	}
+	if len(resp.Neighbors) != 1 || resp.Neighbors[0] != (Contact{2, 400}) {
+		t.Errorf("Expected label 1 to only touch label 2 with contact 400, got %s\n", string(r))
+	}
+
+	// Use an ROI that excludes the contact between bodies 1 and 2 at x = 29/30.
+	server.CreateTestInstance(t, uuid, "roi", "myroi", dvid.Config{})
+	reqStr = fmt.Sprintf("%snode/%s/myroi/roi", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", reqStr, bytes.NewBufferString("[[1, 1, 1, 2]]"))
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/adjacency?roi=myroi", server.WebAPIPath, uuid)
+	r = server.TestHTTP(t, "GET", reqStr, nil)
+	var edges []Edge
+	if err := json.Unmarshal(r, &edges); err != nil {
+		t.Fatalf("Unable to parse adjacency response %q: %v\n", string(r), err)
+	}
+	expectedEdges := []Edge{{2, 3, 400}, {2, 4, 100}}
+	if len(edges) != len(expectedEdges) {
+		t.Fatalf("Expected ROI adjacency edges %v, got %s\n", expectedEdges, string(r))
+	}
+	for i, edge := range expectedEdges {
+		if edges[i] != edge {
+			t.Errorf("Expected ROI adjacency edge %d to be %v, got %v\n", i, edge, edges[i])
+		}
+	}
+
+	// Need a label or ROI.
+	reqStr = fmt.Sprintf("%snode/%s/labels/adjacency", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", reqStr, nil)
+}
+
+func TestLabelStats(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed volume and data instances
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	reqStr := fmt.Sprintf("%snode/%s/labels/stats/1", server.WebAPIPath, uuid)
+	r := server.TestHTTP(t, "GET", reqStr, nil)
+	var stats LabelStats
+	if err := json.Unmarshal(r, &stats); err != nil {
+		t.Fatalf("Unable to parse stats response %q: %v\n", string(r), err)
+	}
+	if stats.Label != 1 || stats.Voxels != 20*20*80 || stats.Blocks != 3 {
+		t.Errorf("Bad stats for label 1: %s\n", string(r))
+	}
+	if stats.Bounds == nil || stats.Bounds.MinPoint != (dvid.Point3d{10, 40, 10}) || stats.Bounds.MaxPoint != (dvid.Point3d{29, 59, 89}) {
+		t.Errorf("Bad bounds for label 1: %s\n", string(r))
+	}
+	if stats.Centroid == nil {
+		t.Fatalf("Expected centroid for label 1: %s\n", string(r))
+	}
+	for i, c := range [3]float64{19.5, 49.5, 49.5} {
+		if math.Abs(stats.Centroid[i]-c) > 1e-6 {
+			t.Errorf("Bad centroid for label 1: %s\n", string(r))
+		}
+	}
+	expected := Moments{XX: 33.25, YY: 33.25, ZZ: 533.25}
+	if stats.Moments == nil || math.Abs(stats.Moments.XX-expected.XX) > 1e-6 || math.Abs(stats.Moments.YY-expected.YY) > 1e-6 ||
+		math.Abs(stats.Moments.ZZ-expected.ZZ) > 1e-6 || math.Abs(stats.Moments.XY) > 1e-6 || math.Abs(stats.Moments.XZ) > 1e-6 ||
+		math.Abs(stats.Moments.YZ) > 1e-6 {
+		t.Errorf("Expected moments %v for label 1, got %s\n", expected, string(r))
+	}
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/stats/99", server.WebAPIPath, uuid)
+	server.TestBadHTTP(t, "GET", reqStr, nil)
+
+	// Batch request with a missing label.
+	reqStr = fmt.Sprintf("%snode/%s/labels/stats", server.WebAPIPath, uuid)
+	r = server.TestHTTP(t, "POST", reqStr, bytes.NewBufferString("[3, 99]"))
+	var allStats []LabelStats
+	if err := json.Unmarshal(r, &allStats); err != nil {
+		t.Fatalf("Unable to parse stats response %q: %v\n", string(r), err)
+	}
+	if len(allStats) != 2 || allStats[0].Voxels != 20*20*30 || allStats[1].Label != 99 || allStats[1].Voxels != 0 || allStats[1].Bounds != nil {
+		t.Errorf("Bad batch stats for labels 3 and 99: %s\n", string(r))
+	}
+	tooMany, err := json.Marshal(make([]uint64, MaxStatsLabels+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TestBadHTTP(t, "POST", reqStr, bytes.NewBuffer(tooMany))
+
+	// Make sure cached stats are updated after a merge.
+	testMerge := mergeJSON(`[1, 3]`)
+	testMerge.send(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	reqStr = fmt.Sprintf("%snode/%s/labels/stats/1", server.WebAPIPath, uuid)
+	r = server.TestHTTP(t, "GET", reqStr, nil)
+	if err := json.Unmarshal(r, &stats); err != nil {
+		t.Fatalf("Unable to parse stats response %q: %v\n", string(r), err)
+	}
+	if stats.Voxels != 20*20*80+20*20*30 || stats.Bounds.MaxPoint != (dvid.Point3d{59, 59, 89}) {
+		t.Errorf("Bad stats for label 1 after merge: %s\n", string(r))
+	}
+}
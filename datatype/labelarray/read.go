@@ -74,6 +74,7 @@ type getOperation struct {
 	voxels      *Labels
 	blocksInROI map[string]bool
 	mapping     *labels.Mapping
+	mapper      *svMapper
 }
 
 // GetLabels copies labels from the storage engine to Labels, a requested subvolume or 2d image.
@@ -94,6 +95,7 @@ func (d *Data) GetLabels(v dvid.VersionID, scale uint8, vox *Labels, r *imageblk
 
 	iv := dvid.InstanceVersion{d.DataUUID(), v}
 	mapping := labels.LabelMap(iv)
+	mapper := d.newMapper(v)
 
 	wg := new(sync.WaitGroup)
 
@@ -130,9 +132,9 @@ func (d *Data) GetLabels(v dvid.VersionID, scale uint8, vox *Labels, r *imageblk
 					blocksInROI[indexString] = true
 				}
 			}
-			chunkOp = &storage.ChunkOp{&getOperation{vox, blocksInROI, mapping}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{vox, blocksInROI, mapping, mapper}, wg}
 		} else {
-			chunkOp = &storage.ChunkOp{&getOperation{vox, nil, mapping}, wg}
+			chunkOp = &storage.ChunkOp{&getOperation{vox, nil, mapping, mapper}, wg}
 		}
 
 		if !hasbuffer {
@@ -197,6 +199,7 @@ func (d *Data) GetBlocks(v dvid.VersionID, scale uint8, start dvid.ChunkPoint3d,
 
 	iv := dvid.InstanceVersion{d.DataUUID(), v}
 	mapping := labels.LabelMap(iv)
+	mapper := d.newMapper(v)
 
 	keyvalues, err := store.GetRange(ctx, begTKey, endTKey)
 	if err != nil {
@@ -220,6 +223,9 @@ func (d *Data) GetBlocks(v dvid.VersionID, scale uint8, start dvid.ChunkPoint3d,
 		if err = block.UnmarshalBinary(deserialization); err != nil {
 			return nil, fmt.Errorf("Unable to unmarshal binary block: %v\n", err)
 		}
+		if err = mapper.ApplyToBlock(&block); err != nil {
+			return nil, err
+		}
 		labelData, _ := block.MakeLabelVolume()
 		if mapping != nil {
 			n := len(labelData) / 8
@@ -313,6 +319,10 @@ func (d *Data) readChunk(chunk *storage.Chunk) {
 			dvid.Errorf("Unable to unmarshal labels Block compression in %q: %v\n", d.DataName(), err)
 			return
 		}
+		if err := op.mapper.ApplyToBlock(&block); err != nil {
+			dvid.Errorf("Unable to map supervoxels of block in %q: %v\n", d.DataName(), err)
+			return
+		}
 	}
 
 	// Perform the operation.
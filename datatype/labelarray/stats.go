@@ -0,0 +1,261 @@
+/*
+	This file supports computation of label statistics like bounding box, centroid, and second
+	moments directly from label blocks, with results cached per version.
+*/
+
+package labelarray
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// maximum number of label stats cached per version before the version's cache is reset.
+const maxCachedStats = 10000
+
+// MaxStatsLabels is the maximum number of labels whose stats can be requested at once.
+const MaxStatsLabels = 1000
+
+// Moments are the central second moments of a label's voxel coordinates, normalized by
+// the number of voxels, i.e., the covariance of the voxel coordinates.
+type Moments struct {
+	XX float64 `json:"xx"`
+	YY float64 `json:"yy"`
+	ZZ float64 `json:"zz"`
+	XY float64 `json:"xy"`
+	XZ float64 `json:"xz"`
+	YZ float64 `json:"yz"`
+}
+
+// StatsBounds is the exact bounding box of a label's voxels.
+type StatsBounds struct {
+	MinPoint dvid.Point3d `json:"minpoint"`
+	MaxPoint dvid.Point3d `json:"maxpoint"`
+}
+
+// LabelStats gives statistics of a label's voxels.  Only the label and zero voxels
+// are set if the label is not present.
+type LabelStats struct {
+	Label    uint64       `json:"label"`
+	Voxels   uint64       `json:"voxels"`
+	Blocks   int          `json:"blocks"` // number of blocks touched by the label
+	Bounds   *StatsBounds `json:"bounds,omitempty"`
+	Centroid *[3]float64  `json:"centroid,omitempty"`
+	Moments  *Moments     `json:"moments,omitempty"`
+}
+
+// momentSums accumulates coordinate moments, combining the exact sums of each block
+// into running means and co-moments so precision is kept for large labels.
+type momentSums struct {
+	n    float64
+	mean [3]float64
+	c    [6]float64 // co-moments: xx, yy, zz, xy, xz, yz
+}
+
+// pairs of axes for each co-moment.
+var momentAxes = [6][2]int{{0, 0}, {1, 1}, {2, 2}, {0, 1}, {0, 2}, {1, 2}}
+
+// add combines the sums of a block's voxels, where s and ss are the sums of voxel
+// coordinates and their products relative to the block offset.
+func (m *momentSums) add(offset dvid.Point3d, n int64, s [3]int64, ss [6]int64) {
+	nb := float64(n)
+	var meanb [3]float64
+	for i := 0; i < 3; i++ {
+		meanb[i] = float64(offset[i]) + float64(s[i])/nb
+	}
+	total := m.n + nb
+	var delta [3]float64
+	for i := 0; i < 3; i++ {
+		delta[i] = meanb[i] - m.mean[i]
+	}
+	for k, axes := range momentAxes {
+		i, j := axes[0], axes[1]
+		cb := float64(ss[k]) - float64(s[i])*float64(s[j])/nb
+		m.c[k] += cb + delta[i]*delta[j]*m.n*nb/total
+	}
+	for i := 0; i < 3; i++ {
+		m.mean[i] += delta[i] * nb / total
+	}
+	m.n = total
+}
+
+// labelStatsCache holds label stats per version along with a generation number per
+// version that is incremented on any invalidation, so stats computed concurrently with
+// a mutation are not cached.
+type labelStatsCache struct {
+	sync.Mutex
+	gen   map[dvid.VersionID]uint64
+	stats map[dvid.VersionID]map[uint64]*LabelStats
+}
+
+func (c *labelStatsCache) get(v dvid.VersionID, label uint64) (stats *LabelStats, gen uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.stats != nil {
+		stats = c.stats[v][label]
+	}
+	return stats, c.gen[v]
+}
+
+func (c *labelStatsCache) put(v dvid.VersionID, gen uint64, stats *LabelStats) {
+	c.Lock()
+	defer c.Unlock()
+	if c.gen[v] != gen {
+		return
+	}
+	if c.stats == nil {
+		c.stats = make(map[dvid.VersionID]map[uint64]*LabelStats)
+	}
+	vstats, found := c.stats[v]
+	if !found || len(vstats) >= maxCachedStats {
+		vstats = make(map[uint64]*LabelStats)
+		c.stats[v] = vstats
+	}
+	vstats[stats.Label] = stats
+}
+
+func (c *labelStatsCache) invalidate(v dvid.VersionID, lbls ...uint64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.gen == nil {
+		c.gen = make(map[dvid.VersionID]uint64)
+	}
+	c.gen[v]++
+	if vstats, found := c.stats[v]; found {
+		for _, label := range lbls {
+			delete(vstats, label)
+		}
+	}
+}
+
+// invalidates any cached stats for the given labels, which can be supervoxels if the
+// data instance is mapped.
+func (d *Data) invalidateStats(v dvid.VersionID, lbls ...uint64) {
+	if d.MappedLabels {
+		mapper := d.newMapper(v)
+		for _, label := range lbls {
+			body, err := mapper.MapLabel(label)
+			if err != nil {
+				dvid.Errorf("unable to map label %d to invalidate stats for data %q: %v\n", label, d.DataName(), err)
+				continue
+			}
+			if body != label {
+				lbls = append(lbls, body)
+			}
+		}
+	}
+	d.statsCache.invalidate(v, lbls...)
+}
+
+// GetLabelStats returns statistics for a label, which are cached until the label is modified.
+func (d *Data) GetLabelStats(v dvid.VersionID, label uint64) (*LabelStats, error) {
+	stats, gen := d.statsCache.get(v, label)
+	if stats != nil {
+		return stats, nil
+	}
+	stats, err := d.computeLabelStats(v, label)
+	if err != nil {
+		return nil, err
+	}
+	d.statsCache.put(v, gen, stats)
+	return stats, nil
+}
+
+// computes label stats by scanning all blocks in the label index.
+func (d *Data) computeLabelStats(v dvid.VersionID, label uint64) (*LabelStats, error) {
+	timedLog := dvid.NewTimeLog()
+
+	blockSize, ok := d.BlockSize().(dvid.Point3d)
+	if !ok {
+		return nil, fmt.Errorf("can't compute stats because block size for instance %s is not 3d: %v", d.DataName(), d.BlockSize())
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	meta, lbls, err := d.GetMappedLabelMeta(ctx, label, dvid.Bounds{})
+	if err != nil {
+		return nil, err
+	}
+	stats := &LabelStats{Label: label}
+	if meta == nil || len(meta.Blocks) == 0 {
+		return stats, nil
+	}
+
+	var bounds StatsBounds
+	var moments momentSums
+	for _, izyx := range meta.Blocks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		labelData, err := d.GetSupervoxelBlock(v, 0, bcoord)
+		if err != nil {
+			return nil, err
+		}
+		if len(labelData) == 0 {
+			continue
+		}
+		offset := bcoord.MinPoint(blockSize).(dvid.Point3d)
+		var n int64
+		var s [3]int64
+		var ss [6]int64
+		var i int32
+		var pos [3]int64
+		for z := int32(0); z < blockSize[2]; z++ {
+			for y := int32(0); y < blockSize[1]; y++ {
+				for x := int32(0); x < blockSize[0]; x, i = x+1, i+1 {
+					if _, found := lbls[binary.LittleEndian.Uint64(labelData[i*8:i*8+8])]; !found {
+						continue
+					}
+					pt := dvid.Point3d{offset[0] + x, offset[1] + y, offset[2] + z}
+					if stats.Voxels == 0 && n == 0 {
+						bounds.MinPoint, bounds.MaxPoint = pt, pt
+					} else {
+						for a := 0; a < 3; a++ {
+							if pt[a] < bounds.MinPoint[a] {
+								bounds.MinPoint[a] = pt[a]
+							}
+							if pt[a] > bounds.MaxPoint[a] {
+								bounds.MaxPoint[a] = pt[a]
+							}
+						}
+					}
+					pos = [3]int64{int64(x), int64(y), int64(z)}
+					n++
+					for a := 0; a < 3; a++ {
+						s[a] += pos[a]
+					}
+					for k, axes := range momentAxes {
+						ss[k] += pos[axes[0]] * pos[axes[1]]
+					}
+				}
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		moments.add(offset, n, s, ss)
+		stats.Voxels += uint64(n)
+		stats.Blocks++
+	}
+	if stats.Voxels == 0 {
+		return stats, nil
+	}
+
+	centroid := moments.mean
+	stats.Bounds = &bounds
+	stats.Centroid = &centroid
+	stats.Moments = &Moments{
+		XX: moments.c[0] / moments.n,
+		YY: moments.c[1] / moments.n,
+		ZZ: moments.c[2] / moments.n,
+		XY: moments.c[3] / moments.n,
+		XZ: moments.c[4] / moments.n,
+		YZ: moments.c[5] / moments.n,
+	}
+
+	timedLog.Infof("Computed stats for label %d, data %q: %d voxels over %d blocks", label, d.DataName(), stats.Voxels, stats.Blocks)
+	return stats, nil
+}
@@ -65,6 +65,7 @@ type splitOp struct {
 	mutID       uint64
 	bcoord      dvid.IZYXString
 	deleteBlkCh chan dvid.IZYXString
+	splitSize   *uint64 // accumulates # voxels relabeled across blocks in a coarse split.
 	downresMut  *downres.Mutation
 }
 
@@ -0,0 +1,320 @@
+/*
+	This file supports graph algorithms run on the server so clients don't have to retrieve
+	the whole graph: connected components, neighborhoods, shortest paths, and agglomeration.
+*/
+
+package labelgraph
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// vertexIDs sorts vertex ids in increasing order.
+type vertexIDs []dvid.VertexID
+
+func (v vertexIDs) Len() int           { return len(v) }
+func (v vertexIDs) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v vertexIDs) Less(i, j int) bool { return v[i] < v[j] }
+
+// weightedGraph is an in-memory graph of vertex weights and weighted adjacencies.
+type weightedGraph struct {
+	weights   map[dvid.VertexID]float64
+	neighbors map[dvid.VertexID]map[dvid.VertexID]float64
+}
+
+// returns the whole graph visible in the context's version.
+func loadWeightedGraph(ctx *datastore.VersionedCtx, db storage.GraphDB) (*weightedGraph, error) {
+	vertices, err := db.GetVertices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertices: %v\n", err)
+	}
+	edges, err := db.GetEdges(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve edges: %v\n", err)
+	}
+	g := &weightedGraph{
+		weights:   make(map[dvid.VertexID]float64, len(vertices)),
+		neighbors: make(map[dvid.VertexID]map[dvid.VertexID]float64, len(vertices)),
+	}
+	for _, vertex := range vertices {
+		g.weights[vertex.Id] = vertex.Weight
+		g.neighbors[vertex.Id] = make(map[dvid.VertexID]float64)
+	}
+	for _, edge := range edges {
+		id1, id2 := edge.Vertexpair.Vertex1, edge.Vertexpair.Vertex2
+		if g.neighbors[id1] == nil || g.neighbors[id2] == nil {
+			continue // ignore dangling edges
+		}
+		g.neighbors[id1][id2] = edge.Weight
+		g.neighbors[id2][id1] = edge.Weight
+	}
+	return g, nil
+}
+
+// components returns the connected components when only edges with weight above the
+// threshold are used.  Each component's vertices are sorted and components are ordered
+// by their smallest vertex.
+func (g *weightedGraph) components(threshold float64) [][]dvid.VertexID {
+	ids := make(vertexIDs, 0, len(g.weights))
+	for id := range g.weights {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	visited := make(map[dvid.VertexID]struct{}, len(ids))
+	var components [][]dvid.VertexID
+	for _, id := range ids {
+		if _, found := visited[id]; found {
+			continue
+		}
+		visited[id] = struct{}{}
+		component := vertexIDs{id}
+		for toVisit := []dvid.VertexID{id}; len(toVisit) != 0; {
+			cur := toVisit[len(toVisit)-1]
+			toVisit = toVisit[:len(toVisit)-1]
+			for id2, weight := range g.neighbors[cur] {
+				if weight <= threshold {
+					continue
+				}
+				if _, found := visited[id2]; !found {
+					visited[id2] = struct{}{}
+					component = append(component, id2)
+					toVisit = append(toVisit, id2)
+				}
+			}
+		}
+		sort.Sort(component)
+		components = append(components, component)
+	}
+	return components
+}
+
+// returns an empty graph for responses.
+func newLabelGraph() *LabelGraph {
+	return &LabelGraph{
+		Transactions: make([]transactionItem, 0),
+		Vertices:     make([]labelVertex, 0),
+		Edges:        make([]labelEdge, 0),
+	}
+}
+
+// subgraph returns the given vertices with the edges among them that have weight above
+// the threshold.
+func (g *weightedGraph) subgraph(ids []dvid.VertexID, threshold float64) *LabelGraph {
+	labelgraph := newLabelGraph()
+	for _, id := range ids {
+		labelgraph.Vertices = append(labelgraph.Vertices, labelVertex{id, g.weights[id]})
+	}
+	for _, id := range ids {
+		var ids2 vertexIDs
+		for id2, weight := range g.neighbors[id] {
+			if id < id2 && weight > threshold {
+				ids2 = append(ids2, id2)
+			}
+		}
+		sort.Sort(ids2)
+		for _, id2 := range ids2 {
+			labelgraph.Edges = append(labelgraph.Edges, labelEdge{id, id2, g.neighbors[id][id2]})
+		}
+	}
+	return labelgraph
+}
+
+// Components returns the connected components of the graph using only edges with weight
+// above the threshold.  Each component is returned as a graph of its vertices and those
+// edges.  Like subgraph requests, this is a bulk operation that sets a data-wide lock.
+func (d *Data) Components(ctx *datastore.VersionedCtx, threshold float64) ([]*LabelGraph, error) {
+	db, err := d.GetGraphDB()
+	if err != nil {
+		return nil, err
+	}
+	if !d.setBusy() {
+		return nil, fmt.Errorf("Server busy with bulk transaction")
+	}
+	defer d.setNotBusy()
+
+	g, err := loadWeightedGraph(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	components := g.components(threshold)
+	graphs := make([]*LabelGraph, len(components))
+	for i, component := range components {
+		graphs[i] = g.subgraph(component, threshold)
+	}
+	return graphs, nil
+}
+
+// Neighborhood returns the vertices within the given number of hops of a vertex and all
+// edges among them.
+func (d *Data) Neighborhood(ctx *datastore.VersionedCtx, id dvid.VertexID, hops int) (*LabelGraph, error) {
+	db, err := d.GetGraphDB()
+	if err != nil {
+		return nil, err
+	}
+	if hops < 0 {
+		return nil, fmt.Errorf("Number of hops must be non-negative, got %d", hops)
+	}
+	vertex, err := db.GetVertex(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", id, err)
+	}
+	found := map[dvid.VertexID]dvid.GraphVertex{id: vertex}
+	frontier := []dvid.GraphVertex{vertex}
+	for hop := 0; hop < hops && len(frontier) != 0; hop++ {
+		var next []dvid.GraphVertex
+		for _, vertex := range frontier {
+			for _, id2 := range vertex.Vertices {
+				if _, visited := found[id2]; visited {
+					continue
+				}
+				vertex2, err := db.GetVertex(ctx, id2)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", id2, err)
+				}
+				found[id2] = vertex2
+				next = append(next, vertex2)
+			}
+		}
+		frontier = next
+	}
+
+	ids := make(vertexIDs, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+	labelgraph := newLabelGraph()
+	for _, id := range ids {
+		labelgraph.Vertices = append(labelgraph.Vertices, labelVertex{id, found[id].Weight})
+	}
+	for _, id := range ids {
+		var ids2 vertexIDs
+		for _, id2 := range found[id].Vertices {
+			if _, inside := found[id2]; inside && id < id2 {
+				ids2 = append(ids2, id2)
+			}
+		}
+		sort.Sort(ids2)
+		for _, id2 := range ids2 {
+			edge, err := db.GetEdge(ctx, id, id2)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve edge %d-%d: %v\n", id, id2, err)
+			}
+			labelgraph.Edges = append(labelgraph.Edges, labelEdge{id, id2, edge.Weight})
+		}
+	}
+	return labelgraph, nil
+}
+
+// ShortestPath returns the path with the fewest edges between two vertices.  The path's
+// vertices are returned in order from the first vertex followed by the path's edges.
+func (d *Data) ShortestPath(ctx *datastore.VersionedCtx, id1, id2 dvid.VertexID) (*LabelGraph, error) {
+	db, err := d.GetGraphDB()
+	if err != nil {
+		return nil, err
+	}
+	vertex, err := db.GetVertex(ctx, id1)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", id1, err)
+	}
+	if _, err := db.GetVertex(ctx, id2); err != nil {
+		return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", id2, err)
+	}
+
+	// breadth-first search from the first vertex, visiting neighbors in id order
+	previous := map[dvid.VertexID]dvid.VertexID{id1: id1}
+	weights := map[dvid.VertexID]float64{id1: vertex.Weight}
+	for toVisit := []dvid.GraphVertex{vertex}; len(toVisit) != 0; toVisit = toVisit[1:] {
+		cur := toVisit[0]
+		if cur.Id == id2 {
+			break
+		}
+		neighbors := make(vertexIDs, len(cur.Vertices))
+		copy(neighbors, cur.Vertices)
+		sort.Sort(neighbors)
+		for _, next := range neighbors {
+			if _, visited := previous[next]; visited {
+				continue
+			}
+			vertex, err := db.GetVertex(ctx, next)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to retrieve vertex %d: %v\n", next, err)
+			}
+			previous[next] = cur.Id
+			weights[next] = vertex.Weight
+			toVisit = append(toVisit, vertex)
+		}
+	}
+	if _, found := previous[id2]; !found {
+		return nil, fmt.Errorf("No path between vertices %d and %d", id1, id2)
+	}
+
+	var path []dvid.VertexID
+	for id := id2; id != id1; id = previous[id] {
+		path = append(path, id)
+	}
+	path = append(path, id1)
+	labelgraph := newLabelGraph()
+	for i := len(path) - 1; i >= 0; i-- {
+		labelgraph.Vertices = append(labelgraph.Vertices, labelVertex{path[i], weights[path[i]]})
+		if i == 0 {
+			break
+		}
+		edge, err := db.GetEdge(ctx, path[i], path[i-1])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to retrieve edge %d-%d: %v\n", path[i], path[i-1], err)
+		}
+		labelgraph.Edges = append(labelgraph.Edges, labelEdge{path[i], path[i-1], edge.Weight})
+	}
+	return labelgraph, nil
+}
+
+// Agglomerate merges all vertices connected by edges with weight above the threshold.
+// Each connected component is merged into its vertex with the largest weight, or the
+// smallest id of those, and the merge is added to the merge history so it can be undone.
+// The merges are returned in the format of merge requests with the kept vertex last.
+func (d *Data) Agglomerate(ctx *datastore.VersionedCtx, threshold float64) ([]*LabelGraph, error) {
+	db, err := d.GetGraphDB()
+	if err != nil {
+		return nil, err
+	}
+	if !d.setBusy() {
+		return nil, fmt.Errorf("Server busy with bulk transaction")
+	}
+	defer d.setNotBusy()
+
+	g, err := loadWeightedGraph(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	merges := []*LabelGraph{}
+	for _, component := range g.components(threshold) {
+		if len(component) < 2 {
+			continue
+		}
+		keep := 0
+		for i, id := range component {
+			if g.weights[id] > g.weights[component[keep]] {
+				keep = i
+			}
+		}
+		merge := newLabelGraph()
+		for i, id := range component {
+			if i != keep {
+				merge.Vertices = append(merge.Vertices, labelVertex{Id: id})
+			}
+		}
+		merge.Vertices = append(merge.Vertices, labelVertex{Id: component[keep]})
+		if err := d.handleMerge(ctx, db, nil, merge, true); err != nil {
+			return merges, err
+		}
+		merges = append(merges, merge)
+	}
+	return merges, nil
+}
@@ -0,0 +1,284 @@
+/*
+	This file supports a persistent, versioned history of merges so they can be undone.
+*/
+
+package labelgraph
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// keyMergeHistory is the TKey class for merge records.  It is above the key classes
+// used by the graph store for vertices, edges, and their properties.
+const keyMergeHistory storage.TKeyClass = 200
+
+// keyMergeHead is the TKey class for the sequence number of the latest merge record, so
+// the latest record can be found without scanning the history.
+const keyMergeHead storage.TKeyClass = 201
+
+// NewMergeTKey returns a TKey for the merge record with the given sequence number.
+func NewMergeTKey(seq uint64) storage.TKey {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return storage.NewTKey(keyMergeHistory, buf)
+}
+
+// DecodeMergeTKey returns the sequence number of a merge record TKey.
+func DecodeMergeTKey(tk storage.TKey) (uint64, error) {
+	ibytes, err := tk.ClassBytes(keyMergeHistory)
+	if err != nil {
+		return 0, err
+	}
+	if len(ibytes) != 8 {
+		return 0, fmt.Errorf("bad merge record key of %d bytes", len(ibytes))
+	}
+	return binary.BigEndian.Uint64(ibytes), nil
+}
+
+func mergeHeadTKey() storage.TKey {
+	return storage.NewTKey(keyMergeHead, nil)
+}
+
+// mergeRecord holds the merged vertices and all their edges as they were before a merge,
+// which is enough to undo the merge.
+type mergeRecord struct {
+	Keep     dvid.VertexID // vertex that the other vertices were merged into
+	Vertices []labelVertex
+	Edges    []labelEdge
+
+	// Partial is true while the graph may be between its state before and after the
+	// merge, e.g., if a merge or undo failed partway, so an undo restores the graph
+	// without checking for later changes.
+	Partial bool
+}
+
+// returns the sequence number of the latest merge record visible in the context's version
+// and whether there is one.
+func lastMergeSeq(ctx *datastore.VersionedCtx, db storage.OrderedKeyValueDB) (uint64, bool, error) {
+	data, err := db.Get(ctx, mergeHeadTKey())
+	if err != nil || data == nil {
+		return 0, false, err
+	}
+	if len(data) != 8 {
+		return 0, false, fmt.Errorf("bad merge history head of %d bytes", len(data))
+	}
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+// returns a batch for merge history changes.
+func (d *Data) newHistoryBatch(ctx *datastore.VersionedCtx, db storage.OrderedKeyValueDB) (storage.Batch, error) {
+	batcher, ok := db.(storage.KeyValueBatcher)
+	if !ok {
+		return nil, fmt.Errorf("Unable to change merge history of labelgraph %q: store can't do batching!", d.DataName())
+	}
+	return batcher.NewBatch(ctx), nil
+}
+
+// putMerge stores a merge record with the given sequence number as the latest record.
+func (d *Data) putMerge(ctx *datastore.VersionedCtx, seq uint64, record *mergeRecord) error {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	serialization, err := dvid.SerializeData(data, d.Compression(), d.Checksum())
+	if err != nil {
+		return fmt.Errorf("Unable to serialize merge record: %v\n", err)
+	}
+	batch, err := d.newHistoryBatch(ctx, db)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, 8)
+	binary.BigEndian.PutUint64(head, seq)
+	batch.Put(NewMergeTKey(seq), serialization)
+	batch.Put(mergeHeadTKey(), head)
+	return batch.Commit()
+}
+
+// saveMerge appends a merge record to the merge history of the context's version and
+// returns its sequence number.
+func (d *Data) saveMerge(ctx *datastore.VersionedCtx, record *mergeRecord) (uint64, error) {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return 0, err
+	}
+	seq, found, err := lastMergeSeq(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		seq++
+	}
+	return seq, d.putMerge(ctx, seq, record)
+}
+
+// deleteMerge removes the latest merge record, which has the given sequence number.
+func (d *Data) deleteMerge(ctx *datastore.VersionedCtx, seq uint64) error {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return err
+	}
+	batch, err := d.newHistoryBatch(ctx, db)
+	if err != nil {
+		return err
+	}
+	batch.Delete(NewMergeTKey(seq))
+	if seq == 0 {
+		batch.Delete(mergeHeadTKey())
+	} else {
+		head := make([]byte, 8)
+		binary.BigEndian.PutUint64(head, seq-1)
+		batch.Put(mergeHeadTKey(), head)
+	}
+	return batch.Commit()
+}
+
+// clearMergeHistory deletes the merge history visible in the context's version.
+func (d *Data) clearMergeHistory(ctx *datastore.VersionedCtx) error {
+	db, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return err
+	}
+	tkeys, err := db.KeysInRange(ctx, storage.MinTKey(keyMergeHistory), storage.MaxTKey(keyMergeHistory))
+	if err != nil {
+		return err
+	}
+	if len(tkeys) == 0 {
+		return nil
+	}
+	batch, err := d.newHistoryBatch(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, tk := range tkeys {
+		batch.Delete(tk)
+	}
+	batch.Delete(mergeHeadTKey())
+	return batch.Commit()
+}
+
+// restoreMerge returns the vertices and edges of a merge record to their state before the
+// merge.  It can be repeated, so it also rolls back a merge that failed partway.
+func restoreMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, record *mergeRecord) error {
+	// remove the edges of the kept vertex and any merged vertices that remain
+	keepvertex, err := db.GetVertex(ctx, record.Keep)
+	if err != nil {
+		return fmt.Errorf("Cannot restore merge: vertex %d no longer exists", record.Keep)
+	}
+	for _, id2 := range keepvertex.Vertices {
+		if err := db.RemoveEdge(ctx, record.Keep, id2); err != nil {
+			return fmt.Errorf("Failed to remove edge %d-%d: %v\n", record.Keep, id2, err)
+		}
+	}
+	for _, vertex := range record.Vertices {
+		if vertex.Id == record.Keep {
+			continue
+		}
+		if _, err := db.GetVertex(ctx, vertex.Id); err == nil {
+			if err := db.RemoveVertex(ctx, vertex.Id); err != nil {
+				return fmt.Errorf("Failed to remove vertex %d: %v\n", vertex.Id, err)
+			}
+		}
+	}
+
+	// restore the vertices and edges
+	for _, vertex := range record.Vertices {
+		if vertex.Id == record.Keep {
+			err = db.SetVertexWeight(ctx, vertex.Id, vertex.Weight)
+		} else {
+			err = db.AddVertex(ctx, vertex.Id, vertex.Weight)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to restore vertex %d: %v\n", vertex.Id, err)
+		}
+	}
+	for _, edge := range record.Edges {
+		if err := db.AddEdge(ctx, edge.Id1, edge.Id2, edge.Weight); err != nil {
+			return fmt.Errorf("Failed to restore edge %d-%d: %v\n", edge.Id1, edge.Id2, err)
+		}
+	}
+	return nil
+}
+
+// undoMerge restores the vertices and edges of the latest merge in the context's version
+// to their state before the merge and removes the merge from the history.  Changes made
+// to these vertices and edges after the merge are lost.  The record is marked partial
+// before any changes, so a failed undo can be retried.
+func (d *Data) undoMerge(ctx *datastore.VersionedCtx, db storage.GraphDB) error {
+	kvdb, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return err
+	}
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	seq, found, err := lastMergeSeq(ctx, kvdb)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("No merge to undo in labelgraph %q", d.DataName())
+	}
+	data, err := kvdb.Get(ctx, NewMergeTKey(seq))
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("Merge record %d of labelgraph %q is missing", seq, d.DataName())
+	}
+	value, _, err := dvid.DeserializeData(data, true)
+	if err != nil {
+		return fmt.Errorf("Unable to deserialize merge record: %v\n", err)
+	}
+	var record mergeRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return fmt.Errorf("Unable to decode merge record: %v\n", err)
+	}
+
+	// Make sure a completed merge can be undone before changing anything.
+	if !record.Partial {
+		merged := make(map[dvid.VertexID]struct{}, len(record.Vertices))
+		for _, vertex := range record.Vertices {
+			merged[vertex.Id] = struct{}{}
+			if vertex.Id == record.Keep {
+				continue
+			}
+			if _, err := db.GetVertex(ctx, vertex.Id); err == nil {
+				return fmt.Errorf("Cannot undo merge: merged vertex %d has been added again", vertex.Id)
+			}
+		}
+		if _, err := db.GetVertex(ctx, record.Keep); err != nil {
+			return fmt.Errorf("Cannot undo merge: vertex %d no longer exists", record.Keep)
+		}
+		for _, edge := range record.Edges {
+			for _, id := range []dvid.VertexID{edge.Id1, edge.Id2} {
+				if _, found := merged[id]; found {
+					continue
+				}
+				if _, err := db.GetVertex(ctx, id); err != nil {
+					return fmt.Errorf("Cannot undo merge: vertex %d no longer exists", id)
+				}
+			}
+		}
+		record.Partial = true
+		if err := d.putMerge(ctx, seq, &record); err != nil {
+			return err
+		}
+	}
+
+	if err := restoreMerge(ctx, db, &record); err != nil {
+		return err
+	}
+	return d.deleteMerge(ctx, seq)
+}
@@ -8,7 +8,9 @@ import (
 	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -88,11 +90,35 @@ const graphSchema = `
 
 const HelpMessage = `
 API for 'labelgraph' datatype (github.com/janelia-flyem/dvid/datatype/labelgraph)
-History and therefore UNDO is not yet supported.  I will need to access the KeyValue
-setter and maintain a transaction log (how to avoid collisions with graph keyspacee
-if using the same key value store -- avoid conflict by doing a dynamic check?!).
 =============================================================================
 
+The graph is stored in the ordered key-value store assigned to the data instance and
+is versioned, so each version node sees the graph of its ancestors plus any changes
+made in that node.  The merge history used for UNDO is also stored per version.
+
+Command-line:
+
+$ dvid repo <UUID> new labelgraph <data name> <settings...>
+
+	Adds newly named labelgraph data to repo with specified UUID.
+
+	Example:
+
+	$ dvid repo 3f8c new labelgraph agglo RecomputeSplitEdges=true
+
+    Arguments:
+
+    UUID                 Hexidecimal string with enough characters to uniquely identify a version node.
+    data name            Name of data to create, e.g., "agglo"
+    settings             Configuration settings in "key=value" format separated by spaces.
+
+    Configuration Settings (case-insensitive keys)
+
+    RecomputeSplitEdges  If "true", the edges of labels split in a synced labelarray are
+                         recomputed using the contact area as the edge weight.  Default "false".
+
+    ------------------
+
 HTTP API (Level 2 REST):
 
 Note that browsers support HTTP PUT and DELETE via javascript but only GET/POST are
@@ -121,6 +147,31 @@ POST <api URL>/node/<UUID>/<data name>/info
     data name     Name of voxels data.
 
 
+POST <api URL>/node/<UUID>/<data name>/sync?<options>
+
+    Establishes a labelarray for which the graph is kept in sync, where vertex ids are labels.
+    Expects JSON to be POSTed with the following format:
+
+    { "sync": "segmentation" }
+
+	To delete syncs, pass an empty string of names with query string "replace=true":
+
+	{ "sync": "" }
+
+    When labels are merged in the labelarray, the vertices of the merged labels are merged
+    into the vertex of the target label as in the merge request, summing weights.  The merge
+    is not saved in the merge history.  Labels without vertices are ignored.
+
+    When a label with a vertex is split, a vertex with zero weight is created for the new
+    label.  If the RecomputeSplitEdges setting is true, the edges of both labels are replaced
+    by edges to the vertices of contacting labels, with the contact area as the edge weight.
+
+    Query-string Options:
+
+    replace    Set to "true" if you want passed syncs to replace and not be appended to current syncs.
+			   Default operation is false.
+
+
 GET  <api URL>/node/<UUID>/<data name>/subgraph
 POST  <api URL>/node/<UUID>/<data name>/subgraph
 DELETE  <api URL>/node/<UUID>/<data name>/subgraph
@@ -132,7 +183,7 @@ DELETE  <api URL>/node/<UUID>/<data name>/subgraph
     many performance issues.  GET (retrieve subgraph),
     POST (add subgraph -- does not change existing graph connections), DELETE (delete whole
     graph or subgraph indicated by a list of nodes or list of edges).  POSTs or DELETEs using
-    this URI will erase all merge history.
+    this URI will erase all merge history for the version node.
 
     Example: 
 
@@ -161,7 +212,8 @@ POST  <api URL>/node/<UUID>/<data name>/merge/[nohistory]
 
     Merge a list of vertices as specified by a vertex array called "vertices".
     The last vertex is the vertex ID that will be used.  If nohistory is specified,
-    the history of this transaction is not saved.  Edge and Vertex weights will be summed.
+    the history of this transaction is not saved and it cannot be undone.  The merge
+    history of each version node is persisted.  Edge and Vertex weights will be summed.
     If different weights are desired, all edge and vertex weights should be specified as done
     when posting a subgraph.
 
@@ -173,7 +225,11 @@ POST  <api URL>/node/<UUID>/<data name>/merge/[nohistory]
 
 POST  <api URL>/node/<UUID>/<data name>/undomerge
 
-    Undoes last merge.  An error message is returned if no UNDO occurs.
+    Undoes last merge in the version node, restoring the merged vertices and their edges
+    with the weights they had before the merge.  Properties of the merged vertices are not
+    restored, and changes to the merged vertex and its edges after the merge are lost.
+    Merges in ancestor nodes can be undone in a child node without affecting the ancestors.
+    An error message is returned if no UNDO occurs.
 
     Arguments:
 
@@ -196,6 +252,76 @@ GET  <api URL>/node/<UUID>/<data name>/neighbors/<vertex>
     vertex        ID of vertex
 
 
+GET  <api URL>/node/<UUID>/<data name>/components[?threshold=<weight>]
+
+    Retrieves the connected components of the graph when only edges with a weight above
+    the threshold are used.  Vertices without such edges are components by themselves.
+    Like subgraph requests, this sets a data-wide lock.
+
+    The "Content-type" of the HTTP response is "application/json" as an array of graphs,
+    one per component, each with its vertices and the edges above the threshold.
+    Components are ordered by their smallest vertex id.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add/retrieve.
+
+    Query-string Options:
+
+    threshold     Edges must have weight above this value.  Default 0.
+
+
+GET  <api URL>/node/<UUID>/<data name>/neighborhood/<vertex>[?hops=<k>]
+
+    Retrieves the vertices within k hops of the given vertex and all edges among them
+    as a node list and edge list in JSON.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add/retrieve.
+    vertex        ID of vertex
+
+    Query-string Options:
+
+    hops          Maximum number of edges from the given vertex.  Default 1.
+
+
+GET  <api URL>/node/<UUID>/<data name>/shortestpath/<vertex1>/<vertex2>
+
+    Retrieves the path with the fewest edges from vertex1 to vertex2 as a node list and
+    edge list in JSON.  The vertices are listed in order along the path starting with
+    vertex1.  An error is returned if the vertices are not connected.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add/retrieve.
+    vertex1       ID of the starting vertex
+    vertex2       ID of the ending vertex
+
+
+POST  <api URL>/node/<UUID>/<data name>/agglomerate?threshold=<weight>
+
+    Merges all vertices connected by edges with a weight above the threshold.  Each
+    connected component of these edges is merged into its vertex with the largest weight
+    (the smallest id if tied), summing weights as in the merge request.  Each merge is
+    saved in the merge history and can be undone with undomerge.  This sets a data-wide lock.
+
+    The "Content-type" of the HTTP response is "application/json" as an array of the
+    merges done, each in the format of a merge request with the kept vertex last.
+
+    Arguments:
+
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of data to add/retrieve.
+
+    Query-string Options:
+
+    threshold     Required.  Edges must have weight above this value to be merged.
+
+
 POST  <api URL>/node/<UUID>/<data name>/weight
 
     Updates the weight associated with the provided vertices and edges.  Requests
@@ -290,7 +416,6 @@ TODO:
 * Handle tranactions across multiple DVID clients
 * Consider transaction/lock handling at a lower-level (Neo4j solutions?); atomicity of commands?
 
-* Implement transaction history as keyvalue array and support undo command (allow users to flush transaction history and perform mergers without transactions)
 * Implement better atomicity at storage level to prevent weirdness (all writes should be in a batch -- most currently are)
 `
 
@@ -530,7 +655,7 @@ func NewType() *Type {
 			BulkIniter: false,
 			BulkWriter: false,
 			Batcher:    true,
-			GraphDB:    true,
+			GraphDB:    false,
 		},
 	}
 	return dtype
@@ -544,7 +669,11 @@ func (dtype *Type) NewDataService(uuid dvid.UUID, id dvid.InstanceID, name dvid.
 	if err != nil {
 		return nil, err
 	}
-	return &Data{Data: basedata}, nil
+	data := &Data{Data: basedata}
+	if err := data.setByConfig(c); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
 // Help returns help mesage for datatype
@@ -552,27 +681,60 @@ func (dtype *Type) Help() string {
 	return fmt.Sprintf(HelpMessage)
 }
 
+// Properties are additional properties for labelgraph data beyond those in standard datastore.Data.
+type Properties struct {
+	// RecomputeSplitEdges is true if the edges of labels split in a synced labelarray are
+	// recomputed with weights equal to the contact area of the labels.
+	RecomputeSplitEdges bool
+}
+
+// setByConfig sets the properties from a configuration.
+func (p *Properties) setByConfig(c dvid.Config) error {
+	recompute, found, err := c.GetBool("RecomputeSplitEdges")
+	if err != nil {
+		return err
+	}
+	if found {
+		p.RecomputeSplitEdges = recompute
+	}
+	return nil
+}
+
 // Data embeds the datastore's Data and extends it with transaction properties
 // (default values are okay after deserializing).
 type Data struct {
 	*datastore.Data
-	transaction_log *transactionLog
-	busy            bool
-	datawide_mutex  sync.Mutex
+	Properties
+
+	// Keep track of sync operations that could be updating the data.
+	datastore.Updater
+
+	transaction_logs map[dvid.VersionID]*transactionLog // transaction logs for each version
+	busy             bool
+	datawide_mutex   sync.Mutex
+
+	// guards the one-time migration of graph data from the global graph store.
+	migrateMu     sync.Mutex
+	graphMigrated bool
+
+	syncCh   chan datastore.SyncMessage
+	syncDone chan *sync.WaitGroup
 }
 
 func (d *Data) Equals(d2 *Data) bool {
 	if !d.Data.Equals(d2.Data) {
 		return false
 	}
-	return true
+	return d.Properties == d2.Properties
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Base *datastore.Data
+		Base     *datastore.Data
+		Extended Properties
 	}{
 		d.Data,
+		d.Properties,
 	})
 }
 
@@ -582,6 +744,10 @@ func (d *Data) GobDecode(b []byte) error {
 	if err := dec.Decode(&(d.Data)); err != nil {
 		return err
 	}
+	// labelgraph data saved before properties were added has no encoded properties.
+	if err := dec.Decode(&(d.Properties)); err != nil && err != io.EOF {
+		return fmt.Errorf("decoding labelgraph %q properties: %v", d.DataName(), err)
+	}
 	return nil
 }
 
@@ -591,6 +757,9 @@ func (d *Data) GobEncode() ([]byte, error) {
 	if err := enc.Encode(d.Data); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(d.Properties); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -599,13 +768,114 @@ func (d *Data) Help() string {
 	return fmt.Sprintf(HelpMessage)
 }
 
-// initializeLog ensures that the transaction_log has been created
-func (d *Data) initializeLog() {
+// GetGraphDB returns a graph store that uses the ordered key-value store assigned to
+// this data instance.  Graph data stored by earlier versions of DVID in the global graph
+// store is migrated on first use.
+func (d *Data) GetGraphDB() (storage.GraphDB, error) {
+	kvdb, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.migrateGraphStore(kvdb); err != nil {
+		return nil, err
+	}
+	return storage.NewGraphStore(kvdb)
+}
+
+// errStopRange stops a raw range query early.
+var errStopRange = errors.New("stop range query")
+
+// calls f on each key-value pair of all versions of the data instance in a store,
+// stopping at the first error returned by f.
+func (d *Data) processRawRange(db storage.OrderedKeyValueDB, keysOnly bool, f func(*storage.KeyValue) error) error {
+	minKey, maxKey := storage.NewDataContext(d, 0).KeyRange()
+	ch := make(chan *storage.KeyValue, 1000)
+	cancel := make(chan struct{}, 1)
+	var fErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for kv := range ch {
+			if kv == nil || kv.K == nil || fErr != nil {
+				continue
+			}
+			if fErr = f(kv); fErr != nil {
+				cancel <- struct{}{}
+			}
+		}
+	}()
+	queryErr := db.RawRangeQuery(minKey, maxKey, keysOnly, ch, cancel)
+	close(ch)
+	wg.Wait()
+	if fErr != nil {
+		return fErr
+	}
+	return queryErr
+}
+
+// migrateGraphStore copies the data instance's graph from the global graph store used by
+// earlier versions of DVID if that store differs from the instance's store and the
+// instance's store has no data for this instance.  The copied data is then deleted from
+// the global graph store.
+func (d *Data) migrateGraphStore(kvdb storage.OrderedKeyValueDB) error {
+	d.migrateMu.Lock()
+	defer d.migrateMu.Unlock()
+	if d.graphMigrated {
+		return nil
+	}
+	graphdb, err := storage.GraphStore()
+	if err != nil {
+		return err
+	}
+	oldGraph, ok := graphdb.(*storage.GraphKeyValueDB)
+	if !ok || oldGraph.OrderedKeyValueDB == kvdb {
+		d.graphMigrated = true
+		return nil
+	}
+	oldKV := oldGraph.OrderedKeyValueDB
+
+	var hasData bool
+	err = d.processRawRange(kvdb, true, func(kv *storage.KeyValue) error {
+		hasData = true
+		return errStopRange
+	})
+	if err != nil && err != errStopRange {
+		return err
+	}
+	if !hasData {
+		var numCopied int
+		err = d.processRawRange(oldKV, false, func(kv *storage.KeyValue) error {
+			numCopied++
+			return kvdb.RawPut(kv.K, kv.V)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to migrate labelgraph %q from global graph store: %v", d.DataName(), err)
+		}
+		if numCopied != 0 {
+			if err := oldKV.DeleteAll(storage.NewDataContext(d, 0), true); err != nil {
+				return fmt.Errorf("unable to delete labelgraph %q from global graph store after migration: %v", d.DataName(), err)
+			}
+			dvid.Infof("Migrated %d key-value pairs of labelgraph %q from global graph store\n", numCopied, d.DataName())
+		}
+	}
+	d.graphMigrated = true
+	return nil
+}
+
+// getLog returns the transaction log for a version, creating it if necessary
+func (d *Data) getLog(v dvid.VersionID) *transactionLog {
 	d.datawide_mutex.Lock()
 	defer d.datawide_mutex.Unlock()
-	if d.transaction_log == nil {
-		d.transaction_log = NewTransactionLog()
+	if d.transaction_logs == nil {
+		d.transaction_logs = make(map[dvid.VersionID]*transactionLog)
+	}
+	log, found := d.transaction_logs[v]
+	if !found {
+		log = NewTransactionLog()
+		d.transaction_logs[v] = log
 	}
+	return log
 }
 
 // setBusy checks if data is busy with a large transaction (currently small transactions do not
@@ -720,8 +990,50 @@ func (d *Data) handleSubgraphBulk(ctx *datastore.VersionedCtx, db storage.GraphD
 	} else {
 		err = fmt.Errorf("Does not support PUT")
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	// the merge history can't be undone over a changed graph
+	if method == "post" || method == "delete" {
+		if err = d.clearMergeHistory(ctx); err != nil {
+			return fmt.Errorf("Failed to clear merge history: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// SetEdgeWeights sets the weights of the given edges, creating any missing vertices with
+// zero weight.  Like a subgraph POST, this is a bulk operation that sets a data-wide lock.
+func (d *Data) SetEdgeWeights(v dvid.VersionID, weights map[dvid.VertexPairID]float64) error {
+	db, err := d.GetGraphDB()
+	if err != nil {
+		return err
+	}
+	if !d.setBusy() {
+		return fmt.Errorf("Server busy with bulk transaction")
+	}
+	defer d.setNotBusy()
+
+	ctx := datastore.NewVersionedCtx(d, v)
+	added := make(map[dvid.VertexID]struct{})
+	for pair, weight := range weights {
+		for _, id := range []dvid.VertexID{pair.Vertex1, pair.Vertex2} {
+			if _, found := added[id]; found {
+				continue
+			}
+			if _, err := db.GetVertex(ctx, id); err != nil {
+				if err := db.AddVertex(ctx, id, 0); err != nil {
+					return fmt.Errorf("Failed to add vertex: %v\n", err)
+				}
+			}
+			added[id] = struct{}{}
+		}
+		if err := db.AddEdge(ctx, pair.Vertex1, pair.Vertex2, weight); err != nil {
+			return fmt.Errorf("Failed to add edge: %v\n", err)
+		}
+	}
+	return nil
 }
 
 func (d *Data) extractOpenVertices(labelgraph *LabelGraph) []transactionItem {
@@ -755,8 +1067,9 @@ func (d *Data) extractOpenVertices(labelgraph *LabelGraph) []transactionItem {
 func (d *Data) handleWeightUpdate(ctx *datastore.VersionedCtx, db storage.GraphDB, w http.ResponseWriter, labelgraph *LabelGraph) error {
 
 	// collect all vertices that need to be locked and wrap in transaction ("read only")
+	transaction_log := d.getLog(ctx.VersionID())
 	open_vertices := d.extractOpenVertices(labelgraph)
-	transaction_group, err := d.transaction_log.createTransactionGroup(open_vertices, true)
+	transaction_group, err := transaction_log.createTransactionGroup(open_vertices, true)
 
 	if err != nil {
 		transaction_group.closeTransaction()
@@ -843,7 +1156,7 @@ func (d *Data) handleWeightUpdate(ctx *datastore.VersionedCtx, db storage.GraphD
 			// close transaction, create new transaction from leftovers in labelgraph
 			transaction_group.closeTransaction()
 			open_vertices = d.extractOpenVertices(labelgraph)
-			transaction_group, _ = d.transaction_log.createTransactionGroup(open_vertices, true)
+			transaction_group, _ = transaction_log.createTransactionGroup(open_vertices, true)
 		}
 	}
 
@@ -851,14 +1164,23 @@ func (d *Data) handleWeightUpdate(ctx *datastore.VersionedCtx, db storage.GraphD
 	return nil
 }
 
-// handleMerge merges a list of vertices onto the final vertex in the Vertices list
-func (d *Data) handleMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, w http.ResponseWriter, labelgraph *LabelGraph) error {
+// handleMerge merges a list of vertices onto the final vertex in the Vertices list.  If
+// saveHistory is true, the merge is added to the version's merge history so it can be undone.
+func (d *Data) handleMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, w http.ResponseWriter, labelgraph *LabelGraph, saveHistory bool) error {
 
 	numverts := len(labelgraph.Vertices)
 	if numverts < 2 {
 		return fmt.Errorf("Must specify at least two vertices for merging")
 	}
 
+	// merges and their history must not interleave with other merges or undos
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	record := &mergeRecord{Keep: labelgraph.Vertices[numverts-1].Id}
+	oldedges := make(map[dvid.VertexPairID]float64)
+
 	overlapweights := make(map[dvid.VertexID]float64)
 	vertweight := float64(0)
 	var keepvertex dvid.GraphVertex
@@ -873,6 +1195,7 @@ func (d *Data) handleMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, w ht
 		}
 		allverts[vert.Id] = struct{}{}
 		vertweight += vert.Weight
+		record.Vertices = append(record.Vertices, labelVertex{vert.Id, vert.Weight})
 
 		if i == (numverts - 1) {
 			keepvertex = vert
@@ -883,6 +1206,7 @@ func (d *Data) handleMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, w ht
 					return fmt.Errorf("Failed to retrieve edge %d-%d: %v\n", vertex.Id, vert2, err)
 				}
 				overlapweights[vert2] += edge.Weight
+				oldedges[edge.Vertexpair] = edge.Weight
 			}
 		}
 	}
@@ -895,6 +1219,10 @@ func (d *Data) handleMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, w ht
 		}
 		overlapweights[vert2] += edge.Weight
 		keepverts[vert2] = struct{}{}
+		oldedges[edge.Vertexpair] = edge.Weight
+	}
+	for pair, weight := range oldedges {
+		record.Edges = append(record.Edges, labelEdge{pair.Vertex1, pair.Vertex2, weight})
 	}
 
 	// use specified weights even if marked as 0
@@ -918,6 +1246,38 @@ func (d *Data) handleMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, w ht
 		vertweight = labelgraph.Vertices[numverts-1].Weight
 	}
 
+	// save the history record before changing the graph, marked partial until the merge
+	// is complete, so a merge that fails partway can be undone.
+	var seq uint64
+	if saveHistory {
+		record.Partial = true
+		var err error
+		if seq, err = d.saveMerge(ctx, record); err != nil {
+			return fmt.Errorf("Failed to save merge history: %v\n", err)
+		}
+	}
+	if err := applyMerge(ctx, db, labelgraph, keepvertex, allverts, keepverts, overlapweights, vertweight); err != nil {
+		if rerr := restoreMerge(ctx, db, record); rerr != nil {
+			dvid.Criticalf("Unable to roll back failed merge onto vertex %d of labelgraph %q: %v\n", keepvertex.Id, d.DataName(), rerr)
+		} else if saveHistory {
+			if derr := d.deleteMerge(ctx, seq); derr != nil {
+				dvid.Errorf("Unable to delete merge record after rollback in labelgraph %q: %v\n", d.DataName(), derr)
+			}
+		}
+		return err
+	}
+	if saveHistory {
+		record.Partial = false
+		if err := d.putMerge(ctx, seq, record); err != nil {
+			return fmt.Errorf("Failed to save merge history: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// applyMerge changes the graph for a merge of the given vertices onto the kept vertex.
+func applyMerge(ctx *datastore.VersionedCtx, db storage.GraphDB, labelgraph *LabelGraph, keepvertex dvid.GraphVertex, allverts, keepverts map[dvid.VertexID]struct{}, overlapweights map[dvid.VertexID]float64, vertweight float64) error {
+	numverts := len(labelgraph.Vertices)
 	for id2, newweight := range overlapweights {
 		if _, ok := allverts[id2]; !ok {
 			// only examine edges where the node is not internal
@@ -1021,7 +1381,7 @@ func (d *Data) handlePropertyTransaction(ctx *datastore.VersionedCtx, db storage
 	data, err := ioutil.ReadAll(r.Body)
 
 	// only allow 1000 vertices to be locked
-	transactions, start, err := d.transaction_log.createTransactionGroupBinary(data, readonly)
+	transactions, start, err := d.getLog(ctx.VersionID()).createTransactionGroupBinary(data, readonly)
 	defer transactions.closeTransaction()
 	if err != nil {
 		return fmt.Errorf("Failed to create property transaction: %v", err)
@@ -1290,6 +1650,34 @@ func (d *Data) ExtractGraph(r *http.Request, disableSchema bool) (*LabelGraph, e
 	return labelgraph, err
 }
 
+// getThreshold returns the edge weight threshold from the query string, which defaults
+// to 0 unless it is required.
+func getThreshold(r *http.Request, required bool) (float64, error) {
+	thresholdStr := r.URL.Query().Get("threshold")
+	if thresholdStr == "" {
+		if required {
+			return 0, fmt.Errorf("threshold must be specified in query string")
+		}
+		return 0, nil
+	}
+	threshold, err := strconv.ParseFloat(thresholdStr, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad threshold %q: %v", thresholdStr, err)
+	}
+	return threshold, nil
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Could not serialize graph")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(m)
+	return err
+}
+
 // --- DataService interface ---
 
 // DoRPC acts as a switchboard for RPC commands -- not supported
@@ -1304,15 +1692,12 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 	// ----------- printing out each one.
 	// timedLog := dvid.NewTimeLog()
 
-	db, err := storage.GraphStore()
+	db, err := d.GetGraphDB()
 	if err != nil {
 		server.BadRequest(w, r, err)
 		return
 	}
 
-	// make sure transaction log is created
-	d.initializeLog()
-
 	// Break URL request into arguments
 	url := r.URL.Path[len(server.WebAPIPath):]
 	parts := strings.Split(url, "/")
@@ -1340,6 +1725,16 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, string(jsonBytes))
+	case "sync":
+		if method != "post" {
+			server.BadRequest(w, r, "Only POST allowed to sync endpoint")
+			return
+		}
+		replace := r.URL.Query().Get("replace") == "true"
+		if err := datastore.SetSyncByJSON(d, uuid, replace, r.Body); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
 	case "subgraph":
 		// disable json schema validation (will speedup POST command)
 		queryStrings := r.URL.Query()
@@ -1378,11 +1773,108 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			server.BadRequest(w, r, err)
 			return
 		}
-		err = d.handleMerge(ctx, db, w, labelgraph)
+		saveHistory := len(parts) < 5 || parts[4] != "nohistory"
+		err = d.handleMerge(ctx, db, w, labelgraph, saveHistory)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+	case "components":
+		if method != "get" {
+			server.BadRequest(w, r, "Only supports GETs")
+			return
+		}
+		threshold, err := getThreshold(r, false)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		components, err := d.Components(ctx, threshold)
 		if err != nil {
 			server.BadRequest(w, r, err)
 			return
 		}
+		if err := writeJSON(w, components); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+	case "neighborhood":
+		if method != "get" {
+			server.BadRequest(w, r, "Only supports GETs")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "Vertex number not provided")
+			return
+		}
+		id, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, "Bad vertex number %q", parts[4])
+			return
+		}
+		hops := 1
+		if hopsStr := r.URL.Query().Get("hops"); hopsStr != "" {
+			if hops, err = strconv.Atoi(hopsStr); err != nil {
+				server.BadRequest(w, r, "Bad number of hops %q", hopsStr)
+				return
+			}
+		}
+		labelgraph, err := d.Neighborhood(ctx, dvid.VertexID(id), hops)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if err := writeJSON(w, labelgraph); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+	case "shortestpath":
+		if method != "get" {
+			server.BadRequest(w, r, "Only supports GETs")
+			return
+		}
+		if len(parts) < 6 {
+			server.BadRequest(w, r, "Two vertex numbers must be provided")
+			return
+		}
+		id1, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, "Bad vertex number %q", parts[4])
+			return
+		}
+		id2, err := strconv.ParseUint(parts[5], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, "Bad vertex number %q", parts[5])
+			return
+		}
+		labelgraph, err := d.ShortestPath(ctx, dvid.VertexID(id1), dvid.VertexID(id2))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if err := writeJSON(w, labelgraph); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+	case "agglomerate":
+		if method != "post" {
+			server.BadRequest(w, r, "Only supports POSTs")
+			return
+		}
+		threshold, err := getThreshold(r, true)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		merges, err := d.Agglomerate(ctx, threshold)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if err := writeJSON(w, merges); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
 	case "weight":
 		if method != "post" {
 			server.BadRequest(w, r, "Only supports POSTs")
@@ -1411,9 +1903,14 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			return
 		}
 	case "undomerge":
-		// not supported until transaction history is supported
-		server.BadRequest(w, r, "undomerge not yet implemented")
-		return
+		if method != "post" {
+			server.BadRequest(w, r, "Only supports POSTs")
+			return
+		}
+		if err := d.undoMerge(ctx, db); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
 	default:
 		server.BadAPIRequest(w, r, d)
 		return
@@ -2,6 +2,7 @@ package labelgraph
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -174,3 +175,326 @@ func TestLabelgraphPostAndDelete(t *testing.T) {
 		t.Errorf("Bad ROI after ROI delete.  Should be %s got: %s\n", expectedResp, string(returnedData))
 	}
 }
+
+// returns the graph in a version node
+func getSubgraph(t *testing.T, uuid dvid.UUID, name dvid.InstanceName) LabelGraph {
+	subgraphRequest := fmt.Sprintf("%snode/%s/%s/subgraph", server.WebAPIPath, uuid, name)
+	graph, err := loadGraphJSON(server.TestHTTP(t, "GET", subgraphRequest, nil))
+	if err != nil {
+		t.Fatalf("Error on getting back JSON from subgraph GET: %v\n", err)
+	}
+	return graph
+}
+
+// check merges and their undo across versions and restarts
+func TestLabelgraphMergeUndo(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "labelgraph", "lg", dvid.Config{})
+
+	original := LabelGraph{
+		Transactions: []transactionItem{},
+		Vertices:     []labelVertex{{1, 1}, {2, 2}, {3, 3}, {4, 4}},
+		Edges:        []labelEdge{{1, 2, 5}, {1, 3, 8}, {2, 3, 6}, {3, 4, 7}},
+	}
+	jsonBytes, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("Can't encode graph into JSON: %v\n", err)
+	}
+	subgraphRequest := fmt.Sprintf("%snode/%s/lg/subgraph", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", subgraphRequest, bytes.NewReader(jsonBytes))
+
+	// merge in a child version
+	if err := datastore.Commit(uuid, "graph loaded", nil); err != nil {
+		t.Fatalf("Unable to commit node %s: %v\n", uuid, err)
+	}
+	uuid2, err := datastore.NewVersion(uuid, "merges", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create child of node %s: %v\n", uuid, err)
+	}
+	mergeJSON := `{"Vertices": [{"Id": 1}, {"Id": 2}], "Edges": []}`
+	mergeRequest := fmt.Sprintf("%snode/%s/lg/merge", server.WebAPIPath, uuid2)
+	server.TestHTTP(t, "POST", mergeRequest, bytes.NewBufferString(mergeJSON))
+
+	merged := LabelGraph{
+		Transactions: []transactionItem{},
+		Vertices:     []labelVertex{{2, 3}, {3, 3}, {4, 4}},
+		Edges:        []labelEdge{{2, 3, 14}, {3, 4, 7}},
+	}
+	if graph := getSubgraph(t, uuid2, "lg"); !reflect.DeepEqual(graph, merged) {
+		t.Errorf("Bad graph after merge.  Expected %v, got %v\n", merged, graph)
+	}
+	if graph := getSubgraph(t, uuid, "lg"); !reflect.DeepEqual(graph, original) {
+		t.Errorf("Merge in child changed parent graph.  Expected %v, got %v\n", original, graph)
+	}
+
+	// merge history should persist across restarts
+	datastore.CloseReopenTest()
+
+	undoRequest := fmt.Sprintf("%snode/%s/lg/undomerge", server.WebAPIPath, uuid2)
+	server.TestHTTP(t, "POST", undoRequest, nil)
+	if graph := getSubgraph(t, uuid2, "lg"); !reflect.DeepEqual(graph, original) {
+		t.Errorf("Bad graph after undo.  Expected %v, got %v\n", original, graph)
+	}
+	server.TestBadHTTP(t, "POST", undoRequest, nil)
+
+	// merges without history can't be undone
+	mergeRequest = fmt.Sprintf("%snode/%s/lg/merge/nohistory", server.WebAPIPath, uuid2)
+	server.TestHTTP(t, "POST", mergeRequest, bytes.NewBufferString(mergeJSON))
+	if graph := getSubgraph(t, uuid2, "lg"); !reflect.DeepEqual(graph, merged) {
+		t.Errorf("Bad graph after merge.  Expected %v, got %v\n", merged, graph)
+	}
+	server.TestBadHTTP(t, "POST", undoRequest, nil)
+
+	// a merge left partway done has a partial record that undo rolls back
+	mergeRequest = fmt.Sprintf("%snode/%s/lg/merge", server.WebAPIPath, uuid2)
+	server.TestHTTP(t, "POST", mergeRequest, bytes.NewBufferString(`{"Vertices": [{"Id": 3}, {"Id": 4}], "Edges": []}`))
+	dataservice, err := datastore.GetDataByUUIDName(uuid2, "lg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := dataservice.(*Data)
+	v, err := datastore.VersionFromUUID(uuid2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	db, err := d.GetGraphDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvdb, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddVertex(ctx, 3, 3); err != nil {
+		t.Fatal(err)
+	}
+	seq, found, err := lastMergeSeq(ctx, kvdb)
+	if err != nil || !found {
+		t.Fatalf("Expected merge record, found %t: %v\n", found, err)
+	}
+	data, err := kvdb.Get(ctx, NewMergeTKey(seq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _, err := dvid.DeserializeData(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record mergeRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		t.Fatal(err)
+	}
+	record.Partial = true
+	if err := d.putMerge(ctx, seq, &record); err != nil {
+		t.Fatal(err)
+	}
+	server.TestHTTP(t, "POST", undoRequest, nil)
+	if graph := getSubgraph(t, uuid2, "lg"); !reflect.DeepEqual(graph, merged) {
+		t.Errorf("Bad graph after undo of partial merge.  Expected %v, got %v\n", merged, graph)
+	}
+	server.TestBadHTTP(t, "POST", undoRequest, nil)
+}
+
+// check graph algorithm endpoints
+func TestLabelgraphAlgorithms(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	server.CreateTestInstance(t, uuid, "labelgraph", "lg", dvid.Config{})
+
+	original := LabelGraph{
+		Transactions: []transactionItem{},
+		Vertices:     []labelVertex{{1, 1}, {2, 5}, {3, 2}, {4, 1}, {5, 1}, {6, 1}},
+		Edges:        []labelEdge{{1, 2, 0.9}, {2, 3, 0.8}, {3, 4, 0.2}, {4, 5, 0.7}},
+	}
+	jsonBytes, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("Can't encode graph into JSON: %v\n", err)
+	}
+	apiStr := fmt.Sprintf("%snode/%s/lg/", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", apiStr+"subgraph", bytes.NewReader(jsonBytes))
+
+	getGraphs := func(req string) []LabelGraph {
+		var graphs []LabelGraph
+		if err := json.Unmarshal(server.TestHTTP(t, "GET", req, nil), &graphs); err != nil {
+			t.Fatalf("Bad JSON returned from %s: %v\n", req, err)
+		}
+		return graphs
+	}
+	getGraph := func(req string) LabelGraph {
+		graph, err := loadGraphJSON(server.TestHTTP(t, "GET", req, nil))
+		if err != nil {
+			t.Fatalf("Bad JSON returned from %s: %v\n", req, err)
+		}
+		return graph
+	}
+	none := []transactionItem{}
+
+	components := getGraphs(apiStr + "components?threshold=0.5")
+	expectedComponents := []LabelGraph{
+		{none, []labelVertex{{1, 1}, {2, 5}, {3, 2}}, []labelEdge{{1, 2, 0.9}, {2, 3, 0.8}}},
+		{none, []labelVertex{{4, 1}, {5, 1}}, []labelEdge{{4, 5, 0.7}}},
+		{none, []labelVertex{{6, 1}}, []labelEdge{}},
+	}
+	if !reflect.DeepEqual(components, expectedComponents) {
+		t.Errorf("Bad components.  Expected %v, got %v\n", expectedComponents, components)
+	}
+	if components = getGraphs(apiStr + "components"); len(components) != 2 {
+		t.Errorf("Expected 2 components with threshold 0, got %v\n", components)
+	}
+
+	neighborhood := getGraph(apiStr + "neighborhood/1")
+	expected := LabelGraph{none, []labelVertex{{1, 1}, {2, 5}}, []labelEdge{{1, 2, 0.9}}}
+	if !reflect.DeepEqual(neighborhood, expected) {
+		t.Errorf("Bad neighborhood.  Expected %v, got %v\n", expected, neighborhood)
+	}
+	neighborhood = getGraph(apiStr + "neighborhood/1?hops=2")
+	expected = LabelGraph{none, []labelVertex{{1, 1}, {2, 5}, {3, 2}}, []labelEdge{{1, 2, 0.9}, {2, 3, 0.8}}}
+	if !reflect.DeepEqual(neighborhood, expected) {
+		t.Errorf("Bad 2-hop neighborhood.  Expected %v, got %v\n", expected, neighborhood)
+	}
+
+	path := getGraph(apiStr + "shortestpath/5/1")
+	expected = LabelGraph{
+		none,
+		[]labelVertex{{5, 1}, {4, 1}, {3, 2}, {2, 5}, {1, 1}},
+		[]labelEdge{{5, 4, 0.7}, {4, 3, 0.2}, {3, 2, 0.8}, {2, 1, 0.9}},
+	}
+	if !reflect.DeepEqual(path, expected) {
+		t.Errorf("Bad shortest path.  Expected %v, got %v\n", expected, path)
+	}
+	server.TestBadHTTP(t, "GET", apiStr+"shortestpath/1/6", nil)
+
+	// agglomerate and then undo each merge
+	server.TestBadHTTP(t, "POST", apiStr+"agglomerate", nil)
+	var merges []LabelGraph
+	if err := json.Unmarshal(server.TestHTTP(t, "POST", apiStr+"agglomerate?threshold=0.5", nil), &merges); err != nil {
+		t.Fatalf("Bad JSON returned from agglomerate: %v\n", err)
+	}
+	expectedMerges := []LabelGraph{
+		{none, []labelVertex{{1, 0}, {3, 0}, {2, 0}}, []labelEdge{}},
+		{none, []labelVertex{{5, 0}, {4, 0}}, []labelEdge{}},
+	}
+	if !reflect.DeepEqual(merges, expectedMerges) {
+		t.Errorf("Bad agglomeration merges.  Expected %v, got %v\n", expectedMerges, merges)
+	}
+	agglomerated := LabelGraph{none, []labelVertex{{2, 8}, {4, 2}, {6, 1}}, []labelEdge{{2, 4, 0.2}}}
+	if graph := getSubgraph(t, uuid, "lg"); !reflect.DeepEqual(graph, agglomerated) {
+		t.Errorf("Bad graph after agglomeration.  Expected %v, got %v\n", agglomerated, graph)
+	}
+	server.TestHTTP(t, "POST", apiStr+"undomerge", nil)
+	server.TestHTTP(t, "POST", apiStr+"undomerge", nil)
+	if graph := getSubgraph(t, uuid, "lg"); !reflect.DeepEqual(graph, original) {
+		t.Errorf("Bad graph after undoing agglomeration.  Expected %v, got %v\n", original, graph)
+	}
+}
+
+// check that a synced labelgraph follows merges and splits of a labelarray
+func TestLabelgraphSync(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	config.Clear()
+	config.Set("RecomputeSplitEdges", "true")
+	server.CreateTestInstance(t, uuid, "labelgraph", "lg", config)
+
+	apiStr := fmt.Sprintf("%snode/%s/", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", apiStr+"lg/sync", bytes.NewBufferString(`{"sync": "labels"}`))
+
+	// labels 1, 2, and 3 are slabs along x
+	nx, ny, nz := 64, 32, 32
+	labelvol := make([]byte, nx*ny*nz*8)
+	for z := 0; z < nz; z++ {
+		for y := 0; y < ny; y++ {
+			for x := 0; x < nx; x++ {
+				label := uint64(1)
+				if x >= 48 {
+					label = 3
+				} else if x >= 32 {
+					label = 2
+				}
+				i := ((z*ny+y)*nx + x) * 8
+				binary.LittleEndian.PutUint64(labelvol[i:i+8], label)
+			}
+		}
+	}
+	server.TestHTTP(t, "POST", apiStr+"labels/raw/0_1_2/64_32_32/0_0_0", bytes.NewBuffer(labelvol))
+
+	graph := LabelGraph{
+		Vertices: []labelVertex{{1, 10}, {2, 20}, {3, 30}},
+		Edges:    []labelEdge{{1, 2, 5}, {2, 3, 6}},
+	}
+	jsonBytes, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatalf("Can't encode graph into JSON: %v\n", err)
+	}
+	server.TestHTTP(t, "POST", apiStr+"lg/subgraph", bytes.NewReader(jsonBytes))
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// merging labels should merge vertices
+	server.TestHTTP(t, "POST", apiStr+"labels/merge", bytes.NewBufferString("[1, 2]"))
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "lg"); err != nil {
+		t.Fatalf("Error blocking on sync of labelgraph: %v\n", err)
+	}
+	none := []transactionItem{}
+	expected := LabelGraph{none, []labelVertex{{1, 30}, {3, 30}}, []labelEdge{{1, 3, 6}}}
+	if graph := getSubgraph(t, uuid, "lg"); !reflect.DeepEqual(graph, expected) {
+		t.Errorf("Bad graph after merge sync.  Expected %v, got %v\n", expected, graph)
+	}
+
+	// splitting x < 16 from label 1 should add a vertex and recompute edges from contacts
+	rles := make(dvid.RLEs, 0, ny*nz)
+	for z := 0; z < nz; z++ {
+		for y := 0; y < ny; y++ {
+			rles = append(rles, dvid.NewRLE(dvid.Point3d{0, int32(y), int32(z)}, 16))
+		}
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3))          // # of dimensions
+	binary.Write(buf, binary.LittleEndian, byte(0))           // dimension of run (X = 0)
+	buf.WriteByte(byte(0))                                    // reserved for later
+	binary.Write(buf, binary.LittleEndian, uint32(0))         // Placeholder for # voxels
+	binary.Write(buf, binary.LittleEndian, uint32(len(rles))) // Placeholder for # spans
+	rleBytes, err := rles.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unable to serialize RLEs: %v\n", err)
+	}
+	buf.Write(rleBytes)
+	r := server.TestHTTP(t, "POST", apiStr+"labels/split/1", buf)
+	var split struct {
+		Label uint64 `json:"label"`
+	}
+	if err := json.Unmarshal(r, &split); err != nil {
+		t.Fatalf("Bad split response %q: %v\n", string(r), err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "lg"); err != nil {
+		t.Fatalf("Error blocking on sync of labelgraph: %v\n", err)
+	}
+	newID := dvid.VertexID(split.Label)
+	expected = LabelGraph{
+		none,
+		[]labelVertex{{1, 30}, {3, 30}, {newID, 0}},
+		[]labelEdge{{1, 3, 1024}, {1, newID, 1024}},
+	}
+	if graph := getSubgraph(t, uuid, "lg"); !reflect.DeepEqual(graph, expected) {
+		t.Errorf("Bad graph after split sync.  Expected %v, got %v\n", expected, graph)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+	This file supports keeping a labelgraph in sync with merges and splits of a labelarray.
+*/
+
+package labelgraph
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
+	"github.com/janelia-flyem/dvid/datatype/labelarray"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// Number of change messages we can buffer before blocking on sync channel.
+const syncBufferSize = 100
+
+// InitDataHandlers launches goroutines to handle each labelgraph instance's syncs.
+func (d *Data) InitDataHandlers() error {
+	if d.syncCh != nil || d.syncDone != nil {
+		return nil
+	}
+	d.syncCh = make(chan datastore.SyncMessage, syncBufferSize)
+	d.syncDone = make(chan *sync.WaitGroup)
+
+	// Launch handlers of sync events.
+	dvid.Infof("Launching sync event handler for data %q...\n", d.DataName())
+	go d.processEvents()
+	return nil
+}
+
+// Shutdown terminates blocks until syncs are done then terminates background goroutines processing data.
+func (d *Data) Shutdown(wg *sync.WaitGroup) {
+	if d.syncDone != nil {
+		dwg := new(sync.WaitGroup)
+		dwg.Add(1)
+		d.syncDone <- dwg
+		dwg.Wait() // Block until we are done.
+	}
+	wg.Done()
+}
+
+// GetSyncSubs implements the datastore.Syncer interface.  Returns a list of subscriptions
+// to the sync data instance that will notify the receiver.
+func (d *Data) GetSyncSubs(synced dvid.Data) (datastore.SyncSubs, error) {
+	if synced.TypeName() != "labelarray" {
+		return nil, fmt.Errorf("Unable to sync %s with %s since datatype %q is not supported.", d.DataName(), synced.DataName(), synced.TypeName())
+	}
+	if d.syncCh == nil {
+		if err := d.InitDataHandlers(); err != nil {
+			return nil, fmt.Errorf("unable to initialize handlers for data %q: %v\n", d.DataName(), err)
+		}
+	}
+	subs := datastore.SyncSubs{
+		{
+			Event:  datastore.SyncEvent{synced.DataUUID(), labels.MergeEndEvent},
+			Notify: d.DataUUID(),
+			Ch:     d.syncCh,
+		},
+		{
+			Event:  datastore.SyncEvent{synced.DataUUID(), labels.SplitEndEvent},
+			Notify: d.DataUUID(),
+			Ch:     d.syncCh,
+		},
+	}
+	return subs, nil
+}
+
+// GetSyncedLabelarray returns the labelarray this labelgraph is synced to or nil if
+// there is none.
+func (d *Data) GetSyncedLabelarray() *labelarray.Data {
+	for dataUUID := range d.SyncedData() {
+		source, err := labelarray.GetByDataUUID(dataUUID)
+		if err == nil {
+			return source
+		}
+		dvid.Errorf("Got error accessing synced labelarray %s: %v\n", dataUUID, err)
+	}
+	return nil
+}
+
+// Processes each merge or split of the synced labelarray as we get it.
+func (d *Data) processEvents() {
+	var stop bool
+	var wg *sync.WaitGroup
+	for {
+		select {
+		case wg = <-d.syncDone:
+			queued := len(d.syncCh)
+			if queued > 0 {
+				dvid.Infof("Received shutdown signal for %q sync events (%d in queue)\n", d.DataName(), queued)
+				stop = true
+			} else {
+				dvid.Infof("Shutting down sync event handler for instance %q...\n", d.DataName())
+				wg.Done()
+				return
+			}
+		case msg := <-d.syncCh:
+			d.StartUpdate()
+			ctx := datastore.NewVersionedCtx(d, msg.Version)
+			var err error
+			switch delta := msg.Delta.(type) {
+			case labels.DeltaMergeEnd:
+				err = d.syncMerge(ctx, delta.MergeOp)
+			case labels.DeltaSplitEnd:
+				err = d.syncSplit(ctx, delta)
+			default:
+				err = fmt.Errorf("unexpected delta: %v", msg)
+			}
+			if err != nil {
+				dvid.Errorf("labelgraph %q unable to sync %s event: %v\n", d.DataName(), msg.Event, err)
+			}
+			d.StopUpdate()
+
+			if stop && len(d.syncCh) == 0 {
+				dvid.Infof("Shutting down sync even handler for instance %q after draining sync events.\n", d.DataName())
+				wg.Done()
+				return
+			}
+		}
+	}
+}
+
+// syncMerge merges the vertices of the merged labels into the target label's vertex,
+// summing weights.  Labels without vertices are ignored, and a vertex is created for the
+// target if necessary.  Synced merges are not added to the merge history since undoing
+// them would leave the graph out of sync with the labels.
+func (d *Data) syncMerge(ctx *datastore.VersionedCtx, op labels.MergeOp) error {
+	db, err := d.GetGraphDB()
+	if err != nil {
+		return err
+	}
+	merged := make(vertexIDs, 0, len(op.Merged))
+	for label := range op.Merged {
+		_, err := db.GetVertex(ctx, dvid.VertexID(label))
+		if err == nil {
+			merged = append(merged, dvid.VertexID(label))
+		} else if err != storage.ErrVertexNotFound {
+			return fmt.Errorf("Failed to retrieve vertex %d: %v\n", label, err)
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	sort.Sort(merged)
+	target := dvid.VertexID(op.Target)
+	if _, err := db.GetVertex(ctx, target); err != nil {
+		if err != storage.ErrVertexNotFound {
+			return fmt.Errorf("Failed to retrieve vertex %d: %v\n", target, err)
+		}
+		if err := db.AddVertex(ctx, target, 0); err != nil {
+			return fmt.Errorf("Failed to add vertex %d: %v\n", target, err)
+		}
+	}
+	merge := newLabelGraph()
+	for _, id := range merged {
+		merge.Vertices = append(merge.Vertices, labelVertex{Id: id})
+	}
+	merge.Vertices = append(merge.Vertices, labelVertex{Id: target})
+	return d.handleMerge(ctx, db, nil, merge, false)
+}
+
+// syncSplit adds a vertex with zero weight for the new label of a split if the split label
+// has a vertex.  If RecomputeSplitEdges is set, the edges of both labels are replaced by
+// edges to neighboring labels with vertices, weighted by the contact area.  If the synced
+// labelarray has mapped labels, the split labels are supervoxels and their bodies are used.
+func (d *Data) syncSplit(ctx *datastore.VersionedCtx, delta labels.DeltaSplitEnd) error {
+	db, err := d.GetGraphDB()
+	if err != nil {
+		return err
+	}
+	oldLabel, newLabel := delta.OldLabel, delta.NewLabel
+	source := d.GetSyncedLabelarray()
+	if source != nil && source.MappedLabels {
+		bodies, err := source.MapLabels(ctx.VersionID(), []uint64{oldLabel, newLabel})
+		if err != nil {
+			return err
+		}
+		oldLabel, newLabel = bodies[0], bodies[1]
+		if oldLabel == newLabel {
+			return nil // split supervoxel stays in its body
+		}
+	}
+	oldID := dvid.VertexID(oldLabel)
+	newID := dvid.VertexID(newLabel)
+	if _, err := db.GetVertex(ctx, oldID); err != nil {
+		if err == storage.ErrVertexNotFound {
+			return nil // split label isn't in graph
+		}
+		return fmt.Errorf("Failed to retrieve vertex %d: %v\n", oldID, err)
+	}
+
+	mu := ctx.Mutex()
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := db.GetVertex(ctx, newID); err != nil {
+		if err != storage.ErrVertexNotFound {
+			return fmt.Errorf("Failed to retrieve vertex %d: %v\n", newID, err)
+		}
+		if err := db.AddVertex(ctx, newID, 0); err != nil {
+			return fmt.Errorf("Failed to add vertex %d: %v\n", newID, err)
+		}
+	}
+	if !d.RecomputeSplitEdges {
+		return nil
+	}
+	if source == nil {
+		return fmt.Errorf("no synced labelarray to recompute edges of split label %d", delta.OldLabel)
+	}
+	for _, id := range []dvid.VertexID{oldID, newID} {
+		contacts, err := source.GetAdjacency(ctx.VersionID(), uint64(id))
+		if err != nil {
+			return err
+		}
+		if err := d.setContactEdges(ctx, db, id, contacts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setContactEdges replaces the edges of a vertex with edges to the vertices of contacting
+// labels weighted by contact area.
+func (d *Data) setContactEdges(ctx *datastore.VersionedCtx, db storage.GraphDB, id dvid.VertexID, contacts []labelarray.Contact) error {
+	vertex, err := db.GetVertex(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve vertex %d: %v\n", id, err)
+	}
+	weights := make(map[dvid.VertexID]float64, len(contacts))
+	for _, contact := range contacts {
+		id2 := dvid.VertexID(contact.Label)
+		if _, err := db.GetVertex(ctx, id2); err == nil {
+			weights[id2] = float64(contact.Contact)
+		}
+	}
+	for _, id2 := range vertex.Vertices {
+		if _, found := weights[id2]; !found {
+			if err := db.RemoveEdge(ctx, id, id2); err != nil {
+				return fmt.Errorf("Failed to remove edge %d-%d: %v\n", id, id2, err)
+			}
+		}
+	}
+	for id2, weight := range weights {
+		if err := db.AddEdge(ctx, id, id2, weight); err != nil {
+			return fmt.Errorf("Failed to add edge %d-%d: %v\n", id, id2, err)
+		}
+	}
+	return nil
+}
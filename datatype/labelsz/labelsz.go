@@ -1,5 +1,5 @@
 /*
-	Package labelsz supports ranking labels by # annotations of each type.
+	Package labelsz supports ranking labels by # annotations of each type and # voxels.
 */
 package labelsz
 
@@ -116,7 +116,19 @@ POST <api URL>/node/<UUID>/<data name>/sync?<options>
 	and then kept in sync thereafter.  It is not allowed to change syncs.  You can, however,
 	create a new labelsz data instance and sync it as required.
 
-    The labelsz data type only accepts syncs to annotation data instances.
+    The labelsz data type accepts syncs to annotation data instances for annotation counts
+    and to labelarray or labelvol data instances for voxel counts, e.g.,
+
+    { "sync": "synapses,bodies" }
+
+    Label changes reach the annotation counts through the annotation instance, which can
+    itself be synced to labelblk and labelvol instances or to a labelarray instance.
+
+    Voxel counts are initialized by a reload and then kept in sync with merges and splits
+    of labels and with voxels written to a synced labelarray, so labels ingested after the
+    sync are counted without a reload.
+    Voxel counts are for the entire label and are not restricted to any ROI, and counts
+    are capped at 4,294,967,294 voxels.
 
     GET Query-string Options:
 
@@ -131,7 +143,7 @@ GET <api URL>/node/<UUID>/<data name>/count/<label>/<index type>
 	the catch-all for synapses "AllSyn", or the number of voxels "Voxels".
 
 	For synapse indexing, the labelsz data instance must be synced with an annotations instance.
-	(future) For # voxel indexing, the labelsz data instance must be synced with a labelvol instance.
+	For # voxel indexing, the labelsz data instance must be synced with a labelarray or labelvol instance.
 
 	Example:
 
@@ -151,7 +163,7 @@ GET <api URL>/node/<UUID>/<data name>/top/<N>/<index type>
 	the catch-all for synapses "AllSyn", or the number of voxels "Voxels".
 
 	For synapse indexing, the labelsz data instance must be synced with an annotations instance.
-	(future) For # voxel indexing, the labelsz data instance must be synced with a labelvol instance.
+	For # voxel indexing, the labelsz data instance must be synced with a labelarray or labelvol instance.
 
 	Example:
 
@@ -172,7 +184,7 @@ GET <api URL>/node/<UUID>/<data name>/threshold/<T>/<index type>[?<options>]
 	the catch-all for synapses "AllSyn", or the number of voxels "Voxels".
 
 	For synapse indexing, the labelsz data instance must be synced with an annotations instance.
-	(future) For # voxel indexing, the labelsz data instance must be synced with a labelvol instance.
+	For # voxel indexing, the labelsz data instance must be synced with a labelarray or labelvol instance.
 
     GET Query-string Options:
 
@@ -190,10 +202,52 @@ GET <api URL>/node/<UUID>/<data name>/threshold/<T>/<index type>[?<options>]
 	In the above example, the query returns the labels ranked #10,001 to #10,003 in the sorted list, in
 	descending order of # PreSyn >= 10.
 
+POST <api URL>/node/<UUID>/<data name>/query
+
+	Returns a list of labels that meet minimum counts for any number of index types, sorted
+	in descending order of the count for one index type.  Expects JSON to be POSTed with
+	the following format:
+
+	{
+		"thresholds": { "PreSyn": 5, "PostSyn": 20 },
+		"sort": "AllSyn",
+		"after": { "label": 23, "count": 65 },
+		"roi": "medulla",
+		"n": 100
+	}
+
+	thresholds  Optional minimum counts keyed by index type.
+	sort        Index type used to sort labels.  Only labels with a non-zero count for this
+	            index type are returned.
+	after       Optional cursor so only labels ranked after the given label and its count for
+	            the sort index type are returned.  To page through a ranking, use the last
+	            label and sort count returned by the previous query.
+	roi         Optional ROI given as "<roiname>" or "<roiname>,<uuid>", where the version of
+	            the query is used if no UUID is given.  Counts of each label are recounted from
+	            the synced annotation instance for annotations within this ROI and any ROI
+	            given when the labelsz was created.  Thresholds, sorting, and the cursor then
+	            apply to these counts.  Voxel counts can't be restricted to an ROI.  Labels
+	            are visited in order of their full counts, and a query that has to recount
+	            more than 100,000 labels returns an error, so use a sort threshold for
+	            queries within small ROIs.
+	n           Number of labels to return.  If zero, not given, or above 10,000, up to 10,000
+	            labels are returned.
+
+	Example:
+
+	POST <api URL>/node/3f8c/labelrankings/query
+
+	{ "thresholds": { "PreSyn": 5, "PostSyn": 20 }, "sort": "AllSyn", "n": 2 }
+
+	Returns:
+
+	[ { "Label": 188, "PostSyn": 43, "PreSyn": 38, "AllSyn": 81 }, { "Label": 23, "PostSyn": 21, "PreSyn": 44, "AllSyn": 65 } ]
+
 POST <api URL>/node/<UUID>/<data name>/reload
 
-	Forces asynchornous denormalization from its synced annotations instance.  Can be 
-	used to initialize a newly added instance.  Note that the labelsz will be locked until
+	Forces asynchornous denormalization from its synced annotations instance and
+	its synced labelarray or labelvol instance.  Can be used to initialize a newly
+	added instance.  Note that the labelsz will be locked until
 	the denormalization is finished with a log message.
 `
 
@@ -328,11 +382,37 @@ func (d *Data) Equals(d2 *Data) bool {
 
 func (d *Data) GetSyncedAnnotation() *annotation.Data {
 	for dataUUID := range d.SyncedData() {
-		source, err := annotation.GetByDataUUID(dataUUID)
-		if err == nil {
-			return source
+		source, err := datastore.GetDataByDataUUID(dataUUID)
+		if err != nil {
+			dvid.Errorf("Got error accessing synced data %s: %v\n", dataUUID, err)
+			continue
+		}
+		if annot, ok := source.(*annotation.Data); ok {
+			return annot
+		}
+	}
+	return nil
+}
+
+// LabelSizer is a label data instance, e.g., labelarray or labelvol, that can provide
+// the number of voxels in each of its labels.
+type LabelSizer interface {
+	dvid.Data
+	ProcessLabelSizes(v dvid.VersionID, f func(label, voxels uint64)) error
+}
+
+// GetSyncedLabels returns the synced label data instance that provides voxel counts or
+// nil if there is none.
+func (d *Data) GetSyncedLabels() LabelSizer {
+	for dataUUID := range d.SyncedData() {
+		source, err := datastore.GetDataByDataUUID(dataUUID)
+		if err != nil {
+			dvid.Errorf("Got error accessing synced data %s: %v\n", dataUUID, err)
+			continue
+		}
+		if sizer, ok := source.(LabelSizer); ok {
+			return sizer
 		}
-		dvid.Errorf("Got error accessing synced annotation %s: %v\n", dataUUID, err)
 	}
 	return nil
 }
@@ -739,6 +819,34 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		}
 		timedLog.Infof("HTTP %s: get %d labels for index type %s with threshold %d: %s", r.Method, num, i, t, r.URL)
 
+	case "query":
+		// POST <api URL>/node/<UUID>/<data name>/query
+		if action != "post" {
+			server.BadRequest(w, r, "Only POST action is available on 'query' endpoint.")
+			return
+		}
+		var q LabelQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			server.BadRequest(w, r, fmt.Errorf("unable to decode query JSON: %v", err))
+			return
+		}
+		labels, err := d.QueryLabels(ctx, &q)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		jsonBytes, err := json.Marshal(labels)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if _, err := w.Write(jsonBytes); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		timedLog.Infof("HTTP %s: query returned %d labels sorted by %s: %s", r.Method, len(labels), q.Sort, r.URL)
+
 	case "reload":
 		// POST <api URL>/node/<UUID>/<data name>/reload
 		if action != "post" {
@@ -753,17 +861,20 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 }
 
 func (d *Data) ReloadData(ctx *datastore.VersionedCtx) {
+	d.StartUpdate() // stopped when resync is done so reload can be waited on.
 	go d.resync(ctx)
 	dvid.Infof("Started recalculation of labelsz %q...\n", d.DataName())
 }
 
-// Get all labeled annotations from synced annotation instance and repopulate the labelsz.
+// Get all labeled annotations from synced annotation instance and all label sizes from
+// synced label instance, then repopulate the labelsz.
 func (d *Data) resync(ctx *datastore.VersionedCtx) {
-	timedLog := dvid.NewTimeLog()
+	defer d.StopUpdate()
 
 	annot := d.GetSyncedAnnotation()
-	if annot == nil {
-		dvid.Errorf("Unable to get synced annotation.  Aborting reload of labelsz %q.\n", d.DataName())
+	sizer := d.GetSyncedLabels()
+	if annot == nil && sizer == nil {
+		dvid.Errorf("Unable to get synced annotation or labels.  Aborting reload of labelsz %q.\n", d.DataName())
 		return
 	}
 
@@ -773,15 +884,13 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 		return
 	}
 
-	d.StartUpdate()
 	d.Lock()
+	defer d.Unlock()
 
 	minTSLTKey := storage.MinTKey(keyTypeSizeLabel)
 	maxTSLTKey := storage.MaxTKey(keyTypeSizeLabel)
 	if err := store.DeleteRange(ctx, minTSLTKey, maxTSLTKey); err != nil {
 		dvid.Errorf("Unable to delete type-size-label denormalization for labelsz %q: %v\n", d.DataName(), err)
-		d.Unlock()
-		d.StopUpdate()
 		return
 	}
 
@@ -789,15 +898,25 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 	maxTypeTKey := storage.MaxTKey(keyTypeLabel)
 	if err := store.DeleteRange(ctx, minTypeTKey, maxTypeTKey); err != nil {
 		dvid.Errorf("Unable to delete type-label denormalization for labelsz %q: %v\n", d.DataName(), err)
-		d.Unlock()
-		d.StopUpdate()
 		return
 	}
 
+	if annot != nil {
+		d.resyncAnnotations(ctx, store, annot)
+	}
+	if sizer != nil {
+		d.resyncVoxels(ctx, store, sizer)
+	}
+}
+
+// repopulate the annotation counts of all labels from the synced annotation instance.
+func (d *Data) resyncAnnotations(ctx *datastore.VersionedCtx, store storage.OrderedKeyValueDB, annot *annotation.Data) {
+	timedLog := dvid.NewTimeLog()
+
 	buf := make([]byte, 4)
 	var indexMap [AllSyn]uint32
 	var totLabels uint64
-	err = annot.ProcessLabelAnnotations(ctx.VersionID(), func(label uint64, elems annotation.ElementsNR) {
+	err := annot.ProcessLabelAnnotations(ctx.VersionID(), func(label uint64, elems annotation.ElementsNR) {
 		totLabels++
 		for i := IndexType(0); i < AllSyn; i++ {
 			indexMap[i] = 0
@@ -823,8 +942,50 @@ func (d *Data) resync(ctx *datastore.VersionedCtx) {
 	if err != nil {
 		dvid.Errorf("Error in reload of labelsz %q: %v\n", d.DataName(), err)
 	}
-	d.Unlock()
-	d.StopUpdate()
 
 	timedLog.Infof("Completed labelsz %q reload of %d labels from annotation %q", d.DataName(), totLabels, annot.DataName())
 }
+
+// repopulate the voxel counts of all labels from the synced label instance.
+func (d *Data) resyncVoxels(ctx *datastore.VersionedCtx, store storage.OrderedKeyValueDB, sizer LabelSizer) {
+	timedLog := dvid.NewTimeLog()
+
+	batcher, ok := store.(storage.KeyValueBatcher)
+	if !ok {
+		dvid.Errorf("Labelsz %q requires batch-enabled store, which %q is not\n", d.DataName(), store)
+		return
+	}
+	batch := batcher.NewBatch(ctx)
+
+	var totLabels uint64
+	var commitErr error
+	err := sizer.ProcessLabelSizes(ctx.VersionID(), func(label, voxels uint64) {
+		if commitErr != nil {
+			return
+		}
+		totLabels++
+		count := voxelCount(voxels)
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, count)
+		batch.Put(NewTypeLabelTKey(Voxels, label), buf)
+		batch.Put(NewTypeSizeLabelTKey(Voxels, count, label), nil)
+		if totLabels%1000 == 0 {
+			if commitErr = batch.Commit(); commitErr == nil {
+				batch = batcher.NewBatch(ctx)
+			}
+		}
+	})
+	if err != nil {
+		dvid.Errorf("Error in reload of labelsz %q: %v\n", d.DataName(), err)
+		return
+	}
+	if commitErr == nil {
+		commitErr = batch.Commit()
+	}
+	if commitErr != nil {
+		dvid.Errorf("Error in batch commit during reload of labelsz %q: %v\n", d.DataName(), commitErr)
+		return
+	}
+
+	timedLog.Infof("Completed labelsz %q reload of %d label sizes from %q", d.DataName(), totLabels, sizer.DataName())
+}
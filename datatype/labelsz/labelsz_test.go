@@ -102,7 +102,9 @@ func getBytesRLE(t *testing.T, rles dvid.RLEs) *bytes.Buffer {
 	return buf
 }
 
-func checkSequencing(t *testing.T, uuid dvid.UUID) {
+// checkSequencing checks labelsz rankings through element modifications and merges and
+// splits sent to the labels data instance of the given name.
+func checkSequencing(t *testing.T, uuid dvid.UUID, labelsName dvid.InstanceName) {
 	// Check if we have correct sequencing for no ROI labelsz.
 	if err := datastore.BlockOnUpdating(uuid, "noroi"); err != nil {
 		t.Fatalf("Error blocking on sync of noroi labelsz: %v\n", err)
@@ -240,11 +242,11 @@ func checkSequencing(t *testing.T, uuid dvid.UUID) {
 	}
 
 	// Check sync on merge.
-	if err := datastore.BlockOnUpdating(uuid, "bodies"); err != nil {
-		t.Fatalf("Error blocking on sync of bodies: %v\n", err)
+	if err := datastore.BlockOnUpdating(uuid, labelsName); err != nil {
+		t.Fatalf("Error blocking on sync of %s: %v\n", labelsName, err)
 	}
 	testMerge := mergeJSON(`[200, 300]`)
-	testMerge.send(t, uuid, "bodies")
+	testMerge.send(t, uuid, string(labelsName))
 
 	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
 		t.Fatalf("Error blocking on sync of labels: %v\n", err)
@@ -330,7 +332,7 @@ func checkSequencing(t *testing.T, uuid dvid.UUID) {
 	buf := getBytesRLE(t, rles)
 
 	// Submit the split sparsevol
-	url = fmt.Sprintf("%snode/%s/%s/split/%d?splitlabel=150", server.WebAPIPath, uuid, "bodies", 100)
+	url = fmt.Sprintf("%snode/%s/%s/split/%d?splitlabel=150", server.WebAPIPath, uuid, labelsName, 100)
 	data = server.TestHTTP(t, "POST", url, buf)
 	jsonVal := make(map[string]uint64)
 	if err := json.Unmarshal(data, &jsonVal); err != nil {
@@ -371,7 +373,7 @@ func checkSequencing(t *testing.T, uuid dvid.UUID) {
 	buf = getBytesRLE(t, rles)
 
 	// Submit the coarse split of 200 -> 250
-	url = fmt.Sprintf("%snode/%s/%s/split-coarse/200?splitlabel=250", server.WebAPIPath, uuid, "bodies")
+	url = fmt.Sprintf("%snode/%s/%s/split-coarse/200?splitlabel=250", server.WebAPIPath, uuid, labelsName)
 	data = server.TestHTTP(t, "POST", url, buf)
 	jsonVal = make(map[string]uint64)
 	if err := json.Unmarshal(data, &jsonVal); err != nil {
@@ -527,7 +529,82 @@ func TestLabels(t *testing.T) {
 	url := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
 	server.TestHTTP(t, "POST", url, strings.NewReader(string(testJSON)))
 
-	checkSequencing(t, uuid)
+	checkSequencing(t, uuid, "bodies")
+}
+
+func TestLabelarrayLabels(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	// Create testbed volume and data instances
+	uuid, _ := datastore.NewTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32") // coarse split in checkSequencing uses 32^3 blocks
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+
+	// Populate the labels, which should automatically populate the label index
+	_ = createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Add annotations syncing with "labels" instance, which provides both blocks and merge/split events.
+	config.Clear()
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	server.CreateTestSync(t, uuid, "mysynapses", "labels")
+
+	// Create a ROI that will be used for our labelsz.
+	server.CreateTestInstance(t, uuid, "roi", "myroi", config)
+	roiRequest := fmt.Sprintf("%snode/%s/myroi/roi", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", roiRequest, getROIReader())
+
+	// Create labelsz instances synced to the above annotations.
+	server.CreateTestInstance(t, uuid, "labelsz", "noroi", config)
+	server.CreateTestSync(t, uuid, "noroi", "mysynapses")
+	config.Set("ROI", fmt.Sprintf("myroi,%s", uuid))
+	server.CreateTestInstance(t, uuid, "labelsz", "withroi", config)
+	server.CreateTestSync(t, uuid, "withroi", "mysynapses")
+
+	// PUT same synapses as TestLabels.
+	var synapses annotation.Elements
+	var x, y, z int32
+	for z = 4; z < 128; z += 4 {
+		for y = 4; y < 128; y += 4 {
+			for x = 4; x < 128; x += 4 {
+				e := annotation.Element{
+					annotation.ElementNR{
+						Pos:  dvid.Point3d{x, y, z},
+						Kind: annotation.PostSyn,
+					},
+					[]annotation.Relationship{},
+				}
+				synapses = append(synapses, e)
+			}
+		}
+	}
+	for z = 2; z < 128; z += 4 {
+		for y = 2; y < 128; y += 4 {
+			for x = 2; x < 128; x += 4 {
+				e := annotation.Element{
+					annotation.ElementNR{
+						Pos:  dvid.Point3d{x, y, z},
+						Kind: annotation.PreSyn,
+					},
+					[]annotation.Relationship{},
+				}
+				synapses = append(synapses, e)
+			}
+		}
+	}
+	testJSON, err := json.Marshal(synapses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, strings.NewReader(string(testJSON)))
+
+	checkSequencing(t, uuid, "labels")
 }
 
 func TestLabelsResync(t *testing.T) {
@@ -617,5 +694,299 @@ func TestLabelsResync(t *testing.T) {
 	url = fmt.Sprintf("%snode/%s/withroi/reload", server.WebAPIPath, uuid)
 	server.TestHTTP(t, "POST", url, nil)
 
-	checkSequencing(t, uuid)
+	checkSequencing(t, uuid, "bodies")
+}
+
+func TestLabelarrayVoxels(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+
+	// Label 100 has 64 x 128 x 128 voxels while labels 200 and 300 have 64 x 128 x 64.
+	_ = createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	// Create labelsz synced to labels after population so need reload.
+	config.Clear()
+	server.CreateTestInstance(t, uuid, "labelsz", "voxels", config)
+	server.CreateTestSync(t, uuid, "voxels", "labels")
+
+	url := fmt.Sprintf("%snode/%s/voxels/reload", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, nil)
+	if err := datastore.BlockOnUpdating(uuid, "voxels"); err != nil {
+		t.Fatalf("Error blocking on reload of labelsz: %v\n", err)
+	}
+
+	url = fmt.Sprintf("%snode/%s/voxels/top/3/Voxels", server.WebAPIPath, uuid)
+	data := server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `[{"Label":100,"Size":1048576},{"Label":200,"Size":524288},{"Label":300,"Size":524288}]` {
+		t.Errorf("Got back incorrect Voxels ranking:\n%v\n", string(data))
+	}
+
+	url = fmt.Sprintf("%snode/%s/voxels/threshold/600000/Voxels", server.WebAPIPath, uuid)
+	data = server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `[{"Label":100,"Size":1048576}]` {
+		t.Errorf("Got back incorrect Voxels threshold:\n%v\n", string(data))
+	}
+
+	// Check sync on merge.
+	testMerge := mergeJSON(`[200, 300]`)
+	testMerge.send(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	time.Sleep(1 * time.Second)
+	if err := datastore.BlockOnUpdating(uuid, "voxels"); err != nil {
+		t.Fatalf("Error blocking on sync of labelsz: %v\n", err)
+	}
+
+	url = fmt.Sprintf("%snode/%s/voxels/top/3/Voxels", server.WebAPIPath, uuid)
+	data = server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `[{"Label":100,"Size":1048576},{"Label":200,"Size":1048576}]` {
+		t.Errorf("Got back incorrect post-merge Voxels ranking:\n%v\n", string(data))
+	}
+
+	url = fmt.Sprintf("%snode/%s/voxels/count/300/Voxels", server.WebAPIPath, uuid)
+	data = server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `{"Label":300,"Voxels":0}` {
+		t.Errorf("Got back incorrect post-merge Voxels count of label 300:\n%v\n", string(data))
+	}
+
+	// Check sync on split of 19 x 19 x 19 voxels from label 100 -> 150.
+	var rles dvid.RLEs
+	for z := int32(0); z < 19; z++ {
+		for y := int32(0); y < 19; y++ {
+			rles = append(rles, dvid.NewRLE(dvid.Point3d{0, y, z}, 19))
+		}
+	}
+	url = fmt.Sprintf("%snode/%s/labels/split/100?splitlabel=150", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, getBytesRLE(t, rles))
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	time.Sleep(1 * time.Second)
+	if err := datastore.BlockOnUpdating(uuid, "voxels"); err != nil {
+		t.Fatalf("Error blocking on sync of labelsz: %v\n", err)
+	}
+
+	url = fmt.Sprintf("%snode/%s/voxels/top/3/Voxels", server.WebAPIPath, uuid)
+	data = server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `[{"Label":200,"Size":1048576},{"Label":100,"Size":1041717},{"Label":150,"Size":6859}]` {
+		t.Errorf("Got back incorrect post-split Voxels ranking:\n%v\n", string(data))
+	}
+
+	// Check sync on coarse split of two 32 x 32 x 32 blocks from label 200 -> 250.
+	rles = dvid.RLEs{dvid.NewRLE(dvid.Point3d{2, 0, 0}, 2)}
+	url = fmt.Sprintf("%snode/%s/labels/split-coarse/200?splitlabel=250", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, getBytesRLE(t, rles))
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	time.Sleep(1 * time.Second)
+	if err := datastore.BlockOnUpdating(uuid, "voxels"); err != nil {
+		t.Fatalf("Error blocking on sync of labelsz: %v\n", err)
+	}
+
+	url = fmt.Sprintf("%snode/%s/voxels/top/4/Voxels", server.WebAPIPath, uuid)
+	data = server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `[{"Label":100,"Size":1041717},{"Label":200,"Size":983040},{"Label":250,"Size":65536},{"Label":150,"Size":6859}]` {
+		t.Errorf("Got back incorrect post-coarse-split Voxels ranking:\n%v\n", string(data))
+	}
+}
+
+func TestLabelarrayVoxelsIngest(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+
+	// Create labelsz synced to labels before population so no reload is needed.
+	config.Clear()
+	server.CreateTestInstance(t, uuid, "labelsz", "voxels", config)
+	server.CreateTestSync(t, uuid, "voxels", "labels")
+
+	_ = createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+	time.Sleep(1 * time.Second)
+	if err := datastore.BlockOnUpdating(uuid, "voxels"); err != nil {
+		t.Fatalf("Error blocking on sync of labelsz: %v\n", err)
+	}
+
+	url := fmt.Sprintf("%snode/%s/voxels/top/3/Voxels", server.WebAPIPath, uuid)
+	data := server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `[{"Label":100,"Size":1048576},{"Label":200,"Size":524288},{"Label":300,"Size":524288}]` {
+		t.Errorf("Got back incorrect Voxels ranking after ingest:\n%v\n", string(data))
+	}
+
+	url = fmt.Sprintf("%snode/%s/voxels/count/300/Voxels", server.WebAPIPath, uuid)
+	data = server.TestHTTP(t, "GET", url, nil)
+	if string(data) != `{"Label":300,"Voxels":524288}` {
+		t.Errorf("Got back incorrect Voxels count of label 300 after ingest:\n%v\n", string(data))
+	}
+}
+
+func TestLabelsQuery(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := datastore.NewTestRepo()
+	var config dvid.Config
+	config.Set("BlockSize", "32,32,32")
+	server.CreateTestInstance(t, uuid, "labelarray", "labels", config)
+	_ = createLabelTestVolume(t, uuid, "labels")
+
+	if err := datastore.BlockOnUpdating(uuid, "labels"); err != nil {
+		t.Fatalf("Error blocking on sync of labels: %v\n", err)
+	}
+
+	config.Clear()
+	server.CreateTestInstance(t, uuid, "annotation", "mysynapses", config)
+	server.CreateTestSync(t, uuid, "mysynapses", "labels")
+
+	server.CreateTestInstance(t, uuid, "roi", "myroi", config)
+	roiRequest := fmt.Sprintf("%snode/%s/myroi/roi", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", roiRequest, getROIReader())
+
+	server.CreateTestInstance(t, uuid, "labelsz", "noroi", config)
+	server.CreateTestSync(t, uuid, "noroi", "mysynapses")
+	config.Set("ROI", fmt.Sprintf("myroi,%s", uuid))
+	server.CreateTestInstance(t, uuid, "labelsz", "withroi", config)
+	server.CreateTestSync(t, uuid, "withroi", "mysynapses")
+
+	// PUT same synapses as TestLabels.
+	var synapses annotation.Elements
+	var x, y, z int32
+	for z = 4; z < 128; z += 4 {
+		for y = 4; y < 128; y += 4 {
+			for x = 4; x < 128; x += 4 {
+				e := annotation.Element{
+					annotation.ElementNR{
+						Pos:  dvid.Point3d{x, y, z},
+						Kind: annotation.PostSyn,
+					},
+					[]annotation.Relationship{},
+				}
+				synapses = append(synapses, e)
+			}
+		}
+	}
+	for z = 2; z < 128; z += 4 {
+		for y = 2; y < 128; y += 4 {
+			for x = 2; x < 128; x += 4 {
+				e := annotation.Element{
+					annotation.ElementNR{
+						Pos:  dvid.Point3d{x, y, z},
+						Kind: annotation.PreSyn,
+					},
+					[]annotation.Relationship{},
+				}
+				synapses = append(synapses, e)
+			}
+		}
+	}
+	testJSON, err := json.Marshal(synapses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("%snode/%s/mysynapses/elements", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", url, strings.NewReader(string(testJSON)))
+
+	if err := datastore.BlockOnUpdating(uuid, "mysynapses"); err != nil {
+		t.Fatalf("Error blocking on sync of synapses: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "noroi"); err != nil {
+		t.Fatalf("Error blocking on sync of noroi labelsz: %v\n", err)
+	}
+	if err := datastore.BlockOnUpdating(uuid, "withroi"); err != nil {
+		t.Fatalf("Error blocking on sync of withroi labelsz: %v\n", err)
+	}
+
+	// Label 100 has 16384 PreSyn and 14415 PostSyn, label 200 has 8192 PreSyn and 7440 PostSyn,
+	// and label 300 has 8192 PreSyn and 7936 PostSyn.
+	url = fmt.Sprintf("%snode/%s/noroi/query", server.WebAPIPath, uuid)
+	query := `{"thresholds": {"PreSyn": 8192, "PostSyn": 7500}, "sort": "AllSyn"}`
+	data := server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":100,"PostSyn":14415,"PreSyn":16384,"AllSyn":30799},{"Label":300,"PostSyn":7936,"PreSyn":8192,"AllSyn":16128}]` {
+		t.Errorf("Got back incorrect query result:\n%v\n", string(data))
+	}
+
+	query = `{"thresholds": {"PreSyn": 1}, "sort": "PostSyn", "n": 1}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":100,"PostSyn":14415,"PreSyn":16384}]` {
+		t.Errorf("Got back incorrect query result with n:\n%v\n", string(data))
+	}
+
+	query = `{"thresholds": {"PreSyn": 1}, "sort": "PostSyn", "after": {"label": 100, "count": 14415}, "n": 1}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":300,"PostSyn":7936,"PreSyn":8192}]` {
+		t.Errorf("Got back incorrect query result with cursor:\n%v\n", string(data))
+	}
+
+	query = `{"sort": "PostSyn", "after": {"label": 300, "count": 7936}, "n": 20000}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":200,"PostSyn":7440}]` {
+		t.Errorf("Got back incorrect query result with cursor and large n:\n%v\n", string(data))
+	}
+
+	query = `{"thresholds": {"AllSyn": 16000}, "sort": "AllSyn"}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":100,"AllSyn":30799},{"Label":300,"AllSyn":16128}]` {
+		t.Errorf("Got back incorrect query result with sort threshold:\n%v\n", string(data))
+	}
+
+	query = `{"thresholds": {"PreSyn": 20000}, "sort": "AllSyn"}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[]` {
+		t.Errorf("Expected no labels from query, got:\n%v\n", string(data))
+	}
+
+	server.TestBadHTTP(t, "POST", url, strings.NewReader(`{"sort": "Foo"}`))
+	server.TestBadHTTP(t, "POST", url, strings.NewReader(`{"thresholds": {"Foo": 1}, "sort": "PreSyn"}`))
+	server.TestBadHTTP(t, "POST", url, strings.NewReader(`{"sort": "PreSyn", "n": -1}`))
+
+	// Within the ROI, label 100 has 2048 PreSyn and PostSyn while labels 200 and 300 have 1024.
+	query = `{"thresholds": {"PostSyn": 1025}, "sort": "PreSyn", "roi": "myroi"}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":100,"PostSyn":2048,"PreSyn":2048}]` {
+		t.Errorf("Got back incorrect query result within ROI:\n%v\n", string(data))
+	}
+
+	query = fmt.Sprintf(`{"sort": "AllSyn", "roi": "myroi,%s"}`, uuid)
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":100,"AllSyn":4096},{"Label":200,"AllSyn":2048},{"Label":300,"AllSyn":2048}]` {
+		t.Errorf("Got back incorrect query result within ROI given with UUID:\n%v\n", string(data))
+	}
+
+	query = `{"sort": "AllSyn", "roi": "myroi", "after": {"label": 200, "count": 2048}}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":300,"AllSyn":2048}]` {
+		t.Errorf("Got back incorrect query result within ROI with cursor:\n%v\n", string(data))
+	}
+
+	server.TestBadHTTP(t, "POST", url, strings.NewReader(`{"sort": "PreSyn", "roi": "nonexistent"}`))
+	server.TestBadHTTP(t, "POST", url, strings.NewReader(`{"sort": "Voxels", "roi": "myroi"}`))
+
+	// Within the ROI, label 100 has 2048 PreSyn and PostSyn while labels 200 and 300 have 1024.
+	url = fmt.Sprintf("%snode/%s/withroi/query", server.WebAPIPath, uuid)
+	query = `{"thresholds": {"PostSyn": 1025}, "sort": "PreSyn"}`
+	data = server.TestHTTP(t, "POST", url, strings.NewReader(query))
+	if string(data) != `[{"Label":100,"PostSyn":2048,"PreSyn":2048}]` {
+		t.Errorf("Got back incorrect ROI query result:\n%v\n", string(data))
+	}
 }
@@ -0,0 +1,342 @@
+/*
+	This file supports ranking queries that combine thresholds on several index types.
+*/
+
+package labelsz
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/datatype/annotation"
+	"github.com/janelia-flyem/dvid/datatype/roi"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// MaxROIQueryScan is the maximum number of labels whose annotations are recounted for a
+// query restricted to an ROI.
+const MaxROIQueryScan = 100000
+
+// LabelQuery is a ranking query for labels that meet minimum counts of any number of
+// index types, sorted by the count of one index type.
+type LabelQuery struct {
+	// Thresholds gives the minimum count for each index type name, e.g., "PreSyn".
+	Thresholds map[string]uint32 `json:"thresholds"`
+
+	// Sort is the name of the index type used to sort labels in descending order.
+	Sort string `json:"sort"`
+
+	// After is an optional cursor, where only labels ranked after the given label are returned.
+	After *LabelCursor `json:"after"`
+
+	// ROI optionally restricts counts to an ROI given as "<roiname>" or "<roiname>,<uuid>",
+	// where the version of the query is used if no UUID is given.
+	ROI string `json:"roi"`
+
+	// N is the number of labels, where zero N or N above MaxLabelsReturned returns up to
+	// MaxLabelsReturned labels.
+	N int `json:"n"`
+}
+
+// LabelCursor gives a position in a ranking via a label and its count for the sort index type,
+// e.g., the last label returned by a previous query.
+type LabelCursor struct {
+	Label uint64 `json:"label"`
+	Count uint32 `json:"count"`
+}
+
+// LabelCounts holds the counts of a label for the index types in a query.
+type LabelCounts struct {
+	Label  uint64
+	Counts map[IndexType]uint32
+}
+
+// MarshalJSON returns JSON of the form { "Label": 188, "PreSyn": 38, "AllSyn": 81 }
+// where counts are ordered by index type.
+func (lc LabelCounts) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"Label":%d`, lc.Label)
+	for i := PostSyn; i <= Voxels; i++ {
+		if count, found := lc.Counts[i]; found {
+			fmt.Fprintf(&buf, `,%q:%d`, i, count)
+		}
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// checks the query and returns the sort index type and thresholds by index type.
+func (d *Data) parseQuery(q *LabelQuery) (sortIndex IndexType, thresholds map[IndexType]uint32, err error) {
+	if q.Sort == "" {
+		err = fmt.Errorf("query must specify an index type to sort on")
+		return
+	}
+	sortIndex = StringToIndexType(q.Sort)
+	if sortIndex == UnknownIndex {
+		err = fmt.Errorf("unknown sort index type specified (%q)", q.Sort)
+		return
+	}
+	thresholds = make(map[IndexType]uint32, len(q.Thresholds))
+	for name, t := range q.Thresholds {
+		i := StringToIndexType(name)
+		if i == UnknownIndex {
+			err = fmt.Errorf("unknown index type specified for threshold (%q)", name)
+			return
+		}
+		thresholds[i] = t
+	}
+	if q.N < 0 {
+		err = fmt.Errorf("bad number of requested labels (%d)", q.N)
+		return
+	}
+	if q.After != nil && q.After.Count >= math.MaxUint32 {
+		err = fmt.Errorf("bad count (%d) in query cursor", q.After.Count)
+		return
+	}
+	if q.ROI != "" {
+		if _, found := thresholds[Voxels]; found || sortIndex == Voxels {
+			err = fmt.Errorf("voxel counts can't be restricted to ROI %q", q.ROI)
+			return
+		}
+	}
+	return
+}
+
+// QueryLabels returns the labels that meet all the thresholds of a query, sorted in
+// descending order of the query's sort index type.  Labels are ranked by walking the
+// sorted keys of the sort index type and only labels with non-zero count for it are
+// returned.  We allow a maximum of MaxLabelsReturned returned labels and start after the
+// query's cursor if given.  Queries restricted to an ROI recount annotations within the ROI.
+func (d *Data) QueryLabels(ctx *datastore.VersionedCtx, q *LabelQuery) ([]LabelCounts, error) {
+	sortIndex, thresholds, err := d.parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	nReturns := q.N
+	if nReturns == 0 || nReturns > MaxLabelsReturned {
+		nReturns = MaxLabelsReturned
+	}
+	if q.ROI != "" {
+		return d.queryROILabels(ctx, q, sortIndex, thresholds, nReturns)
+	}
+
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup key range for iterating through keys of the sort index type, starting with the
+	// key following the cursor's if given.
+	begTKey := NewTypeSizeLabelTKey(sortIndex, math.MaxUint32-1, 0)
+	if q.After != nil {
+		switch {
+		case q.After.Label != math.MaxUint64:
+			begTKey = NewTypeSizeLabelTKey(sortIndex, q.After.Count, q.After.Label+1)
+		case q.After.Count != 0:
+			begTKey = NewTypeSizeLabelTKey(sortIndex, q.After.Count-1, 0)
+		default:
+			return []LabelCounts{}, nil
+		}
+	}
+	endTKey := NewTypeSizeLabelTKey(sortIndex, 0, math.MaxUint64)
+	minSort := thresholds[sortIndex]
+
+	d.RLock()
+	defer d.RUnlock()
+
+	// Iterate through sorted size list, checking other thresholds, until we get what we need.
+	shortCircuitErr := fmt.Errorf("Found data, aborting.")
+	results := []LabelCounts{}
+	err = store.ProcessRange(ctx, begTKey, endTKey, nil, func(chunk *storage.Chunk) error {
+		idxType, sz, label, err := DecodeTypeSizeLabelTKey(chunk.K)
+		if err != nil {
+			return err
+		}
+		if idxType != sortIndex {
+			return fmt.Errorf("bad iteration of keys: expected index type %s, got %s", sortIndex, idxType)
+		}
+		if sz < minSort {
+			return shortCircuitErr
+		}
+		counts := map[IndexType]uint32{sortIndex: sz}
+		for i, t := range thresholds {
+			if i == sortIndex {
+				continue
+			}
+			val, err := store.Get(ctx, NewTypeLabelTKey(i, label))
+			if err != nil {
+				return err
+			}
+			var count uint32
+			if val != nil {
+				if len(val) != 4 {
+					return fmt.Errorf("bad size in value for index type %s, label %d: value has length %d", i, label, len(val))
+				}
+				count = binary.LittleEndian.Uint32(val)
+			}
+			if count < t {
+				return nil
+			}
+			counts[i] = count
+		}
+		results = append(results, LabelCounts{Label: label, Counts: counts})
+		if len(results) == nReturns {
+			return shortCircuitErr
+		}
+		return nil
+	})
+	if err != shortCircuitErr && err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// roiRanking is a heap of labels with the lowest ranked label, i.e., the one with the lowest
+// count of the sort index type and then the highest label, at the top.
+type roiRanking struct {
+	sortIndex IndexType
+	labels    []LabelCounts
+}
+
+func (r *roiRanking) Len() int {
+	return len(r.labels)
+}
+
+func (r *roiRanking) Less(i, j int) bool {
+	ci, cj := r.labels[i].Counts[r.sortIndex], r.labels[j].Counts[r.sortIndex]
+	if ci != cj {
+		return ci < cj
+	}
+	return r.labels[i].Label > r.labels[j].Label
+}
+
+func (r *roiRanking) Swap(i, j int) {
+	r.labels[i], r.labels[j] = r.labels[j], r.labels[i]
+}
+
+func (r *roiRanking) Push(x interface{}) {
+	r.labels = append(r.labels, x.(LabelCounts))
+}
+
+func (r *roiRanking) Pop() interface{} {
+	n := len(r.labels) - 1
+	lc := r.labels[n]
+	r.labels = r.labels[:n]
+	return lc
+}
+
+// returns the counts of a label's annotations within both the given ROI and the ROI of this
+// labelsz, if any.
+func (d *Data) countInROI(v dvid.VersionID, annot *annotation.Data, iROI *roi.Immutable, label uint64) (map[IndexType]uint32, error) {
+	elems, err := annot.GetLabelAnnotations(v, label)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[IndexType]uint32, AllSyn)
+	for _, elem := range elems {
+		if iROI.VoxelWithin(elem.Pos) && d.inROI(elem.Pos) {
+			counts[elementToIndexType(elem.Kind)]++
+			counts[AllSyn]++
+		}
+	}
+	return counts, nil
+}
+
+// returns the labels ranked by their annotation counts within the query's ROI, which are
+// recounted from the synced annotation instance.  Since stored counts bound the counts within
+// the ROI, labels are visited in descending order of stored counts until no remaining label
+// can be ranked or MaxROIQueryScan labels have been recounted.
+func (d *Data) queryROILabels(ctx *datastore.VersionedCtx, q *LabelQuery, sortIndex IndexType, thresholds map[IndexType]uint32, nReturns int) ([]LabelCounts, error) {
+	annot := d.GetSyncedAnnotation()
+	if annot == nil {
+		return nil, fmt.Errorf("labelsz %q has no synced annotation instance for counts within ROI %q", d.DataName(), q.ROI)
+	}
+	spec := q.ROI
+	if !strings.Contains(spec, ",") {
+		uuid, err := datastore.UUIDFromVersion(ctx.VersionID())
+		if err != nil {
+			return nil, err
+		}
+		spec = fmt.Sprintf("%s,%s", spec, uuid)
+	}
+	iROI, err := roi.ImmutableBySpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	if iROI == nil {
+		return nil, fmt.Errorf("unable to find ROI %q", q.ROI)
+	}
+
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	begTKey := NewTypeSizeLabelTKey(sortIndex, math.MaxUint32-1, 0)
+	endTKey := NewTypeSizeLabelTKey(sortIndex, 0, math.MaxUint64)
+	minSort := thresholds[sortIndex]
+	if minSort == 0 {
+		minSort = 1 // only labels with non-zero count for the sort index type are returned
+	}
+
+	d.RLock()
+	defer d.RUnlock()
+
+	shortCircuitErr := fmt.Errorf("Found data, aborting.")
+	ranking := &roiRanking{sortIndex: sortIndex}
+	var scanned int
+	err = store.ProcessRange(ctx, begTKey, endTKey, nil, func(chunk *storage.Chunk) error {
+		idxType, sz, label, err := DecodeTypeSizeLabelTKey(chunk.K)
+		if err != nil {
+			return err
+		}
+		if idxType != sortIndex {
+			return fmt.Errorf("bad iteration of keys: expected index type %s, got %s", sortIndex, idxType)
+		}
+		if sz < minSort {
+			return shortCircuitErr
+		}
+		if ranking.Len() == nReturns && sz < ranking.labels[0].Counts[sortIndex] {
+			return shortCircuitErr
+		}
+		if scanned++; scanned > MaxROIQueryScan {
+			return fmt.Errorf("query within ROI %q requires recounting more than %d labels; raise the sort threshold", q.ROI, MaxROIQueryScan)
+		}
+		counts, err := d.countInROI(ctx.VersionID(), annot, iROI, label)
+		if err != nil {
+			return err
+		}
+		count := counts[sortIndex]
+		if count < minSort {
+			return nil
+		}
+		if q.After != nil && (count > q.After.Count || (count == q.After.Count && label <= q.After.Label)) {
+			return nil
+		}
+		lc := LabelCounts{Label: label, Counts: map[IndexType]uint32{sortIndex: count}}
+		for i, t := range thresholds {
+			if counts[i] < t {
+				return nil
+			}
+			lc.Counts[i] = counts[i]
+		}
+		heap.Push(ranking, lc)
+		if ranking.Len() > nReturns {
+			heap.Pop(ranking)
+		}
+		return nil
+	})
+	if err != shortCircuitErr && err != nil {
+		return nil, err
+	}
+	results := make([]LabelCounts, ranking.Len())
+	for i := len(results) - 1; i >= 0; i-- {
+		results[i] = heap.Pop(ranking).(LabelCounts)
+	}
+	return results, nil
+}
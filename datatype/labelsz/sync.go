@@ -3,10 +3,12 @@ package labelsz
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"sync"
 
 	"github.com/janelia-flyem/dvid/datastore"
 	"github.com/janelia-flyem/dvid/datatype/annotation"
+	"github.com/janelia-flyem/dvid/datatype/common/labels"
 	"github.com/janelia-flyem/dvid/dvid"
 	"github.com/janelia-flyem/dvid/storage"
 )
@@ -48,22 +50,50 @@ func (d *Data) GetSyncSubs(synced dvid.Data) (datastore.SyncSubs, error) {
 		}
 	}
 
-	subs := datastore.SyncSubs{
-		datastore.SyncSub{
-			Event:  datastore.SyncEvent{synced.DataUUID(), annotation.ModifyElementsEvent},
-			Notify: d.DataUUID(),
-			Ch:     d.syncCh,
-		},
-		// datastore.SyncSub{
-		// 	Event:  datastore.SyncEvent{synced.DataUUID(), annotation.SetElementsEvent},
-		// 	Notify: d.DataUUID(),
-		// 	Ch:     d.SyncCh,
-		// },
+	var subs datastore.SyncSubs
+	switch synced.TypeName() {
+	case "annotation":
+		subs = datastore.SyncSubs{
+			datastore.SyncSub{
+				Event:  datastore.SyncEvent{synced.DataUUID(), annotation.ModifyElementsEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+			// datastore.SyncSub{
+			// 	Event:  datastore.SyncEvent{synced.DataUUID(), annotation.SetElementsEvent},
+			// 	Notify: d.DataUUID(),
+			// 	Ch:     d.SyncCh,
+			// },
+		}
+	case "labelvol":
+		subs = datastore.SyncSubs{
+			datastore.SyncSub{
+				Event:  datastore.SyncEvent{synced.DataUUID(), labels.ChangeSizeEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+		}
+	case "labelarray":
+		// labelarray doesn't send size deletions for merged labels so use end of merge.
+		subs = datastore.SyncSubs{
+			datastore.SyncSub{
+				Event:  datastore.SyncEvent{synced.DataUUID(), labels.ChangeSizeEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+			datastore.SyncSub{
+				Event:  datastore.SyncEvent{synced.DataUUID(), labels.MergeEndEvent},
+				Notify: d.DataUUID(),
+				Ch:     d.syncCh,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("Unable to sync %s with %s since datatype %q is not supported.", d.DataName(), synced.DataName(), synced.TypeName())
 	}
 	return subs, nil
 }
 
-// If annotation elements are added or deleted, adjust the label counts.
+// If annotation elements are added or deleted or label sizes change, adjust the label counts.
 func (d *Data) processEvents() {
 	batcher, err := d.GetKeyValueBatcher()
 	if err != nil {
@@ -90,8 +120,10 @@ func (d *Data) processEvents() {
 			switch delta := msg.Delta.(type) {
 			case annotation.DeltaModifyElements:
 				d.modifyElements(ctx, delta, batcher)
+			case labels.DeltaNewSize, labels.DeltaDeleteSize, labels.DeltaModSize, labels.DeltaReplaceSize, labels.DeltaMergeEnd:
+				d.changeVoxels(ctx, delta, batcher)
 			default:
-				dvid.Criticalf("Cannot sync annotations from modify element.  Got unexpected delta: %v\n", msg)
+				dvid.Criticalf("Cannot sync labelsz %q.  Got unexpected delta: %v\n", d.DataName(), msg)
 			}
 			d.StopUpdate()
 
@@ -216,6 +248,105 @@ func (d *Data) modifyElements(ctx *datastore.VersionedCtx, delta annotation.Delt
 	}
 }
 
+// voxelCount returns the count stored for a label size, which is capped at the largest
+// count that can be ranked.
+func voxelCount(size uint64) uint32 {
+	if size >= math.MaxUint32 {
+		return math.MaxUint32 - 1
+	}
+	return uint32(size)
+}
+
+// returns the current voxel count of a label and whether it has one.
+func (d *Data) getVoxels(ctx *datastore.VersionedCtx, label uint64) (count uint32, found bool, err error) {
+	il := newIndexedLabel(Voxels, label)
+	counts, err := d.getCounts(ctx, map[indexedLabel]int32{il: 0})
+	if err != nil {
+		return
+	}
+	count, found = counts[il]
+	return
+}
+
+// setVoxels adds changes to the batch that replace a label's voxel count, where a size of
+// zero deletes the label's voxel count.
+func (d *Data) setVoxels(ctx *datastore.VersionedCtx, batch storage.Batch, label, size uint64) error {
+	count, found, err := d.getVoxels(ctx, label)
+	if err != nil {
+		return err
+	}
+	if found {
+		batch.Delete(NewTypeSizeLabelTKey(Voxels, count, label))
+	}
+	if size == 0 {
+		batch.Delete(NewTypeLabelTKey(Voxels, label))
+		return nil
+	}
+	newcount := voxelCount(size)
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, newcount)
+	batch.Put(NewTypeLabelTKey(Voxels, label), buf)
+	batch.Put(NewTypeSizeLabelTKey(Voxels, newcount, label), nil)
+	return nil
+}
+
+// changeVoxels adjusts the voxel counts of labels given a change in label sizes or the end
+// of a merge, after which merged labels no longer exist.
+func (d *Data) changeVoxels(ctx *datastore.VersionedCtx, delta interface{}, batcher storage.KeyValueBatcher) {
+	d.Lock()
+	defer d.Unlock()
+
+	var err error
+	batch := batcher.NewBatch(ctx)
+	switch delta := delta.(type) {
+	case labels.DeltaNewSize:
+		err = d.setVoxels(ctx, batch, delta.Label, delta.Size)
+	case labels.DeltaReplaceSize:
+		err = d.setVoxels(ctx, batch, delta.Label, delta.NewSize)
+	case labels.DeltaDeleteSize:
+		err = d.setVoxels(ctx, batch, delta.Label, 0)
+	case labels.DeltaMergeEnd:
+		for label := range delta.Merged {
+			if label == delta.Target {
+				continue
+			}
+			if err = d.setVoxels(ctx, batch, label, 0); err != nil {
+				break
+			}
+		}
+	case labels.DeltaModSize:
+		var count uint32
+		var found bool
+		count, found, err = d.getVoxels(ctx, delta.Label)
+		if err != nil {
+			break
+		}
+		if !found && delta.SizeChange > 0 {
+			// A label without a count that grows is new, e.g., ingested after syncing.
+			err = d.setVoxels(ctx, batch, delta.Label, uint64(delta.SizeChange))
+			break
+		}
+		if !found {
+			// We don't know the size the change applies to, e.g., labelsz wasn't reloaded.
+			dvid.Debugf("labelsz %q ignoring size change of %d for label %d without voxel count\n", d.DataName(), delta.SizeChange, delta.Label)
+			return
+		}
+		size := int64(count) + delta.SizeChange
+		if size < 0 {
+			dvid.Criticalf("labelsz %q received size change that would subtract %d with only count %d!  Setting floor at 0.\n", d.DataName(), -delta.SizeChange, count)
+			size = 0
+		}
+		err = d.setVoxels(ctx, batch, delta.Label, uint64(size))
+	}
+	if err != nil {
+		dvid.Errorf("labelsz %q couldn't get voxel counts for changed labels: %v\n", d.DataName(), err)
+		return
+	}
+	if err := batch.Commit(); err != nil {
+		dvid.Criticalf("bad commit in labelsz %q during sync of label sizes: %v\n", d.DataName(), err)
+	}
+}
+
 /*
 func (d *Data) syncSet(in <-chan datastore.SyncMessage, done <-chan struct{}) {
 	batcher, err := d.GetKeyValueBatcher()
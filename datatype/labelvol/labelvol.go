@@ -286,13 +286,23 @@ POST <api URL>/node/<UUID>/<data name>/merge
 	same toLabel as a single merge request instead of multiple merge requests.
 
 
-POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X]
+POST <api URL>/node/<UUID>/<data name>/split/<label>[?queryopts]
 
 	Splits a portion of a label's voxels into a new label or, if "splitlabel" is specified
 	as an optional query string, the given split label.  Returns the following JSON:
 
 		{ "label": <new label> }
 
+	If "relabel-disconnected" is true, the JSON also includes the new labels given to
+	disconnected components of the remaining label voxels:
+
+		{ "label": <new label>, "components": [ <new label 1>, <new label 2>, ...] }
+
+	The split is committed before the relabeling, so if the relabeling fails, the split
+	label is still returned with the error instead of the components:
+
+		{ "label": <new label>, "relabel-error": "<error message>" }
+
 	This request requires a binary sparse volume in the POSTed body with the following 
 	encoded RLE format, which is compatible with the format returned by a GET on the 
 	"sparsevol" endpoint described above:
@@ -321,7 +331,15 @@ POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X]
 	chain operations like "split-coarse" followed by "split" using voxels, where the new label
 	created by the split coarse is used as the split label for the smaller, higher-res "split".
 
-POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X]
+    Query-string Options:
+
+    splitlabel            Label to use for the split voxels instead of a new label.
+    relabel-disconnected  If "true", after the split, all but the largest connected component
+                            of the remaining label's voxels are given new labels as in the
+                            "split-components" endpoint.
+    connectivity          Either "6" (default) or "26" connectivity for "relabel-disconnected".
+
+POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?queryopts]
 
 	Splits a portion of a label's blocks into a new label or, if "splitlabel" is specified
 	as an optional query string, the given split label.  Returns the following JSON:
@@ -348,7 +366,27 @@ POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X]
 			  ...
 	        int32   Length of run
 
-	The Notes for "split" endpoint above are applicable to this "split-coarse" endpoint.
+	The Notes and query-string options for "split" endpoint above are applicable to this
+	"split-coarse" endpoint.
+
+POST <api URL>/node/<UUID>/<data name>/split-components/<label>[?connectivity=26]
+
+	Finds the connected components of a label's voxels across block boundaries and splits
+	all but the largest component into new labels.  Returns the new labels in JSON:
+
+		{ "labels": [ <new label 1>, <new label 2>, ...] }
+
+	The "labels" list is empty if the label's voxels are already connected.
+
+    Arguments:
+    UUID          Hexidecimal string with enough characters to uniquely identify a version node.
+    data name     Name of label data.
+    label         The label to be split into connected components.
+
+    Query-string Options:
+
+    connectivity  Either "6" (default) for face-connected voxels or "26" for face, edge, and
+                    corner-connected voxels.
 `
 
 var (
@@ -1198,7 +1236,7 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 		timedLog.Infof("HTTP maxlabel request (%s)", r.URL)
 
 	case "split":
-		// POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X]
+		// POST <api URL>/node/<UUID>/<data name>/split/<label>[?splitlabel=X&relabel-disconnected=true]
 		if action != "post" {
 			server.BadRequest(w, r, "Split requests must be POST actions.")
 			return
@@ -1225,17 +1263,25 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, "Bad parameter for 'splitlabel' query string (%q).  Must be uint64.\n", splitStr)
 			}
 		}
+		relabel := queryStrings.Get("relabel-disconnected") == "true"
+		connectivity, err := labels.ParseConnectivity(queryStrings.Get("connectivity"))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
 		toLabel, err := d.SplitLabels(ctx.VersionID(), fromLabel, splitLabel, r.Body)
 		if err != nil {
 			server.BadRequest(w, r, fmt.Sprintf("split: %v", err))
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+		if err := labels.WriteSplitResponse(w, d, ctx.VersionID(), fromLabel, toLabel, relabel, connectivity); err != nil {
+			dvid.Errorf("split of label %d into %d, data %q: %v\n", fromLabel, toLabel, d.DataName(), err)
+		}
 		timedLog.Infof("HTTP split request (%s)", r.URL)
 
 	case "split-coarse":
-		// POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X]
+		// POST <api URL>/node/<UUID>/<data name>/split-coarse/<label>[?splitlabel=X&relabel-disconnected=true]
 		if action != "post" {
 			server.BadRequest(w, r, "Split-coarse requests must be POST actions.")
 			return
@@ -1262,15 +1308,61 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 				server.BadRequest(w, r, "Bad parameter for 'splitlabel' query string (%q).  Must be uint64.\n", splitStr)
 			}
 		}
+		relabel := queryStrings.Get("relabel-disconnected") == "true"
+		connectivity, err := labels.ParseConnectivity(queryStrings.Get("connectivity"))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
 		toLabel, err := d.SplitCoarseLabels(ctx.VersionID(), fromLabel, splitLabel, r.Body)
 		if err != nil {
 			server.BadRequest(w, r, fmt.Sprintf("split-coarse: %v", err))
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, "{%q: %d}", "label", toLabel)
+		if err := labels.WriteSplitResponse(w, d, ctx.VersionID(), fromLabel, toLabel, relabel, connectivity); err != nil {
+			dvid.Errorf("split-coarse of label %d into %d, data %q: %v\n", fromLabel, toLabel, d.DataName(), err)
+		}
 		timedLog.Infof("HTTP split-coarse request (%s)", r.URL)
 
+	case "split-components":
+		// POST <api URL>/node/<UUID>/<data name>/split-components/<label>[?connectivity=26]
+		if action != "post" {
+			server.BadRequest(w, r, "Split-components requests must be POST actions.")
+			return
+		}
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "ERROR: DVID requires label ID to follow 'split-components' command")
+			return
+		}
+		label, err := strconv.ParseUint(parts[4], 10, 64)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if label == 0 {
+			server.BadRequest(w, r, "Label 0 is protected background value and cannot be split.\n")
+			return
+		}
+		connectivity, err := labels.ParseConnectivity(r.URL.Query().Get("connectivity"))
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		components, err := d.SplitComponents(ctx.VersionID(), label, connectivity)
+		if err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("split-components: %v", err))
+			return
+		}
+		jsonBytes, err := json.Marshal(components)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, "{%q: %s}", "labels", string(jsonBytes))
+		timedLog.Infof("HTTP split-components request (%s)", r.URL)
+
 	case "merge":
 		// POST <api URL>/node/<UUID>/<data name>/merge
 		if action != "post" {
@@ -1414,6 +1506,46 @@ func (d *Data) GetLabelRLEs(v dvid.VersionID, label uint64) (dvid.BlockRLEs, err
 	return labelRLEs, nil
 }
 
+// ProcessLabelSizes calls the given function with the number of voxels of each label
+// in the given version.
+func (d *Data) ProcessLabelSizes(v dvid.VersionID, f func(label, voxels uint64)) error {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return fmt.Errorf("Data type labelvol had error initializing store: %v\n", err)
+	}
+
+	// Keys are ordered by label so sum voxels until the label changes.
+	var curLabel, curVoxels uint64
+	var f2 storage.ChunkFunc = func(chunk *storage.Chunk) error {
+		label, _, err := DecodeTKey(chunk.K)
+		if err != nil {
+			return fmt.Errorf("Can't recover label with chunk key %v: %v\n", chunk.K, err)
+		}
+		var blockRLEs dvid.RLEs
+		if err := blockRLEs.UnmarshalBinary(chunk.V); err != nil {
+			return fmt.Errorf("Unable to unmarshal RLE for label in block %v", chunk.K)
+		}
+		if label != curLabel && curVoxels != 0 {
+			f(curLabel, curVoxels)
+			curVoxels = 0
+		}
+		curLabel = label
+		numVoxels, _ := blockRLEs.Stats()
+		curVoxels += numVoxels
+		return nil
+	}
+	ctx := datastore.NewVersionedCtx(d, v)
+	minTKey := storage.MinTKey(keyLabelBlockRLE)
+	maxTKey := storage.MaxTKey(keyLabelBlockRLE)
+	if err := store.ProcessRange(ctx, minTKey, maxTKey, &storage.ChunkOp{}, f2); err != nil {
+		return err
+	}
+	if curVoxels != 0 {
+		f(curLabel, curVoxels)
+	}
+	return nil
+}
+
 type sparseOp struct {
 	versionID dvid.VersionID
 	encoding  []byte
@@ -214,6 +214,18 @@ func (d *Data) asyncMergeLabels(v dvid.VersionID, m labels.MergeOp) {
 // labels.SplitEndEvent occurs at end of split and transmits labels.DeltaSplitEnd struct.
 //
 func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.ReadCloser) (toLabel uint64, err error) {
+	// Read the sparse volume from reader.
+	var split dvid.RLEs
+	split, err = dvid.ReadRLEs(r)
+	if err != nil {
+		return
+	}
+	return d.splitRLEs(v, fromLabel, splitLabel, split)
+}
+
+// splitRLEs splits the voxels given by the RLEs from a label into a given split label or,
+// if the given split label is 0, a new label, which is returned.
+func (d *Data) splitRLEs(v dvid.VersionID, fromLabel, splitLabel uint64, split dvid.RLEs) (toLabel uint64, err error) {
 	store, err := d.GetOrderedKeyValueDB()
 	if err != nil {
 		err = fmt.Errorf("Data type labelvol had error initializing store: %v\n", err)
@@ -253,12 +265,6 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 		return 0, err
 	}
 
-	// Read the sparse volume from reader.
-	var split dvid.RLEs
-	split, err = dvid.ReadRLEs(r)
-	if err != nil {
-		return
-	}
 	toLabelSize, _ := split.Stats()
 
 	// Partition the split spans into blocks.
@@ -331,16 +337,31 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 		return
 	}
 
-	// Write the split sparse vol.
-	if err = d.writeLabelVol(v, toLabel, splitmap, splitblks); err != nil {
-		return
+	// Write the split sparse vol, adding to any voxels a given split label already has.
+	var delta interface{}
+	if splitLabel != 0 {
+		var toLabelRLEs dvid.BlockRLEs
+		if toLabelRLEs, err = d.addLabelRLEs(ctx, toLabel, splitmap); err != nil {
+			return
+		}
+		if err = d.writeLabelVol(v, toLabel, toLabelRLEs, splitblks); err != nil {
+			return
+		}
+		delta = labels.DeltaModSize{
+			Label:      toLabel,
+			SizeChange: int64(toLabelSize),
+		}
+	} else {
+		if err = d.writeLabelVol(v, toLabel, splitmap, splitblks); err != nil {
+			return
+		}
+		delta = labels.DeltaNewSize{
+			Label: toLabel,
+			Size:  toLabelSize,
+		}
 	}
 
 	// Publish change in label sizes.
-	delta := labels.DeltaNewSize{
-		Label: toLabel,
-		Size:  toLabelSize,
-	}
 	evt = datastore.SyncEvent{d.DataUUID(), labels.ChangeSizeEvent}
 	msg = datastore.SyncMessage{labels.ChangeSizeEvent, v, delta}
 	if err = datastore.NotifySubscribers(evt, msg); err != nil {
@@ -367,6 +388,77 @@ func (d *Data) SplitLabels(v dvid.VersionID, fromLabel, splitLabel uint64, r io.
 	return toLabel, nil
 }
 
+// addLabelRLEs returns the union of the given block RLEs and the label's stored RLEs
+// in those blocks.
+func (d *Data) addLabelRLEs(ctx *datastore.VersionedCtx, label uint64, brles dvid.BlockRLEs) (dvid.BlockRLEs, error) {
+	store, err := d.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	added := make(dvid.BlockRLEs, len(brles))
+	for izyxStr, rles := range brles {
+		val, err := store.Get(ctx, NewTKey(label, izyxStr))
+		if err != nil {
+			return nil, err
+		}
+		if val == nil {
+			added[izyxStr] = rles
+			continue
+		}
+		var stored dvid.RLEs
+		if err := stored.UnmarshalBinary(val); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal RLE for label %d in block %s: %v", label, izyxStr, err)
+		}
+		stored.Add(rles)
+		added[izyxStr] = stored
+	}
+	return added, nil
+}
+
+// SplitComponents finds the connected components of a label's voxels using 6 or 26 connectivity
+// and splits all but the largest component into new labels, which are returned.  The label is
+// marked as splitting from the computation of components through the last split so no merge
+// can change it in between, and if any component can't be split, the components already split
+// are returned to the label.
+func (d *Data) SplitComponents(v dvid.VersionID, label uint64, connectivity int) ([]uint64, error) {
+	timedLog := dvid.NewTimeLog()
+
+	iv := d.getMergeIV(v)
+	if err := labels.SplitStart(iv, labels.DeltaSplitStart{label, label}); err != nil {
+		return nil, err
+	}
+	defer labels.SplitStop(iv, labels.DeltaSplitEnd{label, label})
+
+	brles, err := d.GetLabelRLEs(v, label)
+	if err != nil {
+		return nil, err
+	}
+	var rles dvid.RLEs
+	for _, blockRLEs := range brles {
+		rles = append(rles, blockRLEs...)
+	}
+	comps, err := labels.ConnectedComponents(rles, connectivity)
+	if err != nil {
+		return nil, err
+	}
+	if len(comps) < 2 {
+		return []uint64{}, nil
+	}
+	newLabels := make([]uint64, len(comps)-1)
+	for i, comp := range comps[1:] {
+		if newLabels[i], err = d.splitRLEs(v, label, 0, comp); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if _, rbErr := d.splitRLEs(v, newLabels[j], label, comps[j+1]); rbErr != nil {
+					dvid.Criticalf("unable to return split label %d to label %d, data %q: %v\n", newLabels[j], label, d.DataName(), rbErr)
+				}
+			}
+			return nil, err
+		}
+	}
+	timedLog.Infof("Split %d disconnected components from label %d, data %q", len(newLabels), label, d.DataName())
+	return newLabels, nil
+}
+
 // SplitCoarseLabels splits a portion of a label's voxels into a given split label or, if the given split
 // label is 0, a new label, which is returned.  The input is a binary sparse volume defined by block
 // coordinates and should be the smaller portion of a labeled region-to-be-split.
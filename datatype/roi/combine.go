@@ -0,0 +1,296 @@
+/*
+	This file supports set operations on ROIs computed directly on their block spans, with
+	voxel masks only combined for the blocks that have them.
+*/
+
+package roi
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Set operations for combining ROIs.
+const (
+	OpUnion        = "union"
+	OpIntersection = "intersection"
+	OpDifference   = "difference"
+)
+
+// CombineRequest is the JSON payload for a POST /combine request.
+type CombineRequest struct {
+	Op   string
+	ROIs []string // ROI specifications of the form "<roiname>,<uuid>"
+}
+
+// returns true if span s is in a (z, y) row before that of span s2.
+func rowLess(s, s2 dvid.Span) bool {
+	if s[0] != s2[0] {
+		return s[0] < s2[0]
+	}
+	return s[1] < s2[1]
+}
+
+// returns the end index of the row of normalized spans starting at index i.
+func rowEnd(spans dvid.Spans, i int) int {
+	end := i + 1
+	for end < len(spans) && spans[end][0] == spans[i][0] && spans[end][1] == spans[i][1] {
+		end++
+	}
+	return end
+}
+
+// combineRows applies a function to each (z, y) row of spans present in either of two
+// normalized span lists, where the span lists passed to the function are empty if a row
+// is absent.  The rows of returned spans are concatenated in order.
+func combineRows(a, b dvid.Spans, f func(rowA, rowB dvid.Spans) dvid.Spans) dvid.Spans {
+	out := dvid.Spans{}
+	var i, j int
+	for i < len(a) || j < len(b) {
+		var rowA, rowB dvid.Spans
+		switch {
+		case j == len(b) || (i < len(a) && rowLess(a[i], b[j])):
+			end := rowEnd(a, i)
+			rowA, i = a[i:end], end
+		case i == len(a) || rowLess(b[j], a[i]):
+			end := rowEnd(b, j)
+			rowB, j = b[j:end], end
+		default:
+			endA, endB := rowEnd(a, i), rowEnd(b, j)
+			rowA, rowB, i, j = a[i:endA], b[j:endB], endA, endB
+		}
+		out = append(out, f(rowA, rowB)...)
+	}
+	return out
+}
+
+// UnionSpans returns the normalized spans covering blocks in either span list.
+func UnionSpans(a, b dvid.Spans) dvid.Spans {
+	all := make(dvid.Spans, 0, len(a)+len(b))
+	all = append(all, a...)
+	all = append(all, b...)
+	return all.Normalize()
+}
+
+// IntersectSpans returns the normalized spans covering blocks in both span lists.
+func IntersectSpans(a, b dvid.Spans) dvid.Spans {
+	return combineRows(a.Normalize(), b.Normalize(), func(rowA, rowB dvid.Spans) dvid.Spans {
+		var out dvid.Spans
+		var i, j int
+		for i < len(rowA) && j < len(rowB) {
+			x0 := dvid.MaxInt32(rowA[i][2], rowB[j][2])
+			x1 := dvid.MinInt32(rowA[i][3], rowB[j][3])
+			if x0 <= x1 {
+				out = append(out, dvid.Span{rowA[i][0], rowA[i][1], x0, x1})
+			}
+			if rowA[i][3] < rowB[j][3] {
+				i++
+			} else {
+				j++
+			}
+		}
+		return out
+	})
+}
+
+// SubtractSpans returns the normalized spans covering blocks in span list a but not in b.
+func SubtractSpans(a, b dvid.Spans) dvid.Spans {
+	return combineRows(a.Normalize(), b.Normalize(), func(rowA, rowB dvid.Spans) dvid.Spans {
+		var out dvid.Spans
+		j := 0
+		for _, span := range rowA {
+			x0 := span[2]
+			for j < len(rowB) && rowB[j][3] < x0 {
+				j++
+			}
+			for k := j; k < len(rowB) && rowB[k][2] <= span[3]; k++ {
+				if rowB[k][2] > x0 {
+					out = append(out, dvid.Span{span[0], span[1], x0, rowB[k][2] - 1})
+				}
+				x0 = rowB[k][3] + 1
+			}
+			if x0 <= span[3] {
+				out = append(out, dvid.Span{span[0], span[1], x0, span[3]})
+			}
+		}
+		return out
+	})
+}
+
+// CombineSpans applies a set operation across a list of span lists.  For a difference,
+// the spans in all but the first list are subtracted from the first list.
+func CombineSpans(op string, spansList []dvid.Spans) (dvid.Spans, error) {
+	if len(spansList) == 0 {
+		return nil, fmt.Errorf("at least one ROI must be given to combine")
+	}
+	result := spansList[0].Normalize()
+	for _, spans := range spansList[1:] {
+		switch op {
+		case OpUnion:
+			result = UnionSpans(result, spans)
+		case OpIntersection:
+			result = IntersectSpans(result, spans)
+		case OpDifference:
+			result = SubtractSpans(result, spans)
+		default:
+			return nil, fmt.Errorf("unknown ROI combine operation %q", op)
+		}
+	}
+	return result, nil
+}
+
+// maskedSpans are the normalized block spans of an ROI and the voxel masks of its blocks
+// that are only partially within the ROI.
+type maskedSpans struct {
+	spans dvid.Spans
+	masks VoxelMasks
+}
+
+// returns true if the block is within the normalized spans.
+func spansContain(spans dvid.Spans, bcoord dvid.ChunkPoint3d) bool {
+	i := sort.Search(len(spans), func(i int) bool {
+		s := spans[i]
+		if s[0] != bcoord[2] {
+			return s[0] > bcoord[2]
+		}
+		if s[1] != bcoord[1] {
+			return s[1] > bcoord[1]
+		}
+		return s[3] >= bcoord[0]
+	})
+	return i < len(spans) && spans[i][0] == bcoord[2] && spans[i][1] == bcoord[1] && spans[i][2] <= bcoord[0]
+}
+
+// returns whether each voxel of a block, in ZYX order, is within the ROI.  Blocks in the
+// spans without a voxel mask are completely within the ROI.
+func (m maskedSpans) blockVoxels(izyx dvid.IZYXString, bcoord dvid.ChunkPoint3d, blockSize dvid.Point3d) []bool {
+	if mask, found := m.masks[izyx]; found {
+		return blockVoxels(bcoord, blockSize, mask)
+	}
+	inside := make([]bool, blockSize.Prod())
+	if spansContain(m.spans, bcoord) {
+		for i := range inside {
+			inside[i] = true
+		}
+	}
+	return inside
+}
+
+// combineMasked applies a set operation to two ROIs with voxel masks.  The block spans are
+// combined as for ROIs without masks, except a difference only removes blocks completely
+// within the second ROI.  The voxels of each block with a mask in either ROI are then
+// combined, dropping blocks left empty and masks of blocks left full.
+func combineMasked(op string, a, b maskedSpans, blockSize dvid.Point3d) (maskedSpans, error) {
+	var spans dvid.Spans
+	switch op {
+	case OpUnion:
+		spans = UnionSpans(a.spans, b.spans)
+	case OpIntersection:
+		spans = IntersectSpans(a.spans, b.spans)
+	case OpDifference:
+		partial := make(dvid.Spans, 0, len(b.masks))
+		for izyx := range b.masks {
+			bcoord, err := izyx.ToChunkPoint3d()
+			if err != nil {
+				return maskedSpans{}, err
+			}
+			partial = append(partial, dvid.Span{bcoord[2], bcoord[1], bcoord[0], bcoord[0]})
+		}
+		spans = SubtractSpans(a.spans, SubtractSpans(b.spans, partial))
+	default:
+		return maskedSpans{}, fmt.Errorf("unknown ROI combine operation %q", op)
+	}
+	if len(a.masks) == 0 && len(b.masks) == 0 {
+		return maskedSpans{spans, nil}, nil
+	}
+	blocks := make(map[dvid.IZYXString]struct{}, len(a.masks)+len(b.masks))
+	for izyx := range a.masks {
+		blocks[izyx] = struct{}{}
+	}
+	for izyx := range b.masks {
+		blocks[izyx] = struct{}{}
+	}
+	masks := make(VoxelMasks)
+	var empty dvid.Spans
+	for izyx := range blocks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return maskedSpans{}, err
+		}
+		if !spansContain(spans, bcoord) {
+			continue
+		}
+		inside := a.blockVoxels(izyx, bcoord, blockSize)
+		insideB := b.blockVoxels(izyx, bcoord, blockSize)
+		for i := range inside {
+			switch op {
+			case OpUnion:
+				inside[i] = inside[i] || insideB[i]
+			case OpIntersection:
+				inside[i] = inside[i] && insideB[i]
+			case OpDifference:
+				inside[i] = inside[i] && !insideB[i]
+			}
+		}
+		mask, full := insideRLEs(bcoord, blockSize, inside)
+		switch {
+		case full:
+		case len(mask) == 0:
+			empty = append(empty, dvid.Span{bcoord[2], bcoord[1], bcoord[0], bcoord[0]})
+		default:
+			masks[izyx] = mask
+		}
+	}
+	if len(empty) != 0 {
+		spans = SubtractSpans(spans, empty)
+	}
+	return maskedSpans{spans, masks}, nil
+}
+
+// Combine computes a set operation on the ROIs given by "<roiname>,<uuid>" specifications
+// and stores the result as the ROI at the given version, replacing any previous spans and
+// voxel masks.  Voxel masks of the ROIs are combined per block, where a block without a
+// mask is completely within its ROI.  All ROIs must have the same block size as the receiver.
+func (d *Data) Combine(v dvid.VersionID, op string, specs []string) (dvid.Spans, error) {
+	switch op {
+	case OpUnion, OpIntersection, OpDifference:
+	default:
+		return nil, fmt.Errorf("unknown ROI combine operation %q", op)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("at least one ROI must be given to combine")
+	}
+	var result maskedSpans
+	for i, spec := range specs {
+		src, srcV, found, err := DataBySpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("unable to find ROI %q", spec)
+		}
+		if !src.BlockSize.Equals(d.BlockSize) {
+			return nil, fmt.Errorf("ROI %q has block size %s, which differs from %q block size %s", spec, src.BlockSize, d.DataName(), d.BlockSize)
+		}
+		spans, err := src.GetSpans(srcV)
+		if err != nil {
+			return nil, err
+		}
+		masks, err := src.GetVoxelMasks(srcV)
+		if err != nil {
+			return nil, err
+		}
+		operand := maskedSpans{dvid.Spans(spans).Normalize(), masks}
+		if i == 0 {
+			result = operand
+		} else if result, err = combineMasked(op, result, operand, d.BlockSize); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.putSpans(v, result.spans, true, result.masks); err != nil {
+		return nil, err
+	}
+	return result.spans, nil
+}
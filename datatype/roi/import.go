@@ -0,0 +1,252 @@
+/*
+	This file supports creating ROIs from closed meshes and from the block indices of labels.
+*/
+
+package roi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// coarseSparseVolGetter is implemented by label data, e.g., labelarray, that can return the
+// blocks containing a label as a coarse sparse volume.
+type coarseSparseVolGetter interface {
+	BlockSize() dvid.Point
+	GetSparseCoarseVol(ctx *datastore.VersionedCtx, label uint64, bounds dvid.Bounds) ([]byte, error)
+}
+
+// PutLabelBlocks replaces the ROI at the given version with the blocks containing a label
+// in a labelarray instance, which must have the same block size as the ROI.
+func (d *Data) PutLabelBlocks(v dvid.VersionID, labelName dvid.InstanceName, label uint64) error {
+	labelData, err := datastore.GetDataByVersionName(v, labelName)
+	if err != nil {
+		return err
+	}
+	getter, ok := labelData.(coarseSparseVolGetter)
+	if !ok {
+		return fmt.Errorf("data %q does not support block indices of labels", labelName)
+	}
+	if blockSize, ok := getter.BlockSize().(dvid.Point3d); !ok || !blockSize.Equals(d.BlockSize) {
+		return fmt.Errorf("data %q has block size %s, which differs from ROI %q block size %s", labelName, getter.BlockSize(), d.DataName(), d.BlockSize)
+	}
+	encoding, err := getter.GetSparseCoarseVol(datastore.NewVersionedCtx(labelData, v), label, dvid.Bounds{})
+	if err != nil {
+		return err
+	}
+	var rles dvid.RLEs
+	if len(encoding) != 0 {
+		if rles, err = dvid.ReadRLEs(bytes.NewReader(encoding)); err != nil {
+			return err
+		}
+	}
+	if len(rles) == 0 {
+		return fmt.Errorf("label %d not found in data %q", label, labelName)
+	}
+	spans := make(dvid.Spans, len(rles))
+	for i, rle := range rles {
+		start := rle.StartPt()
+		spans[i] = dvid.Span{start[2], start[1], start[0], start[0] + rle.Length() - 1}
+	}
+	return d.PutSpans(v, spans.Normalize(), true)
+}
+
+// MaxMeshCoord is the largest allowed magnitude of a mesh vertex coordinate in voxels.
+const MaxMeshCoord = 1 << 17
+
+type meshVertex [3]float64
+
+type meshTriangle [3]meshVertex
+
+// parses the vertices and faces of a Wavefront OBJ file, triangulating polygonal faces.
+func readOBJ(r io.Reader) ([]meshTriangle, error) {
+	var vertices []meshVertex
+	var triangles []meshTriangle
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("OBJ line %d: vertex must have 3 coordinates", lineNum)
+			}
+			var vertex meshVertex
+			for i := 0; i < 3; i++ {
+				var err error
+				if vertex[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+					return nil, fmt.Errorf("OBJ line %d: bad vertex coordinate %q", lineNum, fields[i+1])
+				}
+				if !(math.Abs(vertex[i]) <= MaxMeshCoord) {
+					return nil, fmt.Errorf("OBJ line %d: vertex coordinate %q outside allowed extent of +/- %d", lineNum, fields[i+1], MaxMeshCoord)
+				}
+			}
+			vertices = append(vertices, vertex)
+		case "f":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("OBJ line %d: face must have at least 3 vertices", lineNum)
+			}
+			face := make([]meshVertex, len(fields)-1)
+			for i, field := range fields[1:] {
+				// Vertex references can be "v", "v/vt", "v/vt/vn", or "v//vn".
+				index, err := strconv.Atoi(strings.Split(field, "/")[0])
+				if err != nil {
+					return nil, fmt.Errorf("OBJ line %d: bad face vertex %q", lineNum, field)
+				}
+				if index < 0 {
+					index += len(vertices) + 1 // negative indices are relative to the end
+				}
+				if index < 1 || index > len(vertices) {
+					return nil, fmt.Errorf("OBJ line %d: face vertex %d out of range", lineNum, index)
+				}
+				face[i] = vertices[index-1]
+			}
+			for i := 2; i < len(face); i++ {
+				triangles = append(triangles, meshTriangle{face[0], face[i-1], face[i]})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return triangles, nil
+}
+
+// returns the edge between two vertices with the vertices in a canonical order.
+func newMeshEdge(v0, v1 meshVertex) [2]meshVertex {
+	for i := 0; i < 3; i++ {
+		if v0[i] != v1[i] {
+			if v0[i] > v1[i] {
+				v0, v1 = v1, v0
+			}
+			break
+		}
+	}
+	return [2]meshVertex{v0, v1}
+}
+
+// returns an error unless the mesh is watertight, i.e., every edge is shared by exactly two
+// triangles.  Vertices are identified by their coordinates.
+func checkWatertight(triangles []meshTriangle) error {
+	edges := make(map[[2]meshVertex]int)
+	for _, tri := range triangles {
+		for i := 0; i < 3; i++ {
+			v0, v1 := tri[i], tri[(i+1)%3]
+			if v0 != v1 {
+				edges[newMeshEdge(v0, v1)]++
+			}
+		}
+	}
+	for edge, n := range edges {
+		if n != 2 {
+			return fmt.Errorf("mesh is not closed: edge from %v to %v is shared by %d faces instead of 2", edge[0], edge[1], n)
+		}
+	}
+	return nil
+}
+
+// returns the x coordinate where a ray parallel to the x axis through (y, z) crosses the
+// triangle and whether it crosses.
+func (tri meshTriangle) crossX(y, z float64) (float64, bool) {
+	// Barycentric coordinates of (y, z) in the triangle projected onto the yz plane.
+	y0, z0 := tri[0][1], tri[0][2]
+	y1, z1 := tri[1][1], tri[1][2]
+	y2, z2 := tri[2][1], tri[2][2]
+	det := (y1-y0)*(z2-z0) - (y2-y0)*(z1-z0)
+	if det == 0 {
+		return 0, false // triangle is parallel to ray
+	}
+	b1 := ((y-y0)*(z2-z0) - (y2-y0)*(z-z0)) / det
+	b2 := ((y1-y0)*(z-z0) - (y-y0)*(z1-z0)) / det
+	b0 := 1 - b1 - b2
+	if b0 < 0 || b1 < 0 || b2 < 0 {
+		return 0, false
+	}
+	return b0*tri[0][0] + b1*tri[1][0] + b2*tri[2][0], true
+}
+
+// Offset of rays from block centers so rays are unlikely to pass exactly through mesh
+// edges or vertices, which are typically at integer or half-integer voxel coordinates.
+const rayJitter = 1e-4 * math.Pi
+
+// MeshSpans returns the block spans of the blocks whose centers are inside a closed mesh
+// given as a Wavefront OBJ file with vertex coordinates in voxels, which must be within
+// +/- MaxMeshCoord.  An error is returned if the mesh is not closed, i.e., if any edge is
+// not shared by exactly two faces.  Insideness is determined by counting the crossings of
+// the mesh by rays along x through block centers.
+func MeshSpans(r io.Reader, blockSize dvid.Point3d) (dvid.Spans, error) {
+	triangles, err := readOBJ(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(triangles) == 0 {
+		return nil, fmt.Errorf("no faces found in OBJ mesh")
+	}
+	if err := checkWatertight(triangles); err != nil {
+		return nil, err
+	}
+	bx, by, bz := float64(blockSize[0]), float64(blockSize[1]), float64(blockSize[2])
+
+	// For each row of blocks along x, find the x coordinates of crossings through the row's
+	// block centers.
+	crossings := make(map[[2]int32][]float64) // (block y, block z) -> crossings
+	for _, tri := range triangles {
+		minY, maxY := math.Min(tri[0][1], math.Min(tri[1][1], tri[2][1])), math.Max(tri[0][1], math.Max(tri[1][1], tri[2][1]))
+		minZ, maxZ := math.Min(tri[0][2], math.Min(tri[1][2], tri[2][2])), math.Max(tri[0][2], math.Max(tri[1][2], tri[2][2]))
+		minBlockY, maxBlockY := int32(math.Ceil((minY-rayJitter)/by-0.5)), int32(math.Floor((maxY-rayJitter)/by-0.5))
+		minBlockZ, maxBlockZ := int32(math.Ceil((minZ-rayJitter)/bz-0.5)), int32(math.Floor((maxZ-rayJitter)/bz-0.5))
+		for blockZ := minBlockZ; blockZ <= maxBlockZ; blockZ++ {
+			for blockY := minBlockY; blockY <= maxBlockY; blockY++ {
+				y := (float64(blockY)+0.5)*by + rayJitter
+				z := (float64(blockZ)+0.5)*bz + rayJitter
+				if x, crosses := tri.crossX(y, z); crosses {
+					row := [2]int32{blockY, blockZ}
+					crossings[row] = append(crossings[row], x)
+				}
+			}
+		}
+	}
+
+	// Blocks with centers between pairs of crossings are inside the mesh.
+	var spans dvid.Spans
+	for row, xs := range crossings {
+		if len(xs)%2 != 0 {
+			return nil, fmt.Errorf("mesh is not closed: odd number of crossings along block row y %d, z %d", row[0], row[1])
+		}
+		sort.Float64s(xs)
+		for i := 0; i < len(xs); i += 2 {
+			x0 := int32(math.Ceil(xs[i]/bx - 0.5))
+			x1 := int32(math.Floor(xs[i+1]/bx - 0.5))
+			if x0 <= x1 {
+				spans = append(spans, dvid.Span{row[1], row[0], x0, x1})
+			}
+		}
+	}
+	return spans.Normalize(), nil
+}
+
+// PutMesh replaces the ROI at the given version with the blocks inside a closed mesh given
+// as a Wavefront OBJ file.
+func (d *Data) PutMesh(v dvid.VersionID, r io.Reader) (dvid.Spans, error) {
+	spans, err := MeshSpans(r, d.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.PutSpans(v, spans, true); err != nil {
+		return nil, err
+	}
+	return spans, nil
+}
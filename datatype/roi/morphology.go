@@ -0,0 +1,105 @@
+/*
+	This file supports morphological erosion and dilation of ROIs at block granularity.
+*/
+
+package roi
+
+import (
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/dvid"
+)
+
+// Shapes of structuring elements for erosion and dilation.
+const (
+	ShapeCube   = "cube"
+	ShapeSphere = "sphere"
+)
+
+// MaxElementSize is the largest allowed radius in blocks of a structuring element.
+const MaxElementSize = 32
+
+// structuringRow is the extent along x, from -halfWidth to +halfWidth, of a structuring
+// element at a (z, y) offset from its center.
+type structuringRow struct {
+	dz, dy    int32
+	halfWidth int32
+}
+
+// returns the rows of a structuring element with the given shape and radius in blocks.
+func structuringElement(shape string, radius int32) ([]structuringRow, error) {
+	if radius < 0 || radius > MaxElementSize {
+		return nil, fmt.Errorf("structuring element size must be between 0 and %d, not %d", MaxElementSize, radius)
+	}
+	var rows []structuringRow
+	for dz := -radius; dz <= radius; dz++ {
+		for dy := -radius; dy <= radius; dy++ {
+			switch shape {
+			case ShapeCube:
+				rows = append(rows, structuringRow{dz, dy, radius})
+			case ShapeSphere:
+				r2 := radius*radius - dz*dz - dy*dy
+				if r2 < 0 {
+					continue
+				}
+				var halfWidth int32
+				for (halfWidth+1)*(halfWidth+1) <= r2 {
+					halfWidth++
+				}
+				rows = append(rows, structuringRow{dz, dy, halfWidth})
+			default:
+				return nil, fmt.Errorf("unknown structuring element shape %q", shape)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// DilateSpans returns the normalized spans of an ROI dilated by a structuring element of the
+// given shape and radius in blocks.
+func DilateSpans(spans dvid.Spans, shape string, radius int32) (dvid.Spans, error) {
+	element, err := structuringElement(shape, radius)
+	if err != nil {
+		return nil, err
+	}
+	dilated := make(dvid.Spans, 0, len(spans)*len(element))
+	for _, span := range spans {
+		for _, row := range element {
+			dilated = append(dilated, dvid.Span{span[0] + row.dz, span[1] + row.dy, span[2] - row.halfWidth, span[3] + row.halfWidth})
+		}
+	}
+	return dilated.Normalize(), nil
+}
+
+// ErodeSpans returns the normalized spans of an ROI eroded by a structuring element of the
+// given shape and radius in blocks.  A block remains if every block covered by the
+// structuring element centered on it is within the ROI.
+func ErodeSpans(spans dvid.Spans, shape string, radius int32) (dvid.Spans, error) {
+	element, err := structuringElement(shape, radius)
+	if err != nil {
+		return nil, err
+	}
+	norm := spans.Normalize()
+
+	// For each row of the structuring element, find the blocks whose row at that offset
+	// covers the structuring element's x extent, and intersect across all rows.
+	var eroded dvid.Spans
+	for i, row := range element {
+		shifted := make(dvid.Spans, 0, len(norm))
+		for _, span := range norm {
+			x0, x1 := span[2]+row.halfWidth, span[3]-row.halfWidth
+			if x0 <= x1 {
+				shifted = append(shifted, dvid.Span{span[0] - row.dz, span[1] - row.dy, x0, x1})
+			}
+		}
+		if i == 0 {
+			eroded = shifted
+		} else {
+			eroded = IntersectSpans(eroded, shifted)
+		}
+		if len(eroded) == 0 {
+			break
+		}
+	}
+	return eroded.Normalize(), nil
+}
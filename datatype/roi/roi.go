@@ -4,6 +4,7 @@
 package roi
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
@@ -116,6 +117,48 @@ DEL  <api URL>/node/<UUID>/<data name>/roi
     UUID          Hexidecimal string with enough characters to uniquely identify a version node.
     data name     Name of ROI data to save/modify or get.
 
+GET  <api URL>/node/<UUID>/<data name>/voxels
+POST <api URL>/node/<UUID>/<data name>/voxels[?data=<label data name>&label=<label>]
+
+    Gets or replaces the ROI with voxel precision.  Blocks that are only partially within
+    the ROI are stored with voxel masks in addition to the block spans of the ROI.  The
+    mask and ptquery endpoints as well as ROI queries of other data types, e.g., annotation,
+    honor the voxel masks.  The combine endpoint combines voxel masks block by block, while
+    the erode and dilate endpoints reject ROIs with voxel masks.  Posting block spans to the
+    /roi endpoint removes all voxel masks.
+
+    The payload for both GET and POST is the "legacy RLE" sparse volume encoding returned by
+    the labelarray sparsevol endpoint, where integers are little endian:
+
+        byte     Payload descriptor, set to 0
+        uint8    Number of dimensions (3)
+        uint8    Dimension of run (0 = X)
+        byte     Reserved
+        uint32   # Voxels
+        uint32   # Spans
+        Repeating unit of:
+            int32   Coordinate of run start (dimension 0)
+            int32   Coordinate of run start (dimension 1)
+            int32   Coordinate of run start (dimension 2)
+            int32   Length of run
+
+    If the "data" and "label" query strings are given for a POST, the ROI is instead created
+    from the sparse volume of the label in the given labelarray data at the same version.
+
+POST <api URL>/node/<UUID>/<data name>/from-mesh
+
+    Replaces the ROI with the blocks inside a closed mesh.  The POSTed mesh is a Wavefront
+    OBJ file with vertex coordinates in voxels, where only vertex ("v") and face ("f") lines
+    are used.  Vertex coordinates must be within +/- 131072 voxels.  A block is within the
+    ROI if its center is inside the mesh.  A mesh that is not closed, i.e., where some edge
+    is not shared by exactly two faces, returns an error.  Vertices are matched by coordinates.
+
+POST <api URL>/node/<UUID>/<data name>/from-label?data=<label data name>&label=<label>
+
+    Replaces the ROI with the blocks containing the given label in labelarray data, which
+    must have the same block size as the ROI.  The blocks are obtained from the label's
+    block index at the same version.
+
 GET <api URL>/node/<UUID>/<data name>/mask/0_1_2/<size>/<offset>
 
 	Returns a binary volume in ZYX order (increasing X is contiguous in array) same as format of
@@ -159,11 +202,33 @@ GET <api URL>/node/<UUID>/<data name>/partition?batchsize=8
     optimized   If "true" or "on", partioning returns non-fixed sized subvolumes where the coverage
                   is better in terms of subvolumes having more active blocks.
 
-TODO (API endpoints that are planned in near future)
+POST <api URL>/node/<UUID>/<data name>/combine
 
-GET  <api URL>/node/<UUID>/<data name>/erode/<element size>
+	Replaces this ROI at the given version with the union, intersection, or difference of
+	other ROIs.  The operation is computed on the block spans of the ROIs, which must all
+	have the same block size as this ROI, and on the voxel masks of blocks only partially
+	within an ROI, where a block without a mask is completely within its ROI.  The POSTed JSON gives the operation and a list
+	of ROIs, each specified as "<roiname>,<uuid>":
+
+	{
+		"Op": "difference",
+		"ROIs": ["medulla,3f8c", "badslab,3f8c"]
+	}
 
-    Returns a ROI that has been eroded with a cubic structuring element of the given size.
+	Op is one of "union", "intersection", or "difference".  For a difference, the union of
+	all but the first ROI is subtracted from the first ROI.  The ROI being written can also
+	be given as one of the operands.
+
+GET  <api URL>/node/<UUID>/<data name>/erode/<element size>[?options]
+POST <api URL>/node/<UUID>/<data name>/erode/<element size>?dest=<roi name>[&options]
+GET  <api URL>/node/<UUID>/<data name>/dilate/<element size>[?options]
+POST <api URL>/node/<UUID>/<data name>/dilate/<element size>?dest=<roi name>[&options]
+
+    Erodes or dilates the ROI at block granularity with a structuring element whose radius
+    is the given element size in blocks, which can be at most 32.  A GET returns JSON of the resulting spans in the
+    same format as the /roi endpoint.  A POST stores the resulting spans into the destination
+    ROI at the same version, replacing any previous spans.  The destination ROI must have the
+    same block size and can be this ROI.  ROIs with voxel masks can't be eroded or dilated.
 
     Example: 
 
@@ -171,6 +236,11 @@ GET  <api URL>/node/<UUID>/<data name>/erode/<element size>
 
     This returns JSON for an ROI that has been eroded by 1 block.
 
+    Query-string Options:
+
+    shape       Shape of the structuring element, either "cube" (default) or "sphere".
+    dest        Name of the ROI where the result is stored.  Required for POST.
+
 `
 
 func init() {
@@ -255,12 +325,13 @@ type Immutable struct {
 	version   dvid.VersionID
 	blockSize dvid.Point3d
 	blocks    map[dvid.IZYXString]struct{}
+	masks     VoxelMasks
 }
 
 func (i Immutable) VoxelWithin(p dvid.Point3d) bool {
 	izyx := p.ToBlockIZYXString(i.blockSize)
 	_, found := i.blocks[izyx]
-	return found
+	return found && !i.masks.Excludes(p, i.blockSize)
 }
 
 // ImmutableBySpec returns an Immutable ROI (or nil if not available) given
@@ -279,12 +350,17 @@ func ImmutableBySpec(spec string) (*Immutable, error) {
 	if err != nil {
 		return nil, err
 	}
+	masks, err := d.GetVoxelMasks(v)
+	if err != nil {
+		return nil, err
+	}
 
 	// Setup the immutable.
 	im := Immutable{
 		version:   v,
 		blockSize: d.BlockSize,
 		blocks:    make(map[dvid.IZYXString]struct{}),
+		masks:     masks,
 	}
 	for _, span := range spans {
 		z, y, x0, x1 := span[0], span[1], span[2], span[3]
@@ -471,6 +547,9 @@ const (
 
 	// keyROI are keys for ROI RLEs
 	keyROI = 90
+
+	// keyVoxelMask are keys for voxel RLEs of blocks partially within an ROI
+	keyVoxelMask = 91
 )
 
 var (
@@ -623,6 +702,11 @@ func (d *Data) Delete(ctx storage.VersionedCtx) error {
 // If the init parameter is true, all previous spans of this ROI are deleted before
 // writing these spans.
 func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool) error {
+	return d.putSpans(versionID, spans, init, nil)
+}
+
+// puts spans and any voxel masks, only marking the version as ready after all are stored.
+func (d *Data) putSpans(versionID dvid.VersionID, spans []dvid.Span, init bool, masks VoxelMasks) error {
 	ctx := datastore.NewVersionedCtx(d, versionID)
 	db, err := d.GetOrderedKeyValueDB()
 	if err != nil {
@@ -659,6 +743,7 @@ func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool)
 	// Put the new key/values
 	const BATCH_SIZE = 10000
 	batch := batcher.NewBatch(ctx)
+	var numPut int
 	for i, span := range spans {
 		if span[0] < d.MinZ {
 			d.MinZ = span[0]
@@ -675,14 +760,31 @@ func (d *Data) PutSpans(versionID dvid.VersionID, spans []dvid.Span, init bool)
 		}
 		tk := storage.NewTKey(keyROI, index.Bytes())
 		batch.Put(tk, dvid.EmptyValue())
-		if (i+1)%BATCH_SIZE == 0 {
+		if numPut++; numPut%BATCH_SIZE == 0 {
 			if err := batch.Commit(); err != nil {
 				return fmt.Errorf("Error on batch PUT at span %d: %v\n", i, err)
 			}
 			batch = batcher.NewBatch(ctx)
 		}
 	}
-	if len(spans)%BATCH_SIZE != 0 {
+	for izyx, mask := range masks {
+		maskBytes, err := mask.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		serialization, err := dvid.SerializeData(maskBytes, d.Compression(), d.Checksum())
+		if err != nil {
+			return err
+		}
+		batch.Put(newVoxelMaskTKey(izyx), serialization)
+		if numPut++; numPut%BATCH_SIZE == 0 {
+			if err := batch.Commit(); err != nil {
+				return fmt.Errorf("Error on batch PUT of voxel masks: %v\n", err)
+			}
+			batch = batcher.NewBatch(ctx)
+		}
+	}
+	if numPut%BATCH_SIZE != 0 {
 		if err := batch.Commit(); err != nil {
 			return fmt.Errorf("Error on last batch PUT: %v\n", err)
 		}
@@ -778,6 +880,45 @@ func (d *Data) GetMask(ctx *datastore.VersionedCtx, subvol *dvid.Subvolume) ([]b
 			}
 		}
 	}
+
+	// Clear voxels outside the voxel masks of blocks partially within the ROI.
+	masks, err := d.getVoxelMasks(ctx, minBlockZ, maxBlockZ)
+	if err != nil {
+		return nil, err
+	}
+	for izyx, rles := range masks {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return nil, err
+		}
+		if bcoord[1] < minBlockY || bcoord[1] > maxBlockY || bcoord[0] < minBlockX || bcoord[0] > maxBlockX {
+			continue
+		}
+		x0, x1 := voxelRange(d.BlockSize[0], bcoord[0], bcoord[0], pt0.Value(0), pt1.Value(0))
+		y0, y1 := voxelRange(d.BlockSize[1], bcoord[1], bcoord[1], pt0.Value(1), pt1.Value(1))
+		z0, z1 := voxelRange(d.BlockSize[2], bcoord[2], bcoord[2], pt0.Value(2), pt1.Value(2))
+		for z := z0; z <= z1; z++ {
+			for y := y0; y <= y1; y++ {
+				i := z*nxy + y*nx + x0
+				for x := x0; x <= x1; x++ {
+					data[i] = 0
+					i++
+				}
+			}
+		}
+		for _, rle := range rles {
+			start := rle.StartPt()
+			y, z := start[1]-pt0.Value(1), start[2]-pt0.Value(2)
+			if y < y0 || y > y1 || z < z0 || z > z1 {
+				continue
+			}
+			rx0 := dvid.MaxInt32(start[0]-pt0.Value(0), x0)
+			rx1 := dvid.MinInt32(start[0]+rle.Length()-1-pt0.Value(0), x1)
+			for x := rx0; x <= rx1; x++ {
+				data[z*nxy+y*nx+x] = 1
+			}
+		}
+	}
 	return data, nil
 }
 
@@ -821,13 +962,33 @@ func (d *Data) PointQuery(ctx *datastore.VersionedCtx, jsonBytes []byte) ([]byte
 		return nil, err
 	}
 
+	var voxels []dvid.Point3d
+	if err := json.Unmarshal(jsonBytes, &voxels); err != nil {
+		return nil, err
+	}
+
 	// Iterate through each query point, using the ordering to make the search more efficient.
+	// Points in blocks with voxel masks are checked against the mask.
 	inclusions := make([]bool, len(list.Points))
+	masks := make(map[dvid.IZYXString]dvid.RLEs) // nil if block has no voxel mask
 	var included bool
 	curSpan := 0
 	for i, pt := range list.Points {
 		origIndex := list.Indices[i]
 		curSpan, included = seekSpan(pt, spans, curSpan)
+		if included {
+			izyx := pt.ToIZYXString()
+			mask, checked := masks[izyx]
+			if !checked {
+				if mask, _, err = d.getVoxelMask(ctx, izyx); err != nil {
+					return nil, err
+				}
+				masks[izyx] = mask
+			}
+			if mask != nil {
+				included = rlesInclude(mask, voxels[origIndex])
+			}
+		}
 		inclusions[origIndex] = included
 	}
 
@@ -1459,6 +1620,185 @@ func (d *Data) ServeHTTP(uuid dvid.UUID, ctx *datastore.VersionedCtx, w http.Res
 			fmt.Fprintf(w, string(jsonBytes))
 			comment = fmt.Sprintf("HTTP POST ptquery '%s'\n", d.DataName())
 		}
+	case "voxels":
+		switch method {
+		case "get":
+			runs, err := d.getVoxelRuns(ctx)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			w.Header().Set("Content-type", "application/octet-stream")
+			if !d.IsReady(ctx.VersionID()) {
+				w.WriteHeader(http.StatusPartialContent)
+			}
+			bw := bufio.NewWriter(w)
+			numVoxels, numRuns, err := runs.writeSparseVol(bw)
+			if err == nil {
+				err = bw.Flush()
+			}
+			if err != nil {
+				dvid.Errorf("Error writing voxels of ROI %q: %v\n", d.DataName(), err)
+				return
+			}
+			comment = fmt.Sprintf("HTTP GET voxels of ROI %q: %d voxels in %d runs\n", d.DataName(), numVoxels, numRuns)
+		case "post":
+			queryStrings := r.URL.Query()
+			if labelName := queryStrings.Get("data"); labelName != "" {
+				label, err := strconv.ParseUint(queryStrings.Get("label"), 10, 64)
+				if err != nil || label == 0 {
+					server.BadRequest(w, r, "POST voxels from label data %q requires a non-zero 'label'", labelName)
+					return
+				}
+				if err := d.PutLabelSparseVol(ctx.VersionID(), dvid.InstanceName(labelName), label); err != nil {
+					server.BadRequest(w, r, err)
+					return
+				}
+				comment = fmt.Sprintf("HTTP POST voxels of ROI %q from label %d of %q\n", d.DataName(), label, labelName)
+			} else {
+				if err := d.PutSparseVol(ctx.VersionID(), r.Body); err != nil {
+					server.BadRequest(w, r, err)
+					return
+				}
+				comment = fmt.Sprintf("HTTP POST voxels of ROI %q\n", d.DataName())
+			}
+		default:
+			server.BadRequest(w, r, "voxels only supports GET or POST request")
+			return
+		}
+	case "from-mesh":
+		if method != "post" {
+			server.BadRequest(w, r, "from-mesh only supports POST request")
+			return
+		}
+		spans, err := d.PutMesh(ctx.VersionID(), r.Body)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		comment = fmt.Sprintf("HTTP POST from-mesh ROI %q: %d spans\n", d.DataName(), len(spans))
+	case "from-label":
+		if method != "post" {
+			server.BadRequest(w, r, "from-label only supports POST request")
+			return
+		}
+		queryStrings := r.URL.Query()
+		labelName := queryStrings.Get("data")
+		if labelName == "" {
+			server.BadRequest(w, r, "from-label requires a 'data' query string giving the label data")
+			return
+		}
+		label, err := strconv.ParseUint(queryStrings.Get("label"), 10, 64)
+		if err != nil || label == 0 {
+			server.BadRequest(w, r, "from-label requires a non-zero 'label' query string")
+			return
+		}
+		if err := d.PutLabelBlocks(ctx.VersionID(), dvid.InstanceName(labelName), label); err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		comment = fmt.Sprintf("HTTP POST from-label ROI %q: label %d of %q\n", d.DataName(), label, labelName)
+	case "combine":
+		if method != "post" {
+			server.BadRequest(w, r, "combine only supports POST request")
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		var req CombineRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			server.BadRequest(w, r, fmt.Sprintf("Error trying to parse POSTed combine JSON: %v", err))
+			return
+		}
+		spans, err := d.Combine(ctx.VersionID(), req.Op, req.ROIs)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		comment = fmt.Sprintf("HTTP POST combine ROI %q: %s of %d ROIs into %d spans\n", d.DataName(), req.Op, len(req.ROIs), len(spans))
+	case "erode", "dilate":
+		if len(parts) < 5 {
+			server.BadRequest(w, r, "%q must be followed by element size", command)
+			return
+		}
+		radius, err := strconv.ParseInt(parts[4], 10, 32)
+		if err != nil {
+			server.BadRequest(w, r, "Bad element size %q: %v", parts[4], err)
+			return
+		}
+		if radius < 0 || radius > MaxElementSize {
+			server.BadRequest(w, r, "Element size must be between 0 and %d, not %d", MaxElementSize, radius)
+			return
+		}
+		queryStrings := r.URL.Query()
+		shape := queryStrings.Get("shape")
+		if shape == "" {
+			shape = ShapeCube
+		}
+		var dest *Data
+		switch method {
+		case "get":
+		case "post":
+			destName := queryStrings.Get("dest")
+			if destName == "" {
+				server.BadRequest(w, r, "POST on %q requires a 'dest' ROI name", command)
+				return
+			}
+			if dest, err = GetByUUIDName(uuid, dvid.InstanceName(destName)); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			if !dest.BlockSize.Equals(d.BlockSize) {
+				server.BadRequest(w, r, "destination ROI %q has block size %s, which differs from block size %s", destName, dest.BlockSize, d.BlockSize)
+				return
+			}
+		default:
+			server.BadRequest(w, r, "%q only supports GET or POST request", command)
+			return
+		}
+		masked, err := d.hasVoxelMasks(ctx)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if masked {
+			server.BadRequest(w, r, "ROI %q has voxel masks, which %s doesn't support", d.DataName(), command)
+			return
+		}
+		spans, err := GetSpans(ctx)
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		var result dvid.Spans
+		if command == "erode" {
+			result, err = ErodeSpans(spans, shape, int32(radius))
+		} else {
+			result, err = DilateSpans(spans, shape, int32(radius))
+		}
+		if err != nil {
+			server.BadRequest(w, r, err)
+			return
+		}
+		if dest != nil {
+			if err := dest.PutSpans(ctx.VersionID(), result, true); err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			comment = fmt.Sprintf("HTTP POST %s ROI %q by %d (%s) into ROI %q: %d spans\n", command, d.DataName(), radius, shape, dest.DataName(), len(result))
+		} else {
+			jsonBytes, err := json.Marshal(result)
+			if err != nil {
+				server.BadRequest(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, string(jsonBytes))
+			comment = fmt.Sprintf("HTTP GET %s ROI %q by %d (%s): %d spans\n", command, d.DataName(), radius, shape, len(result))
+		}
 	case "partition":
 		if method != "get" {
 			server.BadRequest(w, r, "partition only supports GET request")
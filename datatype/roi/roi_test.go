@@ -2,6 +2,7 @@ package roi
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 
@@ -353,6 +355,395 @@ func TestROIPostAndDelete(t *testing.T) {
 	}
 }
 
+func TestROICombine(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+
+	spansA := []dvid.Span{{1, 1, 0, 10}, {1, 2, 0, 5}, {2, 1, 3, 4}}
+	spansB := []dvid.Span{{1, 1, 5, 7}, {1, 1, 9, 12}, {1, 3, 0, 0}, {2, 1, 0, 3}}
+	for name, spans := range map[string][]dvid.Span{"roiA": spansA, "roiB": spansB} {
+		config := dvid.NewConfig()
+		if _, err := datastore.NewData(uuid, roitype, dvid.InstanceName(name), config); err != nil {
+			t.Fatalf("Error creating new roi instance: %v\n", err)
+		}
+		server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/%s/roi", server.WebAPIPath, uuid, name), getSpansJSON(spans))
+	}
+	config := dvid.NewConfig()
+	if _, err := datastore.NewData(uuid, roitype, "result", config); err != nil {
+		t.Fatalf("Error creating new roi instance: %v\n", err)
+	}
+	config = dvid.NewConfig()
+	config.Set("BlockSize", "64,64,64")
+	if _, err := datastore.NewData(uuid, roitype, "bigblocks", config); err != nil {
+		t.Fatalf("Error creating new roi instance: %v\n", err)
+	}
+
+	combineReq := fmt.Sprintf("%snode/%s/result/combine", server.WebAPIPath, uuid)
+	roiReq := fmt.Sprintf("%snode/%s/result/roi", server.WebAPIPath, uuid)
+	tests := []struct {
+		op       string
+		rois     []string
+		expected []dvid.Span
+	}{
+		{"union", []string{"roiA", "roiB"}, []dvid.Span{{1, 1, 0, 12}, {1, 2, 0, 5}, {1, 3, 0, 0}, {2, 1, 0, 4}}},
+		{"intersection", []string{"roiA", "roiB"}, []dvid.Span{{1, 1, 5, 7}, {1, 1, 9, 10}, {2, 1, 3, 3}}},
+		{"difference", []string{"roiA", "roiB"}, []dvid.Span{{1, 1, 0, 4}, {1, 1, 8, 8}, {1, 2, 0, 5}, {2, 1, 4, 4}}},
+		{"difference", []string{"roiB", "roiA"}, []dvid.Span{{1, 1, 11, 12}, {1, 3, 0, 0}, {2, 1, 0, 2}}},
+		{"difference", []string{"roiA", "roiA"}, []dvid.Span{}},
+	}
+	for _, tc := range tests {
+		var specs []string
+		for _, name := range tc.rois {
+			specs = append(specs, fmt.Sprintf("%s,%s", name, uuid))
+		}
+		reqJSON, err := json.Marshal(CombineRequest{Op: tc.op, ROIs: specs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.TestHTTP(t, "POST", combineReq, bytes.NewReader(reqJSON))
+		spans, err := putSpansJSON(server.TestHTTP(t, "GET", roiReq, nil))
+		if err != nil {
+			t.Fatalf("Error on getting back JSON from roi GET: %v\n", err)
+		}
+		if !reflect.DeepEqual(spans, tc.expected) {
+			t.Errorf("Bad %s of %v\nExpected:\n%v\nReturned:\n%v\n", tc.op, tc.rois, tc.expected, spans)
+		}
+	}
+
+	badReqs := []string{
+		fmt.Sprintf(`{"Op": "xor", "ROIs": ["roiA,%s", "roiB,%s"]}`, uuid, uuid),
+		fmt.Sprintf(`{"Op": "union", "ROIs": ["roiA,%s", "bigblocks,%s"]}`, uuid, uuid),
+		fmt.Sprintf(`{"Op": "union", "ROIs": ["nonexistent,%s"]}`, uuid),
+		`{"Op": "union", "ROIs": []}`,
+	}
+	for _, req := range badReqs {
+		server.TestBadHTTP(t, "POST", combineReq, bytes.NewBufferString(req))
+	}
+}
+
+func TestROIErodeDilate(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	for _, name := range []dvid.InstanceName{"cube", "block", "margin"} {
+		config := dvid.NewConfig()
+		if _, err := datastore.NewData(uuid, roitype, name, config); err != nil {
+			t.Fatalf("Error creating new roi instance: %v\n", err)
+		}
+	}
+
+	// 5 x 5 x 5 cube of blocks and a single block.
+	var cubeSpans []dvid.Span
+	for z := int32(0); z < 5; z++ {
+		for y := int32(0); y < 5; y++ {
+			cubeSpans = append(cubeSpans, dvid.Span{z, y, 0, 4})
+		}
+	}
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/cube/roi", server.WebAPIPath, uuid), getSpansJSON(cubeSpans))
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/block/roi", server.WebAPIPath, uuid), getSpansJSON([]dvid.Span{{0, 0, 0, 0}}))
+
+	var innerSpans []dvid.Span
+	for z := int32(1); z < 4; z++ {
+		for y := int32(1); y < 4; y++ {
+			innerSpans = append(innerSpans, dvid.Span{z, y, 1, 3})
+		}
+	}
+	var outerSpans []dvid.Span
+	for z := int32(-1); z < 6; z++ {
+		for y := int32(-1); y < 6; y++ {
+			outerSpans = append(outerSpans, dvid.Span{z, y, -1, 5})
+		}
+	}
+	tests := []struct {
+		req      string
+		expected []dvid.Span
+	}{
+		{"cube/erode/0", cubeSpans},
+		{"cube/erode/1", innerSpans},
+		{"cube/erode/2", []dvid.Span{{2, 2, 2, 2}}},
+		{"cube/erode/3", []dvid.Span{}},
+		{"cube/erode/1?shape=sphere", innerSpans},
+		{"cube/dilate/1", outerSpans},
+		{"block/dilate/1?shape=sphere", []dvid.Span{{-1, 0, 0, 0}, {0, -1, 0, 0}, {0, 0, -1, 1}, {0, 1, 0, 0}, {1, 0, 0, 0}}},
+		{"block/erode/1?shape=sphere", []dvid.Span{}},
+	}
+	for _, tc := range tests {
+		returnedData := server.TestHTTP(t, "GET", fmt.Sprintf("%snode/%s/%s", server.WebAPIPath, uuid, tc.req), nil)
+		spans, err := putSpansJSON(returnedData)
+		if err != nil {
+			t.Fatalf("Error on getting back JSON from %s: %v\n", tc.req, err)
+		}
+		if !reflect.DeepEqual(spans, tc.expected) {
+			t.Errorf("Bad result for %s\nExpected:\n%v\nReturned:\n%v\n", tc.req, tc.expected, spans)
+		}
+	}
+
+	// Store a dilation into a destination ROI.
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/cube/dilate/1?dest=margin", server.WebAPIPath, uuid), nil)
+	spans, err := putSpansJSON(server.TestHTTP(t, "GET", fmt.Sprintf("%snode/%s/margin/roi", server.WebAPIPath, uuid), nil))
+	if err != nil {
+		t.Fatalf("Error on getting back JSON from roi GET: %v\n", err)
+	}
+	if !reflect.DeepEqual(spans, outerSpans) {
+		t.Errorf("Bad stored dilation\nExpected:\n%v\nReturned:\n%v\n", outerSpans, spans)
+	}
+
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/cube/erode/-1", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/cube/dilate/%d", server.WebAPIPath, uuid, MaxElementSize+1), nil)
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/cube/erode/1?shape=torus", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/cube/erode/1", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/cube/erode/1?dest=nonexistent", server.WebAPIPath, uuid), nil)
+}
+
+func encodeSparseVol(rles dvid.RLEs) io.Reader {
+	numVoxels, numRuns := rles.Stats()
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3))
+	binary.Write(buf, binary.LittleEndian, byte(0))
+	buf.WriteByte(byte(0))
+	binary.Write(buf, binary.LittleEndian, uint32(numVoxels))
+	binary.Write(buf, binary.LittleEndian, uint32(numRuns))
+	rleBytes, err := rles.MarshalBinary()
+	if err != nil {
+		log.Fatalf("Can't encode RLEs: %v\n", err)
+	}
+	buf.Write(rleBytes)
+	return buf
+}
+
+func TestROIVoxels(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	config := dvid.NewConfig()
+	if _, err := datastore.NewData(uuid, roitype, "layer", config); err != nil {
+		t.Fatalf("Error creating new roi instance: %v\n", err)
+	}
+
+	// Blocks (0,0,0) and (1,0,0) are partially within the ROI and block (2,0,0) is full.
+	rles := dvid.RLEs{dvid.NewRLE(dvid.Point3d{0, 0, 0}, 40)}
+	for z := int32(0); z < 32; z++ {
+		for y := int32(0); y < 32; y++ {
+			rles = append(rles, dvid.NewRLE(dvid.Point3d{64, y, z}, 32))
+		}
+	}
+	voxelsReq := fmt.Sprintf("%snode/%s/layer/voxels", server.WebAPIPath, uuid)
+	server.TestHTTP(t, "POST", voxelsReq, encodeSparseVol(rles))
+
+	roiReq := fmt.Sprintf("%snode/%s/layer/roi", server.WebAPIPath, uuid)
+	spans, err := putSpansJSON(server.TestHTTP(t, "GET", roiReq, nil))
+	if err != nil {
+		t.Fatalf("Error on getting back JSON from roi GET: %v\n", err)
+	}
+	if !reflect.DeepEqual(spans, []dvid.Span{{0, 0, 0, 2}}) {
+		t.Errorf("Bad block spans for voxel ROI: %v\n", spans)
+	}
+
+	returned, err := dvid.ReadRLEs(bytes.NewReader(server.TestHTTP(t, "GET", voxelsReq, nil)))
+	if err != nil {
+		t.Fatalf("Error reading returned voxels: %v\n", err)
+	}
+	if !reflect.DeepEqual(returned, rles.Normalize()) {
+		t.Errorf("Bad voxels for ROI\nExpected:\n%v\nReturned:\n%v\n", rles.Normalize(), returned)
+	}
+
+	ptqueryReq := fmt.Sprintf("%snode/%s/layer/ptquery", server.WebAPIPath, uuid)
+	pts := []dvid.Point3d{{5, 0, 0}, {5, 1, 0}, {39, 0, 0}, {40, 0, 0}, {70, 20, 20}, {100, 0, 0}}
+	inclusions, err := putInclusionJSON(server.TestHTTP(t, "POST", ptqueryReq, getPointsJSON(pts)))
+	if err != nil {
+		t.Fatalf("Error on getting back JSON from ptquery: %v\n", err)
+	}
+	if !reflect.DeepEqual(inclusions, []bool{true, false, true, false, true, false}) {
+		t.Errorf("Bad ptquery for voxel ROI: %v\n", inclusions)
+	}
+
+	maskReq := fmt.Sprintf("%snode/%s/layer/mask/0_1_2/100_2_1/0_0_0", server.WebAPIPath, uuid)
+	mask := server.TestHTTP(t, "GET", maskReq, nil)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 100; x++ {
+			var expected byte
+			if (y == 0 && x < 40) || (x >= 64 && x < 96) {
+				expected = 1
+			}
+			if mask[y*100+x] != expected {
+				t.Fatalf("Expected mask value %d at (%d, %d, 0), got %d\n", expected, x, y, mask[y*100+x])
+			}
+		}
+	}
+
+	// Posting block spans removes voxel masks.
+	server.TestHTTP(t, "POST", roiReq, getSpansJSON([]dvid.Span{{0, 0, 0, 0}}))
+	returned, err = dvid.ReadRLEs(bytes.NewReader(server.TestHTTP(t, "GET", voxelsReq, nil)))
+	if err != nil {
+		t.Fatalf("Error reading returned voxels: %v\n", err)
+	}
+	if numVoxels, _ := returned.Stats(); numVoxels != 32*32*32 {
+		t.Errorf("Expected full block of voxels after posting block spans, got %d voxels\n", numVoxels)
+	}
+
+	server.TestBadHTTP(t, "POST", voxelsReq+"?data=nonexistent&label=1", nil)
+	server.TestBadHTTP(t, "POST", voxelsReq+"?data=layer&label=1", nil)
+}
+
+func TestROICombineVoxels(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	for _, name := range []dvid.InstanceName{"roiA", "roiB", "result"} {
+		config := dvid.NewConfig()
+		if _, err := datastore.NewData(uuid, roitype, name, config); err != nil {
+			t.Fatalf("Error creating new roi instance: %v\n", err)
+		}
+	}
+
+	// Returns runs for block (1,0,0) except the voxels in the first row from x0 to x1.
+	block1 := func(x0, x1 int32) dvid.RLEs {
+		var rles dvid.RLEs
+		for z := int32(0); z < 32; z++ {
+			for y := int32(0); y < 32; y++ {
+				if y != 0 || z != 0 || x0 > x1 {
+					rles = append(rles, dvid.NewRLE(dvid.Point3d{32, y, z}, 32))
+					continue
+				}
+				if x0 > 32 {
+					rles = append(rles, dvid.NewRLE(dvid.Point3d{32, 0, 0}, x0-32))
+				}
+				if x1 < 63 {
+					rles = append(rles, dvid.NewRLE(dvid.Point3d{x1 + 1, 0, 0}, 63-x1))
+				}
+			}
+		}
+		return rles
+	}
+
+	// ROI A is partially within block (0,0,0) and fills block (1,0,0), while ROI B is
+	// partially within both blocks.
+	rlesA := append(dvid.RLEs{dvid.NewRLE(dvid.Point3d{0, 0, 0}, 20)}, block1(1, 0)...)
+	rlesB := dvid.RLEs{dvid.NewRLE(dvid.Point3d{10, 0, 0}, 20), dvid.NewRLE(dvid.Point3d{40, 0, 0}, 10)}
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/roiA/voxels", server.WebAPIPath, uuid), encodeSparseVol(rlesA))
+	server.TestHTTP(t, "POST", fmt.Sprintf("%snode/%s/roiB/voxels", server.WebAPIPath, uuid), encodeSparseVol(rlesB))
+
+	combineReq := fmt.Sprintf("%snode/%s/result/combine", server.WebAPIPath, uuid)
+	roiReq := fmt.Sprintf("%snode/%s/result/roi", server.WebAPIPath, uuid)
+	voxelsReq := fmt.Sprintf("%snode/%s/result/voxels", server.WebAPIPath, uuid)
+	tests := []struct {
+		op        string
+		rois      []string
+		expSpans  []dvid.Span
+		expVoxels dvid.RLEs
+	}{
+		{"union", []string{"roiA", "roiB"}, []dvid.Span{{0, 0, 0, 1}},
+			append(dvid.RLEs{dvid.NewRLE(dvid.Point3d{0, 0, 0}, 30)}, block1(1, 0)...)},
+		{"intersection", []string{"roiA", "roiB"}, []dvid.Span{{0, 0, 0, 1}},
+			dvid.RLEs{dvid.NewRLE(dvid.Point3d{10, 0, 0}, 10), dvid.NewRLE(dvid.Point3d{40, 0, 0}, 10)}},
+		{"difference", []string{"roiA", "roiB"}, []dvid.Span{{0, 0, 0, 1}},
+			append(dvid.RLEs{dvid.NewRLE(dvid.Point3d{0, 0, 0}, 10)}, block1(40, 49)...)},
+		{"difference", []string{"roiB", "roiA"}, []dvid.Span{{0, 0, 0, 0}},
+			dvid.RLEs{dvid.NewRLE(dvid.Point3d{20, 0, 0}, 10)}},
+	}
+	for _, tc := range tests {
+		var specs []string
+		for _, name := range tc.rois {
+			specs = append(specs, fmt.Sprintf("%s,%s", name, uuid))
+		}
+		reqJSON, err := json.Marshal(CombineRequest{Op: tc.op, ROIs: specs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.TestHTTP(t, "POST", combineReq, bytes.NewReader(reqJSON))
+		spans, err := putSpansJSON(server.TestHTTP(t, "GET", roiReq, nil))
+		if err != nil {
+			t.Fatalf("Error on getting back JSON from roi GET: %v\n", err)
+		}
+		if !reflect.DeepEqual(spans, tc.expSpans) {
+			t.Errorf("Bad spans for %s of %v\nExpected:\n%v\nReturned:\n%v\n", tc.op, tc.rois, tc.expSpans, spans)
+		}
+		returned, err := dvid.ReadRLEs(bytes.NewReader(server.TestHTTP(t, "GET", voxelsReq, nil)))
+		if err != nil {
+			t.Fatalf("Error reading returned voxels: %v\n", err)
+		}
+		if !reflect.DeepEqual(returned, tc.expVoxels.Normalize()) {
+			t.Errorf("Bad voxels for %s of %v\nExpected:\n%v\nReturned:\n%v\n", tc.op, tc.rois, tc.expVoxels.Normalize(), returned)
+		}
+	}
+
+	// Morphology is only done at block granularity, so masked ROIs are rejected.
+	server.TestBadHTTP(t, "GET", fmt.Sprintf("%snode/%s/roiA/erode/1", server.WebAPIPath, uuid), nil)
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/roiB/dilate/1?dest=result", server.WebAPIPath, uuid), nil)
+}
+
+// returns an OBJ mesh of a box with quad faces.
+func boxOBJ(min, max dvid.Point3d) string {
+	var buf bytes.Buffer
+	for i := 0; i < 8; i++ {
+		x, y, z := min[0], min[1], min[2]
+		if i&1 != 0 {
+			x = max[0]
+		}
+		if i&2 != 0 {
+			y = max[1]
+		}
+		if i&4 != 0 {
+			z = max[2]
+		}
+		fmt.Fprintf(&buf, "v %d %d %d\n", x, y, z)
+	}
+	buf.WriteString("vn 0 0 1\n")
+	buf.WriteString("f 1//1 3//1 4//1 2//1\nf 5 6 8 7\nf 1 2 6 5\nf 3 7 8 4\nf 1 5 7 3\nf -7 -5 -1 -3\n")
+	return buf.String()
+}
+
+func TestROIFromMesh(t *testing.T) {
+	datastore.OpenTest()
+	defer datastore.CloseTest()
+
+	uuid, _ := initTestRepo()
+	config := dvid.NewConfig()
+	if _, err := datastore.NewData(uuid, roitype, "neuropil", config); err != nil {
+		t.Fatalf("Error creating new roi instance: %v\n", err)
+	}
+	meshReq := fmt.Sprintf("%snode/%s/neuropil/from-mesh", server.WebAPIPath, uuid)
+	roiReq := fmt.Sprintf("%snode/%s/neuropil/roi", server.WebAPIPath, uuid)
+
+	tests := []struct {
+		min, max dvid.Point3d
+		expected []dvid.Span
+	}{
+		{dvid.Point3d{0, 0, 0}, dvid.Point3d{96, 64, 64}, []dvid.Span{{0, 0, 0, 2}, {0, 1, 0, 2}, {1, 0, 0, 2}, {1, 1, 0, 2}}},
+		{dvid.Point3d{40, 40, 40}, dvid.Point3d{100, 100, 100}, []dvid.Span{{1, 1, 1, 2}, {1, 2, 1, 2}, {2, 1, 1, 2}, {2, 2, 1, 2}}},
+		{dvid.Point3d{20, 20, 20}, dvid.Point3d{40, 40, 40}, []dvid.Span{}},
+	}
+	for _, tc := range tests {
+		server.TestHTTP(t, "POST", meshReq, bytes.NewBufferString(boxOBJ(tc.min, tc.max)))
+		spans, err := putSpansJSON(server.TestHTTP(t, "GET", roiReq, nil))
+		if err != nil {
+			t.Fatalf("Error on getting back JSON from roi GET: %v\n", err)
+		}
+		if !reflect.DeepEqual(spans, tc.expected) {
+			t.Errorf("Bad ROI from box mesh %s -> %s\nExpected:\n%v\nReturned:\n%v\n", tc.min, tc.max, tc.expected, spans)
+		}
+	}
+
+	server.TestBadHTTP(t, "POST", meshReq, bytes.NewBufferString("v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 4\n"))
+	server.TestBadHTTP(t, "POST", meshReq, bytes.NewBufferString("v 0 0 0\n"))
+	openBox := strings.TrimSuffix(boxOBJ(dvid.Point3d{0, 0, 0}, dvid.Point3d{96, 64, 64}), "f -7 -5 -1 -3\n")
+	server.TestBadHTTP(t, "POST", meshReq, bytes.NewBufferString(openBox))
+	noTop := strings.Replace(boxOBJ(dvid.Point3d{0, 0, 0}, dvid.Point3d{96, 64, 64}), "f 5 6 8 7\n", "", 1)
+	server.TestBadHTTP(t, "POST", meshReq, bytes.NewBufferString(noTop))
+	tube := strings.Replace(boxOBJ(dvid.Point3d{0, 0, 0}, dvid.Point3d{96, 64, 64}), "f 1 5 7 3\n", "", 1)
+	tube = strings.TrimSuffix(tube, "f -7 -5 -1 -3\n")
+	server.TestBadHTTP(t, "POST", meshReq, bytes.NewBufferString(tube))
+	farBox := boxOBJ(dvid.Point3d{0, 0, 0}, dvid.Point3d{96, 64, 2 * MaxMeshCoord})
+	server.TestBadHTTP(t, "POST", meshReq, bytes.NewBufferString(farBox))
+	server.TestBadHTTP(t, "POST", fmt.Sprintf("%snode/%s/neuropil/from-label?data=neuropil&label=1", server.WebAPIPath, uuid), nil)
+}
+
 func TestROICreateAndSerialize(t *testing.T) {
 	datastore.OpenTest()
 	defer datastore.CloseTest()
@@ -0,0 +1,372 @@
+/*
+	This file supports ROIs with voxel precision.  Blocks that are only partially within
+	an ROI have voxel masks, stored as RLEs, in addition to the block spans of the ROI.
+	Blocks in the ROI spans without a voxel mask are completely within the ROI.
+*/
+
+package roi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/janelia-flyem/dvid/datastore"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// sparseVolGetter is implemented by label data, e.g., labelarray, that can return a label's
+// sparse volume in the legacy RLE encoding.
+type sparseVolGetter interface {
+	GetLegacyRLE(ctx *datastore.VersionedCtx, label uint64, scale uint8, bounds dvid.Bounds) ([]byte, error)
+}
+
+// VoxelMasks maps blocks that are only partially within an ROI to RLEs of the voxels
+// within the ROI.
+type VoxelMasks map[dvid.IZYXString]dvid.RLEs
+
+// Excludes returns true if the given voxel is in a block with a voxel mask that does
+// not include the voxel.  Voxels in blocks without masks are not excluded.
+func (m VoxelMasks) Excludes(pt, blockSize dvid.Point3d) bool {
+	if len(m) == 0 {
+		return false
+	}
+	rles, found := m[pt.ToBlockIZYXString(blockSize)]
+	if !found {
+		return false
+	}
+	return !rlesInclude(rles, pt)
+}
+
+// returns true if the point is within one of the RLEs.
+func rlesInclude(rles dvid.RLEs, pt dvid.Point3d) bool {
+	for _, rle := range rles {
+		if rle.Within(pt) {
+			return true
+		}
+	}
+	return false
+}
+
+func newVoxelMaskTKey(izyx dvid.IZYXString) storage.TKey {
+	return storage.NewTKey(keyVoxelMask, []byte(izyx))
+}
+
+func (d *Data) decodeVoxelMask(data []byte) (dvid.RLEs, error) {
+	maskBytes, _, err := dvid.DeserializeData(data, true)
+	if err != nil {
+		return nil, err
+	}
+	var rles dvid.RLEs
+	if err := rles.UnmarshalBinary(maskBytes); err != nil {
+		return nil, err
+	}
+	return rles, nil
+}
+
+// returns the voxel masks for blocks with z coordinates in the given range.
+func (d *Data) getVoxelMasks(ctx *datastore.VersionedCtx, minZ, maxZ int32) (VoxelMasks, error) {
+	db, err := ctx.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, err
+	}
+	masks := make(VoxelMasks)
+	minIZYX := dvid.ChunkPoint3d{math.MinInt32, math.MinInt32, minZ}.ToIZYXString()
+	maxIZYX := dvid.ChunkPoint3d{math.MaxInt32, math.MaxInt32, maxZ}.ToIZYXString()
+	err = db.ProcessRange(ctx, newVoxelMaskTKey(minIZYX), newVoxelMaskTKey(maxIZYX), &storage.ChunkOp{}, func(chunk *storage.Chunk) error {
+		ibytes, err := chunk.K.ClassBytes(keyVoxelMask)
+		if err != nil {
+			return err
+		}
+		rles, err := d.decodeVoxelMask(chunk.V)
+		if err != nil {
+			return err
+		}
+		masks[dvid.IZYXString(ibytes)] = rles
+		return nil
+	})
+	return masks, err
+}
+
+// GetVoxelMasks returns the voxel masks of all blocks partially within the ROI.
+func (d *Data) GetVoxelMasks(v dvid.VersionID) (VoxelMasks, error) {
+	ctx := datastore.NewVersionedCtx(d, v)
+	return d.getVoxelMasks(ctx, math.MinInt32, math.MaxInt32)
+}
+
+var errFoundVoxelMask = fmt.Errorf("found voxel mask")
+
+// returns true if any block of the ROI has a voxel mask.
+func (d *Data) hasVoxelMasks(ctx *datastore.VersionedCtx) (bool, error) {
+	db, err := ctx.GetOrderedKeyValueDB()
+	if err != nil {
+		return false, err
+	}
+	minIZYX := dvid.ChunkPoint3d{math.MinInt32, math.MinInt32, math.MinInt32}.ToIZYXString()
+	maxIZYX := dvid.ChunkPoint3d{math.MaxInt32, math.MaxInt32, math.MaxInt32}.ToIZYXString()
+	err = db.ProcessRange(ctx, newVoxelMaskTKey(minIZYX), newVoxelMaskTKey(maxIZYX), &storage.ChunkOp{}, func(chunk *storage.Chunk) error {
+		return errFoundVoxelMask
+	})
+	if err == errFoundVoxelMask {
+		return true, nil
+	}
+	return false, err
+}
+
+// returns the voxel mask of a block and whether it was found.
+func (d *Data) getVoxelMask(ctx *datastore.VersionedCtx, izyx dvid.IZYXString) (dvid.RLEs, bool, error) {
+	db, err := ctx.GetOrderedKeyValueDB()
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := db.Get(ctx, newVoxelMaskTKey(izyx))
+	if err != nil || data == nil {
+		return nil, false, err
+	}
+	rles, err := d.decodeVoxelMask(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return rles, true, nil
+}
+
+// returns normalized RLEs for the voxels of RLEs within a block and whether the
+// RLEs fill the block.
+func blockVoxelRLEs(bcoord dvid.ChunkPoint3d, blockSize dvid.Point3d, rles dvid.RLEs) (dvid.RLEs, bool) {
+	return insideRLEs(bcoord, blockSize, blockVoxels(bcoord, blockSize, rles))
+}
+
+// returns whether each voxel of a block, in ZYX order, is within the RLEs, which must be
+// within the block.
+func blockVoxels(bcoord dvid.ChunkPoint3d, blockSize dvid.Point3d, rles dvid.RLEs) []bool {
+	nx, ny, nz := blockSize[0], blockSize[1], blockSize[2]
+	offset := bcoord.MinPoint(blockSize).(dvid.Point3d)
+	inside := make([]bool, nx*ny*nz)
+	for _, rle := range rles {
+		start := rle.StartPt()
+		i := ((start[2]-offset[2])*ny+start[1]-offset[1])*nx + start[0] - offset[0]
+		for n := int32(0); n < rle.Length(); n++ {
+			inside[i] = true
+			i++
+		}
+	}
+	return inside
+}
+
+// returns normalized RLEs for the voxels of a block that are inside and whether all voxels
+// of the block are inside.
+func insideRLEs(bcoord dvid.ChunkPoint3d, blockSize dvid.Point3d, inside []bool) (dvid.RLEs, bool) {
+	nx, ny, nz := blockSize[0], blockSize[1], blockSize[2]
+	offset := bcoord.MinPoint(blockSize).(dvid.Point3d)
+	full := true
+	for _, in := range inside {
+		if !in {
+			full = false
+			break
+		}
+	}
+	if full {
+		return nil, true
+	}
+	var mask dvid.RLEs
+	var i int32
+	for z := int32(0); z < nz; z++ {
+		for y := int32(0); y < ny; y++ {
+			var x0 int32 = -1 // start of current run or -1 if not in run
+			for x := int32(0); x < nx; x++ {
+				if inside[i] {
+					if x0 < 0 {
+						x0 = x
+					}
+				} else if x0 >= 0 {
+					mask = append(mask, dvid.NewRLE(dvid.Point3d{offset[0] + x0, offset[1] + y, offset[2] + z}, x-x0))
+					x0 = -1
+				}
+				i++
+			}
+			if x0 >= 0 {
+				mask = append(mask, dvid.NewRLE(dvid.Point3d{offset[0] + x0, offset[1] + y, offset[2] + z}, nx-x0))
+			}
+		}
+	}
+	return mask, false
+}
+
+// PutVoxels replaces the ROI at the given version with the voxels given by RLEs.
+// Blocks completely covered by the RLEs are stored only as block spans, while blocks
+// partially covered also get a voxel mask.
+func (d *Data) PutVoxels(v dvid.VersionID, rles dvid.RLEs) error {
+	brles, err := rles.Partition(d.BlockSize)
+	if err != nil {
+		return err
+	}
+	spans := make(dvid.Spans, 0, len(brles))
+	masks := make(VoxelMasks)
+	for izyx, blockRLEs := range brles {
+		bcoord, err := izyx.ToChunkPoint3d()
+		if err != nil {
+			return err
+		}
+		spans = append(spans, dvid.Span{bcoord[2], bcoord[1], bcoord[0], bcoord[0]})
+		if mask, full := blockVoxelRLEs(bcoord, d.BlockSize, blockRLEs); !full {
+			masks[izyx] = mask
+		}
+	}
+	return d.putSpans(v, spans.Normalize(), true, masks)
+}
+
+// PutSparseVol replaces the ROI at the given version with a sparse volume in the legacy
+// RLE encoding used by the labelarray sparsevol endpoint.
+func (d *Data) PutSparseVol(v dvid.VersionID, r io.Reader) error {
+	rles, err := dvid.ReadRLEs(r)
+	if err != nil {
+		return err
+	}
+	return d.PutVoxels(v, rles)
+}
+
+// PutLabelSparseVol replaces the ROI at the given version with the sparse volume of a
+// label in a labelarray instance.
+func (d *Data) PutLabelSparseVol(v dvid.VersionID, labelName dvid.InstanceName, label uint64) error {
+	labelData, err := datastore.GetDataByVersionName(v, labelName)
+	if err != nil {
+		return err
+	}
+	getter, ok := labelData.(sparseVolGetter)
+	if !ok {
+		return fmt.Errorf("data %q does not support sparse volumes", labelName)
+	}
+	encoding, err := getter.GetLegacyRLE(datastore.NewVersionedCtx(labelData, v), label, 0, dvid.Bounds{})
+	if err != nil {
+		return err
+	}
+	if len(encoding) == 0 {
+		return fmt.Errorf("label %d not found in data %q", label, labelName)
+	}
+	return d.PutSparseVol(v, bytes.NewReader(encoding))
+}
+
+// voxelRuns generates the normalized runs of voxels within an ROI from its block spans and
+// voxel masks, so full blocks never have to be expanded into runs in memory.
+type voxelRuns struct {
+	blockSize dvid.Point3d
+	spans     dvid.Spans
+	masks     VoxelMasks
+}
+
+func (d *Data) getVoxelRuns(ctx *datastore.VersionedCtx) (*voxelRuns, error) {
+	spans, err := GetSpans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	masks, err := d.getVoxelMasks(ctx, math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	return &voxelRuns{d.BlockSize, spans, masks}, nil
+}
+
+// calls f on each run in z, y, x order, where runs adjacent along x are merged.
+func (vr *voxelRuns) process(f func(dvid.RLE) error) error {
+	bs := vr.blockSize
+	for i := 0; i < len(vr.spans); {
+		// Get the spans in this row of blocks and the sorted voxel masks of its blocks.
+		bz, by := vr.spans[i][0], vr.spans[i][1]
+		j := i + 1
+		for j < len(vr.spans) && vr.spans[j][0] == bz && vr.spans[j][1] == by {
+			j++
+		}
+		row := vr.spans[i:j]
+		i = j
+		rowMasks := make(map[int32]dvid.RLEs)
+		for _, span := range row {
+			for x := span[2]; x <= span[3]; x++ {
+				if mask, found := vr.masks[dvid.ChunkPoint3d{x, by, bz}.ToIZYXString()]; found {
+					rowMasks[x] = mask.Normalize()
+				}
+			}
+		}
+
+		for z := bz * bs[2]; z < (bz+1)*bs[2]; z++ {
+			for y := by * bs[1]; y < (by+1)*bs[1]; y++ {
+				var run dvid.RLE
+				var inRun bool
+				add := func(start dvid.Point3d, length int32) error {
+					if inRun && run.StartPt()[0]+run.Length() == start[0] {
+						run.Extend(length)
+						return nil
+					}
+					if inRun {
+						if err := f(run); err != nil {
+							return err
+						}
+					}
+					run, inRun = dvid.NewRLE(start, length), true
+					return nil
+				}
+				for _, span := range row {
+					for x := span[2]; x <= span[3]; x++ {
+						mask, found := rowMasks[x]
+						if !found {
+							if err := add(dvid.Point3d{x * bs[0], y, z}, bs[0]); err != nil {
+								return err
+							}
+							continue
+						}
+						// Masks are sorted, so consume the runs of this voxel row.
+						for len(mask) > 0 {
+							pt := mask[0].StartPt()
+							if pt[2] > z || (pt[2] == z && pt[1] > y) {
+								break
+							}
+							if pt[2] == z && pt[1] == y {
+								if err := add(pt, mask[0].Length()); err != nil {
+									return err
+								}
+							}
+							mask = mask[1:]
+						}
+						rowMasks[x] = mask
+					}
+				}
+				if inRun {
+					if err := f(run); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writes the voxels in the legacy RLE encoding used by the labelarray sparsevol endpoint.
+// The runs are generated twice, once to count them for the header and once to write them.
+func (vr *voxelRuns) writeSparseVol(w io.Writer) (numVoxels, numRuns uint32, err error) {
+	err = vr.process(func(rle dvid.RLE) error {
+		numVoxels += uint32(rle.Length())
+		numRuns++
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	header := make([]byte, 12)
+	header[0] = dvid.EncodingBinary
+	header[1] = 3 // # of dimensions
+	header[2] = 0 // dimension of run (X = 0)
+	header[3] = 0 // reserved for later
+	binary.LittleEndian.PutUint32(header[4:8], numVoxels)
+	binary.LittleEndian.PutUint32(header[8:12], numRuns)
+	if _, err = w.Write(header); err != nil {
+		return
+	}
+	err = vr.process(func(rle dvid.RLE) error {
+		_, err := rle.WriteTo(w)
+		return err
+	})
+	return
+}
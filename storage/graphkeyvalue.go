@@ -15,12 +15,16 @@ package storage
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 
 	"github.com/janelia-flyem/dvid/dvid"
 )
 
+// ErrVertexNotFound is returned when a requested vertex is not in the graph.
+var ErrVertexNotFound = errors.New("vertex not found")
+
 // graphType enumerates the graph key types
 type graphType byte
 
@@ -541,8 +545,9 @@ func (db *GraphKeyValueDB) RemoveGraph(ctx Context) error {
 func (db *GraphKeyValueDB) RemoveVertex(ctx Context, id dvid.VertexID) error {
 	batcher := db.dbbatch.NewBatch(ctx)
 
+	// vertex property keys have no second vertex, so bound by the next vertex id
 	keylb := &graphIndex{keyVertexProperty, id, 0, ""}
-	keyub := &graphIndex{keyVertexProperty, id, 1, ""}
+	keyub := &graphIndex{keyVertexProperty, id + 1, 0, ""}
 	keys, err := db.KeysInRange(ctx, keylb.Bytes(), keyub.Bytes())
 	if err != nil {
 		return err
@@ -717,6 +722,9 @@ func (db *GraphKeyValueDB) GetVertex(ctx Context, id dvid.VertexID) (dvid.GraphV
 	if err != nil {
 		return vertex, err
 	}
+	if data == nil {
+		return vertex, ErrVertexNotFound
+	}
 	vertex, err = db.deserializeVertex(data)
 	return vertex, err
 }